@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ardanlabs/conf/v3"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+	"github.com/ardanlabs/usdl/foundation/fingerprint"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// cli holds what the commands need to talk to the CAP.
+type cli struct {
+	db         *dbfile.DB
+	id         client.ID
+	passphrase string
+	url        string
+	jwt        string
+	options    []client.Option
+	linger     time.Duration
+	out        io.Writer
+}
+
+func (c cli) run(args conf.Args) error {
+	switch args.Num(0) {
+	case "send":
+		return c.send(args[1:])
+
+	case "listen":
+		return c.listen()
+
+	case "contacts":
+		return c.contacts(args[1:])
+
+	case "share-key":
+		return c.shareKey(args[1:])
+
+	case "verify":
+		return c.verify(args[1:])
+
+	case "p2p":
+		return c.p2p(args[1:])
+
+	case "rotate":
+		return c.rotate()
+
+	case "backup":
+		return c.backup()
+
+	case "restore":
+		return c.restore(args[1:])
+
+	case "export":
+		return c.exportArchive(args[1:])
+
+	case "import":
+		return c.importArchive(args[1:])
+	}
+
+	return fmt.Errorf("unknown command: %q", args.Num(0))
+}
+
+// =============================================================================
+
+func (c cli) send(args conf.Args) error {
+	if len(args) < 2 {
+		return errors.New("usage: send <addr> <msg>")
+	}
+
+	to, err := parseAddress(args.Num(0))
+	if err != nil {
+		return err
+	}
+
+	return c.sendMessage(to, strings.Join(args[1:], " "))
+}
+
+func (c cli) shareKey(args conf.Args) error {
+	if len(args) != 1 {
+		return errors.New("usage: share-key <addr>")
+	}
+
+	to, err := parseAddress(args.Num(0))
+	if err != nil {
+		return err
+	}
+
+	return c.sendMessage(to, "/share key")
+}
+
+// verify prints the safety number for the contact, or with confirm marks
+// the contact's key as verified.
+func (c cli) verify(args conf.Args) error {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args.Num(1) != "confirm") {
+		return errors.New("usage: verify <addr> [confirm]")
+	}
+
+	to, err := parseAddress(args.Num(0))
+	if err != nil {
+		return err
+	}
+
+	app := c.newApp(newUI(io.Discard))
+
+	if args.Num(1) == "confirm" {
+		return app.VerifyContact(to)
+	}
+
+	number, err := app.SafetyNumber(to)
+	if err != nil {
+		return fmt.Errorf("safety number: %w", err)
+	}
+
+	fmt.Fprintln(c.out, fingerprint.Format(number, 4))
+
+	return nil
+}
+
+// rotate moves the account to a new ID and tells the contacts. The CAP
+// forwards messages for the old ID to the new one for a while.
+func (c cli) rotate() error {
+	passphrase, err := c.keyPassphrase()
+	if err != nil {
+		return err
+	}
+
+	app, ui, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	defer ui.close()
+
+	newID, err := app.RotateID(context.Background(), configFilePath, passphrase)
+	if newID == (common.Address{}) {
+		return fmt.Errorf("rotate: %w", err)
+	}
+
+	fmt.Fprintln(c.out, newID.Hex())
+
+	if err != nil {
+		return fmt.Errorf("some contacts weren't told: %w", err)
+	}
+
+	select {
+	case <-time.After(c.linger):
+	case <-app.Done():
+	}
+
+	return nil
+}
+
+// backup prints the words that restore the keys.
+func (c cli) backup() error {
+	passphrase, err := c.keyPassphrase()
+	if err != nil {
+		return err
+	}
+
+	words, newKey, err := client.Backup(configFilePath, passphrase)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	fmt.Fprintln(c.out, words)
+
+	if newKey {
+		fmt.Fprintln(os.Stderr, "Your encryption key predates backups, so restoring gives you a new one and your contacts will see it change.")
+	}
+
+	return nil
+}
+
+// restore finishes restoring the keys, which happens before the storage is
+// opened, by importing the archive if there is one and sharing the key with
+// every contact.
+func (c cli) restore(args conf.Args) error {
+	if len(args) > 1 {
+		return errors.New("usage: restore [archive]")
+	}
+
+	if len(args) == 1 {
+		if err := c.importArchive(args); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(c.out, c.id.MyAccountID.Hex())
+
+	app, ui, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	defer ui.close()
+
+	if err := app.AnnounceKey(); err != nil {
+		return fmt.Errorf("some contacts weren't sent the key: %w", err)
+	}
+
+	select {
+	case <-time.After(c.linger):
+	case <-app.Done():
+	}
+
+	return nil
+}
+
+// exportArchive writes the contacts and history to an archive encrypted
+// with a new passphrase.
+func (c cli) exportArchive(args conf.Args) error {
+	if len(args) != 1 {
+		return errors.New("usage: export <file>")
+	}
+
+	passphrase, err := client.AskPassphrase("Archive passphrase", true)
+	if err != nil {
+		return fmt.Errorf("passphrase: %w", err)
+	}
+
+	data, err := c.db.Export(passphrase)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	if err := os.WriteFile(args.Num(0), data, 0600); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	return nil
+}
+
+// importArchive adds the contacts and history in the archive.
+func (c cli) importArchive(args conf.Args) error {
+	if len(args) != 1 {
+		return errors.New("usage: import <file>")
+	}
+
+	data, err := os.ReadFile(args.Num(0))
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	passphrase, err := client.AskPassphrase("Archive passphrase", false)
+	if err != nil {
+		return fmt.Errorf("passphrase: %w", err)
+	}
+
+	if err := c.db.Import(data, passphrase); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	return nil
+}
+
+func (c cli) listen() error {
+	app, ui, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	defer ui.close()
+
+	ln, err := listenControl()
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	go serveControl(ln, app.SendMessageHandler)
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case <-shutdown:
+		return nil
+
+	case <-app.Done():
+		return errors.New("connection to the CAP lost")
+	}
+}
+
+func (c cli) contacts(args conf.Args) error {
+	switch args.Num(0) {
+	case "list":
+		enc := json.NewEncoder(c.out)
+
+		for _, usr := range c.db.Contacts() {
+			if err := enc.Encode(newContactLine(usr)); err != nil {
+				return fmt.Errorf("encode: %w", err)
+			}
+		}
+
+		return nil
+
+	case "add":
+		if len(args) < 3 {
+			return errors.New("usage: contacts add <addr> <name>")
+		}
+
+		id, err := parseAddress(args.Num(1))
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.db.QueryContactByID(id); err == nil {
+			return fmt.Errorf("contact already exists: %s", id)
+		}
+
+		if _, err := c.db.InsertContact(id, strings.Join(args[2:], " ")); err != nil {
+			return fmt.Errorf("add contact: %w", err)
+		}
+
+		return nil
+
+	case "rename":
+		if len(args) < 3 {
+			return errors.New("usage: contacts rename <addr> <name>")
+		}
+
+		id, err := parseAddress(args.Num(1))
+		if err != nil {
+			return err
+		}
+
+		if err := c.db.UpdateContactName(id, strings.Join(args[2:], " ")); err != nil {
+			return fmt.Errorf("rename contact: %w", err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("unknown contacts command: %q", args.Num(0))
+}
+
+// p2p asks the CAP to dial the CAP of the contact. It only needs the HTTP
+// API so it doesn't take the user's WebSocket connection.
+func (c cli) p2p(args conf.Args) error {
+	if args.Num(0) != "connect" || len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: p2p connect <addr> [host]")
+	}
+
+	to, err := parseAddress(args.Num(1))
+	if err != nil {
+		return err
+	}
+
+	if host := args.Num(2); host != "" {
+		if err := c.db.UpdateContactTCPHost(to, host); err != nil {
+			return fmt.Errorf("update tcp host: %w", err)
+		}
+	}
+
+	app := c.newApp(newUI(io.Discard))
+
+	err = app.EstablishTCPConnection(context.Background(), c.id.MyAccountID, to)
+	switch {
+	case err == nil:
+		fmt.Fprintln(c.out, "tcp connection established")
+		return nil
+
+	case errors.Is(err, client.ErrConnectionDropped):
+		fmt.Fprintln(c.out, "tcp connection dropped")
+		return nil
+	}
+
+	return fmt.Errorf("establish tcp connection: %w", err)
+}
+
+// =============================================================================
+
+// sendMessage sends the message through listen when it's running. Otherwise
+// it connects to the CAP, sends the message and waits for the linger period
+// so anything the CAP sends back, like queued messages or a rejected nonce,
+// is written out before disconnecting.
+func (c cli) sendMessage(to common.Address, msg string) error {
+	sent, err := sendControl(to, msg)
+	if sent {
+		if err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+
+		return nil
+	}
+
+	app, ui, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	defer ui.close()
+
+	if err := app.SendMessageHandler(to, []byte(msg)); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	select {
+	case <-time.After(c.linger):
+	case <-app.Done():
+	}
+
+	return nil
+}
+
+func (c cli) connect() (*client.App, *jsonUI, error) {
+	ui := newUI(c.out)
+	app := c.newApp(ui)
+
+	if err := app.Handshake(c.db.MyAccount()); err != nil {
+		return nil, nil, fmt.Errorf("handshake: %w", err)
+	}
+
+	return app, ui, nil
+}
+
+// keyPassphrase returns the passphrase for the keys, asking for it when it
+// wasn't configured.
+func (c cli) keyPassphrase() (string, error) {
+	if c.passphrase != "" {
+		return c.passphrase, nil
+	}
+
+	passphrase, err := client.PromptPassphrase(configFilePath)
+	if err != nil {
+		return "", fmt.Errorf("passphrase: %w", err)
+	}
+
+	return passphrase, nil
+}
+
+func (c cli) newApp(ui client.UI) *client.App {
+	return client.NewApp(c.db, c.id, c.url, ui, c.jwt, filepath.Join(configFilePath, "transfers"), c.options...)
+}
+
+func parseAddress(s string) (common.Address, error) {
+	if !common.IsHexAddress(s) {
+		return common.Address{}, fmt.Errorf("invalid address: %q", s)
+	}
+
+	return common.HexToAddress(s), nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// controlFile is the socket listen serves so send and share-key can use its
+// connection, since the CAP allows one connection per user.
+const controlFile = "cli.sock"
+
+// controlTimeout is how long a request on the control socket is given.
+const controlTimeout = 30 * time.Second
+
+// controlRequest is a message for listen to send.
+type controlRequest struct {
+	To  common.Address `json:"to"`
+	Msg string         `json:"msg"`
+}
+
+// controlResponse reports if listen sent the message.
+type controlResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// listenControl opens the control socket. A socket left behind by a listen
+// that didn't exit cleanly is replaced, the CAP has already refused the
+// connection if another listen is running.
+func listenControl() (net.Listener, error) {
+	path := filepath.Join(configFilePath, controlFile)
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove control socket: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+
+	// Anyone who can write to the socket can send as the user.
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("control socket: %w", err)
+	}
+
+	return ln, nil
+}
+
+// serveControl sends the messages requested on the control socket until the
+// listener is closed. Requests are handled one at a time so the nonces go
+// out in order.
+func serveControl(ln net.Listener, send func(to common.Address, msg []byte) error) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		handleControl(conn, send)
+	}
+}
+
+func handleControl(conn net.Conn, send func(to common.Address, msg []byte) error) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var resp controlResponse
+
+	var req controlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("decode: %s", err)
+	} else if err := send(req.To, []byte(req.Msg)); err != nil {
+		resp.Error = err.Error()
+	}
+
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// sendControl asks a running listen to send the message. It reports false
+// when no listen is running.
+func sendControl(to common.Address, msg string) (bool, error) {
+	conn, err := net.Dial("unix", filepath.Join(configFilePath, controlFile))
+	if err != nil {
+		return false, nil
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(controlRequest{To: to, Msg: msg}); err != nil {
+		return true, fmt.Errorf("control request: %w", err)
+	}
+
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return true, fmt.Errorf("control response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return true, errors.New(resp.Error)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,179 @@
+// This program provides a headless command line client for the chat. It
+// shares the ID and storage of the TUI so the two can be used interchangeably.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ardanlabs/conf/v3"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/foundation/keystore"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	configFilePath = "zarf/client"
+	keysFolder     = "zarf/client/id/"
+	activeKID      = "key"
+	issuer         = "usdl project"
+)
+
+const usage = `Commands:
+  send <addr> <msg>              Send a message to a contact.
+  listen                         Stream incoming messages as JSON lines.
+  contacts list                  List the contacts as JSON lines.
+  contacts add <addr> <name>     Add a contact.
+  contacts rename <addr> <name>  Rename a contact.
+  share-key <addr>               Share your public key with a contact.
+  verify <addr> [confirm]        Show the safety number to compare with a
+                                 contact, or mark the contact as verified.
+  p2p connect <addr> [host]      Open or drop a peer-to-peer connection with
+                                 a contact, saving the contact's TCP host.
+  rotate                         Move to a new ID and tell every contact.
+                                 Prints the new address.
+  backup                         Print the words that restore the keys.
+  restore [archive]              Recreate the keys from the backup words,
+                                 import the archive and share the key with
+                                 every contact.
+  export <file>                  Write the contacts and history to an
+                                 archive encrypted with a passphrase.
+  import <file>                  Add the contacts and history in an archive.
+
+The CAP allows one connection per user, so while listen is running send
+and share-key go through its connection, and what the CAP sends back is
+written out by listen. They fail while the TUI is connected with the same
+ID.`
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg := struct {
+		conf.Version
+		Linger     time.Duration `conf:"default:500ms"`
+		Passphrase string        `conf:"mask,help:unlocks the keys instead of asking for it"`
+		CAP        struct {
+			URL      string `conf:"default:http://localhost:3000"`
+			CAFile   string
+			CertFile string
+			KeyFile  string
+		}
+		Args conf.Args
+	}{
+		Version: conf.Version{
+			Build: "develop",
+			Desc:  "CLI",
+		},
+	}
+
+	const prefix = "CLI"
+	help, err := conf.Parse(prefix, &cfg)
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			fmt.Println(help)
+			fmt.Println(usage)
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	if len(cfg.Args) == 0 {
+		fmt.Println(usage)
+		return errors.New("command required")
+	}
+
+	// -------------------------------------------------------------------------
+
+	var id client.ID
+
+	switch {
+	case cfg.Args.Num(0) == "restore":
+		if id, err = client.PromptRestore(configFilePath, cfg.Passphrase); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+
+		// History left by the lost keys can't be read with the new storage
+		// key, an archive brings it back.
+		if err := dbfile.SetAsideHistory(configFilePath); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+
+	default:
+		if id, err = client.UnlockID(configFilePath, cfg.Passphrase); err != nil {
+			return fmt.Errorf("id: %w", err)
+		}
+	}
+
+	// -------------------------------------------------------------------------
+
+	ks := keystore.New()
+
+	if _, err := ks.LoadByFileSystem(os.DirFS(keysFolder)); err != nil {
+		return fmt.Errorf("loading keys by fs: %w", err)
+	}
+	authCfg := auth.Config{
+		Log:       nil,
+		KeyLookup: ks,
+		Issuer:    issuer,
+	}
+
+	ath, err := auth.New(authCfg)
+	if err != nil {
+		return fmt.Errorf("constructing auth: %w", err)
+	}
+
+	tkn, err := ath.GenerateToken(activeKID, auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id.MyAccountID.Hex(),
+			Issuer:    issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(8760 * time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("generating token: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	db, err := dbfile.NewDB(configFilePath, id, tkn)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	var options []client.Option
+
+	if strings.HasPrefix(cfg.CAP.URL, "https://") {
+		tlsConfig, err := client.NewTLSConfig(cfg.CAP.CAFile, cfg.CAP.CertFile, cfg.CAP.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls config: %w", err)
+		}
+
+		options = append(options, client.WithTLSConfig(tlsConfig))
+	}
+
+	c := cli{
+		db:         db,
+		id:         id,
+		passphrase: cfg.Passphrase,
+		url:        cfg.CAP.URL,
+		jwt:        tkn,
+		options:    options,
+		linger:     cfg.Linger,
+		out:        os.Stdout,
+	}
+
+	return c.run(cfg.Args)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// messageLine is what is written for each message the app displays.
+type messageLine struct {
+	From      common.Address  `json:"from"`
+	To        common.Address  `json:"to"`
+	Group     *common.Address `json:"group,omitempty"`
+	Name      string          `json:"name"`
+	Text      string          `json:"text"`
+	Encrypted bool            `json:"encrypted"`
+	Date      time.Time       `json:"date"`
+}
+
+func newMessageLine(msg client.Message) messageLine {
+	date := msg.DateCreated
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	line := messageLine{
+		From:      msg.From,
+		To:        msg.To,
+		Name:      msg.Name,
+		Text:      client.StitchMessages(msg.Content),
+		Encrypted: msg.Encrypted,
+		Date:      date,
+	}
+
+	if msg.Group != (common.Address{}) {
+		line.Group = &msg.Group
+	}
+
+	return line
+}
+
+// contactLine is what is written for each contact.
+type contactLine struct {
+	ID         common.Address `json:"id"`
+	Name       string         `json:"name"`
+	Group      bool           `json:"group"`
+	Encrypted  bool           `json:"encrypted"`
+	Verified   bool           `json:"verified,omitempty"`
+	KeyChanged bool           `json:"key_changed,omitempty"`
+	TCPHost    string         `json:"tcp_host,omitempty"`
+}
+
+func newContactLine(usr client.User) contactLine {
+	return contactLine{
+		ID:         usr.ID,
+		Name:       usr.Name,
+		Group:      usr.Group,
+		Encrypted:  usr.Key != "",
+		Verified:   usr.Verified,
+		KeyChanged: usr.KeyChanged,
+		TCPHost:    usr.TCPHost,
+	}
+}
+
+// =============================================================================
+
+// jsonUI implements the client UI by writing each message as a JSON line.
+type jsonUI struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closed bool
+}
+
+func newUI(w io.Writer) *jsonUI {
+	return &jsonUI{
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (u *jsonUI) Run() error {
+	return nil
+}
+
+func (u *jsonUI) WriteText(msg client.Message) {
+	u.write(newMessageLine(msg))
+}
+
+func (u *jsonUI) TransferOffer(offer client.TransferOffer) {
+	u.write(messageLine{
+		From: offer.From,
+		Name: "system",
+		Text: fmt.Sprintf("file offered: %s (%d bytes) transfer %s", offer.Name, offer.Size, offer.ID),
+		Date: time.Now(),
+	})
+}
+
+func (u *jsonUI) AddContact(id common.Address, name string)                     {}
+func (u *jsonUI) AddGroup(id common.Address, name string)                       {}
+func (u *jsonUI) RemoveContact(id common.Address)                               {}
+func (u *jsonUI) ApplyContactPrefix(id common.Address, option string, add bool) {}
+
+// close stops writing so the error from closing the connection isn't
+// reported.
+func (u *jsonUI) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.closed = true
+}
+
+func (u *jsonUI) write(line messageLine) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closed {
+		return
+	}
+
+	u.enc.Encode(line)
+}
@@ -2,21 +2,29 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/ardanlabs/conf/v3"
 	"github.com/ardanlabs/usdl/api/clients/tui/ui"
 	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
 	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
 	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/foundation/agents"
+	"github.com/ardanlabs/usdl/foundation/agents/fakellm"
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
 	"github.com/ardanlabs/usdl/foundation/agents/ollamallm"
+	"github.com/ardanlabs/usdl/foundation/agents/openaillm"
 	"github.com/ardanlabs/usdl/foundation/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/golang-jwt/jwt/v4"
 )
 
 const (
-	url            = "localhost:3000"
 	configFilePath = "zarf/client"
 	keysFolder     = "zarf/client/id/"
 	activeKID      = "key"
@@ -31,9 +39,71 @@ func main() {
 }
 
 func run() error {
-	id, err := client.NewID(configFilePath)
+	cfg := struct {
+		conf.Version
+		AIMode     bool   `conf:"default:false,flag:aimode"`
+		Passphrase string `conf:"mask,help:unlocks the keys instead of asking for it"`
+		Restore    bool   `conf:"default:false,help:recreate the keys from the backup words"`
+		Agent      struct {
+			Backend     string `conf:"default:ollama,help:ollama or openai or fake"`
+			Model       string `conf:"help:defaults to llama3.2:latest for ollama"`
+			BaseURL     string
+			APIKey      string  `conf:"mask"`
+			Temperature float64 `conf:"default:1.0"`
+			MaxTokens   int     `conf:"default:500"`
+			Recall      int     `conf:"default:500,help:token budget for relevant older messages (0 is off)"`
+		}
+		Guard struct {
+			Allow      []string      `conf:"help:addresses the agent may answer (all when empty)"`
+			MaxReplies int           `conf:"default:10,help:replies to one contact per window (0 is no limit)"`
+			Window     time.Duration `conf:"default:10m"`
+			MaxTurns   int           `conf:"default:5,help:replies in a row before the agent assumes a loop"`
+			Similarity float64       `conf:"default:0.9,help:word overlap with a reply that counts as an echo"`
+			Pause      time.Duration `conf:"default:30s,help:how long typing pauses the agent"`
+		}
+		CAP struct {
+			URL      string `conf:"default:http://localhost:3000"`
+			CAFile   string
+			CertFile string
+			KeyFile  string
+		}
+	}{
+		Version: conf.Version{
+			Build: "develop",
+			Desc:  "TUI",
+		},
+	}
+
+	const prefix = "TUI"
+	help, err := conf.Parse(prefix, &cfg)
 	if err != nil {
-		return fmt.Errorf("id: %w", err)
+		if errors.Is(err, conf.ErrHelpWanted) {
+			fmt.Println(help)
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	var id client.ID
+
+	switch {
+	case cfg.Restore:
+		if id, err = client.PromptRestore(configFilePath, cfg.Passphrase); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+
+		// History left by the lost keys can't be read with the new storage
+		// key, an archive brings it back with /import.
+		if err := dbfile.SetAsideHistory(configFilePath); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+
+	default:
+		if id, err = client.UnlockID(configFilePath, cfg.Passphrase); err != nil {
+			return fmt.Errorf("id: %w", err)
+		}
 	}
 
 	// -------------------------------------------------------------------------
@@ -77,12 +147,24 @@ func run() error {
 
 	// -------------------------------------------------------------------------
 
-	agent, err := ollamallm.New(db.MyAccount().ProfilePath)
+	profile, err := agents.LoadProfile(db.MyAccount().ProfilePath)
 	if err != nil {
-		return fmt.Errorf("ollama agent: %w", err)
+		return fmt.Errorf("agent: %w", err)
 	}
 
-	// If we can't connect to the ollama agent, we can use it.
+	agent, err := newAgent(cfg.Agent.Backend, agents.Config{
+		Profile:     profile,
+		Model:       cfg.Agent.Model,
+		BaseURL:     cfg.Agent.BaseURL,
+		APIKey:      cfg.Agent.APIKey,
+		Temperature: cfg.Agent.Temperature,
+		MaxTokens:   cfg.Agent.MaxTokens,
+	})
+	if err != nil {
+		return fmt.Errorf("agent: %w", err)
+	}
+
+	// If we can't connect to the agent, we can't use it.
 	fmt.Println("warming up the agent...")
 	if _, err := agent.Chat(context.Background(), "warm up", nil); err != nil {
 		agent = nil
@@ -90,12 +172,67 @@ func run() error {
 
 	// -------------------------------------------------------------------------
 
-	ui := ui.New(id.MyAccountID, agent)
+	allow, err := guard.ParseAddresses(cfg.Guard.Allow)
+	if err != nil {
+		return fmt.Errorf("guard: %w", err)
+	}
+
+	g := guard.New(guard.Config{
+		Allow:      allow,
+		MaxReplies: cfg.Guard.MaxReplies,
+		Window:     cfg.Guard.Window,
+		MaxTurns:   cfg.Guard.MaxTurns,
+		Similarity: cfg.Guard.Similarity,
+		Pause:      cfg.Guard.Pause,
+	})
+
+	ui := ui.New(id.MyAccountID, agent, g)
+	ui.SetMemory(db, cfg.Agent.Recall)
+	ui.SetPassphrase(func(oldPassphrase string, newPassphrase string) error {
+		return client.ChangePassphrase(configFilePath, oldPassphrase, newPassphrase)
+	})
+	ui.SetBackup(func(passphrase string) (string, bool, error) {
+		return client.Backup(configFilePath, passphrase)
+	})
+	ui.SetExport(func(path string, passphrase string) error {
+		data, err := db.Export(passphrase)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(path, data, 0600)
+	})
+	ui.SetImport(func(path string, passphrase string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return db.Import(data, passphrase)
+	})
+
+	if cfg.AIMode {
+		ui.ToggleAgent()
+	}
+
+	var options []client.Option
+
+	if strings.HasPrefix(cfg.CAP.URL, "https://") {
+		tlsConfig, err := client.NewTLSConfig(cfg.CAP.CAFile, cfg.CAP.CertFile, cfg.CAP.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls config: %w", err)
+		}
+
+		options = append(options, client.WithTLSConfig(tlsConfig))
+	}
 
-	app := client.NewApp(db, id, url, ui, tkn)
+	app := client.NewApp(db, id, cfg.CAP.URL, ui, tkn, filepath.Join(configFilePath, "transfers"), options...)
 	defer app.Close()
 
 	ui.SetApp(app)
+	ui.SetRotate(func(passphrase string) (common.Address, error) {
+		return app.RotateID(context.Background(), configFilePath, passphrase)
+	})
 
 	// -------------------------------------------------------------------------
 
@@ -103,9 +240,31 @@ func run() error {
 		return fmt.Errorf("handshake: %w", err)
 	}
 
+	if cfg.Restore {
+		if err := app.AnnounceKey(); err != nil {
+			fmt.Println("some contacts weren't sent the key:", err)
+		}
+	}
+
 	if err := app.Run(); err != nil {
 		return fmt.Errorf("run: %w", err)
 	}
 
 	return nil
 }
+
+// newAgent constructs the agent for the backend.
+func newAgent(backend string, cfg agents.Config) (agents.Agent, error) {
+	switch backend {
+	case "ollama":
+		return ollamallm.New(cfg)
+
+	case "openai":
+		return openaillm.New(cfg)
+
+	case "fake":
+		return fakellm.New(), nil
+	}
+
+	return nil, fmt.Errorf("unknown backend: %q", backend)
+}
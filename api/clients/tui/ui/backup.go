@@ -0,0 +1,182 @@
+package ui
+
+import (
+	"github.com/rivo/tview"
+)
+
+// BackupFunc returns the mnemonic that restores the account, and reports if
+// restoring it gives the account a new encryption key.
+type BackupFunc func(passphrase string) (words string, newKey bool, err error)
+
+// ExportFunc writes the contacts and history to an archive at the path,
+// encrypted with the passphrase.
+type ExportFunc func(path string, passphrase string) error
+
+// ImportFunc adds the contacts and history in the archive at the path,
+// decrypted with the passphrase.
+type ImportFunc func(path string, passphrase string) error
+
+// backupForm asks for the passphrase and shows the words that restore the
+// account. It must be called on the tview goroutine.
+func (ui *TUI) backupForm() {
+	if ui.backup == nil {
+		ui.writeSystem("Backing up the keys isn't supported")
+		return
+	}
+
+	const page = "backup"
+
+	var passphrase string
+
+	form := tview.NewForm().
+		AddTextView("", "Show the words that restore your account. Anyone who sees them can take it over.", 40, 3, true, false).
+		AddPasswordField("Passphrase", "", 28, '*', func(text string) { passphrase = text })
+
+	done := func() {
+		ui.pages.RemovePage(page)
+		ui.tviewApp.SetFocus(ui.textArea)
+	}
+
+	form.AddButton("Show", func() {
+		done()
+
+		words, newKey, err := ui.backup(passphrase)
+		if err != nil {
+			ui.writeSystem("Error with backup: %s", err)
+			return
+		}
+
+		text := "Write these words down and keep them safe:\n\n" + words
+		if newKey {
+			text += "\n\nYour encryption key predates backups, so restoring gives you a new one and your contacts will see it change."
+		}
+
+		ui.showWords(text)
+	})
+
+	form.AddButton("Cancel", done)
+	form.SetCancelFunc(done)
+
+	form.SetBorder(true).SetTitle("Backup")
+
+	ui.pages.AddPage(page, centered(form, 46, 12), true, true)
+	ui.tviewApp.SetFocus(form)
+}
+
+// showWords shows the backup words until the user closes them.
+func (ui *TUI) showWords(text string) {
+	const page = "words"
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Done"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage(page)
+			ui.tviewApp.SetFocus(ui.textArea)
+		})
+
+	ui.pages.AddPage(page, modal, false, true)
+	ui.tviewApp.SetFocus(modal)
+}
+
+// exportForm asks for a passphrase for the archive and exports the contacts
+// and history to the path. It must be called on the tview goroutine.
+func (ui *TUI) exportForm(path string) {
+	if ui.export == nil {
+		ui.writeSystem("Exporting isn't supported")
+		return
+	}
+
+	if path == "" {
+		ui.writeSystem("Error with export: usage /export <path>")
+		return
+	}
+
+	const page = "export"
+
+	var passphrase, confirm string
+
+	form := tview.NewForm().
+		AddPasswordField("Passphrase", "", 30, '*', func(text string) { passphrase = text }).
+		AddPasswordField("Repeat", "", 30, '*', func(text string) { confirm = text })
+
+	done := func() {
+		ui.pages.RemovePage(page)
+		ui.tviewApp.SetFocus(ui.textArea)
+	}
+
+	form.AddButton("Export", func() {
+		done()
+
+		switch {
+		case passphrase == "":
+			ui.writeSystem("Error with export: the passphrase can't be empty")
+			return
+
+		case passphrase != confirm:
+			ui.writeSystem("Error with export: the passphrases don't match")
+			return
+		}
+
+		if err := ui.export(path, passphrase); err != nil {
+			ui.writeSystem("Error with export: %s", err)
+			return
+		}
+
+		ui.writeSystem("Exported the contacts and history to %s", path)
+	})
+
+	form.AddButton("Cancel", done)
+	form.SetCancelFunc(done)
+
+	form.SetBorder(true).SetTitle("Export Archive")
+
+	ui.pages.AddPage(page, centered(form, 46, 9), true, true)
+	ui.tviewApp.SetFocus(form)
+}
+
+// importForm asks for the archive's passphrase and imports the contacts and
+// history from the path. It must be called on the tview goroutine.
+func (ui *TUI) importForm(path string) {
+	if ui.importArchive == nil {
+		ui.writeSystem("Importing isn't supported")
+		return
+	}
+
+	if path == "" {
+		ui.writeSystem("Error with import: usage /import <path>")
+		return
+	}
+
+	const page = "import"
+
+	var passphrase string
+
+	form := tview.NewForm().
+		AddPasswordField("Passphrase", "", 30, '*', func(text string) { passphrase = text })
+
+	done := func() {
+		ui.pages.RemovePage(page)
+		ui.tviewApp.SetFocus(ui.textArea)
+	}
+
+	form.AddButton("Import", func() {
+		done()
+
+		if err := ui.importArchive(path, passphrase); err != nil {
+			ui.writeSystem("Error with import: %s", err)
+			return
+		}
+
+		ui.listContacts()
+		ui.writeSystem("Imported the contacts and history from %s", path)
+	})
+
+	form.AddButton("Cancel", done)
+	form.SetCancelFunc(done)
+
+	form.SetBorder(true).SetTitle("Import Archive")
+
+	ui.pages.AddPage(page, centered(form, 46, 7), true, true)
+	ui.tviewApp.SetFocus(form)
+}
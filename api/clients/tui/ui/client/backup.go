@@ -0,0 +1,155 @@
+package client
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ardanlabs/usdl/foundation/mnemonic"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The identity key is backed up as a 24 word mnemonic of the key itself, and
+// the X25519 key is derived from the mnemonic's seed, so the words restore
+// both. The RSA key only protects local storage and a new one is made on
+// restore; the history moves with a storage archive instead.
+
+// ErrKeyFileExists is returned when restoring into a folder that still
+// holds a keyfile.
+var ErrKeyFileExists = errors.New("keyfile already exists")
+
+// msgKeyInfo binds the X25519 key derived from the seed to its use.
+const msgKeyInfo = "usdl x25519 message key"
+
+// Backup returns the mnemonic for the account in the folder. The passphrase
+// must open the keyfile. Accounts created before keys were derived from the
+// mnemonic get a new X25519 key when they're restored, which newKey
+// reports, so contacts will see the key change.
+func Backup(filePath string, passphrase string) (words string, newKey bool, err error) {
+	id, err := readKeyFile(filepath.Join(filePath, "id", keyFileName), passphrase)
+	if err != nil {
+		return "", false, err
+	}
+
+	words, err = mnemonic.New(crypto.FromECDSA(id.PrivKeyECDSA))
+	if err != nil {
+		return "", false, fmt.Errorf("mnemonic: %w", err)
+	}
+
+	pkX25519, err := deriveKeyX25519(words)
+	if err != nil {
+		return "", false, err
+	}
+
+	return words, !pkX25519.Equal(id.PrivKeyX25519), nil
+}
+
+// RestoreID recreates the account from the mnemonic in a keyfile encrypted
+// with the passphrase. The folder must not hold a keyfile already. Once
+// connected, AnnounceKey tells the contacts about the restored key.
+func RestoreID(filePath string, words string, passphrase string) (ID, error) {
+	dir := filepath.Join(filePath, "id")
+	os.MkdirAll(dir, os.ModePerm)
+
+	fileName := filepath.Join(dir, keyFileName)
+	if fileExists(fileName) {
+		return ID{}, ErrKeyFileExists
+	}
+
+	entropy, err := mnemonic.Entropy(words)
+	if err != nil {
+		return ID{}, err
+	}
+
+	pkECDSA, err := crypto.ToECDSA(entropy)
+	if err != nil {
+		return ID{}, fmt.Errorf("ecdsa key: %w", err)
+	}
+
+	pkRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return ID{}, fmt.Errorf("generating key: %w", err)
+	}
+
+	pkX25519, err := deriveKeyX25519(words)
+	if err != nil {
+		return ID{}, err
+	}
+
+	id, err := newID(crypto.PubkeyToAddress(pkECDSA.PublicKey), pkECDSA, pkRSA, pkX25519)
+	if err != nil {
+		return ID{}, err
+	}
+
+	secret, err := encodeKeys(id)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if err := writeKeyFile(fileName, secret, passphrase); err != nil {
+		return ID{}, err
+	}
+
+	if err := authKey(filepath.Join(dir, authFileName), id.PrivKeyRSA); err != nil {
+		return ID{}, fmt.Errorf("id: %w", err)
+	}
+
+	return id, nil
+}
+
+// AnnounceKey shares the encryption key with every contact, which also has
+// the contacts start new sessions since a restored account has none.
+func (app *App) AnnounceKey() error {
+	var errs []error
+	for _, usr := range app.db.Contacts() {
+		if usr.Group || usr.ID == (common.Address{}) {
+			continue
+		}
+
+		if err := app.SendMessageHandler(usr.ID, []byte("/share key")); err != nil {
+			errs = append(errs, fmt.Errorf("telling %s: %w", usr.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// =============================================================================
+
+// keyX25519For returns the X25519 key derived from the mnemonic of the
+// identity key.
+func keyX25519For(pkECDSA *ecdsa.PrivateKey) (*ecdh.PrivateKey, error) {
+	words, err := mnemonic.New(crypto.FromECDSA(pkECDSA))
+	if err != nil {
+		return nil, fmt.Errorf("mnemonic: %w", err)
+	}
+
+	return deriveKeyX25519(words)
+}
+
+func deriveKeyX25519(words string) (*ecdh.PrivateKey, error) {
+	seed, err := mnemonic.Seed(words, "")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(sha256.New, seed, nil, msgKeyInfo, 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+
+	pk, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("x25519 key: %w", err)
+	}
+
+	return pk, nil
+}
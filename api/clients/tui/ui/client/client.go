@@ -4,28 +4,32 @@ package client
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto/tls"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ardanlabs/usdl/app/sdk/errs"
 	"github.com/ardanlabs/usdl/foundation/signature"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 var ErrConnectionDropped = errors.New("connection dropped")
 
+// KeyUpdated is the note stored in a contact's history when the contact
+// shares a new key.
+const KeyUpdated = "** updated contact's key **"
+
 type MyAccount struct {
 	ID          common.Address
 	Name        string
@@ -36,6 +40,7 @@ type MyAccount struct {
 type Message struct {
 	From        common.Address
 	To          common.Address
+	Group       common.Address
 	Name        string
 	Content     [][]byte
 	DateCreated time.Time
@@ -45,9 +50,12 @@ type Message struct {
 type User struct {
 	ID           common.Address
 	Name         string
+	Group        bool
 	AppLastNonce uint64
 	LastNonce    uint64
 	Key          string
+	Verified     bool
+	KeyChanged   bool
 	TCPHost      string
 	Messages     []Message
 }
@@ -56,16 +64,27 @@ type Storage interface {
 	Contacts() []User
 	QueryContactByID(id common.Address) (User, error)
 	InsertContact(id common.Address, name string) (User, error)
+	InsertGroup(id common.Address, name string) (User, error)
+	DeleteContact(id common.Address) error
 	InsertMessage(id common.Address, msg Message) error
 	UpdateAppNonce(id common.Address, nonce uint64) error
 	UpdateContactNonce(id common.Address, nonce uint64) error
 	UpdateContactKey(id common.Address, key string) error
+	UpdateContactVerified(id common.Address, verified bool) error
+	UpdateContactKeyChanged(id common.Address, changed bool) error
+	QuerySession(id common.Address) ([]byte, error)
+	UpdateSession(id common.Address, data []byte) error
+	RotateContact(oldID common.Address, newID common.Address) (User, error)
+	RotateAccount(id ID) error
 }
 
 type UI interface {
 	Run() error // Must be non-blocking
 	WriteText(msg Message)
 	AddContact(id common.Address, name string)
+	AddGroup(id common.Address, name string)
+	RemoveContact(id common.Address)
+	TransferOffer(offer TransferOffer)
 	ApplyContactPrefix(id common.Address, option string, add bool)
 }
 
@@ -73,6 +92,7 @@ type UI interface {
 
 type outgoingMessage struct {
 	ToID      common.Address `json:"toID"`
+	Group     bool           `json:"group,omitempty"`
 	Encrypted bool           `json:"encrypted"`
 	Msg       [][]byte       `json:"msg"`
 	FromNonce uint64         `json:"fromNonce"`
@@ -88,32 +108,79 @@ type usr struct {
 }
 
 type incomingMessage struct {
-	From      usr      `json:"from"`
-	Encrypted bool     `json:"encrypted"`
-	Msg       [][]byte `json:"msg"`
+	From      usr            `json:"from"`
+	Group     common.Address `json:"group"`
+	Encrypted bool           `json:"encrypted"`
+	Msg       [][]byte       `json:"msg"`
 }
 
 // =============================================================================
 
 type App struct {
-	db   Storage
-	ui   UI
-	id   ID
-	url  string
-	jwt  string
-	conn *websocket.Conn
+	db           Storage
+	ui           UI
+	id           ID
+	url          string
+	jwt          string
+	client       *http.Client
+	dialer       *websocket.Dialer
+	conn         *websocket.Conn
+	done         chan struct{}
+	sendMu       sync.Mutex
+	capNonces    map[common.Address]uint64
+	transferPath string
+	transfers    map[uuid.UUID]*transfer
+	transfersMu  sync.Mutex
+	sessionMu    sync.Mutex
 }
 
-func NewApp(db Storage, id ID, url string, ui UI, jwt string) *App {
-	return &App{
-		db:  db,
-		ui:  ui,
-		id:  id,
-		jwt: jwt,
-		url: url,
+// Option represents a function that can alter the App during construction.
+type Option func(app *App)
+
+// WithTLSConfig uses the TLS configuration for the HTTP and WebSocket
+// connections to the CAP. Use NewTLSConfig to trust a private CA.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(app *App) {
+		transport := defaultClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg
+
+		app.client = &http.Client{
+			Transport: transport,
+		}
+
+		dialer := *websocket.DefaultDialer
+		dialer.TLSClientConfig = cfg
+		app.dialer = &dialer
 	}
 }
 
+// NewApp constructs the client app. The url is the base URL of the CAP, an
+// https url will use wss for the WebSocket connection. A url without a
+// scheme is treated as http.
+func NewApp(db Storage, id ID, url string, ui UI, jwt string, transferPath string, options ...Option) *App {
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	app := App{
+		db:           db,
+		ui:           ui,
+		id:           id,
+		jwt:          jwt,
+		url:          strings.TrimSuffix(url, "/"),
+		client:       &defaultClient,
+		dialer:       websocket.DefaultDialer,
+		transferPath: transferPath,
+		transfers:    make(map[uuid.UUID]*transfer),
+	}
+
+	for _, option := range options {
+		option(&app)
+	}
+
+	return &app
+}
+
 func (app *App) Close() error {
 	if app.conn == nil {
 		return nil
@@ -131,14 +198,20 @@ func (app *App) Run() error {
 }
 
 func (app *App) Handshake(acct MyAccount) error {
-	url := fmt.Sprintf("ws://%s/connect", app.url)
+	url, err := app.wsURL("/connect")
+	if err != nil {
+		return fmt.Errorf("ws url: %w", err)
+	}
 
 	requestHeader := http.Header{}
 	requestHeader.Add("Authorization", "Bearer "+app.jwt)
 
-	conn, resp, err := websocket.DefaultDialer.Dial(url, requestHeader)
+	conn, resp, err := app.dialer.Dial(url, requestHeader)
 	if err != nil {
-		return fmt.Errorf("dial: %w: %s", err, resp.Status)
+		if resp != nil {
+			return fmt.Errorf("dial: %w: %s", err, resp.Status)
+		}
+		return fmt.Errorf("dial: %w", err)
 	}
 
 	app.conn = conn
@@ -150,18 +223,39 @@ func (app *App) Handshake(acct MyAccount) error {
 		return fmt.Errorf("read: %w", err)
 	}
 
-	if string(msg) != "HELLO" {
+	challenge, found := strings.CutPrefix(string(msg), "HELLO ")
+	if !found {
 		return fmt.Errorf("unexpected message: %s", msg)
 	}
 
 	// -------------------------------------------------------------------------
+	// Prove we own the account by signing the challenge.
+
+	dataToSign := struct {
+		ID        common.Address
+		Challenge string
+	}{
+		ID:        app.id.MyAccountID,
+		Challenge: challenge,
+	}
+
+	v, r, s, err := signature.Sign(dataToSign, app.id.PrivKeyECDSA)
+	if err != nil {
+		return fmt.Errorf("signing challenge: %w", err)
+	}
 
 	user := struct {
-		ID   common.Address
-		Name string
+		ID   common.Address `json:"id"`
+		Name string         `json:"name"`
+		V    *big.Int       `json:"v"`
+		R    *big.Int       `json:"r"`
+		S    *big.Int       `json:"s"`
 	}{
 		ID:   app.id.MyAccountID,
 		Name: acct.Name,
+		V:    v,
+		R:    r,
+		S:    s,
 	}
 
 	data, err := json.Marshal(user)
@@ -175,19 +269,40 @@ func (app *App) Handshake(acct MyAccount) error {
 
 	// -------------------------------------------------------------------------
 
-	if _, _, err = conn.ReadMessage(); err != nil {
+	_, msg, err = conn.ReadMessage()
+	if err != nil {
 		return fmt.Errorf("read: %w", err)
 	}
 
+	if !strings.HasPrefix(string(msg), "WELCOME") {
+		return fmt.Errorf("handshake rejected: %s", msg)
+	}
+
+	// Storage restored without an archive starts the nonces over, which
+	// the CAP would reject, so carry on from the nonces the CAP has.
+	if err := app.syncNonces(context.Background()); err != nil {
+		app.ui.WriteText(errorMessage("sync nonces: %s", err))
+	}
+
 	// -------------------------------------------------------------------------
 
+	done := make(chan struct{})
+	app.done = done
+
 	go func() {
+		defer close(done)
 		app.ReceiveCapMessage(conn)
 	}()
 
 	return nil
 }
 
+// Done returns a channel that is closed when the app stops receiving
+// messages from the CAP.
+func (app *App) Done() <-chan struct{} {
+	return app.done
+}
+
 // =============================================================================
 
 func (app *App) ReceiveCapMessage(conn *websocket.Conn) {
@@ -201,7 +316,25 @@ func (app *App) ReceiveCapMessage(conn *websocket.Conn) {
 		var inMsg incomingMessage
 		if err := json.Unmarshal(rawMsg, &inMsg); err != nil {
 			app.ui.WriteText(errorMessage("unmarshal: %s", err))
-			return
+			continue
+		}
+
+		// System events come from the CAP, which sends them from the zero
+		// address. A contact can't sign a message from it, so a contact's
+		// EVENT frame is treated like any other message.
+		if inMsg.From.ID == (common.Address{}) {
+			if err := app.preprocessRecvEvent(inMsg); err != nil {
+				app.ui.WriteText(errorMessage("preprocess event: %s", err))
+			}
+			continue
+		}
+
+		// Group messages belong to the group's conversation.
+		if inMsg.Group != (common.Address{}) {
+			if err := app.preprocessRecvGroupMessage(inMsg); err != nil {
+				app.ui.WriteText(errorMessage("preprocess group message: %s", err))
+			}
+			continue
 		}
 
 		user, err := app.db.QueryContactByID(inMsg.From.ID)
@@ -223,29 +356,36 @@ func (app *App) ReceiveCapMessage(conn *websocket.Conn) {
 
 		if len(inMsg.Msg) == 0 {
 			app.ui.WriteText(errorMessage("no message"))
-			return
+			continue
 		}
 
 		// -----------------------------------------------------------------
 
-		if string(inMsg.Msg[0]) != "EVENT" {
-			expNonce := user.LastNonce + 1
-			if inMsg.From.Nonce < expNonce {
-				app.ui.WriteText(errorMessage("invalid nonce: possible security issue with contact: got: %d, exp: %d", inMsg.From.Nonce, expNonce))
-				return
-			}
+		// The CAP rejects replays, so a stale nonce here means the message
+		// is dropped but the connection stays up. Session messages can
+		// arrive out of order over different transports and the session
+		// rejects replays itself.
+		expNonce := user.LastNonce + 1
+		if inMsg.From.Nonce < expNonce && !isSessionMessage(inMsg.Msg) {
+			app.ui.WriteText(errorMessage("invalid nonce: possible security issue with contact: got: %d, exp: %d", inMsg.From.Nonce, expNonce))
+			continue
+		}
 
-			if err := app.db.UpdateContactNonce(inMsg.From.ID, expNonce); err != nil {
+		if inMsg.From.Nonce >= expNonce {
+			if err := app.db.UpdateContactNonce(inMsg.From.ID, inMsg.From.Nonce); err != nil {
 				app.ui.WriteText(errorMessage("update app nonce: %s", err))
 				return
 			}
 		}
 
 		// ---------------------------------------------------------------------
+		// A message that can't be read, like a replayed session message or a
+		// bad key, is dropped so it doesn't cut the user off. Only failing to
+		// read the connection or update the contact ends the loop.
 
 		if err := app.preprocessRecvMessage(inMsg); err != nil {
 			app.ui.WriteText(errorMessage("preprocess message: %s", err))
-			return
+			continue
 		}
 	}
 }
@@ -264,20 +404,16 @@ func (app *App) SendMessageHandler(to common.Address, msg []byte) error {
 		return fmt.Errorf("query contact: %w", err)
 	}
 
-	// -------------------------------------------------------------------------
-	// Split message into chunks of 240 bytes so they can be encrypted.
-
-	const maxBytes = 240
-	var msgs [][]byte
-	chunks := (len(msg) / maxBytes) + 1 // Calculate the number of chunks.
-	var start int
+	if usr.Group && msg[0] == '/' {
+		return fmt.Errorf("commands can't be sent to a group")
+	}
 
-	for range chunks {
-		end := min(start+maxBytes, len(msg))
-		msgs = append(msgs, msg[start:end])
-		start = start + maxBytes
+	if usr.KeyChanged && msg[0] != '/' {
+		return ErrKeyChanged
 	}
 
+	msgs := [][]byte{msg}
+
 	onWire, onScreen, err := app.preprocessSendMessage(usr, msgs)
 	if err != nil {
 		return fmt.Errorf("preprocess message: %w", err)
@@ -285,14 +421,60 @@ func (app *App) SendMessageHandler(to common.Address, msg []byte) error {
 
 	// -------------------------------------------------------------------------
 
-	nonce := usr.AppLastNonce + 1
+	var encrypted bool
+	if usr.Key != "" {
+		encrypted = true
+	}
+
+	if err := app.sendSigned(usr, onWire, encrypted); err != nil {
+		return err
+	}
+
+	// -------------------------------------------------------------------------
+
+	if msg[0] != '/' {
+		msg := Message{
+			From:      app.id.MyAccountID,
+			To:        to,
+			Name:      "You",
+			Content:   onScreen,
+			Encrypted: encrypted,
+		}
+
+		if err := app.db.InsertMessage(to, msg); err != nil {
+			return fmt.Errorf("add message: %w", err)
+		}
+
+		app.ui.WriteText(msg)
+	}
+
+	return nil
+}
+
+// sendSigned signs the message with the next nonce for the contact and
+// sends it to the CAP. Transfers send from their own goroutines so the
+// nonce is read under the lock.
+func (app *App) sendSigned(usr User, onWire [][]byte, encrypted bool) error {
+	if app.conn == nil {
+		return fmt.Errorf("no connection")
+	}
+
+	app.sendMu.Lock()
+	defer app.sendMu.Unlock()
+
+	usr, err := app.db.QueryContactByID(usr.ID)
+	if err != nil {
+		return fmt.Errorf("query contact: %w", err)
+	}
+
+	nonce := max(usr.AppLastNonce, app.capNonces[usr.ID]) + 1
 
 	dataToSign := struct {
 		ToID      common.Address
 		Msg       [][]byte
 		FromNonce uint64
 	}{
-		ToID:      to,
+		ToID:      usr.ID,
 		Msg:       onWire,
 		FromNonce: nonce,
 	}
@@ -302,13 +484,9 @@ func (app *App) SendMessageHandler(to common.Address, msg []byte) error {
 		return fmt.Errorf("signing: %w", err)
 	}
 
-	var encrypted bool
-	if usr.Key != "" {
-		encrypted = true
-	}
-
 	outMsg := outgoingMessage{
-		ToID:      to,
+		ToID:      usr.ID,
+		Group:     usr.Group,
 		Encrypted: encrypted,
 		Msg:       onWire,
 		FromNonce: nonce,
@@ -328,28 +506,10 @@ func (app *App) SendMessageHandler(to common.Address, msg []byte) error {
 
 	// -------------------------------------------------------------------------
 
-	if err := app.db.UpdateAppNonce(to, nonce); err != nil {
+	if err := app.db.UpdateAppNonce(usr.ID, nonce); err != nil {
 		return fmt.Errorf("update app nonce: %w", err)
 	}
 
-	// -------------------------------------------------------------------------
-
-	if msg[0] != '/' {
-		msg := Message{
-			From:      app.id.MyAccountID,
-			To:        to,
-			Name:      "You",
-			Content:   onScreen,
-			Encrypted: encrypted,
-		}
-
-		if err := app.db.InsertMessage(to, msg); err != nil {
-			return fmt.Errorf("add message: %w", err)
-		}
-
-		app.ui.WriteText(msg)
-	}
-
 	return nil
 }
 
@@ -382,12 +542,31 @@ var defaultClient = http.Client{
 	},
 }
 
+// syncNonces fetches the last nonces the CAP accepted from us, which are
+// used for contacts whose stored nonce is behind.
+func (app *App) syncNonces(ctx context.Context) error {
+	var resp struct {
+		Nonces map[common.Address]uint64 `json:"nonces"`
+	}
+
+	if err := app.transferDo(ctx, http.MethodGet, app.url+"/nonces", app.jwt, nil, "", &resp); err != nil {
+		return err
+	}
+
+	app.sendMu.Lock()
+	defer app.sendMu.Unlock()
+
+	app.capNonces = resp.Nonces
+
+	return nil
+}
+
 type StateResponse struct {
 	TCPConnections []common.Address `json:"tcp_connections"`
 }
 
 func (app *App) GetState(ctx context.Context) (StateResponse, error) {
-	url := fmt.Sprintf("http://%s/state", app.url)
+	url := app.url + "/state"
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
@@ -398,7 +577,7 @@ func (app *App) GetState(ctx context.Context) (StateResponse, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Add("Authorization", "Bearer "+app.jwt)
 
-	resp, err := defaultClient.Do(req)
+	resp, err := app.client.Do(req)
 	if err != nil {
 		return StateResponse{}, fmt.Errorf("do: error: %w", err)
 	}
@@ -441,7 +620,7 @@ func (app *App) EstablishTCPConnection(ctx context.Context, tuiUserID common.Add
 		return fmt.Errorf("encoding error: %w", err)
 	}
 
-	url := fmt.Sprintf("http://%s/tcpconnectdrop", app.url)
+	url := app.url + "/tcpconnectdrop"
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &b)
 	if err != nil {
@@ -452,7 +631,7 @@ func (app *App) EstablishTCPConnection(ctx context.Context, tuiUserID common.Add
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Add("Authorization", "Bearer "+app.jwt)
 
-	resp, err := defaultClient.Do(req)
+	resp, err := app.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("do: error: %w", err)
 	}
@@ -489,43 +668,78 @@ func (app *App) EstablishTCPConnection(ctx context.Context, tuiUserID common.Add
 
 // =============================================================================
 
-func (app *App) preprocessRecvMessage(inMsg incomingMessage) error {
+// preprocessRecvEvent shows a system event from the CAP. The fields after
+// the event name are optional, so a short frame can't crash the receive
+// loop.
+func (app *App) preprocessRecvEvent(inMsg incomingMessage) error {
 	msgs := inMsg.Msg
 
-	if len(msgs) == 0 {
-		return fmt.Errorf("no message")
+	if len(msgs) < 2 || string(msgs[0]) != "EVENT" {
+		return fmt.Errorf("not an event")
 	}
 
-	// -------------------------------------------------------------------------
-	// Process Event Message
-
-	if string(msgs[0]) == "EVENT" {
-		switch string(msgs[1]) {
-		case "TCP-CONN":
-			app.ui.WriteText(Message{
-				From:    inMsg.From.ID,
-				To:      app.id.MyAccountID,
-				Name:    "system",
-				Content: [][]byte{[]byte("TCP connection established from: " + inMsg.From.ID.String())},
-			})
-
-			app.ui.ApplyContactPrefix(inMsg.From.ID, "->", true)
-
-		case "TCP-DROP":
-			app.ui.WriteText(Message{
-				From:    inMsg.From.ID,
-				To:      app.id.MyAccountID,
-				Name:    "system",
-				Content: [][]byte{[]byte("TCP connection dropped from: " + inMsg.From.ID.String())},
-			})
-
-			app.ui.ApplyContactPrefix(inMsg.From.ID, "->", false)
-
-		default:
-			return fmt.Errorf("unknown event: %s", string(inMsg.Msg[0]))
+	field := func(i int) string {
+		if len(msgs) > i {
+			return string(msgs[i])
 		}
+		return ""
+	}
 
-		return nil
+	switch string(msgs[1]) {
+	case "TCP-CONN":
+		peerID := common.HexToAddress(field(2))
+
+		app.ui.WriteText(Message{
+			From:    peerID,
+			To:      app.id.MyAccountID,
+			Name:    "system",
+			Content: [][]byte{[]byte("TCP connection established from: " + peerID.String())},
+		})
+
+		app.ui.ApplyContactPrefix(peerID, "->", true)
+
+	case "TCP-DROP":
+		peerID := common.HexToAddress(field(2))
+
+		app.ui.WriteText(Message{
+			From:    peerID,
+			To:      app.id.MyAccountID,
+			Name:    "system",
+			Content: [][]byte{[]byte("TCP connection dropped from: " + peerID.String())},
+		})
+
+		app.ui.ApplyContactPrefix(peerID, "->", false)
+
+	case "GROUP-ERROR":
+		app.ui.WriteText(Message{
+			Name:    "system",
+			Content: [][]byte{[]byte("group " + field(2) + ": " + field(3))},
+		})
+
+	case "NONCE-ERROR":
+		app.ui.WriteText(Message{
+			Name:    "system",
+			Content: [][]byte{[]byte("message to " + field(2) + " rejected: " + field(3))},
+		})
+
+	case "MAILBOX":
+		app.ui.WriteText(Message{
+			Name:    "system",
+			Content: [][]byte{[]byte(field(2) + " queued message(s) delivered while you were offline")},
+		})
+
+	default:
+		return fmt.Errorf("unknown event: %s", string(msgs[1]))
+	}
+
+	return nil
+}
+
+func (app *App) preprocessRecvMessage(inMsg incomingMessage) error {
+	msgs := inMsg.Msg
+
+	if len(msgs) == 0 || len(msgs[0]) == 0 {
+		return fmt.Errorf("no message")
 	}
 
 	// -------------------------------------------------------------------------
@@ -549,15 +763,9 @@ func (app *App) preprocessRecvMessage(inMsg incomingMessage) error {
 			return nil
 		}
 
-		decryptedData := make([][]byte, len(msgs))
-
-		for i, msg := range msgs {
-			dd, err := rsa.DecryptPKCS1v15(rand.Reader, app.id.PrivKeyRSA, []byte(msg))
-			if err != nil {
-				return fmt.Errorf("decrypting message: %w", err)
-			}
-
-			decryptedData[i] = dd
+		decryptedData, err := app.openMessage(inMsg.From.ID, msgs)
+		if err != nil {
+			return err
 		}
 
 		msg := Message{
@@ -587,16 +795,45 @@ func (app *App) preprocessRecvMessage(inMsg incomingMessage) error {
 	}
 
 	switch parts[0] {
+	case "file":
+		return app.preprocessRecvFile(inMsg, parts)
+
+	case "rotate":
+		return app.preprocessRecvRotation(inMsg, msgStr[8:])
+
 	case "key":
-		if err := app.db.UpdateContactKey(inMsg.From.ID, msgStr[5:]); err != nil {
-			return fmt.Errorf("updating key: %w", err)
+		data := msgStr[5:]
+
+		key, err := parseKeyBundle(inMsg.From.ID, data)
+		if err != nil {
+			return fmt.Errorf("reading key: %w", err)
+		}
+
+		usr, err := app.db.QueryContactByID(inMsg.From.ID)
+		if err != nil {
+			return fmt.Errorf("query contact: %w", err)
+		}
+
+		changed, err := app.pinContactKey(usr, key, isKeyBundle(data))
+		if err != nil {
+			return err
+		}
+
+		// A contact shares its key again after restoring its account, when
+		// the sessions it had are gone.
+		if err := app.restartSession(usr.ID); err != nil {
+			return err
+		}
+
+		if changed {
+			return app.writeKeyChanged(usr)
 		}
 
 		msg := Message{
 			From:      inMsg.From.ID,
 			To:        app.id.MyAccountID,
 			Name:      inMsg.From.Name,
-			Content:   [][]byte{[]byte("** updated contact's key **")},
+			Content:   [][]byte{[]byte(KeyUpdated)},
 			Encrypted: false,
 		}
 
@@ -622,20 +859,9 @@ func (app *App) preprocessSendMessage(usr User, msgs [][]byte) (onWire [][]byte,
 			return msgs, msgs, nil
 		}
 
-		publicKey, err := getPublicKey(usr.Key)
+		encryptedData, err := app.sealMessage(usr, msgs)
 		if err != nil {
-			return nil, nil, fmt.Errorf("unable to read public key: %w", err)
-		}
-
-		encryptedData := make([][]byte, len(msgs))
-
-		for i, msg := range msgs {
-			ed, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, msg)
-			if err != nil {
-				return nil, nil, fmt.Errorf("encrypting message: %w", err)
-			}
-
-			encryptedData[i] = ed
+			return nil, nil, err
 		}
 
 		return encryptedData, msgs, nil
@@ -657,11 +883,21 @@ func (app *App) preprocessSendMessage(usr User, msgs [][]byte) (onWire [][]byte,
 	case "share":
 		switch parts[1] {
 		case "key":
-			if app.id.PubKeyRSA == "" {
+			if app.id.PubKeyX25519 == "" {
 				return nil, nil, fmt.Errorf("no key to share")
 			}
 
-			errMsg := fmt.Appendf(nil, "/key %s", app.id.PubKeyRSA)
+			bundle, err := newKeyBundle(app.id, app.id.PubKeyX25519)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			data, err := json.Marshal(bundle)
+			if err != nil {
+				return nil, nil, fmt.Errorf("marshal key: %w", err)
+			}
+
+			errMsg := fmt.Appendf(nil, "/key %s", data)
 
 			return [][]byte{errMsg}, [][]byte{errMsg}, nil
 		}
@@ -672,16 +908,21 @@ func (app *App) preprocessSendMessage(usr User, msgs [][]byte) (onWire [][]byte,
 
 // =============================================================================
 
-func getPublicKey(pemBlock string) (*rsa.PublicKey, error) {
-	block, _ := pem.Decode([]byte(pemBlock))
-	if block == nil {
-		return nil, errors.New("invalid key: Key must be a PEM encoded PKCS1 or PKCS8 key")
+// wsURL converts the CAP url into the WebSocket url for the path.
+func (app *App) wsURL(path string) (string, error) {
+	u, err := url.Parse(app.url + path)
+	if err != nil {
+		return "", err
 	}
 
-	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("parse public key: %w", err)
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported scheme: %s", u.Scheme)
 	}
 
-	return publicKey.(*rsa.PublicKey), nil
+	return u.String(), nil
 }
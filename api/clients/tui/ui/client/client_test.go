@@ -0,0 +1,192 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbmem"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+// TestEvents provides a test of the client handling event frames it can't
+// trust or that are missing fields.
+func TestEvents(t *testing.T) {
+	t.Log("Given the need to only show system events the CAP sent.")
+	{
+		id, err := client.GenerateID()
+		if err != nil {
+			t.Fatalf("\tShould be able to generate an id: %s. %s", err, "X")
+		}
+
+		bob := common.HexToAddress("0x6fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9")
+
+		frames := []frame{
+			{From: bob, Nonce: 1, Msg: []string{"EVENT", "NONCE-ERROR"}},
+			{From: bob, Nonce: 2, Msg: []string{"EVENT", "GROUP-ERROR"}},
+			{Msg: []string{"EVENT", "NONCE-ERROR"}},
+			{Msg: []string{"EVENT", "GROUP-ERROR"}},
+			{Msg: []string{"EVENT"}},
+			{Msg: []string{"EVENT", "MAILBOX", "1"}},
+		}
+
+		ui := &recordUI{}
+		app := client.NewApp(dbmem.NewDB(id, "alice", "jwt"), id, "localhost", ui, "jwt", t.TempDir())
+
+		receive(t, app, frames)
+
+		msgs := ui.messages()
+
+		if !contains(msgs, bob, "EVENTNONCE-ERROR") {
+			t.Fatalf("\tShould show a contact's EVENT frame as a message from the contact, got %q. %s", texts(msgs), "X")
+		}
+		t.Log("\tShould show a contact's EVENT frame as a message from the contact.", "OK")
+
+		if !contains(msgs, common.Address{}, "message to  rejected: ") {
+			t.Fatalf("\tShould show a short NONCE-ERROR event, got %q. %s", texts(msgs), "X")
+		}
+		t.Log("\tShould show a short NONCE-ERROR event.", "OK")
+
+		if !contains(msgs, bob, "EVENTGROUP-ERROR") {
+			t.Fatalf("\tShould show a contact's GROUP-ERROR frame as a message from the contact, got %q. %s", texts(msgs), "X")
+		}
+
+		if !contains(msgs, common.Address{}, "group : ") {
+			t.Fatalf("\tShould show a short GROUP-ERROR event, got %q. %s", texts(msgs), "X")
+		}
+		t.Log("\tShould show a short GROUP-ERROR event.", "OK")
+
+		if !contains(msgs, common.Address{}, "preprocess event: not an event") {
+			t.Fatalf("\tShould reject an event without a name, got %q. %s", texts(msgs), "X")
+		}
+		t.Log("\tShould reject an event without a name.", "OK")
+
+		if !contains(msgs, common.Address{}, "1 queued message(s) delivered while you were offline") {
+			t.Fatalf("\tShould keep receiving after the short events, got %q. %s", texts(msgs), "X")
+		}
+		t.Log("\tShould keep receiving after the short events.", "OK")
+	}
+}
+
+// =============================================================================
+
+// frame is a message the test CAP sends to the client.
+type frame struct {
+	From  common.Address
+	Nonce uint64
+	Msg   []string
+}
+
+// receive serves the frames over a WebSocket and runs the client's receive
+// loop until the connection is closed.
+func receive(t *testing.T, app *client.App, frames []frame) {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for _, f := range frames {
+			msg := make([][]byte, len(f.Msg))
+			for i, m := range f.Msg {
+				msg[i] = []byte(m)
+			}
+
+			out := struct {
+				From struct {
+					ID    common.Address `json:"id"`
+					Name  string         `json:"name"`
+					Nonce uint64         `json:"nonce"`
+				} `json:"from"`
+				Msg [][]byte `json:"msg"`
+			}{
+				Msg: msg,
+			}
+			out.From.ID = f.From
+			out.From.Name = "bob"
+			out.From.Nonce = f.Nonce
+
+			if err := conn.WriteJSON(out); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("\tShould be able to dial the test CAP: %s. %s", err, "X")
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.ReceiveCapMessage(conn)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("\tShould stop receiving once the connection is closed. %s", "X")
+	}
+}
+
+func contains(msgs []client.Message, from common.Address, text string) bool {
+	for _, msg := range msgs {
+		if msg.From == from && client.StitchMessages(msg.Content) == text {
+			return true
+		}
+	}
+
+	return false
+}
+
+func texts(msgs []client.Message) []string {
+	var texts []string
+	for _, msg := range msgs {
+		texts = append(texts, msg.Name+": "+client.StitchMessages(msg.Content))
+	}
+
+	return texts
+}
+
+// =============================================================================
+
+// recordUI records what the client app displays.
+type recordUI struct {
+	mu   sync.Mutex
+	msgs []client.Message
+}
+
+func (ui *recordUI) Run() error {
+	return nil
+}
+
+func (ui *recordUI) WriteText(msg client.Message) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.msgs = append(ui.msgs, msg)
+}
+
+func (ui *recordUI) AddContact(id common.Address, name string)                     {}
+func (ui *recordUI) AddGroup(id common.Address, name string)                       {}
+func (ui *recordUI) RemoveContact(id common.Address)                               {}
+func (ui *recordUI) TransferOffer(offer client.TransferOffer)                      {}
+func (ui *recordUI) ApplyContactPrefix(id common.Address, option string, add bool) {}
+
+func (ui *recordUI) messages() []client.Message {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	return append([]client.Message(nil), ui.msgs...)
+}
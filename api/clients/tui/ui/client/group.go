@@ -0,0 +1,197 @@
+package client
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// CreateGroup creates a new group owned by this user. The group ID is a
+// random address so it can be used like any other contact.
+func (app *App) CreateGroup(name string) (common.Address, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return common.Address{}, fmt.Errorf("group name cannot be empty")
+	}
+
+	var groupID common.Address
+	if _, err := rand.Read(groupID[:]); err != nil {
+		return common.Address{}, fmt.Errorf("group id: %w", err)
+	}
+
+	grp, err := app.db.InsertGroup(groupID, name)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("insert group: %w", err)
+	}
+
+	if err := app.sendSigned(grp, [][]byte{fmt.Appendf(nil, "/group create %s", name)}, false); err != nil {
+		return common.Address{}, fmt.Errorf("send: %w", err)
+	}
+
+	app.ui.AddGroup(groupID, name)
+
+	return groupID, nil
+}
+
+// InviteToGroup adds the member to the group. Only the group owner can
+// invite members.
+func (app *App) InviteToGroup(groupID common.Address, member common.Address) error {
+	grp, err := app.queryGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Appendf(nil, "/group invite %s %s", member.Hex(), grp.Name)
+
+	return app.sendSigned(grp, [][]byte{cmd}, false)
+}
+
+// KickFromGroup removes the member from the group. Only the group owner can
+// kick members.
+func (app *App) KickFromGroup(groupID common.Address, member common.Address) error {
+	grp, err := app.queryGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Appendf(nil, "/group kick %s", member.Hex())
+
+	return app.sendSigned(grp, [][]byte{cmd}, false)
+}
+
+// LeaveGroup removes this user from the group. If the owner leaves, the
+// group is deleted.
+func (app *App) LeaveGroup(groupID common.Address) error {
+	grp, err := app.queryGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	if err := app.sendSigned(grp, [][]byte{[]byte("/group leave")}, false); err != nil {
+		return err
+	}
+
+	if err := app.db.DeleteContact(groupID); err != nil {
+		return fmt.Errorf("delete group: %w", err)
+	}
+
+	app.ui.RemoveContact(groupID)
+
+	return nil
+}
+
+// =============================================================================
+
+func (app *App) queryGroup(groupID common.Address) (User, error) {
+	grp, err := app.db.QueryContactByID(groupID)
+	if err != nil {
+		return User{}, fmt.Errorf("query group: %w", err)
+	}
+
+	if !grp.Group {
+		return User{}, fmt.Errorf("contact is not a group: %s", groupID)
+	}
+
+	return grp, nil
+}
+
+func (app *App) preprocessRecvGroupMessage(inMsg incomingMessage) error {
+	msgs := inMsg.Msg
+
+	if len(msgs) == 0 || len(msgs[0]) == 0 {
+		return fmt.Errorf("no message")
+	}
+
+	groupID := inMsg.Group
+
+	_, err := app.db.QueryContactByID(groupID)
+	known := err == nil
+
+	name := inMsg.From.Name
+	if usr, err := app.db.QueryContactByID(inMsg.From.ID); err == nil {
+		name = usr.Name
+	}
+
+	content := msgs
+
+	// -------------------------------------------------------------------------
+	// Process Group Commands
+
+	if parts := strings.Fields(string(msgs[0])); len(parts) > 1 && parts[0] == "/group" {
+		var member common.Address
+		if len(parts) > 2 {
+			member = common.HexToAddress(parts[2])
+		}
+
+		switch parts[1] {
+		case "invite":
+			if member == app.id.MyAccountID && !known {
+				groupName := "Group"
+				if len(parts) > 3 {
+					groupName = strings.Join(parts[3:], " ")
+				}
+
+				if _, err := app.db.InsertGroup(groupID, groupName); err != nil {
+					return fmt.Errorf("insert group: %w", err)
+				}
+
+				app.ui.AddGroup(groupID, groupName)
+				known = true
+			}
+
+			content = [][]byte{fmt.Appendf(nil, "** %s invited %s **", name, member.Hex())}
+
+		case "kick":
+			if member == app.id.MyAccountID {
+				if !known {
+					return nil
+				}
+
+				if err := app.db.DeleteContact(groupID); err != nil {
+					return fmt.Errorf("delete group: %w", err)
+				}
+
+				app.ui.RemoveContact(groupID)
+				app.ui.WriteText(Message{
+					Name:    "system",
+					Content: [][]byte{fmt.Appendf(nil, "%s removed you from group %s", name, groupID.Hex())},
+				})
+
+				return nil
+			}
+
+			content = [][]byte{fmt.Appendf(nil, "** %s removed %s **", name, member.Hex())}
+
+		case "leave":
+			content = [][]byte{fmt.Appendf(nil, "** %s left the group **", name)}
+
+		default:
+			return fmt.Errorf("unknown group command: %s", parts[1])
+		}
+	}
+
+	if !known {
+		return fmt.Errorf("message for unknown group: %s", groupID.Hex())
+	}
+
+	// -------------------------------------------------------------------------
+	// Process Normal Message
+
+	msg := Message{
+		From:    inMsg.From.ID,
+		To:      app.id.MyAccountID,
+		Group:   groupID,
+		Name:    name,
+		Content: content,
+	}
+
+	if err := app.db.InsertMessage(groupID, msg); err != nil {
+		return fmt.Errorf("add message: %w", err)
+	}
+
+	app.ui.WriteText(msg)
+
+	return nil
+}
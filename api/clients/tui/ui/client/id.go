@@ -2,46 +2,69 @@ package client
 
 import (
 	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
+	"github.com/ardanlabs/usdl/foundation/keyfile"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
-const idFileName = "key.ecdsa"
-const encFileName = "key.rsa"
+const keyFileName = "key.json"
+const authFileName = "key.rsa"
 
+// Key files written in the clear by older installs, which NewID moves into
+// the keyfile.
+const (
+	legacyIDFileName  = "key.ecdsa"
+	legacyEncFileName = "key.rsa"
+	legacyMsgFileName = "key.x25519"
+)
+
+// ID holds the user's keys. The ECDSA key is the identity, the X25519 key
+// encrypts messages from contacts and the RSA key encrypts local storage
+// and messages from contacts on older clients.
 type ID struct {
-	MyAccountID  common.Address
-	PrivKeyECDSA *ecdsa.PrivateKey
-	PrivKeyRSA   *rsa.PrivateKey
-	PubKeyRSA    string
+	MyAccountID   common.Address
+	PrivKeyECDSA  *ecdsa.PrivateKey
+	PrivKeyRSA    *rsa.PrivateKey
+	PubKeyRSA     string
+	PrivKeyX25519 *ecdh.PrivateKey
+	PubKeyX25519  string
 }
 
-func NewID(filePath string) (ID, error) {
-	os.MkdirAll(filepath.Join(filePath, "id"), os.ModePerm)
+// NewID reads the user's keys from the keyfile in the folder, decrypting
+// them with the passphrase. When there is no keyfile one is created, from
+// the plain key files of an older install if there are any.
+func NewID(filePath string, passphrase string) (ID, error) {
+	dir := filepath.Join(filePath, "id")
+	os.MkdirAll(dir, os.ModePerm)
 
-	fileName := filepath.Join(filePath, "id", idFileName)
+	fileName := filepath.Join(dir, keyFileName)
 
-	var addr common.Address
-	var pkECDSA *ecdsa.PrivateKey
+	var id ID
 
 	_, err := os.Stat(fileName)
 	switch {
-	case err != nil:
-		addr, pkECDSA, err = createKeyID(fileName)
+	case err == nil:
+		id, err = readKeyFile(fileName, passphrase)
+
+	case fileExists(filepath.Join(dir, legacyIDFileName)):
+		id, err = migrateKeys(dir, fileName, passphrase)
 
 	default:
-		addr, pkECDSA, err = readKeyID(fileName)
+		id, err = createKeyFile(fileName, passphrase)
 	}
 
 	if err != nil {
@@ -50,28 +73,87 @@ func NewID(filePath string) (ID, error) {
 
 	// -------------------------------------------------------------------------
 
-	fileName = filepath.Join(filePath, "id", encFileName)
+	if err := authKey(filepath.Join(dir, authFileName), id.PrivKeyRSA); err != nil {
+		return ID{}, fmt.Errorf("id: %w", err)
+	}
 
-	var pkRSA *rsa.PrivateKey
+	return id, nil
+}
 
-	_, err = os.Stat(fileName)
-	switch {
-	case err != nil:
-		pkRSA, err = createKeyEnc(fileName)
+// HasKeyFile reports whether the folder holds a keyfile, so a passphrase
+// for a new keyfile doesn't need to be chosen.
+func HasKeyFile(filePath string) bool {
+	return fileExists(filepath.Join(filePath, "id", keyFileName))
+}
 
-	default:
-		pkRSA, err = readKeyEnc(fileName)
+// ChangePassphrase encrypts the keyfile in the folder with a new passphrase.
+func ChangePassphrase(filePath string, oldPassphrase string, newPassphrase string) error {
+	fileName := filepath.Join(filePath, "id", keyFileName)
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("reading keyfile: %w", err)
 	}
 
+	secret, err := keyfile.Decrypt(data, oldPassphrase)
 	if err != nil {
-		return ID{}, fmt.Errorf("id: %w", err)
+		return fmt.Errorf("decrypting keyfile: %w", err)
 	}
 
-	// -------------------------------------------------------------------------
+	if err := writeKeyFile(fileName, secret, newPassphrase); err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	asn1Bytes, err := x509.MarshalPKIXPublicKey(&pkRSA.PublicKey)
+// GenerateID constructs an ID with new keys that are only held in memory.
+func GenerateID() (ID, error) {
+	pkECDSA, err := crypto.GenerateKey()
 	if err != nil {
-		return ID{}, fmt.Errorf("marshaling public key: %w", err)
+		return ID{}, fmt.Errorf("generateKey: %w", err)
+	}
+
+	pkRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return ID{}, fmt.Errorf("generating key: %w", err)
+	}
+
+	pkX25519, err := keyX25519For(pkECDSA)
+	if err != nil {
+		return ID{}, err
+	}
+
+	return newID(crypto.PubkeyToAddress(pkECDSA.PublicKey), pkECDSA, pkRSA, pkX25519)
+}
+
+func newID(addr common.Address, pkECDSA *ecdsa.PrivateKey, pkRSA *rsa.PrivateKey, pkX25519 *ecdh.PrivateKey) (ID, error) {
+	pubRSA, err := publicPEM(&pkRSA.PublicKey)
+	if err != nil {
+		return ID{}, err
+	}
+
+	pubX25519, err := publicPEM(pkX25519.PublicKey())
+	if err != nil {
+		return ID{}, err
+	}
+
+	id := ID{
+		MyAccountID:   addr,
+		PrivKeyECDSA:  pkECDSA,
+		PrivKeyRSA:    pkRSA,
+		PubKeyRSA:     pubRSA,
+		PrivKeyX25519: pkX25519,
+		PubKeyX25519:  pubX25519,
+	}
+
+	return id, nil
+}
+
+func publicPEM(pub any) (string, error) {
+	asn1Bytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("marshaling public key: %w", err)
 	}
 
 	publicBlock := pem.Block{
@@ -81,68 +163,203 @@ func NewID(filePath string) (ID, error) {
 
 	var buf bytes.Buffer
 	if err := pem.Encode(&buf, &publicBlock); err != nil {
-		return ID{}, fmt.Errorf("encoding to public PEM: %w", err)
+		return "", fmt.Errorf("encoding to public PEM: %w", err)
 	}
 
-	id := ID{
-		MyAccountID:  addr,
-		PrivKeyECDSA: pkECDSA,
-		PrivKeyRSA:   pkRSA,
-		PubKeyRSA:    buf.String(),
+	return buf.String(), nil
+}
+
+// =============================================================================
+
+// keyFileData is the secret held in the keyfile.
+type keyFileData struct {
+	ECDSA  []byte `json:"ecdsa"`
+	RSA    []byte `json:"rsa"`
+	X25519 []byte `json:"x25519"`
+}
+
+func encodeKeys(id ID) ([]byte, error) {
+	kfd := keyFileData{
+		ECDSA:  crypto.FromECDSA(id.PrivKeyECDSA),
+		RSA:    x509.MarshalPKCS1PrivateKey(id.PrivKeyRSA),
+		X25519: id.PrivKeyX25519.Bytes(),
 	}
 
-	return id, nil
+	secret, err := json.Marshal(kfd)
+	if err != nil {
+		return nil, fmt.Errorf("marshal keys: %w", err)
+	}
+
+	return secret, nil
 }
 
-// =============================================================================
+func decodeKeys(secret []byte) (ID, error) {
+	var kfd keyFileData
+	if err := json.Unmarshal(secret, &kfd); err != nil {
+		return ID{}, fmt.Errorf("unmarshal keys: %w", err)
+	}
+
+	pkECDSA, err := crypto.ToECDSA(kfd.ECDSA)
+	if err != nil {
+		return ID{}, fmt.Errorf("ecdsa key: %w", err)
+	}
 
-func createKeyID(fileName string) (common.Address, *ecdsa.PrivateKey, error) {
-	privateKey, err := crypto.GenerateKey()
+	pkRSA, err := x509.ParsePKCS1PrivateKey(kfd.RSA)
 	if err != nil {
-		return common.Address{}, nil, fmt.Errorf("generateKey: %w", err)
+		return ID{}, fmt.Errorf("rsa key: %w", err)
 	}
 
-	if err := crypto.SaveECDSA(fileName, privateKey); err != nil {
-		return common.Address{}, nil, fmt.Errorf("saveECDSA: %w", err)
+	pkX25519, err := ecdh.X25519().NewPrivateKey(kfd.X25519)
+	if err != nil {
+		return ID{}, fmt.Errorf("x25519 key: %w", err)
 	}
 
-	return crypto.PubkeyToAddress(privateKey.PublicKey), privateKey, nil
+	return newID(crypto.PubkeyToAddress(pkECDSA.PublicKey), pkECDSA, pkRSA, pkX25519)
 }
 
-func readKeyID(fileName string) (common.Address, *ecdsa.PrivateKey, error) {
-	privateKey, err := crypto.LoadECDSA(fileName)
+func createKeyFile(fileName string, passphrase string) (ID, error) {
+	id, err := GenerateID()
+	if err != nil {
+		return ID{}, err
+	}
+
+	secret, err := encodeKeys(id)
 	if err != nil {
-		return common.Address{}, nil, fmt.Errorf("loadECDSA: %w", err)
+		return ID{}, err
+	}
+
+	if err := writeKeyFile(fileName, secret, passphrase); err != nil {
+		return ID{}, err
 	}
 
-	return crypto.PubkeyToAddress(privateKey.PublicKey), privateKey, nil
+	return id, nil
 }
 
-func createKeyEnc(fileName string) (*rsa.PrivateKey, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+func readKeyFile(fileName string, passphrase string) (ID, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return ID{}, fmt.Errorf("reading keyfile: %w", err)
+	}
+
+	secret, err := keyfile.Decrypt(data, passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("generating key: %w", err)
+		return ID{}, fmt.Errorf("decrypting keyfile: %w", err)
 	}
 
-	privateFile, err := os.Create(fileName)
+	return decodeKeys(secret)
+}
+
+// writeKeyFile replaces the keyfile in one step so a crash can't leave it
+// half written.
+func writeKeyFile(fileName string, secret []byte, passphrase string) error {
+	data, err := keyfile.Encrypt(secret, passphrase, keyfile.DefaultIterations)
+	if err != nil {
+		return fmt.Errorf("encrypting keyfile: %w", err)
+	}
+
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("writing keyfile: %w", err)
+	}
+
+	if err := os.Rename(tmp, fileName); err != nil {
+		return fmt.Errorf("replacing keyfile: %w", err)
+	}
+
+	return nil
+}
+
+// migrateKeys moves the plain key files of an older install into a new
+// keyfile. The X25519 key is derived if the install predates it. The
+// RSA key file is left for authKey to replace.
+func migrateKeys(dir string, fileName string, passphrase string) (ID, error) {
+	pkECDSA, err := crypto.LoadECDSA(filepath.Join(dir, legacyIDFileName))
+	if err != nil {
+		return ID{}, fmt.Errorf("loadECDSA: %w", err)
+	}
+
+	pkRSA, err := readKeyRSA(filepath.Join(dir, legacyEncFileName))
+	if err != nil {
+		return ID{}, err
+	}
+
+	msgFileName := filepath.Join(dir, legacyMsgFileName)
+
+	var pkX25519 *ecdh.PrivateKey
+	switch {
+	case fileExists(msgFileName):
+		pkX25519, err = readKeyMsg(msgFileName)
+
+	default:
+		pkX25519, err = keyX25519For(pkECDSA)
+	}
+
+	if err != nil {
+		return ID{}, err
+	}
+
+	id, err := newID(crypto.PubkeyToAddress(pkECDSA.PublicKey), pkECDSA, pkRSA, pkX25519)
 	if err != nil {
-		return nil, fmt.Errorf("creating private file: %w", err)
+		return ID{}, err
+	}
+
+	secret, err := encodeKeys(id)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if err := writeKeyFile(fileName, secret, passphrase); err != nil {
+		return ID{}, err
+	}
+
+	for _, name := range []string{legacyIDFileName, legacyMsgFileName} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return ID{}, fmt.Errorf("removing plain key: %w", err)
+		}
+	}
+
+	return id, nil
+}
+
+// authKey makes sure there is an RSA key for signing the development JWT
+// next to the keyfile. The CAP reads the same folder to verify the token,
+// so the key stays in the clear and must not be the storage key, which it
+// is after a migration.
+func authKey(fileName string, storageKey *rsa.PrivateKey) error {
+	if fileExists(fileName) {
+		pk, err := readKeyRSA(fileName)
+		if err != nil {
+			return err
+		}
+
+		if !pk.Equal(storageKey) {
+			return nil
+		}
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("generating key: %w", err)
 	}
-	defer privateFile.Close()
 
 	privateBlock := pem.Block{
 		Type:  "PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
 	}
 
-	if err := pem.Encode(privateFile, &privateBlock); err != nil {
-		return nil, fmt.Errorf("encoding to private file: %w", err)
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&privateBlock), 0600); err != nil {
+		return fmt.Errorf("writing auth key: %w", err)
 	}
 
-	return privateKey, nil
+	if err := os.Rename(tmp, fileName); err != nil {
+		return fmt.Errorf("replacing auth key: %w", err)
+	}
+
+	return nil
 }
 
-func readKeyEnc(fileName string) (*rsa.PrivateKey, error) {
+func readKeyRSA(fileName string) (*rsa.PrivateKey, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
 		return nil, fmt.Errorf("opening key file: %w", err)
@@ -151,12 +368,10 @@ func readKeyEnc(fileName string) (*rsa.PrivateKey, error) {
 
 	pemData, err := io.ReadAll(io.LimitReader(file, 1024*1024))
 	if err != nil {
-		return nil, fmt.Errorf("reading auth private key: %w", err)
+		return nil, fmt.Errorf("reading rsa private key: %w", err)
 	}
 
-	privatePEM := string(pemData)
-
-	block, _ := pem.Decode([]byte(privatePEM))
+	block, _ := pem.Decode(pemData)
 	if block == nil {
 		return nil, errors.New("invalid key: Key must be a PEM encoded PKCS1 or PKCS8 key")
 	}
@@ -177,3 +392,32 @@ func readKeyEnc(fileName string) (*rsa.PrivateKey, error) {
 
 	return pk, nil
 }
+
+func readKeyMsg(fileName string) (*ecdh.PrivateKey, error) {
+	pemData, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("invalid key: Key must be a PEM encoded PKCS8 key")
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, ok := parsedKey.(*ecdh.PrivateKey)
+	if !ok || pk.Curve() != ecdh.X25519() {
+		return nil, errors.New("key is not a valid X25519 private key")
+	}
+
+	return pk, nil
+}
+
+func fileExists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return err == nil
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ardanlabs/usdl/foundation/keyfile"
+	"github.com/ardanlabs/usdl/foundation/mnemonic"
+	"golang.org/x/term"
+)
+
+// UnlockID constructs the ID with the passphrase, asking for it on the
+// terminal when it's empty. A wrong passphrase can be retried a few times.
+func UnlockID(filePath string, passphrase string) (ID, error) {
+	if passphrase != "" {
+		return NewID(filePath, passphrase)
+	}
+
+	for range 3 {
+		passphrase, err := PromptPassphrase(filePath)
+		if err != nil {
+			return ID{}, fmt.Errorf("passphrase: %w", err)
+		}
+
+		id, err := NewID(filePath, passphrase)
+		if errors.Is(err, keyfile.ErrPassphrase) {
+			fmt.Fprintln(os.Stderr, "Wrong passphrase")
+			continue
+		}
+
+		return id, err
+	}
+
+	return ID{}, keyfile.ErrPassphrase
+}
+
+// PromptPassphrase asks for the passphrase that protects the keys in the
+// folder. When there is no keyfile yet, a new passphrase is asked for twice.
+func PromptPassphrase(filePath string) (string, error) {
+	if HasKeyFile(filePath) {
+		return AskPassphrase("Passphrase", false)
+	}
+
+	fmt.Fprintln(os.Stderr, "Choose a passphrase to encrypt your keys with.")
+
+	return AskPassphrase("Passphrase", true)
+}
+
+// AskPassphrase asks for a passphrase on the terminal with the label. A new
+// passphrase can't be empty and is asked for twice.
+func AskPassphrase(label string, isNew bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no terminal to ask for the passphrase on")
+	}
+
+	if !isNew {
+		return readPassphrase(fd, label+": ")
+	}
+
+	for {
+		passphrase, err := readPassphrase(fd, "New "+strings.ToLower(label)+": ")
+		if err != nil {
+			return "", err
+		}
+
+		if passphrase == "" {
+			fmt.Fprintln(os.Stderr, "The passphrase can't be empty")
+			continue
+		}
+
+		confirm, err := readPassphrase(fd, "Repeat "+strings.ToLower(label)+": ")
+		if err != nil {
+			return "", err
+		}
+
+		if passphrase != confirm {
+			fmt.Fprintln(os.Stderr, "The passphrases don't match")
+			continue
+		}
+
+		return passphrase, nil
+	}
+}
+
+// PromptRestore asks for the mnemonic on the terminal and restores the ID
+// into the folder, encrypting it with the passphrase or asking for a new
+// one when it's empty. A mistyped mnemonic can be retried a few times.
+func PromptRestore(filePath string, passphrase string) (ID, error) {
+	if HasKeyFile(filePath) {
+		return ID{}, ErrKeyFileExists
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return ID{}, errors.New("no terminal to ask for the mnemonic on")
+	}
+
+	for range 3 {
+		words, err := readPassphrase(fd, "Backup words: ")
+		if err != nil {
+			return ID{}, fmt.Errorf("mnemonic: %w", err)
+		}
+
+		if _, err := mnemonic.Entropy(words); err != nil {
+			fmt.Fprintf(os.Stderr, "Those words can't be restored: %s\n", err)
+			continue
+		}
+
+		if passphrase == "" {
+			if passphrase, err = PromptPassphrase(filePath); err != nil {
+				return ID{}, fmt.Errorf("passphrase: %w", err)
+			}
+		}
+
+		return RestoreID(filePath, words, passphrase)
+	}
+
+	return ID{}, mnemonic.ErrInvalid
+}
+
+// =============================================================================
+
+func readPassphrase(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	passphrase, err := term.ReadPassword(fd)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase: %w", err)
+	}
+
+	return string(passphrase), nil
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/ardanlabs/usdl/foundation/rotation"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// An ID is rotated by generating new identity and encryption keys and a
+// statement, signed by the old and new identity keys, naming the new
+// address. The CAP forwards messages for the old address to the new one
+// for a grace period, and contacts move the contact to the new address
+// when they receive the statement. The storage key doesn't change, so the
+// history stays readable.
+
+// RotateID moves the account in the folder to a new ID and returns the new
+// address. The passphrase must open the keyfile. The new keys are saved in
+// a pending keyfile before the CAP is told, so they can't be lost once the
+// CAP starts forwarding, and then replace the old ones. If the CAP can't be
+// told, the pending keys are used again the next time. The statement is
+// then sent to every contact. The app keeps using the old ID with the CAP
+// until it's started again, but starts sessions with the new encryption
+// key right away, since that's the key contacts are told about.
+func (app *App) RotateID(ctx context.Context, filePath string, passphrase string) (common.Address, error) {
+	fileName := filepath.Join(filePath, "id", keyFileName)
+	pendingName := fileName + ".pending"
+
+	if _, err := readKeyFile(fileName, passphrase); err != nil {
+		return common.Address{}, err
+	}
+
+	newID, err := pendingID(app.id, pendingName, passphrase)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	stmt, err := rotation.New(app.id.PrivKeyECDSA, newID.PrivKeyECDSA, newID.PubKeyX25519)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("statement: %w", err)
+	}
+
+	data, err := json.Marshal(stmt)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("marshal: %w", err)
+	}
+
+	url := app.url + "/rotations"
+	if err := app.transferDo(ctx, http.MethodPost, url, app.jwt, bytes.NewReader(data), "application/json", nil); err != nil {
+		return common.Address{}, fmt.Errorf("cap: %w", err)
+	}
+
+	if err := os.Rename(pendingName, fileName); err != nil {
+		return common.Address{}, fmt.Errorf("replacing keyfile: %w", err)
+	}
+
+	if err := app.db.RotateAccount(newID); err != nil {
+		return common.Address{}, fmt.Errorf("rotate account: %w", err)
+	}
+
+	app.sessionMu.Lock()
+	app.id.PrivKeyX25519 = newID.PrivKeyX25519
+	app.id.PubKeyX25519 = newID.PubKeyX25519
+	app.sessionMu.Unlock()
+
+	// -------------------------------------------------------------------------
+
+	msg := [][]byte{fmt.Appendf(nil, "/rotate %s", data)}
+
+	var errs []error
+	for _, usr := range app.db.Contacts() {
+		if usr.Group || usr.ID == (common.Address{}) {
+			continue
+		}
+
+		if err := app.sendSigned(usr, msg, false); err != nil {
+			errs = append(errs, fmt.Errorf("telling %s: %w", usr.Name, err))
+		}
+	}
+
+	return newID.MyAccountID, errors.Join(errs...)
+}
+
+// =============================================================================
+
+// pendingID returns the keys saved by a rotation the CAP may not have been
+// told about, or generates new keys and saves them if there aren't any.
+func pendingID(id ID, fileName string, passphrase string) (ID, error) {
+	if _, err := os.Stat(fileName); err == nil {
+		return readKeyFile(fileName, passphrase)
+	}
+
+	next, err := nextID(id)
+	if err != nil {
+		return ID{}, err
+	}
+
+	secret, err := encodeKeys(next)
+	if err != nil {
+		return ID{}, err
+	}
+
+	if err := writeKeyFile(fileName, secret, passphrase); err != nil {
+		return ID{}, err
+	}
+
+	return next, nil
+}
+
+// nextID generates the ID to rotate to.
+func nextID(id ID) (ID, error) {
+	pkECDSA, err := crypto.GenerateKey()
+	if err != nil {
+		return ID{}, fmt.Errorf("generateKey: %w", err)
+	}
+
+	pkX25519, err := keyX25519For(pkECDSA)
+	if err != nil {
+		return ID{}, err
+	}
+
+	return newID(crypto.PubkeyToAddress(pkECDSA.PublicKey), pkECDSA, id.PrivKeyRSA, pkX25519)
+}
+
+// preprocessRecvRotation moves the contact named in the statement to the
+// contact's new ID. The new ID hasn't been verified, even if the old one
+// was, since whoever holds the old key can rotate it. A replaced or
+// verified key blocks sends until the user accepts the new one.
+func (app *App) preprocessRecvRotation(inMsg incomingMessage, data string) error {
+	var stmt rotation.Statement
+	if err := json.Unmarshal([]byte(data), &stmt); err != nil {
+		return fmt.Errorf("unmarshal rotation: %w", err)
+	}
+
+	if err := stmt.Verify(); err != nil {
+		return err
+	}
+
+	if inMsg.From.ID != stmt.OldID && inMsg.From.ID != stmt.NewID {
+		return fmt.Errorf("rotation isn't from the contact")
+	}
+
+	usr, err := app.db.QueryContactByID(stmt.OldID)
+	if err != nil {
+		// The contact already moved or was never a contact.
+		return nil
+	}
+
+	if usr.Group {
+		return fmt.Errorf("a group can't be rotated")
+	}
+
+	if stmt.Key != "" {
+		if _, err := getPublicKey(stmt.Key); err != nil {
+			return fmt.Errorf("rotation key: %w", err)
+		}
+	}
+
+	// -------------------------------------------------------------------------
+
+	usr, err = app.db.RotateContact(stmt.OldID, stmt.NewID)
+	if err != nil {
+		return fmt.Errorf("rotate contact: %w", err)
+	}
+
+	// The new key goes through the same checks as a shared key, so sends
+	// stay blocked until the user accepts it. A verified contact is blocked
+	// even without a new key, since the ID it was verified with is gone.
+	var keyChanged bool
+	if stmt.Key != "" {
+		keyChanged, err = app.pinContactKey(usr, stmt.Key, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if usr.Verified && !keyChanged {
+		if err := app.db.UpdateContactKeyChanged(usr.ID, true); err != nil {
+			return fmt.Errorf("update key changed: %w", err)
+		}
+		keyChanged = true
+	}
+
+	if err := app.db.UpdateContactVerified(usr.ID, false); err != nil {
+		return fmt.Errorf("update verified: %w", err)
+	}
+
+	app.ui.RemoveContact(stmt.OldID)
+	app.ui.RemoveContact(stmt.NewID)
+	app.ui.AddContact(usr.ID, usr.Name)
+
+	msg := Message{
+		From:      usr.ID,
+		To:        app.id.MyAccountID,
+		Name:      usr.Name,
+		Content:   [][]byte{fmt.Appendf(nil, "** contact moved to %s, verify it before trusting it **", usr.ID.Hex())},
+		Encrypted: false,
+	}
+
+	if err := app.db.InsertMessage(usr.ID, msg); err != nil {
+		return fmt.Errorf("add message: %w", err)
+	}
+
+	app.ui.WriteText(msg)
+
+	if keyChanged {
+		if err := app.writeKeyChanged(usr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbmem"
+	"github.com/ardanlabs/usdl/foundation/rotation"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TestRotateIDPending provides a test of the new keys surviving a rotation
+// the CAP wasn't told about.
+func TestRotateIDPending(t *testing.T) {
+	t.Log("Given the need to never lose the keys the CAP may forward to.")
+	{
+		dir := t.TempDir()
+		passphrase := "correct horse battery staple"
+
+		id, err := client.NewID(dir, passphrase)
+		if err != nil {
+			t.Fatalf("\tShould be able to create the id: %s. %s", err, "X")
+		}
+
+		var (
+			mu      sync.Mutex
+			fail    = true
+			newIDs  []common.Address
+			pending = filepath.Join(dir, "id", "key.json.pending")
+		)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			var stmt rotation.Statement
+			if err := json.NewDecoder(r.Body).Decode(&stmt); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			newIDs = append(newIDs, stmt.NewID)
+
+			if fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer srv.Close()
+
+		app := client.NewApp(dbmem.NewDB(id, "alice", "jwt"), id, srv.URL, &recordUI{}, "jwt", t.TempDir())
+
+		if _, err := app.RotateID(context.Background(), dir, passphrase); err == nil {
+			t.Fatalf("\tShould fail when the CAP can't be told. %s", "X")
+		}
+		t.Log("\tShould fail when the CAP can't be told.", "OK")
+
+		if !fileExists(pending) {
+			t.Fatalf("\tShould keep the pending keys. %s", "X")
+		}
+		t.Log("\tShould keep the pending keys.", "OK")
+
+		mu.Lock()
+		fail = false
+		mu.Unlock()
+
+		newID, err := app.RotateID(context.Background(), dir, passphrase)
+		if err != nil {
+			t.Fatalf("\tShould be able to rotate the id: %s. %s", err, "X")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if len(newIDs) != 2 || newIDs[0] != newID || newIDs[1] != newID {
+			t.Fatalf("\tShould rotate to the pending keys, got %v and %s. %s", newIDs, newID, "X")
+		}
+		t.Log("\tShould rotate to the pending keys.", "OK")
+
+		unlocked, err := client.NewID(dir, passphrase)
+		if err != nil || unlocked.MyAccountID != newID {
+			t.Fatalf("\tShould save the new keys, got %s: %v. %s", unlocked.MyAccountID, err, "X")
+		}
+
+		if fileExists(pending) {
+			t.Fatalf("\tShould remove the pending keys. %s", "X")
+		}
+		t.Log("\tShould save the new keys.", "OK")
+	}
+}
+
+func fileExists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return err == nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/ardanlabs/usdl/foundation/envelope"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// sealMessage encrypts the message for the contact. A contact that shared
+// an X25519 key gets the whole message in a single session message. A
+// contact on an older client that shared an RSA key gets the message split
+// into chunks small enough for RSA, each encrypted on its own.
+func (app *App) sealMessage(usr User, msgs [][]byte) ([][]byte, error) {
+	publicKey, err := getPublicKey(usr.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key: %w", err)
+	}
+
+	switch pk := publicKey.(type) {
+	case *ecdh.PublicKey:
+		ed, err := app.sessionSeal(usr, pk, bytes.Join(msgs, nil))
+		if err != nil {
+			return nil, fmt.Errorf("encrypting message: %w", err)
+		}
+
+		return [][]byte{ed}, nil
+
+	case *rsa.PublicKey:
+		chunks := splitMessage(bytes.Join(msgs, nil))
+		encryptedData := make([][]byte, len(chunks))
+
+		for i, msg := range chunks {
+			ed, err := rsa.EncryptPKCS1v15(rand.Reader, pk, msg)
+			if err != nil {
+				return nil, fmt.Errorf("encrypting message: %w", err)
+			}
+
+			encryptedData[i] = ed
+		}
+
+		return encryptedData, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// openMessage decrypts the message chunks from the contact, either as one
+// session message, one envelope or as chunks encrypted with RSA by an older
+// client.
+func (app *App) openMessage(from common.Address, msgs [][]byte) ([][]byte, error) {
+	if isSessionMessage(msgs) {
+		dd, err := app.sessionOpen(from, msgs[0])
+		if err != nil {
+			return nil, fmt.Errorf("decrypting message: %w", err)
+		}
+
+		return splitMessage(dd), nil
+	}
+
+	if len(msgs) == 1 && envelope.Is(msgs[0]) {
+		dd, err := envelope.Open(app.id.PrivKeyX25519, msgs[0], sealAD(from, app.id.MyAccountID))
+		if err != nil {
+			return nil, fmt.Errorf("decrypting message: %w", err)
+		}
+
+		return splitMessage(dd), nil
+	}
+
+	decryptedData := make([][]byte, len(msgs))
+
+	for i, msg := range msgs {
+		dd, err := rsa.DecryptPKCS1v15(rand.Reader, app.id.PrivKeyRSA, msg)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting message: %w", err)
+		}
+
+		decryptedData[i] = dd
+	}
+
+	return decryptedData, nil
+}
+
+// sealKey encrypts a file transfer key for the contact.
+func (app *App) sealKey(usr User, key []byte) ([]byte, error) {
+	publicKey, err := getPublicKey(usr.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key: %w", err)
+	}
+
+	switch pk := publicKey.(type) {
+	case *ecdh.PublicKey:
+		return app.sessionSeal(usr, pk, key)
+
+	case *rsa.PublicKey:
+		return rsa.EncryptPKCS1v15(rand.Reader, pk, key)
+	}
+
+	return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// openKey decrypts a file transfer key from the contact.
+func (app *App) openKey(from common.Address, data []byte) ([]byte, error) {
+	if isSessionMessage([][]byte{data}) {
+		return app.sessionOpen(from, data)
+	}
+
+	if envelope.Is(data) {
+		return envelope.Open(app.id.PrivKeyX25519, data, sealAD(from, app.id.MyAccountID))
+	}
+
+	return rsa.DecryptPKCS1v15(rand.Reader, app.id.PrivKeyRSA, data)
+}
+
+// =============================================================================
+
+// sealAD binds a message to its sender and recipient, so it can't be
+// replayed to a different contact.
+func sealAD(from common.Address, to common.Address) []byte {
+	return append(from.Bytes(), to.Bytes()...)
+}
+
+// splitMessage splits a message into the chunks used for storage.
+func splitMessage(msg []byte) [][]byte {
+	const maxBytes = 240
+
+	var msgs [][]byte
+	for start := 0; start < len(msg); start += maxBytes {
+		msgs = append(msgs, msg[start:min(start+maxBytes, len(msg))])
+	}
+
+	if len(msgs) == 0 {
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func getPublicKey(pemBlock string) (any, error) {
+	block, _ := pem.Decode([]byte(pemBlock))
+	if block == nil {
+		return nil, errors.New("invalid key: Key must be a PEM encoded PKCS1 or PKCS8 key")
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdh.PublicKey:
+		return publicKey, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+}
@@ -0,0 +1,508 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/ardanlabs/usdl/foundation/ratchet"
+	"github.com/ardanlabs/usdl/foundation/signature"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Messages to contacts with an X25519 key are sent in forward secret
+// sessions. The side that sends first starts the session with an X3DH style
+// exchange: its ephemeral key and its identity key, signed by its account,
+// travel with every message until the contact replies. After that every
+// message turns the double ratchet.
+
+// sessionAlg names the scheme used by session messages.
+const sessionAlg = "X3DH-DR-X25519-HKDF-SHA256-AES-256-GCM"
+
+// maxSessions is how many sessions are kept for a contact so messages sent
+// in a session that was replaced can still be read.
+const maxSessions = 4
+
+// maxAccepted is how many session starts from a contact are remembered to
+// reject replays. Older starts are forgotten so the session file doesn't
+// grow forever; replaying one that old is still rejected by the nonce the
+// CAP tracks for the contact.
+const maxAccepted = 64
+
+// keyBundle is a public key signed by the account that owns it.
+type keyBundle struct {
+	ID  common.Address `json:"id"`
+	Key string         `json:"key"`
+	V   *big.Int       `json:"v"`
+	R   *big.Int       `json:"r"`
+	S   *big.Int       `json:"s"`
+}
+
+// sessionInit starts a session on the responder's side.
+type sessionInit struct {
+	Bundle    keyBundle `json:"bundle"`
+	Ephemeral []byte    `json:"ek"`
+}
+
+// sessionMessage represents a message encrypted in a session on the wire.
+type sessionMessage struct {
+	V      int            `json:"v"`
+	Alg    string         `json:"alg"`
+	Init   *sessionInit   `json:"init,omitempty"`
+	Header ratchet.Header `json:"header"`
+	Data   []byte         `json:"data"`
+}
+
+// session is one ratchet session with a contact. Remote is the contact's
+// identity key the session was agreed with. Init is kept on the side that
+// started the session until the contact replies.
+type session struct {
+	ID        string         `json:"id"`
+	Initiator common.Address `json:"initiator"`
+	Remote    string         `json:"remote"`
+	Init      *sessionInit   `json:"init,omitempty"`
+	State     *ratchet.State `json:"state"`
+}
+
+// sessionRecord holds the sessions with a contact, newest first, and which
+// one is used to send. Accepted holds the ephemeral keys of the last
+// sessions the contact started, kept after the session is dropped so a
+// message starting it again is rejected as a replay.
+type sessionRecord struct {
+	Active   string    `json:"active"`
+	Sessions []session `json:"sessions"`
+	Accepted []string  `json:"accepted,omitempty"`
+}
+
+// =============================================================================
+
+// newKeyBundle signs the public key with the account key.
+func newKeyBundle(id ID, key string) (keyBundle, error) {
+	dataToSign := struct {
+		ID  common.Address
+		Key string
+	}{
+		ID:  id.MyAccountID,
+		Key: key,
+	}
+
+	v, r, s, err := signature.Sign(dataToSign, id.PrivKeyECDSA)
+	if err != nil {
+		return keyBundle{}, fmt.Errorf("signing key: %w", err)
+	}
+
+	kb := keyBundle{
+		ID:  id.MyAccountID,
+		Key: key,
+		V:   v,
+		R:   r,
+		S:   s,
+	}
+
+	return kb, nil
+}
+
+// verify checks the key was signed by the account it claims to be from.
+func (kb keyBundle) verify(from common.Address) error {
+	if kb.ID != from {
+		return fmt.Errorf("key bundle is for %s", kb.ID)
+	}
+
+	dataToSign := struct {
+		ID  common.Address
+		Key string
+	}{
+		ID:  kb.ID,
+		Key: kb.Key,
+	}
+
+	addr, err := signature.FromAddress(dataToSign, kb.V, kb.R, kb.S)
+	if err != nil {
+		return fmt.Errorf("key bundle signature: %w", err)
+	}
+
+	if addr != from.Hex() {
+		return errors.New("key bundle signature doesn't match the account")
+	}
+
+	return nil
+}
+
+// parseKeyBundle reads a key shared with /key. Keys from older clients are
+// plain PEM and aren't signed.
+func parseKeyBundle(from common.Address, data string) (string, error) {
+	if !isKeyBundle(data) {
+		return data, nil
+	}
+
+	var kb keyBundle
+	if err := json.Unmarshal([]byte(data), &kb); err != nil {
+		return "", fmt.Errorf("unmarshal key bundle: %w", err)
+	}
+
+	if err := kb.verify(from); err != nil {
+		return "", err
+	}
+
+	return kb.Key, nil
+}
+
+func isKeyBundle(data string) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// =============================================================================
+
+// isSessionMessage reports whether the message was encrypted in a session.
+func isSessionMessage(msgs [][]byte) bool {
+	if len(msgs) != 1 || !bytes.HasPrefix(msgs[0], []byte("{")) {
+		return false
+	}
+
+	var sm struct {
+		Alg string `json:"alg"`
+	}
+
+	if err := json.Unmarshal(msgs[0], &sm); err != nil {
+		return false
+	}
+
+	return sm.Alg == sessionAlg
+}
+
+// sessionSeal encrypts the plaintext in the active session with the
+// contact, starting a new session if there isn't one for the contact's
+// current key.
+func (app *App) sessionSeal(usr User, remote *ecdh.PublicKey, plaintext []byte) ([]byte, error) {
+	app.sessionMu.Lock()
+	defer app.sessionMu.Unlock()
+
+	rec, err := app.querySessions(usr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := rec.find(rec.Active)
+	if idx == -1 || rec.Sessions[idx].Remote != usr.Key {
+		ses, err := app.newSession(usr.Key, remote)
+		if err != nil {
+			return nil, fmt.Errorf("new session: %w", err)
+		}
+
+		rec.add(ses)
+		rec.Active = ses.ID
+		idx = 0
+	}
+
+	ses := &rec.Sessions[idx]
+
+	h, data, err := ses.State.Encrypt(plaintext, sealAD(app.id.MyAccountID, usr.ID))
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	sm := sessionMessage{
+		V:      1,
+		Alg:    sessionAlg,
+		Init:   ses.Init,
+		Header: h,
+		Data:   data,
+	}
+
+	sealed, err := json.Marshal(sm)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	// The key must never be used twice, so the state is saved before the
+	// message is sent.
+	if err := app.updateSessions(usr.ID, rec); err != nil {
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+// sessionOpen decrypts a message from the contact. A message that starts a
+// session the contact sent first creates the session on this side.
+func (app *App) sessionOpen(from common.Address, data []byte) ([]byte, error) {
+	var sm sessionMessage
+	if err := json.Unmarshal(data, &sm); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	if sm.V != 1 || sm.Alg != sessionAlg {
+		return nil, fmt.Errorf("unsupported session message: version %d alg %q", sm.V, sm.Alg)
+	}
+
+	app.sessionMu.Lock()
+	defer app.sessionMu.Unlock()
+
+	rec, err := app.querySessions(from)
+	if err != nil {
+		return nil, err
+	}
+
+	ad := sealAD(from, app.id.MyAccountID)
+
+	// Find the session the message was sent in. Messages that start a
+	// session name it, otherwise every session is tried, newest first.
+	var plaintext []byte
+	var found *session
+	var created bool
+
+	switch {
+	case sm.Init != nil:
+		id := hex.EncodeToString(sm.Init.Ephemeral)
+
+		idx := rec.find(id)
+		if idx == -1 {
+			if slices.Contains(rec.Accepted, id) {
+				return nil, errors.New("replayed session start")
+			}
+
+			ses, err := app.acceptSession(from, *sm.Init)
+			if err != nil {
+				return nil, fmt.Errorf("accept session: %w", err)
+			}
+
+			rec.add(ses)
+			rec.accept(id)
+			idx = 0
+			created = true
+		}
+
+		found = &rec.Sessions[idx]
+
+		if plaintext, err = found.State.Decrypt(sm.Header, sm.Data, ad); err != nil {
+			return nil, fmt.Errorf("decrypt: %w", err)
+		}
+
+	default:
+		for i := range rec.Sessions {
+			if plaintext, err = rec.Sessions[i].State.Decrypt(sm.Header, sm.Data, ad); err == nil {
+				found = &rec.Sessions[i]
+				break
+			}
+		}
+
+		if found == nil {
+			return nil, fmt.Errorf("decrypt: no session can read the message: %w", err)
+		}
+	}
+
+	// -------------------------------------------------------------------------
+	// Choose the session to send in.
+
+	active := rec.find(rec.Active)
+
+	switch {
+	case active == -1:
+		rec.Active = found.ID
+
+	case found.ID == rec.Active:
+
+	case created && rec.Sessions[active].Init == nil:
+		// The contact started over, maybe after losing its sessions.
+		rec.Active = found.ID
+
+	case created:
+		// Both sides started a session at the same time. Both keep the
+		// one started by the lower address.
+		if bytes.Compare(found.Initiator.Bytes(), rec.Sessions[active].Initiator.Bytes()) < 0 {
+			rec.Active = found.ID
+		}
+	}
+
+	// A reply in a session this side started completes it.
+	if found.Initiator == app.id.MyAccountID {
+		found.Init = nil
+	}
+
+	if err := app.updateSessions(from, rec); err != nil {
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// newSession starts a session with the contact's identity key.
+func (app *App) newSession(key string, remote *ecdh.PublicKey) (session, error) {
+	ephemeral, err := ratchet.GenerateKey()
+	if err != nil {
+		return session{}, fmt.Errorf("generate key: %w", err)
+	}
+
+	secret, err := ratchet.InitiatorSecret(app.id.PrivKeyX25519, ephemeral, remote)
+	if err != nil {
+		return session{}, err
+	}
+
+	state, err := ratchet.NewInitiator(secret, remote)
+	if err != nil {
+		return session{}, err
+	}
+
+	bundle, err := newKeyBundle(app.id, app.id.PubKeyX25519)
+	if err != nil {
+		return session{}, err
+	}
+
+	ses := session{
+		ID:        hex.EncodeToString(ephemeral.PublicKey().Bytes()),
+		Initiator: app.id.MyAccountID,
+		Remote:    key,
+		Init: &sessionInit{
+			Bundle:    bundle,
+			Ephemeral: ephemeral.PublicKey().Bytes(),
+		},
+		State: state,
+	}
+
+	return ses, nil
+}
+
+// acceptSession creates this side of a session the contact started. The
+// key the session was started with is pinned on first contact, and a
+// different key than the one pinned changes the contact's key.
+func (app *App) acceptSession(from common.Address, init sessionInit) (session, error) {
+	if err := init.Bundle.verify(from); err != nil {
+		return session{}, err
+	}
+
+	usr, err := app.db.QueryContactByID(from)
+	if err != nil {
+		return session{}, fmt.Errorf("query contact: %w", err)
+	}
+
+	changed, err := app.pinContactKey(usr, init.Bundle.Key, true)
+	if err != nil {
+		return session{}, err
+	}
+
+	if changed {
+		if err := app.writeKeyChanged(usr); err != nil {
+			return session{}, err
+		}
+	}
+
+	publicKey, err := getPublicKey(init.Bundle.Key)
+	if err != nil {
+		return session{}, fmt.Errorf("identity key: %w", err)
+	}
+
+	remote, ok := publicKey.(*ecdh.PublicKey)
+	if !ok {
+		return session{}, fmt.Errorf("identity key is not an X25519 key")
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(init.Ephemeral)
+	if err != nil {
+		return session{}, fmt.Errorf("ephemeral key: %w", err)
+	}
+
+	secret, err := ratchet.ResponderSecret(app.id.PrivKeyX25519, remote, ephemeral)
+	if err != nil {
+		return session{}, err
+	}
+
+	ses := session{
+		ID:        hex.EncodeToString(init.Ephemeral),
+		Initiator: from,
+		Remote:    init.Bundle.Key,
+		State:     ratchet.NewResponder(secret, app.id.PrivKeyX25519),
+	}
+
+	return ses, nil
+}
+
+func (app *App) querySessions(id common.Address) (sessionRecord, error) {
+	data, err := app.db.QuerySession(id)
+	if err != nil {
+		return sessionRecord{}, fmt.Errorf("query session: %w", err)
+	}
+
+	var rec sessionRecord
+	if len(data) == 0 {
+		return rec, nil
+	}
+
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return sessionRecord{}, fmt.Errorf("unmarshal session: %w", err)
+	}
+
+	return rec, nil
+}
+
+func (app *App) updateSessions(id common.Address, rec sessionRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+
+	if err := app.db.UpdateSession(id, data); err != nil {
+		return fmt.Errorf("update session: %w", err)
+	}
+
+	return nil
+}
+
+// restartSession has the next message to the contact start a new session,
+// for a contact that may have lost its sessions. The old sessions are kept
+// so messages still in flight can be read.
+func (app *App) restartSession(id common.Address) error {
+	app.sessionMu.Lock()
+	defer app.sessionMu.Unlock()
+
+	rec, err := app.querySessions(id)
+	if err != nil {
+		return err
+	}
+
+	if rec.Active == "" {
+		return nil
+	}
+
+	rec.Active = ""
+
+	return app.updateSessions(id, rec)
+}
+
+// =============================================================================
+
+func (rec *sessionRecord) find(id string) int {
+	for i, ses := range rec.Sessions {
+		if ses.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// add puts the session first, dropping the oldest session that isn't the
+// active one once there are too many.
+func (rec *sessionRecord) add(ses session) {
+	rec.Sessions = append([]session{ses}, rec.Sessions...)
+
+	for i := len(rec.Sessions) - 1; len(rec.Sessions) > maxSessions && i > 0; i-- {
+		if rec.Sessions[i].ID != rec.Active {
+			rec.Sessions = append(rec.Sessions[:i], rec.Sessions[i+1:]...)
+		}
+	}
+}
+
+// accept remembers the session start, forgetting the oldest once there are
+// too many.
+func (rec *sessionRecord) accept(id string) {
+	rec.Accepted = append(rec.Accepted, id)
+
+	if n := len(rec.Accepted) - maxAccepted; n > 0 {
+		rec.Accepted = slices.Delete(rec.Accepted, 0, n)
+	}
+}
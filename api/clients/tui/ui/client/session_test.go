@@ -0,0 +1,27 @@
+package client
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSessionAccepted(t *testing.T) {
+	var rec sessionRecord
+
+	for i := range maxAccepted + 10 {
+		rec.accept(fmt.Sprint(i))
+	}
+
+	if len(rec.Accepted) != maxAccepted {
+		t.Fatalf("Should keep %d session starts, got %d", maxAccepted, len(rec.Accepted))
+	}
+
+	if slices.Contains(rec.Accepted, "9") || !slices.Contains(rec.Accepted, "10") {
+		t.Fatalf("Should forget the oldest session starts, got %q", rec.Accepted[:2])
+	}
+
+	if last := rec.Accepted[len(rec.Accepted)-1]; last != fmt.Sprint(maxAccepted+9) {
+		t.Fatalf("Should keep the newest session start last, got %s", last)
+	}
+}
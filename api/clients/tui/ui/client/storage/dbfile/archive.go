@@ -0,0 +1,239 @@
+package dbfile
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/keyfile"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// archiveVersion is the archive version written by Export.
+const archiveVersion = 1
+
+// archive holds the contacts and their history in the clear, before it's
+// encrypted with the passphrase.
+type archive struct {
+	Version  int              `json:"version"`
+	Contacts []archiveContact `json:"contacts"`
+}
+
+type archiveContact struct {
+	dataFileUser
+	Messages []archiveMessage `json:"messages"`
+}
+
+type archiveMessage struct {
+	ID          common.Address `json:"id"`
+	Name        string         `json:"name"`
+	Encrypted   bool           `json:"encrypted"`
+	Content     [][]byte       `json:"content"`
+	DateCreated time.Time      `json:"date_created"`
+}
+
+// Export returns the contacts with their nonces and history encrypted with
+// the passphrase, to import on another machine. Sessions aren't exported,
+// new ones are started with the contacts.
+func (db *DB) Export(passphrase string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return nil, fmt.Errorf("config read: %w", err)
+	}
+
+	arc := archive{
+		Version:  archiveVersion,
+		Contacts: make([]archiveContact, len(df.Contacts)),
+	}
+
+	for i, contact := range df.Contacts {
+		msgs, err := readMsgsFromDisk(contact.ID)
+		if err != nil {
+			return nil, fmt.Errorf("read messages: %w", err)
+		}
+
+		ac := archiveContact{
+			dataFileUser: contact,
+			Messages:     make([]archiveMessage, len(msgs)),
+		}
+
+		for j, msg := range msgs {
+			content := make([][]byte, len(msg.Content))
+			for k, data := range msg.Content {
+				dd, err := rsa.DecryptPKCS1v15(rand.Reader, db.privKeyRSA, data)
+				if err != nil {
+					return nil, fmt.Errorf("decrypting message: %w", err)
+				}
+
+				content[k] = dd
+			}
+
+			ac.Messages[j] = archiveMessage{
+				ID:          msg.ID,
+				Name:        msg.Name,
+				Encrypted:   msg.Encrypted,
+				Content:     content,
+				DateCreated: msg.DateCreated,
+			}
+		}
+
+		arc.Contacts[i] = ac
+	}
+
+	data, err := json.Marshal(arc)
+	if err != nil {
+		return nil, fmt.Errorf("archive marshal: %w", err)
+	}
+
+	sealed, err := keyfile.Encrypt(data, passphrase, keyfile.DefaultIterations)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting archive: %w", err)
+	}
+
+	return sealed, nil
+}
+
+// Import adds the contacts in an archive made by Export. Contacts that
+// already exist keep their details and take the higher nonces, and only get
+// the archived history if they have none, so importing twice is safe.
+func (db *DB) Import(data []byte, passphrase string) error {
+	plain, err := keyfile.Decrypt(data, passphrase)
+	if err != nil {
+		return fmt.Errorf("decrypting archive: %w", err)
+	}
+
+	var arc archive
+	if err := json.Unmarshal(plain, &arc); err != nil {
+		return fmt.Errorf("archive unmarshal: %w", err)
+	}
+
+	if arc.Version != archiveVersion {
+		return fmt.Errorf("unsupported archive version %d", arc.Version)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	for _, ac := range arc.Contacts {
+		u, exists := db.contacts[ac.ID]
+
+		switch {
+		case exists:
+			u.AppLastNonce = max(u.AppLastNonce, ac.AppLastNonce)
+			u.LastNonce = max(u.LastNonce, ac.LastNonce)
+
+			for i := range df.Contacts {
+				if df.Contacts[i].ID == ac.ID {
+					df.Contacts[i].AppLastNonce = u.AppLastNonce
+					df.Contacts[i].LastNonce = u.LastNonce
+					break
+				}
+			}
+
+		default:
+			u = client.User{
+				ID:           ac.ID,
+				Name:         ac.Name,
+				Group:        ac.Group,
+				AppLastNonce: ac.AppLastNonce,
+				LastNonce:    ac.LastNonce,
+				Key:          ac.Key,
+				Verified:     ac.Verified,
+				KeyChanged:   ac.KeyChanged,
+				TCPHost:      ac.TCPHost,
+			}
+
+			df.Contacts = append(df.Contacts, ac.dataFileUser)
+		}
+
+		// The cached history is read again from the file.
+		u.Messages = nil
+		db.contacts[ac.ID] = u
+
+		if err := db.importMsgs(ac.ID, ac.Messages); err != nil {
+			return err
+		}
+	}
+
+	if err := flushDBToDisk(df); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetAsideHistory moves the history, recall indexes and sessions in the
+// folder out of the way, keeping the contacts. They're encrypted with the
+// storage key, so they can't be read once the keys are restored from a
+// mnemonic, which makes a new storage key.
+func SetAsideHistory(filePath string) error {
+	dir := filepath.Join(filePath, dbDirName)
+	aside := filepath.Join(dir, "lost-"+time.Now().UTC().Format("20060102T150405"))
+
+	for _, name := range []string{dbMsgsDirName, dbIndexDirName, dbSessionDirName} {
+		from := filepath.Join(dir, name)
+
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+
+		if err := os.MkdirAll(aside, 0700); err != nil {
+			return fmt.Errorf("set aside: %w", err)
+		}
+
+		if err := os.Rename(from, filepath.Join(aside, name)); err != nil {
+			return fmt.Errorf("set aside: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// importMsgs writes the archived messages for the contact, encrypted with
+// the storage key, unless the contact already has history.
+func (db *DB) importMsgs(id common.Address, msgs []archiveMessage) error {
+	existing, err := readMsgsFromDisk(id)
+	if err != nil {
+		return fmt.Errorf("read messages: %w", err)
+	}
+
+	if len(existing) > 0 {
+		return nil
+	}
+
+	for _, msg := range msgs {
+		encryptedData, err := db.encryptContent(msg.Content)
+		if err != nil {
+			return err
+		}
+
+		m := message{
+			ID:          msg.ID,
+			Name:        msg.Name,
+			Encrypted:   msg.Encrypted,
+			Content:     encryptedData,
+			DateCreated: msg.DateCreated,
+		}
+
+		if err := flushMsgToDisk(id, m); err != nil {
+			return fmt.Errorf("write message: %w", err)
+		}
+	}
+
+	return nil
+}
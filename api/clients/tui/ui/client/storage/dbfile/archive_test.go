@@ -0,0 +1,67 @@
+package dbfile_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+	"github.com/ardanlabs/usdl/foundation/keyfile"
+)
+
+func TestArchive(t *testing.T) {
+	db, id := newDB(t, t.TempDir())
+
+	if err := db.UpdateAppNonce(bob, 12); err != nil {
+		t.Fatalf("Should be able to update the nonce: %s", err)
+	}
+
+	for _, text := range []string{"first", "second"} {
+		msg := client.Message{From: bob, Name: "bob", Content: [][]byte{[]byte(text)}}
+		if err := db.InsertMessage(bob, msg); err != nil {
+			t.Fatalf("Should be able to add a message: %s", err)
+		}
+	}
+
+	data, err := db.Export("archive pass")
+	if err != nil {
+		t.Fatalf("Should be able to export: %s", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	// The same account on a new machine, with a new storage key.
+	moved, err := client.GenerateID()
+	if err != nil {
+		t.Fatalf("Should be able to generate an id: %s", err)
+	}
+	moved.MyAccountID = id.MyAccountID
+
+	db, err = dbfile.NewDB(t.TempDir(), moved, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the new db: %s", err)
+	}
+
+	if err := db.Import(data, "wrong pass"); !errors.Is(err, keyfile.ErrPassphrase) {
+		t.Fatalf("Should reject the wrong passphrase, got %v", err)
+	}
+
+	for range 2 {
+		if err := db.Import(data, "archive pass"); err != nil {
+			t.Fatalf("Should be able to import: %s", err)
+		}
+	}
+
+	usr, err := db.QueryContactByID(bob)
+	if err != nil {
+		t.Fatalf("Should import bob: %s", err)
+	}
+
+	if usr.AppLastNonce != 12 {
+		t.Fatalf("Should import the nonce, got %d", usr.AppLastNonce)
+	}
+
+	if len(usr.Messages) != 2 || string(usr.Messages[1].Content[0]) != "second" {
+		t.Fatalf("Should import the history once, got %d messages", len(usr.Messages))
+	}
+}
@@ -2,6 +2,7 @@
 package dbfile
 
 import (
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
@@ -10,14 +11,18 @@ import (
 	"time"
 
 	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/agents/recall"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 type DB struct {
-	myAccount  client.MyAccount
-	privKeyRSA *rsa.PrivateKey
-	contacts   map[common.Address]client.User
-	mu         sync.RWMutex
+	myAccount     client.MyAccount
+	privKeyRSA    *rsa.PrivateKey
+	privKeyX25519 *ecdh.PrivateKey
+	contacts      map[common.Address]client.User
+	mu            sync.RWMutex
+	indexes       map[common.Address]*recall.Index
+	idxMu         sync.Mutex
 }
 
 func NewDB(filePath string, id client.ID, jwt string) (*DB, error) {
@@ -31,9 +36,12 @@ func NewDB(filePath string, id client.ID, jwt string) (*DB, error) {
 		contacts[usr.ID] = client.User{
 			ID:           usr.ID,
 			Name:         usr.Name,
+			Group:        usr.Group,
 			AppLastNonce: usr.AppLastNonce,
 			LastNonce:    usr.LastNonce,
 			Key:          usr.Key,
+			Verified:     usr.Verified,
+			KeyChanged:   usr.KeyChanged,
 			TCPHost:      usr.TCPHost,
 		}
 	}
@@ -45,8 +53,10 @@ func NewDB(filePath string, id client.ID, jwt string) (*DB, error) {
 			ProfilePath: df.MyAccount.ProfilePath,
 			JWT:         df.MyAccount.JWT,
 		},
-		privKeyRSA: id.PrivKeyRSA,
-		contacts:   contacts,
+		privKeyRSA:    id.PrivKeyRSA,
+		privKeyX25519: id.PrivKeyX25519,
+		contacts:      contacts,
+		indexes:       make(map[common.Address]*recall.Index),
 	}
 
 	return &db, nil
@@ -137,6 +147,47 @@ func (db *DB) QueryContactByID(id common.Address) (client.User, error) {
 }
 
 func (db *DB) InsertContact(id common.Address, name string) (client.User, error) {
+	return db.insertContact(id, name, false)
+}
+
+func (db *DB) InsertGroup(id common.Address, name string) (client.User, error) {
+	return db.insertContact(id, name, true)
+}
+
+func (db *DB) DeleteContact(id common.Address) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// -------------------------------------------------------------------------
+	// Update in the in-memory cache of contacts.
+
+	if _, exists := db.contacts[id]; !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	delete(db.contacts, id)
+
+	// -------------------------------------------------------------------------
+	// Update the local file.
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	for i, contact := range df.Contacts {
+		if contact.ID == id {
+			df.Contacts = append(df.Contacts[:i], df.Contacts[i+1:]...)
+			break
+		}
+	}
+
+	flushDBToDisk(df)
+
+	return nil
+}
+
+func (db *DB) insertContact(id common.Address, name string, group bool) (client.User, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -144,8 +195,9 @@ func (db *DB) InsertContact(id common.Address, name string) (client.User, error)
 	// Update in the in-memory cache of contacts.
 
 	db.contacts[id] = client.User{
-		ID:   id,
-		Name: name,
+		ID:    id,
+		Name:  name,
+		Group: group,
 	}
 
 	// -------------------------------------------------------------------------
@@ -157,8 +209,9 @@ func (db *DB) InsertContact(id common.Address, name string) (client.User, error)
 	}
 
 	dfu := dataFileUser{
-		ID:   id,
-		Name: name,
+		ID:    id,
+		Name:  name,
+		Group: group,
 	}
 
 	df.Contacts = append(df.Contacts, dfu)
@@ -169,14 +222,22 @@ func (db *DB) InsertContact(id common.Address, name string) (client.User, error)
 	// Return the new contact.
 
 	u := client.User{
-		ID:   id,
-		Name: name,
+		ID:    id,
+		Name:  name,
+		Group: group,
 	}
 
 	return u, nil
 }
 
 func (db *DB) InsertMessage(id common.Address, msg client.Message) error {
+
+	// Load the history first so the cache stays in the same order as the
+	// file, which the recall index depends on.
+	if _, err := db.QueryContactByID(id); err != nil {
+		return err
+	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -188,15 +249,9 @@ func (db *DB) InsertMessage(id common.Address, msg client.Message) error {
 	u.Messages = append(u.Messages, msg)
 	db.contacts[id] = u
 
-	encryptedData := make([][]byte, len(msg.Content))
-
-	for i, msg := range msg.Content {
-		ed, err := rsa.EncryptPKCS1v15(rand.Reader, &db.privKeyRSA.PublicKey, msg)
-		if err != nil {
-			return fmt.Errorf("encrypting message: %w", err)
-		}
-
-		encryptedData[i] = ed
+	encryptedData, err := db.encryptContent(msg.Content)
+	if err != nil {
+		return err
 	}
 
 	m := message{
@@ -214,6 +269,27 @@ func (db *DB) InsertMessage(id common.Address, msg client.Message) error {
 	return nil
 }
 
+// encryptContent encrypts the message with the storage key. RSA can only
+// encrypt a small block, so each chunk is split into blocks of maxChunk bytes.
+func (db *DB) encryptContent(content [][]byte) ([][]byte, error) {
+	var encryptedData [][]byte
+
+	for _, chunk := range content {
+		for start := 0; start == 0 || start < len(chunk); start += maxChunk {
+			end := min(start+maxChunk, len(chunk))
+
+			ed, err := rsa.EncryptPKCS1v15(rand.Reader, &db.privKeyRSA.PublicKey, chunk[start:end])
+			if err != nil {
+				return nil, fmt.Errorf("encrypting message: %w", err)
+			}
+
+			encryptedData = append(encryptedData, ed)
+		}
+	}
+
+	return encryptedData, nil
+}
+
 func (db *DB) UpdateAppNonce(id common.Address, nonce uint64) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -321,3 +397,147 @@ func (db *DB) UpdateContactKey(id common.Address, key string) error {
 
 	return nil
 }
+
+func (db *DB) UpdateContactVerified(id common.Address, verified bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// -------------------------------------------------------------------------
+	// Update in the in-memory cache of contacts.
+
+	u, exists := db.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	u.Verified = verified
+
+	db.contacts[id] = u
+
+	// -------------------------------------------------------------------------
+	// Update the local file.
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	for i, contact := range df.Contacts {
+		if contact.ID == id {
+			df.Contacts[i].Verified = verified
+			break
+		}
+	}
+
+	flushDBToDisk(df)
+
+	return nil
+}
+
+func (db *DB) UpdateContactKeyChanged(id common.Address, changed bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// -------------------------------------------------------------------------
+	// Update in the in-memory cache of contacts.
+
+	u, exists := db.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	u.KeyChanged = changed
+
+	db.contacts[id] = u
+
+	// -------------------------------------------------------------------------
+	// Update the local file.
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	for i, contact := range df.Contacts {
+		if contact.ID == id {
+			df.Contacts[i].KeyChanged = changed
+			break
+		}
+	}
+
+	flushDBToDisk(df)
+
+	return nil
+}
+
+func (db *DB) UpdateContactName(id common.Address, name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// -------------------------------------------------------------------------
+	// Update in the in-memory cache of contacts.
+
+	u, exists := db.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	u.Name = name
+
+	db.contacts[id] = u
+
+	// -------------------------------------------------------------------------
+	// Update the local file.
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	for i, contact := range df.Contacts {
+		if contact.ID == id {
+			df.Contacts[i].Name = name
+			break
+		}
+	}
+
+	flushDBToDisk(df)
+
+	return nil
+}
+
+func (db *DB) UpdateContactTCPHost(id common.Address, host string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// -------------------------------------------------------------------------
+	// Update in the in-memory cache of contacts.
+
+	u, exists := db.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	u.TCPHost = host
+
+	db.contacts[id] = u
+
+	// -------------------------------------------------------------------------
+	// Update the local file.
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	for i, contact := range df.Contacts {
+		if contact.ID == id {
+			df.Contacts[i].TCPHost = host
+			break
+		}
+	}
+
+	flushDBToDisk(df)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package dbfile_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var bob = common.HexToAddress("0xdd6B972ffcc631a62CAE1BB9d80b7ff429c8ebA4")
+
+// newDB opens a db in the directory for a new account that has bob as a
+// contact.
+func newDB(t *testing.T, dir string) (*dbfile.DB, client.ID) {
+	t.Helper()
+
+	id, err := client.GenerateID()
+	if err != nil {
+		t.Fatalf("Should be able to generate an id: %s", err)
+	}
+
+	db, err := dbfile.NewDB(dir, id, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the db: %s", err)
+	}
+
+	if _, err := db.InsertContact(bob, "bob"); err != nil {
+		t.Fatalf("Should be able to add bob: %s", err)
+	}
+
+	return db, id
+}
+
+// =============================================================================
+
+func TestLongMessage(t *testing.T) {
+	dir := t.TempDir()
+	db, id := newDB(t, dir)
+
+	// Longer than RSA can encrypt in one block.
+	text := strings.Repeat("the plants are thirsty ", 50)
+
+	msg := client.Message{From: bob, Name: "bob", Content: [][]byte{[]byte(text)}}
+	if err := db.InsertMessage(bob, msg); err != nil {
+		t.Fatalf("Should be able to add a long message: %s", err)
+	}
+
+	db, err := dbfile.NewDB(dir, id, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the db again: %s", err)
+	}
+
+	usr, err := db.QueryContactByID(bob)
+	if err != nil {
+		t.Fatalf("Should find bob: %s", err)
+	}
+
+	if len(usr.Messages) != 1 || client.StitchMessages(usr.Messages[0].Content) != text {
+		t.Fatalf("Should read the long message back, got %d messages", len(usr.Messages))
+	}
+}
@@ -3,7 +3,9 @@ package dbfile
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,15 +14,19 @@ import (
 )
 
 const (
-	dbDirName     = "db"
-	dbMsgsDirName = "msgs"
-	dbFileName    = "data.json"
+	dbDirName        = "db"
+	dbMsgsDirName    = "msgs"
+	dbIndexDirName   = "index"
+	dbSessionDirName = "sessions"
+	dbFileName       = "data.json"
 )
 
 var (
-	dbFileDir string
-	dbMsgsDir string
-	dbFile    string
+	dbFileDir    string
+	dbMsgsDir    string
+	dbIndexDir   string
+	dbSessionDir string
+	dbFile       string
 )
 
 type message struct {
@@ -31,6 +37,11 @@ type message struct {
 	DateCreated time.Time      `json:"date_created"`
 }
 
+// indexEntry holds the encrypted terms of one message for the recall index.
+type indexEntry struct {
+	Content [][]byte `json:"content"`
+}
+
 type myAccount struct {
 	ID          common.Address `json:"id"`
 	Name        string         `json:"name"`
@@ -41,9 +52,12 @@ type myAccount struct {
 type dataFileUser struct {
 	ID           common.Address `json:"id"`
 	Name         string         `json:"name"`
+	Group        bool           `json:"group,omitempty"`
 	AppLastNonce uint64         `json:"app_last_nonce"`
 	LastNonce    uint64         `json:"last_nonce"`
 	Key          string         `json:"key,omitempty"`
+	Verified     bool           `json:"verified,omitempty"`
+	KeyChanged   bool           `json:"key_changed,omitempty"`
 	TCPHost      string         `json:"tcp_host,omitempty"`
 }
 
@@ -55,10 +69,14 @@ type dataFile struct {
 func newDB(filePath string, myAccountID common.Address, jwt string) (dataFile, error) {
 	dbFileDir = filepath.Join(filePath, dbDirName)
 	dbMsgsDir = filepath.Join(filePath, dbDirName, dbMsgsDirName)
+	dbIndexDir = filepath.Join(filePath, dbDirName, dbIndexDirName)
+	dbSessionDir = filepath.Join(filePath, dbDirName, dbSessionDirName)
 	dbFile = filepath.Join(filePath, dbDirName, dbFileName)
 
 	os.MkdirAll(dbFileDir, os.ModePerm)
 	os.MkdirAll(dbMsgsDir, os.ModePerm)
+	os.MkdirAll(dbIndexDir, os.ModePerm)
+	os.MkdirAll(dbSessionDir, 0700)
 
 	var df dataFile
 
@@ -182,13 +200,13 @@ func flushMsgToDisk(id common.Address, msg message) error {
 	switch {
 	case err != nil:
 
-		f, err = os.Create(fileName)
+		f, err = os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
 		if err != nil {
 			return fmt.Errorf("message file create: %w", err)
 		}
 
 	default:
-		f, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+		f, err = os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0600)
 		if err != nil {
 			return fmt.Errorf("message file open: %w", err)
 		}
@@ -211,3 +229,168 @@ func flushMsgToDisk(id common.Address, msg message) error {
 
 	return nil
 }
+
+func readIndexFromDisk(id common.Address) ([]indexEntry, error) {
+	fileName := filepath.Join(dbIndexDir, id.Hex()+".idx")
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("index file open: %w", err)
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+
+	for scanner.Scan() {
+		var entry indexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("unmarshal: %w", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+
+	return entries, nil
+}
+
+// flushIndexToDisk appends the entries to the contact's index file, or
+// replaces the file when rebuilding the index.
+func flushIndexToDisk(id common.Address, entries []indexEntry, rebuild bool) error {
+	fileName := filepath.Join(dbIndexDir, id.Hex()+".idx")
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if rebuild {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(fileName, flag, 0600)
+	if err != nil {
+		return fmt.Errorf("index file open: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("index marshal: %w", err)
+		}
+
+		w.Write(data)
+		w.WriteString("\n")
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("index file write: %w", err)
+	}
+
+	return nil
+}
+
+func readSessionFromDisk(id common.Address) ([]byte, error) {
+	fileName := filepath.Join(dbSessionDir, id.Hex()+".ses")
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("session file read: %w", err)
+	}
+
+	return data, nil
+}
+
+// flushSessionToDisk replaces the contact's session file. The file is
+// written next to the old one and renamed so a crash never leaves a
+// partial session behind.
+func flushSessionToDisk(id common.Address, data []byte) error {
+	fileName := filepath.Join(dbSessionDir, id.Hex()+".ses")
+	tmpName := fileName + ".tmp"
+
+	if err := os.WriteFile(tmpName, data, 0600); err != nil {
+		return fmt.Errorf("session file write: %w", err)
+	}
+
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return fmt.Errorf("session file rename: %w", err)
+	}
+
+	return nil
+}
+
+// moveMsgsOnDisk moves the messages of one contact to another, before any
+// messages the other contact already has.
+func moveMsgsOnDisk(oldID common.Address, newID common.Address) error {
+	oldName := filepath.Join(dbMsgsDir, oldID.Hex()+".msg")
+	newName := filepath.Join(dbMsgsDir, newID.Hex()+".msg")
+
+	oldData, err := os.ReadFile(oldName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("message file read: %w", err)
+	}
+
+	newData, err := os.ReadFile(newName)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("message file read: %w", err)
+	}
+
+	tmpName := newName + ".tmp"
+
+	if err := os.WriteFile(tmpName, append(oldData, newData...), 0600); err != nil {
+		return fmt.Errorf("message file write: %w", err)
+	}
+
+	if err := os.Rename(tmpName, newName); err != nil {
+		return fmt.Errorf("message file rename: %w", err)
+	}
+
+	if err := os.Remove(oldName); err != nil {
+		return fmt.Errorf("message file remove: %w", err)
+	}
+
+	return nil
+}
+
+// removeContactFromDisk removes the contact's recall index and session,
+// which can't be carried over to another ID.
+func removeContactFromDisk(id common.Address) error {
+	fileNames := []string{
+		filepath.Join(dbIndexDir, id.Hex()+".idx"),
+		filepath.Join(dbSessionDir, id.Hex()+".ses"),
+	}
+
+	for _, fileName := range fileNames {
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func removeSessionsFromDisk() error {
+	if err := os.RemoveAll(dbSessionDir); err != nil {
+		return fmt.Errorf("sessions remove: %w", err)
+	}
+
+	if err := os.MkdirAll(dbSessionDir, 0700); err != nil {
+		return fmt.Errorf("sessions create: %w", err)
+	}
+
+	return nil
+}
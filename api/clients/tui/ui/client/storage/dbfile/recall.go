@@ -0,0 +1,165 @@
+package dbfile
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/agents/recall"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxChunk is the most data a 2048 bit RSA key can encrypt with PKCS1v15,
+// less a margin, the same size messages are split into.
+const maxChunk = 240
+
+// Recall returns the contact's past messages that best match the query and
+// fit in the token budget, oldest first. The index is kept on disk next to
+// the messages and encrypted the same way, and catches up with any messages
+// it hasn't seen each time it's used.
+func (db *DB) Recall(id common.Address, query string, maxTokens int) ([]client.Message, error) {
+	usr, err := db.QueryContactByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := usr.Messages
+
+	db.idxMu.Lock()
+	defer db.idxMu.Unlock()
+
+	ix, err := db.index(id, msgs)
+	if err != nil {
+		return nil, fmt.Errorf("index: %w", err)
+	}
+
+	results := ix.Search(query)
+
+	cost := func(doc int) int {
+		if doc >= len(msgs) {
+			return maxTokens + 1
+		}
+		return recall.Tokens(client.StitchMessages(msgs[doc].Content))
+	}
+
+	docs := recall.Select(results, cost, maxTokens)
+
+	found := make([]client.Message, len(docs))
+	for i, doc := range docs {
+		found[i] = msgs[doc]
+	}
+
+	return found, nil
+}
+
+// index returns the contact's index, loading it from disk the first time
+// and adding the messages it hasn't seen.
+func (db *DB) index(id common.Address, msgs []client.Message) (*recall.Index, error) {
+	ix, exists := db.indexes[id]
+	if !exists {
+		var err error
+		if ix, err = db.loadIndex(id, len(msgs)); err != nil {
+			return nil, err
+		}
+		db.indexes[id] = ix
+	}
+
+	if ix.Len() >= len(msgs) {
+		return ix, nil
+	}
+
+	var entries []indexEntry
+
+	for _, msg := range msgs[ix.Len():] {
+		terms := recall.Terms(client.StitchMessages(msg.Content))
+
+		entry, err := db.encryptTerms(terms)
+		if err != nil {
+			return nil, err
+		}
+
+		ix.Add(terms)
+		entries = append(entries, entry)
+	}
+
+	// A new index is written in full, replacing anything left on disk.
+	if err := flushIndexToDisk(id, entries, ix.Len() == len(entries)); err != nil {
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// loadIndex reads the contact's index from disk. An index with more entries
+// than there are messages no longer matches the history and starts over.
+func (db *DB) loadIndex(id common.Address, numMsgs int) (*recall.Index, error) {
+	ix := recall.New()
+
+	entries, err := readIndexFromDisk(id)
+	if err != nil {
+		return nil, fmt.Errorf("read index: %w", err)
+	}
+
+	if len(entries) > numMsgs {
+		return ix, nil
+	}
+
+	for _, entry := range entries {
+		terms, err := db.decryptTerms(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		ix.Add(terms)
+	}
+
+	return ix, nil
+}
+
+func (db *DB) encryptTerms(terms map[string]int) (indexEntry, error) {
+	data, err := json.Marshal(terms)
+	if err != nil {
+		return indexEntry{}, fmt.Errorf("marshal terms: %w", err)
+	}
+
+	var content [][]byte
+
+	for start := 0; start < len(data); start += maxChunk {
+		end := min(start+maxChunk, len(data))
+
+		ed, err := rsa.EncryptPKCS1v15(rand.Reader, &db.privKeyRSA.PublicKey, data[start:end])
+		if err != nil {
+			return indexEntry{}, fmt.Errorf("encrypting terms: %w", err)
+		}
+
+		content = append(content, ed)
+	}
+
+	return indexEntry{Content: content}, nil
+}
+
+func (db *DB) decryptTerms(entry indexEntry) (map[string]int, error) {
+	var data []byte
+
+	for _, chunk := range entry.Content {
+		dd, err := rsa.DecryptPKCS1v15(rand.Reader, db.privKeyRSA, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting terms: %w", err)
+		}
+
+		data = append(data, dd...)
+	}
+
+	terms := make(map[string]int)
+	if len(data) == 0 {
+		return terms, nil
+	}
+
+	if err := json.Unmarshal(data, &terms); err != nil {
+		return nil, fmt.Errorf("unmarshal terms: %w", err)
+	}
+
+	return terms, nil
+}
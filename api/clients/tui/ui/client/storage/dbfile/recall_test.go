@@ -0,0 +1,81 @@
+package dbfile_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+)
+
+func TestRecall(t *testing.T) {
+	dir := t.TempDir()
+	db, id := newDB(t, dir)
+
+	texts := []string{
+		"my favourite colour is turquoise",
+		"the meeting moved to thursday",
+		"did you water the plants",
+	}
+
+	for _, text := range texts {
+		msg := client.Message{From: bob, Name: "bob", Content: [][]byte{[]byte(text)}}
+		if err := db.InsertMessage(bob, msg); err != nil {
+			t.Fatalf("Should be able to add a message: %s", err)
+		}
+	}
+
+	found, err := db.Recall(bob, "what colour do I like?", 100)
+	if err != nil {
+		t.Fatalf("Should be able to recall: %s", err)
+	}
+
+	if len(found) != 1 || client.StitchMessages(found[0].Content) != texts[0] {
+		t.Fatalf("Should recall the colour message, got %+v", found)
+	}
+
+	// -------------------------------------------------------------------------
+
+	data, err := os.ReadFile(filepath.Join(dir, "db", "index", bob.Hex()+".idx"))
+	if err != nil {
+		t.Fatalf("Should write the index to disk: %s", err)
+	}
+
+	if bytes.Contains(data, []byte("turquoise")) || bytes.Contains(data, []byte("thursday")) {
+		t.Fatal("Should encrypt the index on disk")
+	}
+
+	for _, name := range []string{filepath.Join("index", bob.Hex()+".idx"), filepath.Join("msgs", bob.Hex()+".msg")} {
+		info, err := os.Stat(filepath.Join(dir, "db", name))
+		if err != nil {
+			t.Fatalf("Should be able to stat %s: %s", name, err)
+		}
+
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Fatalf("Should only let the user read %s, got %o", name, perm)
+		}
+	}
+
+	// -------------------------------------------------------------------------
+
+	db, err = dbfile.NewDB(dir, id, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the db again: %s", err)
+	}
+
+	msg := client.Message{From: bob, Name: "bob", Content: [][]byte{[]byte("the plants are thirsty")}}
+	if err := db.InsertMessage(bob, msg); err != nil {
+		t.Fatalf("Should be able to add a message: %s", err)
+	}
+
+	found, err = db.Recall(bob, "plants", 100)
+	if err != nil {
+		t.Fatalf("Should be able to recall after a restart: %s", err)
+	}
+
+	if len(found) != 2 {
+		t.Fatalf("Should recall both plant messages after a restart, got %+v", found)
+	}
+}
@@ -0,0 +1,120 @@
+package dbfile
+
+import (
+	"fmt"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// RotateContact moves the contact to the new ID with its history and
+// nonces, after any history the new ID already has. The session is dropped
+// since it's bound to the old ID, and the recall index is rebuilt the next
+// time it's used.
+func (db *DB) RotateContact(oldID common.Address, newID common.Address) (client.User, error) {
+	u, err := func() (client.User, error) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		// ---------------------------------------------------------------------
+		// Update in the in-memory cache of contacts.
+
+		u, exists := db.contacts[oldID]
+		if !exists {
+			return client.User{}, fmt.Errorf("contact not found")
+		}
+
+		u.ID = newID
+		u.Messages = nil
+
+		if cur, exists := db.contacts[newID]; exists {
+			u.AppLastNonce = max(u.AppLastNonce, cur.AppLastNonce)
+			u.LastNonce = max(u.LastNonce, cur.LastNonce)
+		}
+
+		delete(db.contacts, oldID)
+		db.contacts[newID] = u
+
+		// ---------------------------------------------------------------------
+		// Update the local files.
+
+		df, err := readDBFromDisk()
+		if err != nil {
+			return client.User{}, fmt.Errorf("config read: %w", err)
+		}
+
+		contacts := make([]dataFileUser, 0, len(df.Contacts))
+		for _, contact := range df.Contacts {
+			if contact.ID != oldID && contact.ID != newID {
+				contacts = append(contacts, contact)
+			}
+		}
+
+		df.Contacts = append(contacts, dataFileUser{
+			ID:           u.ID,
+			Name:         u.Name,
+			AppLastNonce: u.AppLastNonce,
+			LastNonce:    u.LastNonce,
+			Key:          u.Key,
+			Verified:     u.Verified,
+			KeyChanged:   u.KeyChanged,
+			TCPHost:      u.TCPHost,
+		})
+
+		if err := flushDBToDisk(df); err != nil {
+			return client.User{}, err
+		}
+
+		if err := moveMsgsOnDisk(oldID, newID); err != nil {
+			return client.User{}, fmt.Errorf("move messages: %w", err)
+		}
+
+		for _, id := range []common.Address{oldID, newID} {
+			if err := removeContactFromDisk(id); err != nil {
+				return client.User{}, err
+			}
+		}
+
+		return u, nil
+	}()
+
+	if err != nil {
+		return client.User{}, err
+	}
+
+	db.idxMu.Lock()
+	defer db.idxMu.Unlock()
+
+	delete(db.indexes, oldID)
+	delete(db.indexes, newID)
+
+	return u, nil
+}
+
+// RotateAccount moves this account to the new ID. The sessions are dropped
+// since they're bound to the old ID and sealed with the old keys, and new
+// sessions are sealed with the new ID's key, which the keyfile now holds.
+func (db *DB) RotateAccount(id client.ID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	df, err := readDBFromDisk()
+	if err != nil {
+		return fmt.Errorf("config read: %w", err)
+	}
+
+	df.MyAccount.ID = id.MyAccountID
+
+	if err := flushDBToDisk(df); err != nil {
+		return err
+	}
+
+	db.myAccount.ID = id.MyAccountID
+	db.privKeyX25519 = id.PrivKeyX25519
+
+	if err := removeSessionsFromDisk(); err != nil {
+		return err
+	}
+
+	return nil
+}
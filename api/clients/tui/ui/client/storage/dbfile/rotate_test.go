@@ -0,0 +1,99 @@
+package dbfile_test
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRotateContact(t *testing.T) {
+	dir := t.TempDir()
+	db, id := newDB(t, dir)
+
+	if err := db.UpdateContactNonce(bob, 7); err != nil {
+		t.Fatalf("Should be able to update the nonce: %s", err)
+	}
+
+	msg := client.Message{From: bob, Name: "bob", Content: [][]byte{[]byte("before the move")}}
+	if err := db.InsertMessage(bob, msg); err != nil {
+		t.Fatalf("Should be able to add a message: %s", err)
+	}
+
+	if err := db.UpdateSession(bob, []byte("session")); err != nil {
+		t.Fatalf("Should be able to store a session: %s", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	newBob := common.HexToAddress("0x6327A38415C53FFb36c11db55Ea74cc9cB4976Fd")
+
+	if _, err := db.RotateContact(bob, newBob); err != nil {
+		t.Fatalf("Should be able to rotate bob: %s", err)
+	}
+
+	db, err := dbfile.NewDB(dir, id, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the db again: %s", err)
+	}
+
+	if _, err := db.QueryContactByID(bob); err == nil {
+		t.Fatal("Should not keep the old ID")
+	}
+
+	usr, err := db.QueryContactByID(newBob)
+	if err != nil {
+		t.Fatalf("Should find bob at the new ID: %s", err)
+	}
+
+	if usr.Name != "bob" || usr.LastNonce != 7 {
+		t.Fatalf("Should keep bob's name and nonce, got %q and %d", usr.Name, usr.LastNonce)
+	}
+
+	if len(usr.Messages) != 1 || string(usr.Messages[0].Content[0]) != "before the move" {
+		t.Fatalf("Should move bob's history, got %d messages", len(usr.Messages))
+	}
+
+	data, err := db.QuerySession(newBob)
+	if err != nil || data != nil {
+		t.Fatalf("Should drop the session, got %q: %v", data, err)
+	}
+}
+
+func TestRotateAccount(t *testing.T) {
+	dir := t.TempDir()
+	db, id := newDB(t, dir)
+
+	// -------------------------------------------------------------------------
+
+	// The rotated ID keeps the storage key, like the keyfile does.
+	newID, err := client.GenerateID()
+	if err != nil {
+		t.Fatalf("Should be able to generate an id: %s", err)
+	}
+	newID.PrivKeyRSA = id.PrivKeyRSA
+	newID.PubKeyRSA = id.PubKeyRSA
+
+	if err := db.RotateAccount(newID); err != nil {
+		t.Fatalf("Should be able to rotate the account: %s", err)
+	}
+
+	if err := db.UpdateSession(bob, []byte("after the rotation")); err != nil {
+		t.Fatalf("Should be able to store a session: %s", err)
+	}
+
+	db, err = dbfile.NewDB(dir, newID, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the db with the new keys: %s", err)
+	}
+
+	if acct := db.MyAccount(); acct.ID != newID.MyAccountID {
+		t.Fatalf("Should keep the new ID, got %s", acct.ID)
+	}
+
+	data, err := db.QuerySession(bob)
+	if err != nil || string(data) != "after the rotation" {
+		t.Fatalf("Should read the session with the new keys, got %q: %v", data, err)
+	}
+}
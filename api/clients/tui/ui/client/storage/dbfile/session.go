@@ -0,0 +1,49 @@
+package dbfile
+
+import (
+	"crypto/ecdh"
+	"fmt"
+
+	"github.com/ardanlabs/usdl/foundation/envelope"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// QuerySession returns the contact's session state, or nothing if there
+// isn't a session yet.
+func (db *DB) QuerySession(id common.Address) ([]byte, error) {
+	sealed, err := readSessionFromDisk(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if sealed == nil {
+		return nil, nil
+	}
+
+	data, err := envelope.Open(db.sessionKey(), sealed, id.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("decrypting session: %w", err)
+	}
+
+	return data, nil
+}
+
+// UpdateSession stores the contact's session state. The state holds the
+// keys for the session, so it's sealed for this account's own key.
+func (db *DB) UpdateSession(id common.Address, data []byte) error {
+	sealed, err := envelope.Seal(db.sessionKey().PublicKey(), data, id.Bytes())
+	if err != nil {
+		return fmt.Errorf("encrypting session: %w", err)
+	}
+
+	return flushSessionToDisk(id, sealed)
+}
+
+// sessionKey returns the key the sessions are sealed with, which changes
+// when the account is rotated.
+func (db *DB) sessionKey() *ecdh.PrivateKey {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.privKeyX25519
+}
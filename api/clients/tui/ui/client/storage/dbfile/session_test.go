@@ -0,0 +1,52 @@
+package dbfile_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+)
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	db, id := newDB(t, dir)
+
+	data, err := db.QuerySession(bob)
+	if err != nil || data != nil {
+		t.Fatalf("Should have no session yet, got %q: %v", data, err)
+	}
+
+	state := []byte(`{"rk":"root key secret"}`)
+	if err := db.UpdateSession(bob, state); err != nil {
+		t.Fatalf("Should be able to store a session: %s", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, "db", "sessions", bob.Hex()+".ses"))
+	if err != nil {
+		t.Fatalf("Should write the session to disk: %s", err)
+	}
+
+	if bytes.Contains(onDisk, []byte("root key secret")) {
+		t.Fatal("Should encrypt the session on disk")
+	}
+
+	// -------------------------------------------------------------------------
+
+	db, err = dbfile.NewDB(dir, id, "jwt")
+	if err != nil {
+		t.Fatalf("Should be able to open the db again: %s", err)
+	}
+
+	data, err = db.QuerySession(bob)
+	if err != nil {
+		t.Fatalf("Should be able to read the session after a restart: %s", err)
+	}
+
+	if !bytes.Equal(data, state) {
+		t.Fatalf("Should read back the session, got %q", data)
+	}
+}
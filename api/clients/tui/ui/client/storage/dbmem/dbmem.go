@@ -0,0 +1,252 @@
+// Package dbmem provides an in memory database for the client application.
+// Nothing is written to disk which makes it useful for tests and headless
+// clients.
+package dbmem
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/agents/recall"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type DB struct {
+	myAccount client.MyAccount
+	contacts  map[common.Address]client.User
+	sessions  map[common.Address][]byte
+	mu        sync.RWMutex
+}
+
+func NewDB(id client.ID, name string, jwt string) *DB {
+	db := DB{
+		myAccount: client.MyAccount{
+			ID:   id.MyAccountID,
+			Name: name,
+			JWT:  jwt,
+		},
+		contacts: make(map[common.Address]client.User),
+		sessions: make(map[common.Address][]byte),
+	}
+
+	return &db
+}
+
+func (db *DB) MyAccount() client.MyAccount {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.myAccount
+}
+
+func (db *DB) Contacts() []client.User {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	users := make([]client.User, 0, len(db.contacts))
+	for _, user := range db.contacts {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name <= users[j].Name
+	})
+
+	return users
+}
+
+func (db *DB) QueryContactByID(id common.Address) (client.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	u, exists := db.contacts[id]
+	if !exists {
+		return client.User{}, fmt.Errorf("contact not found")
+	}
+
+	return u, nil
+}
+
+func (db *DB) InsertContact(id common.Address, name string) (client.User, error) {
+	return db.insertContact(id, name, false)
+}
+
+func (db *DB) InsertGroup(id common.Address, name string) (client.User, error) {
+	return db.insertContact(id, name, true)
+}
+
+func (db *DB) DeleteContact(id common.Address) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists := db.contacts[id]; !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	delete(db.contacts, id)
+
+	return nil
+}
+
+func (db *DB) InsertMessage(id common.Address, msg client.Message) error {
+	return db.update(id, func(u *client.User) {
+		u.Messages = append(u.Messages, msg)
+	})
+}
+
+func (db *DB) UpdateAppNonce(id common.Address, nonce uint64) error {
+	return db.update(id, func(u *client.User) {
+		u.AppLastNonce = nonce
+	})
+}
+
+func (db *DB) UpdateContactNonce(id common.Address, nonce uint64) error {
+	return db.update(id, func(u *client.User) {
+		u.LastNonce = nonce
+	})
+}
+
+func (db *DB) UpdateContactKey(id common.Address, key string) error {
+	return db.update(id, func(u *client.User) {
+		u.Key = key
+	})
+}
+
+func (db *DB) UpdateContactVerified(id common.Address, verified bool) error {
+	return db.update(id, func(u *client.User) {
+		u.Verified = verified
+	})
+}
+
+func (db *DB) UpdateContactKeyChanged(id common.Address, changed bool) error {
+	return db.update(id, func(u *client.User) {
+		u.KeyChanged = changed
+	})
+}
+
+func (db *DB) QuerySession(id common.Address) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.sessions[id], nil
+}
+
+func (db *DB) UpdateSession(id common.Address, data []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.sessions[id] = data
+
+	return nil
+}
+
+// RotateContact moves the contact to the new ID with its history and
+// nonces, after any history the new ID already has. The session is dropped
+// since it's bound to the old ID.
+func (db *DB) RotateContact(oldID common.Address, newID common.Address) (client.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, exists := db.contacts[oldID]
+	if !exists {
+		return client.User{}, fmt.Errorf("contact not found")
+	}
+
+	u.ID = newID
+
+	if cur, exists := db.contacts[newID]; exists {
+		u.Messages = append(u.Messages, cur.Messages...)
+		u.AppLastNonce = max(u.AppLastNonce, cur.AppLastNonce)
+		u.LastNonce = max(u.LastNonce, cur.LastNonce)
+	}
+
+	delete(db.contacts, oldID)
+	delete(db.sessions, oldID)
+	delete(db.sessions, newID)
+
+	db.contacts[newID] = u
+
+	return u, nil
+}
+
+// RotateAccount moves this account to the new ID. The sessions are dropped
+// since they're bound to the old ID.
+func (db *DB) RotateAccount(id client.ID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.myAccount.ID = id.MyAccountID
+	db.sessions = make(map[common.Address][]byte)
+
+	return nil
+}
+
+// UpdateContactTCPHost sets the address used to open a peer-to-peer
+// connection to the contact. The file database reads this from the config.
+func (db *DB) UpdateContactTCPHost(id common.Address, host string) error {
+	return db.update(id, func(u *client.User) {
+		u.TCPHost = host
+	})
+}
+
+// =============================================================================
+
+func (db *DB) insertContact(id common.Address, name string, group bool) (client.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := client.User{
+		ID:    id,
+		Name:  name,
+		Group: group,
+	}
+
+	db.contacts[id] = u
+
+	return u, nil
+}
+
+func (db *DB) update(id common.Address, f func(u *client.User)) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, exists := db.contacts[id]
+	if !exists {
+		return fmt.Errorf("contact not found")
+	}
+
+	f(&u)
+
+	db.contacts[id] = u
+
+	return nil
+}
+
+// Recall returns the contact's past messages that best match the query and
+// fit in the token budget, oldest first. The index is built on each call.
+func (db *DB) Recall(id common.Address, query string, maxTokens int) ([]client.Message, error) {
+	usr, err := db.QueryContactByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := recall.New()
+	for _, msg := range usr.Messages {
+		ix.Add(recall.Terms(client.StitchMessages(msg.Content)))
+	}
+
+	cost := func(doc int) int {
+		return recall.Tokens(client.StitchMessages(usr.Messages[doc].Content))
+	}
+
+	docs := recall.Select(ix.Search(query), cost, maxTokens)
+
+	found := make([]client.Message, len(docs))
+	for i, doc := range docs {
+		found[i] = usr.Messages[doc]
+	}
+
+	return found, nil
+}
@@ -0,0 +1,716 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ardanlabs/usdl/app/sdk/errs"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+)
+
+// Size of the plain text chunks a file is split into. The CAP accepts
+// chunks up to 1MB by default.
+const transferChunkSize = 256 * 1024
+
+// Number of bytes sealing a chunk adds, which is the size of the GCM tag.
+const transferOverhead = 16
+
+// Number of times a chunk is attempted before the transfer is considered
+// interrupted and needs to be resumed.
+const transferRetries = 3
+
+// TransferOffer represents a file a contact wants to send.
+type TransferOffer struct {
+	ID   uuid.UUID
+	From common.Address
+	Name string
+	Size int64
+}
+
+// transferMeta is the signed metadata sent with the offer. The key is the
+// AES key for the file sealed with sealKey, through the X25519 session or
+// the recipient's RSA key on an older client, so only the recipient can
+// decrypt the chunks.
+type transferMeta struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Hash      string    `json:"hash"`
+	ChunkSize int       `json:"chunkSize"`
+	Key       []byte    `json:"key"`
+}
+
+func (m transferMeta) chunks() int {
+	return int((m.Size + int64(m.ChunkSize) - 1) / int64(m.ChunkSize))
+}
+
+type transfer struct {
+	meta     transferMeta
+	peer     common.Address
+	key      []byte
+	path     string
+	token    string
+	outgoing bool
+	ready    bool
+}
+
+// =============================================================================
+
+// OfferFile offers the file to the contact. The contact must have shared
+// their key since the file is encrypted end to end.
+func (app *App) OfferFile(to common.Address, path string) (uuid.UUID, error) {
+	usr, err := app.db.QueryContactByID(to)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("query contact: %w", err)
+	}
+
+	if usr.Group {
+		return uuid.UUID{}, fmt.Errorf("files can't be sent to a group")
+	}
+
+	if usr.Key == "" {
+		return uuid.UUID{}, fmt.Errorf("contact has not shared a key")
+	}
+
+	if usr.KeyChanged {
+		return uuid.UUID{}, ErrKeyChanged
+	}
+
+	// -------------------------------------------------------------------------
+
+	f, err := os.Open(path)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("stat: %w", err)
+	}
+
+	if info.IsDir() {
+		return uuid.UUID{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return uuid.UUID{}, fmt.Errorf("hash: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return uuid.UUID{}, fmt.Errorf("key: %w", err)
+	}
+
+	encKey, err := app.sealKey(usr, key)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("encrypting key: %w", err)
+	}
+
+	meta := transferMeta{
+		ID:        uuid.New(),
+		Name:      filepath.Base(path),
+		Size:      info.Size(),
+		Hash:      hex.EncodeToString(h.Sum(nil)),
+		ChunkSize: transferChunkSize,
+		Key:       encKey,
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("marshal: %w", err)
+	}
+
+	// The CAP only issues a token for the transfer to the two of us, and
+	// only takes the chunks this file is split into.
+	create, err := json.Marshal(struct {
+		Recipient common.Address `json:"recipient"`
+		Chunks    int            `json:"chunks"`
+		ChunkSize int            `json:"chunkSize"`
+	}{
+		Recipient: to,
+		Chunks:    meta.chunks(),
+		ChunkSize: meta.ChunkSize + transferOverhead,
+	})
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("marshal: %w", err)
+	}
+
+	if err := app.transferDo(context.Background(), http.MethodPost, app.transferURL(meta.ID, ""), app.jwt, bytes.NewReader(create), "application/json", nil); err != nil {
+		return uuid.UUID{}, fmt.Errorf("create: %w", err)
+	}
+
+	app.addTransfer(&transfer{
+		meta:     meta,
+		peer:     to,
+		key:      key,
+		path:     path,
+		outgoing: true,
+	})
+
+	if err := app.sendSigned(usr, [][]byte{[]byte("/file offer"), data}, false); err != nil {
+		app.removeTransfer(meta.ID)
+		return uuid.UUID{}, err
+	}
+
+	return meta.ID, nil
+}
+
+// AcceptTransfer asks the CAP for a transfer token and hands it to the
+// sender so the upload can begin.
+func (app *App) AcceptTransfer(ctx context.Context, id uuid.UUID) error {
+	t, err := app.queryTransfer(id, false)
+	if err != nil {
+		return err
+	}
+
+	usr, err := app.db.QueryContactByID(t.peer)
+	if err != nil {
+		return fmt.Errorf("query contact: %w", err)
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+
+	if err := app.transferDo(ctx, http.MethodPost, app.transferURL(id, "/token"), app.jwt, nil, "", &resp); err != nil {
+		return fmt.Errorf("token: %w", err)
+	}
+
+	app.updateTransfer(id, func(t *transfer) {
+		t.token = resp.Token
+	})
+
+	cmd := fmt.Appendf(nil, "/file accept %s %s", id, resp.Token)
+
+	return app.sendSigned(usr, [][]byte{cmd}, false)
+}
+
+// RejectTransfer lets the sender know the file is not wanted.
+func (app *App) RejectTransfer(id uuid.UUID) error {
+	t, err := app.queryTransfer(id, false)
+	if err != nil {
+		return err
+	}
+
+	app.removeTransfer(id)
+
+	usr, err := app.db.QueryContactByID(t.peer)
+	if err != nil {
+		return fmt.Errorf("query contact: %w", err)
+	}
+
+	return app.sendSigned(usr, [][]byte{fmt.Appendf(nil, "/file reject %s", id)}, false)
+}
+
+// ResumeTransfer continues an interrupted upload or download from the
+// last chunk that made it.
+func (app *App) ResumeTransfer(ctx context.Context, id uuid.UUID) error {
+	app.transfersMu.Lock()
+	t, exists := app.transfers[id]
+	var cp transfer
+	if exists {
+		cp = *t
+	}
+	app.transfersMu.Unlock()
+
+	switch {
+	case !exists:
+		return fmt.Errorf("transfer %s not found", id)
+
+	case cp.token == "":
+		return fmt.Errorf("transfer %s has not been accepted", id)
+
+	case cp.outgoing:
+		go app.upload(ctx, cp)
+
+	case !cp.ready:
+		return fmt.Errorf("transfer %s is still being uploaded", id)
+
+	default:
+		go app.download(ctx, cp)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+func (app *App) preprocessRecvFile(inMsg incomingMessage, parts []string) error {
+	msgs := inMsg.Msg
+
+	if parts[1] == "offer" {
+		if len(msgs) < 2 {
+			return fmt.Errorf("file offer missing metadata")
+		}
+
+		var meta transferMeta
+		if err := json.Unmarshal(msgs[1], &meta); err != nil {
+			return fmt.Errorf("unmarshal offer: %w", err)
+		}
+
+		// Never trust a name that could write outside of the transfer folder.
+		meta.Name = filepath.Base(meta.Name)
+		if meta.Name == "." || meta.Name == string(filepath.Separator) || meta.ChunkSize <= 0 || meta.Size < 0 {
+			return fmt.Errorf("invalid file offer")
+		}
+
+		key, err := app.openKey(inMsg.From.ID, meta.Key)
+		if err != nil {
+			return fmt.Errorf("decrypting file key: %w", err)
+		}
+
+		app.addTransfer(&transfer{
+			meta: meta,
+			peer: inMsg.From.ID,
+			key:  key,
+			path: app.transferPath,
+		})
+
+		app.ui.TransferOffer(TransferOffer{
+			ID:   meta.ID,
+			From: inMsg.From.ID,
+			Name: meta.Name,
+			Size: meta.Size,
+		})
+
+		return nil
+	}
+
+	// -------------------------------------------------------------------------
+
+	if len(parts) < 3 {
+		return fmt.Errorf("invalid file command format: parts: %d", len(parts))
+	}
+
+	id, err := uuid.Parse(parts[2])
+	if err != nil {
+		return fmt.Errorf("invalid transfer id: %w", err)
+	}
+
+	t, err := app.queryTransfer(id, parts[1] == "accept" || parts[1] == "reject" || parts[1] == "complete")
+	if err != nil {
+		return err
+	}
+
+	if t.peer != inMsg.From.ID {
+		return fmt.Errorf("transfer %s does not belong to %s", id, inMsg.From.ID)
+	}
+
+	switch parts[1] {
+	case "accept":
+		if len(parts) < 4 {
+			return fmt.Errorf("file accept missing token")
+		}
+
+		app.updateTransfer(id, func(t *transfer) {
+			t.token = parts[3]
+		})
+		t.token = parts[3]
+
+		app.ui.WriteText(errorMessage("%s accepted %s, uploading", inMsg.From.Name, t.meta.Name))
+
+		go app.upload(context.Background(), t)
+
+	case "reject":
+		app.removeTransfer(id)
+		app.ui.WriteText(errorMessage("%s rejected %s", inMsg.From.Name, t.meta.Name))
+
+	case "done":
+		app.updateTransfer(id, func(t *transfer) {
+			t.ready = true
+		})
+
+		go app.download(context.Background(), t)
+
+	case "complete":
+		app.removeTransfer(id)
+		app.ui.WriteText(errorMessage("%s received %s", inMsg.From.Name, t.meta.Name))
+
+	default:
+		return fmt.Errorf("unknown file command: %s", parts[1])
+	}
+
+	return nil
+}
+
+// upload sends the chunks the CAP doesn't already have, which is what
+// makes resuming an interrupted upload possible.
+func (app *App) upload(ctx context.Context, t transfer) {
+	if err := app.uploadChunks(ctx, t); err != nil {
+		app.ui.WriteText(errorMessage("transfer %s interrupted: %s: use /file resume %s", t.meta.Name, err, t.meta.ID))
+		return
+	}
+
+	usr, err := app.db.QueryContactByID(t.peer)
+	if err != nil {
+		app.ui.WriteText(errorMessage("query contact: %s", err))
+		return
+	}
+
+	if err := app.sendSigned(usr, [][]byte{fmt.Appendf(nil, "/file done %s", t.meta.ID)}, false); err != nil {
+		app.ui.WriteText(errorMessage("transfer %s: %s", t.meta.Name, err))
+		return
+	}
+
+	app.ui.WriteText(errorMessage("upload of %s complete", t.meta.Name))
+}
+
+func (app *App) uploadChunks(ctx context.Context, t transfer) error {
+	var status struct {
+		Chunks []int `json:"chunks"`
+	}
+
+	if err := app.transferDo(ctx, http.MethodGet, app.transferURL(t.meta.ID, ""), t.token, nil, "", &status); err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+
+	uploaded := make(map[int]bool, len(status.Chunks))
+	for _, idx := range status.Chunks {
+		uploaded[idx] = true
+	}
+
+	f, err := os.Open(t.path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	gcm, err := newTransferGCM(t.key)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, t.meta.ChunkSize)
+
+	for idx := range t.meta.chunks() {
+		if uploaded[idx] {
+			continue
+		}
+
+		n, err := f.ReadAt(buf, int64(idx)*int64(t.meta.ChunkSize))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read chunk %d: %w", idx, err)
+		}
+
+		sealed := gcm.Seal(nil, transferNonce(idx), buf[:n], transferAD(t.meta.ID, idx))
+
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+
+		fw, err := w.CreateFormFile("chunk", fmt.Sprintf("%d", idx))
+		if err != nil {
+			return fmt.Errorf("form file: %w", err)
+		}
+
+		if _, err := fw.Write(sealed); err != nil {
+			return fmt.Errorf("form write: %w", err)
+		}
+
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("form close: %w", err)
+		}
+
+		url := app.transferURL(t.meta.ID, fmt.Sprintf("/chunks/%d", idx))
+
+		err = retry(func() error {
+			return app.transferDo(ctx, http.MethodPost, url, t.token, bytes.NewReader(body.Bytes()), w.FormDataContentType(), nil)
+		})
+		if err != nil {
+			return fmt.Errorf("upload chunk %d: %w", idx, err)
+		}
+	}
+
+	return nil
+}
+
+// download appends chunks to a partial file. The partial file only ever
+// holds whole chunks, so its size tells us where to resume from.
+func (app *App) download(ctx context.Context, t transfer) {
+	path, err := app.downloadChunks(ctx, t)
+	if err != nil {
+		app.ui.WriteText(errorMessage("transfer %s interrupted: %s: use /file resume %s", t.meta.Name, err, t.meta.ID))
+		return
+	}
+
+	app.removeTransfer(t.meta.ID)
+
+	if err := app.transferDo(ctx, http.MethodDelete, app.transferURL(t.meta.ID, ""), t.token, nil, "", nil); err != nil {
+		app.ui.WriteText(errorMessage("transfer %s cleanup: %s", t.meta.Name, err))
+	}
+
+	if usr, err := app.db.QueryContactByID(t.peer); err == nil {
+		if err := app.sendSigned(usr, [][]byte{fmt.Appendf(nil, "/file complete %s", t.meta.ID)}, false); err != nil {
+			app.ui.WriteText(errorMessage("transfer %s: %s", t.meta.Name, err))
+		}
+	}
+
+	app.ui.WriteText(errorMessage("%s saved to %s", t.meta.Name, path))
+}
+
+func (app *App) downloadChunks(ctx context.Context, t transfer) (string, error) {
+	if err := os.MkdirAll(t.path, 0700); err != nil {
+		return "", fmt.Errorf("transfer folder: %w", err)
+	}
+
+	partPath := filepath.Join(t.path, t.meta.ID.String()+".part")
+
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat: %w", err)
+	}
+
+	// Drop anything past the last whole chunk in case we were interrupted
+	// in the middle of a write.
+	start := int(info.Size() / int64(t.meta.ChunkSize))
+	offset := int64(start) * int64(t.meta.ChunkSize)
+
+	if err := f.Truncate(offset); err != nil {
+		return "", fmt.Errorf("truncate: %w", err)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seek: %w", err)
+	}
+
+	gcm, err := newTransferGCM(t.key)
+	if err != nil {
+		return "", err
+	}
+
+	for idx := start; idx < t.meta.chunks(); idx++ {
+		url := app.transferURL(t.meta.ID, fmt.Sprintf("/chunks/%d", idx))
+
+		var sealed []byte
+		err := retry(func() error {
+			var err error
+			sealed, err = app.transferGet(ctx, url, t.token)
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("download chunk %d: %w", idx, err)
+		}
+
+		data, err := gcm.Open(nil, transferNonce(idx), sealed, transferAD(t.meta.ID, idx))
+		if err != nil {
+			return "", fmt.Errorf("decrypt chunk %d: %w", idx, err)
+		}
+
+		if _, err := f.Write(data); err != nil {
+			return "", fmt.Errorf("write chunk %d: %w", idx, err)
+		}
+	}
+
+	// -------------------------------------------------------------------------
+	// Verify the file before it is saved.
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("seek: %w", err)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+
+	if hash := hex.EncodeToString(h.Sum(nil)); hash != t.meta.Hash {
+		f.Close()
+		os.Remove(partPath)
+		return "", fmt.Errorf("hash mismatch: got %s, exp %s", hash, t.meta.Hash)
+	}
+
+	f.Close()
+
+	path := uniquePath(filepath.Join(t.path, t.meta.Name))
+	if err := os.Rename(partPath, path); err != nil {
+		return "", fmt.Errorf("rename: %w", err)
+	}
+
+	return path, nil
+}
+
+// =============================================================================
+
+func (app *App) addTransfer(t *transfer) {
+	app.transfersMu.Lock()
+	defer app.transfersMu.Unlock()
+
+	app.transfers[t.meta.ID] = t
+}
+
+func (app *App) removeTransfer(id uuid.UUID) {
+	app.transfersMu.Lock()
+	defer app.transfersMu.Unlock()
+
+	delete(app.transfers, id)
+}
+
+func (app *App) updateTransfer(id uuid.UUID, f func(t *transfer)) {
+	app.transfersMu.Lock()
+	defer app.transfersMu.Unlock()
+
+	if t, exists := app.transfers[id]; exists {
+		f(t)
+	}
+}
+
+func (app *App) queryTransfer(id uuid.UUID, outgoing bool) (transfer, error) {
+	app.transfersMu.Lock()
+	defer app.transfersMu.Unlock()
+
+	t, exists := app.transfers[id]
+	if !exists || t.outgoing != outgoing {
+		return transfer{}, fmt.Errorf("transfer %s not found", id)
+	}
+
+	return *t, nil
+}
+
+func (app *App) transferURL(id uuid.UUID, path string) string {
+	return fmt.Sprintf("%s/transfers/%s%s", app.url, id, path)
+}
+
+func (app *App) transferGet(ctx context.Context, url string, token string) ([]byte, error) {
+	var data []byte
+	if err := app.transferDo(ctx, http.MethodGet, url, token, nil, "", &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// transferDo performs the request. A *[]byte result receives the raw body,
+// any other non nil result is decoded as JSON.
+func (app *App) transferDo(ctx context.Context, method string, url string, token string, body io.Reader, contentType string, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return fmt.Errorf("create request error: %s: %w", url, err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	resp, err := app.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do: error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("copy error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		var errs *errs.Error
+		if err := json.Unmarshal(data, &errs); err != nil {
+			return fmt.Errorf("request error: status: %s", http.StatusText(resp.StatusCode))
+		}
+
+		return errs
+	}
+
+	switch v := result.(type) {
+	case nil:
+	case *[]byte:
+		*v = data
+	default:
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+func newTransferGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("gcm: %w", err)
+	}
+
+	return gcm, nil
+}
+
+// transferNonce uses the chunk index as the nonce. This is safe since every
+// transfer has its own key and every chunk is only ever encrypted once.
+func transferNonce(idx int) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(idx))
+	return nonce
+}
+
+// transferAD binds each chunk to its transfer and position so chunks can't
+// be swapped around by the CAP.
+func transferAD(id uuid.UUID, idx int) []byte {
+	return binary.BigEndian.AppendUint64(id[:], uint64(idx))
+}
+
+func retry(f func() error) error {
+	var err error
+	for attempt := range transferRetries {
+		if err = f(); err == nil {
+			return nil
+		}
+
+		if attempt < transferRetries-1 {
+			time.Sleep(time.Duration(attempt+1) * time.Second)
+		}
+	}
+
+	return err
+}
+
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for i := 1; ; i++ {
+		p := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(p); err != nil {
+			return p
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"crypto/ecdh"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/ardanlabs/usdl/foundation/fingerprint"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Contact keys are trusted on first use. The first key a contact shares is
+// pinned, and a different key after that marks the contact's key as
+// changed. Nothing is sent to the contact until the change is verified by
+// comparing safety numbers or accepted. A contact moving from its legacy RSA
+// key to an X25519 key signed by its account isn't a change.
+
+// ErrKeyChanged is returned when sending to a contact whose key changed
+// since it was pinned or verified.
+var ErrKeyChanged = errors.New("contact's key changed")
+
+// SafetyNumber returns the number to compare with the contact to check
+// both sides have each other's real keys.
+func (app *App) SafetyNumber(id common.Address) (string, error) {
+	usr, err := app.db.QueryContactByID(id)
+	if err != nil {
+		return "", fmt.Errorf("query contact: %w", err)
+	}
+
+	if usr.Key == "" {
+		return "", fmt.Errorf("contact has not shared a key")
+	}
+
+	localKey, err := keyBytes(app.id.PubKeyX25519)
+	if err != nil {
+		return "", fmt.Errorf("own key: %w", err)
+	}
+
+	remoteKey, err := keyBytes(usr.Key)
+	if err != nil {
+		return "", fmt.Errorf("contact's key: %w", err)
+	}
+
+	number := fingerprint.SafetyNumber(app.id.MyAccountID.Bytes(), localKey, usr.ID.Bytes(), remoteKey)
+
+	return number, nil
+}
+
+// VerifyContact marks the contact's current key as verified.
+func (app *App) VerifyContact(id common.Address) error {
+	usr, err := app.db.QueryContactByID(id)
+	if err != nil {
+		return fmt.Errorf("query contact: %w", err)
+	}
+
+	if usr.Key == "" {
+		return fmt.Errorf("contact has not shared a key")
+	}
+
+	if err := app.db.UpdateContactKeyChanged(id, false); err != nil {
+		return fmt.Errorf("update key changed: %w", err)
+	}
+
+	if err := app.db.UpdateContactVerified(id, true); err != nil {
+		return fmt.Errorf("update verified: %w", err)
+	}
+
+	return nil
+}
+
+// AcceptContactKey accepts the contact's changed key without verifying it,
+// so messages can be sent again.
+func (app *App) AcceptContactKey(id common.Address) error {
+	if err := app.db.UpdateContactKeyChanged(id, false); err != nil {
+		return fmt.Errorf("update key changed: %w", err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// pinContactKey stores the key the contact shared and reports if it
+// replaced a different key. Signed reports the key came in a bundle signed
+// by the contact's account.
+func (app *App) pinContactKey(usr User, key string, signed bool) (bool, error) {
+	if usr.Key == key {
+		return false, nil
+	}
+
+	if err := app.db.UpdateContactKey(usr.ID, key); err != nil {
+		return false, fmt.Errorf("updating key: %w", err)
+	}
+
+	if usr.Key == "" {
+		return false, nil
+	}
+
+	// The safety number changes with the key, so it has to be compared again.
+	if err := app.db.UpdateContactVerified(usr.ID, false); err != nil {
+		return false, fmt.Errorf("update verified: %w", err)
+	}
+
+	if signed && isKeyUpgrade(usr.Key, key) {
+		return false, nil
+	}
+
+	if err := app.db.UpdateContactKeyChanged(usr.ID, true); err != nil {
+		return false, fmt.Errorf("update key changed: %w", err)
+	}
+
+	return true, nil
+}
+
+// writeKeyChanged tells the user the contact's key changed.
+func (app *App) writeKeyChanged(usr User) error {
+	msg := Message{
+		From:      usr.ID,
+		To:        app.id.MyAccountID,
+		Name:      usr.Name,
+		Content:   [][]byte{[]byte("** contact's key changed, verify it before sending **")},
+		Encrypted: false,
+	}
+
+	if err := app.db.InsertMessage(usr.ID, msg); err != nil {
+		return fmt.Errorf("add message: %w", err)
+	}
+
+	app.ui.WriteText(msg)
+
+	return nil
+}
+
+// isKeyUpgrade reports whether the new key only moves the contact from its
+// legacy RSA key to an X25519 key.
+func isKeyUpgrade(oldKey string, newKey string) bool {
+	oldPub, err := getPublicKey(oldKey)
+	if err != nil {
+		return false
+	}
+
+	newPub, err := getPublicKey(newKey)
+	if err != nil {
+		return false
+	}
+
+	_, legacy := oldPub.(*rsa.PublicKey)
+	_, upgraded := newPub.(*ecdh.PublicKey)
+
+	return legacy && upgraded
+}
+
+// keyBytes returns the DER bytes of a PEM encoded public key.
+func keyBytes(pemBlock string) ([]byte, error) {
+	block, _ := pem.Decode([]byte(pemBlock))
+	if block == nil {
+		return nil, errors.New("invalid key: Key must be PEM encoded")
+	}
+
+	return block.Bytes, nil
+}
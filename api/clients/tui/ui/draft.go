@@ -0,0 +1,192 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/agents"
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// draftSendDelay is how long a finished draft waits in the compose box
+// before it's sent, giving the user time to edit or discard it.
+const draftSendDelay = 5 * time.Second
+
+// draft represents an agent response being written into the compose box.
+type draft struct {
+	mu     sync.Mutex
+	to     common.Address
+	cancel context.CancelFunc
+}
+
+// start claims the compose box for a draft to the contact. It fails when
+// another draft is in progress.
+func (d *draft) start(to common.Address, cancel context.CancelFunc) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel != nil {
+		return false
+	}
+
+	d.to = to
+	d.cancel = cancel
+
+	return true
+}
+
+// stop cancels the draft in progress and returns who it was for. The text
+// written so far stays in the compose box.
+func (d *draft) stop() (common.Address, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel == nil {
+		return common.Address{}, false
+	}
+
+	d.cancel()
+	d.cancel = nil
+
+	return d.to, true
+}
+
+// =============================================================================
+
+// agentResponse streams the agent's answer to the contact's message into
+// the compose box. The draft is sent once it's finished unless the user
+// starts typing, which stops the stream and leaves the draft to edit.
+func (ui *TUI) agentResponse(msg client.Message) {
+	if msg.Name == "system" {
+		return
+	}
+
+	from := msg.From
+
+	if err := ui.guard.Check(from, client.StitchMessages(msg.Content)); err != nil {
+		if !errors.Is(err, guard.ErrIgnored) {
+			fmt.Fprintln(ui.textView, "-----")
+			fmt.Fprintf(ui.textView, "agent: not answering %s: %s\n", msg.Name, err)
+		}
+		return
+	}
+
+	msgs := ui.history.retrieve(from)
+
+	input := msgs[len(msgs)-1]
+	history := msgs[:len(msgs)-1]
+
+	if ui.textArea.GetText() != "" {
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintln(ui.textView, "agent: not answering while you have a message in the compose box")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if !ui.draft.start(from, cancel) {
+		cancel()
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintln(ui.textView, "agent: busy with another reply, not answering "+from.Hex())
+		return
+	}
+
+	go ui.streamDraft(ctx, from, client.StitchMessages(msg.Content), input, history)
+}
+
+func (ui *TUI) streamDraft(ctx context.Context, to common.Address, query string, input string, history []string) {
+	ui.tviewApp.QueueUpdateDraw(func() {
+		if ctx.Err() == nil {
+			ui.textArea.SetTitle(" Agent is thinking... ")
+		}
+	})
+
+	history = ui.recall(to, query, input, history)
+
+	var b strings.Builder
+
+	fn := func(chunk string) error {
+		b.WriteString(chunk)
+		text := b.String()
+
+		ui.tviewApp.QueueUpdateDraw(func() {
+			if ctx.Err() == nil {
+				ui.textArea.SetText(text, true)
+				ui.textArea.SetTitle(" Agent is typing... type to edit, Ctrl+X to discard ")
+			}
+		})
+
+		return nil
+	}
+
+	if _, err := agents.Stream(ctx, ui.agent, input, history, fn); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ui.draft.stop()
+
+		ui.tviewApp.QueueUpdateDraw(func() {
+			ui.textArea.SetTitle("")
+		})
+
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintln(ui.textView, "failed agent response: "+err.Error())
+		return
+	}
+
+	ui.tviewApp.QueueUpdateDraw(func() {
+		if ctx.Err() == nil {
+			ui.textArea.SetTitle(fmt.Sprintf(" Sending in %s... type to edit, Ctrl+X to discard ", draftSendDelay))
+		}
+	})
+
+	timer := time.NewTimer(draftSendDelay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	ui.tviewApp.QueueUpdateDraw(func() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ui.draft.stop()
+		ui.textArea.SetTitle("")
+		ui.guard.Replied(to, ui.textArea.GetText())
+		ui.buttonHandler(to)
+	})
+}
+
+// takeOverDraft stops the agent's draft so the user can edit it. It returns
+// who the draft was for, or the zero address when there was no draft.
+func (ui *TUI) takeOverDraft() common.Address {
+	to, ok := ui.draft.stop()
+	if ok {
+		ui.textArea.SetTitle("")
+	}
+
+	return to
+}
+
+// discardDraft stops the agent's draft and clears the compose box.
+func (ui *TUI) discardDraft() bool {
+	if _, ok := ui.draft.stop(); !ok {
+		return false
+	}
+
+	ui.textArea.SetTitle("")
+	ui.textArea.SetText("", false)
+
+	return true
+}
@@ -1,11 +1,19 @@
 package ui
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// Memory represents storage that can find the past messages with a contact
+// that are relevant to a question.
+type Memory interface {
+	Recall(id common.Address, query string, maxTokens int) ([]client.Message, error)
+}
+
 type data struct {
 	msgs []string
 	len  int
@@ -63,3 +71,38 @@ func (h *history) retrieve(id common.Address) []string {
 
 	return v
 }
+
+// =============================================================================
+
+// recall puts the past messages relevant to the query in front of the
+// recent history, leaving out the ones already in the conversation.
+func (ui *TUI) recall(from common.Address, query string, input string, history []string) []string {
+	if ui.memory == nil || ui.recallTokens <= 0 {
+		return history
+	}
+
+	found, err := ui.memory.Recall(from, query, ui.recallTokens)
+	if err != nil {
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintln(ui.textView, "agent: recall: "+err.Error())
+		return history
+	}
+
+	seen := map[string]bool{input: true}
+	for _, h := range history {
+		seen[h] = true
+	}
+
+	var recalled []string
+	for _, msg := range found {
+		line := fmt.Sprintf("%s: %s", msg.Name, client.StitchMessages(msg.Content))
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+
+		recalled = append(recalled, line)
+	}
+
+	return append(recalled, history...)
+}
@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"github.com/rivo/tview"
+)
+
+// PassphraseFunc changes the passphrase that protects the keys.
+type PassphraseFunc func(oldPassphrase string, newPassphrase string) error
+
+// passphraseForm asks for the current passphrase and a new one, and changes
+// it. It must be called on the tview goroutine.
+func (ui *TUI) passphraseForm() {
+	if ui.changePassphrase == nil {
+		ui.writeSystem("Changing the passphrase isn't supported")
+		return
+	}
+
+	const page = "passphrase"
+
+	var oldPassphrase, newPassphrase, confirm string
+
+	form := tview.NewForm().
+		AddPasswordField("Current", "", 30, '*', func(text string) { oldPassphrase = text }).
+		AddPasswordField("New", "", 30, '*', func(text string) { newPassphrase = text }).
+		AddPasswordField("Repeat", "", 30, '*', func(text string) { confirm = text })
+
+	done := func() {
+		ui.pages.RemovePage(page)
+		ui.tviewApp.SetFocus(ui.textArea)
+	}
+
+	form.AddButton("Change", func() {
+		done()
+
+		switch {
+		case newPassphrase == "":
+			ui.writeSystem("Error with passphrase: the new passphrase can't be empty")
+			return
+
+		case newPassphrase != confirm:
+			ui.writeSystem("Error with passphrase: the new passphrases don't match")
+			return
+		}
+
+		if err := ui.changePassphrase(oldPassphrase, newPassphrase); err != nil {
+			ui.writeSystem("Error with passphrase: %s", err)
+			return
+		}
+
+		ui.writeSystem("The passphrase is changed")
+	})
+
+	form.AddButton("Cancel", done)
+	form.SetCancelFunc(done)
+
+	form.SetBorder(true).SetTitle("Change Passphrase")
+
+	ui.pages.AddPage(page, centered(form, 46, 11), true, true)
+	ui.tviewApp.SetFocus(form)
+}
+
+// =============================================================================
+
+// centered places the item in the middle of the screen at the size.
+func centered(item tview.Primitive, width int, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(item, height, 0, true).
+			AddItem(nil, 0, 1, false), width, 0, true).
+		AddItem(nil, 0, 1, false)
+}
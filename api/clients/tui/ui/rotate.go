@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rivo/tview"
+)
+
+// RotateFunc moves the account to a new ID and returns the new address.
+type RotateFunc func(passphrase string) (common.Address, error)
+
+// rotateForm asks for the passphrase and moves the account to a new ID. The
+// TUI has to be started again to use the new ID. It must be called on the
+// tview goroutine.
+func (ui *TUI) rotateForm() {
+	if ui.rotate == nil {
+		ui.writeSystem("Rotating the ID isn't supported")
+		return
+	}
+
+	const page = "rotate"
+
+	var passphrase string
+
+	form := tview.NewForm().
+		AddTextView("", "Replace your ID with a new one and tell your contacts. Use this if your keys were stolen.", 40, 3, true, false).
+		AddPasswordField("Passphrase", "", 28, '*', func(text string) { passphrase = text })
+
+	done := func() {
+		ui.pages.RemovePage(page)
+		ui.tviewApp.SetFocus(ui.textArea)
+	}
+
+	form.AddButton("Rotate", func() {
+		done()
+
+		newID, err := ui.rotate(passphrase)
+		if newID == (common.Address{}) {
+			ui.writeSystem("Error with rotate: %s", err)
+			return
+		}
+
+		text := fmt.Sprintf("Your new ID is %s. Start again to use it.", newID.Hex())
+		if err != nil {
+			text = fmt.Sprintf("%s\n\nSome contacts weren't told: %s", text, err)
+		}
+
+		ui.rotated(text)
+	})
+
+	form.AddButton("Cancel", done)
+	form.SetCancelFunc(done)
+
+	form.SetBorder(true).SetTitle("Rotate ID")
+
+	ui.pages.AddPage(page, centered(form, 46, 12), true, true)
+	ui.tviewApp.SetFocus(form)
+}
+
+// rotated tells the user the ID was rotated and stops the TUI, which still
+// holds the old ID.
+func (ui *TUI) rotated(text string) {
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Quit"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.tviewApp.Stop()
+		})
+
+	ui.pages.AddPage("rotated", modal, false, true)
+	ui.tviewApp.SetFocus(modal)
+}
@@ -5,16 +5,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
-	"math/rand/v2"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
-	"github.com/ardanlabs/usdl/foundation/agents/ollamallm"
+	"github.com/ardanlabs/usdl/foundation/agents"
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gdamore/tcell/v2"
+	"github.com/google/uuid"
 	"github.com/rivo/tview"
 )
 
@@ -22,6 +21,7 @@ import (
 
 type TUI struct {
 	tviewApp *tview.Application
+	pages    *tview.Pages
 	flex     *tview.Flex
 	list     *tview.List
 	textView *tview.TextView
@@ -29,14 +29,26 @@ type TUI struct {
 	aiToggle *tview.Button
 	button   *tview.Button
 	app      *client.App
-	agent    *ollamallm.Agent
+	agent    agents.Agent
+	guard    *guard.Guard
 	history  *history
 	aiMode   bool
+	draft    draft
+
+	memory       Memory
+	recallTokens int
+
+	changePassphrase PassphraseFunc
+	rotate           RotateFunc
+	backup           BackupFunc
+	export           ExportFunc
+	importArchive    ImportFunc
 }
 
-func New(myAccountID common.Address, agent *ollamallm.Agent) *TUI {
+func New(myAccountID common.Address, agent agents.Agent, g *guard.Guard) *TUI {
 	ui := TUI{
 		agent:   agent,
+		guard:   g,
 		history: NewHistory(5),
 	}
 
@@ -159,7 +171,11 @@ func New(myAccountID common.Address, agent *ollamallm.Agent) *TUI {
 		return event
 	})
 
+	pages := tview.NewPages().
+		AddPage("main", flex, true, true)
+
 	ui.tviewApp = tApp
+	ui.pages = pages
 	ui.flex = flex
 	ui.list = list
 	ui.textView = textView
@@ -182,11 +198,22 @@ func New(myAccountID common.Address, agent *ollamallm.Agent) *TUI {
 	button.SetSelectedFunc(buttonHandler)
 
 	textArea.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		ui.guard.HumanActive()
+
 		switch event.Key() {
 		case tcell.KeyEnter:
-			buttonHandler()
+			ui.buttonHandler(ui.takeOverDraft())
 			return nil
+
+		case tcell.KeyCtrlX:
+			if ui.discardDraft() {
+				return nil
+			}
 		}
+
+		// Typing while the agent is writing a draft hands it over to the user.
+		ui.takeOverDraft()
+
 		return event
 	})
 
@@ -195,22 +222,68 @@ func New(myAccountID common.Address, agent *ollamallm.Agent) *TUI {
 
 func (ui *TUI) SetApp(app *client.App) {
 	ui.app = app
+	ui.listContacts()
+}
+
+// listContacts fills the contact list from the app's contacts.
+func (ui *TUI) listContacts() {
+	ui.list.Clear()
 
-	for i, user := range app.Contacts() {
+	for i, user := range ui.app.Contacts() {
 		shortcut := rune(i + 49)
-		switch user.Key {
-		case "":
+		switch {
+		case user.Group:
+			ui.list.AddItem(user.Name, "[blue]"+user.ID.Hex(), shortcut, nil)
+		case user.Key == "":
 			ui.list.AddItem(user.Name, "[red]"+user.ID.Hex(), shortcut, nil)
+		case user.KeyChanged:
+			ui.list.AddItem(user.Name, "[yellow]"+user.ID.Hex(), shortcut, nil)
 		default:
 			ui.list.AddItem(user.Name, "[green]"+user.ID.Hex(), shortcut, nil)
 		}
 	}
 }
 
+// SetMemory lets the agent recall past messages relevant to a question, up
+// to the token budget, from beyond the recent history.
+func (ui *TUI) SetMemory(memory Memory, maxTokens int) {
+	ui.memory = memory
+	ui.recallTokens = maxTokens
+}
+
+// SetPassphrase lets the user change the passphrase that protects the keys
+// with the /passphrase command.
+func (ui *TUI) SetPassphrase(f PassphraseFunc) {
+	ui.changePassphrase = f
+}
+
+// SetRotate lets the user move to a new ID with the /rotate command.
+func (ui *TUI) SetRotate(f RotateFunc) {
+	ui.rotate = f
+}
+
+// SetBackup lets the user see the words that restore the account with the
+// /backup command.
+func (ui *TUI) SetBackup(f BackupFunc) {
+	ui.backup = f
+}
+
+// SetExport lets the user export the contacts and history with the /export
+// command.
+func (ui *TUI) SetExport(f ExportFunc) {
+	ui.export = f
+}
+
+// SetImport lets the user import the contacts and history from an archive
+// with the /import command.
+func (ui *TUI) SetImport(f ImportFunc) {
+	ui.importArchive = f
+}
+
 func (ui *TUI) Run() error {
 	ui.updateState()
 
-	return ui.tviewApp.SetRoot(ui.flex, true).EnableMouse(true).Run()
+	return ui.tviewApp.SetRoot(ui.pages, true).EnableMouse(true).Run()
 }
 
 func (ui *TUI) WriteText(msg client.Message) {
@@ -242,6 +315,13 @@ func (ui *TUI) WriteText(msg client.Message) {
 
 		msgContent := fmt.Sprintf("%s: %s", msg.Name, client.StitchMessages(msg.Content))
 
+		// Messages sent to a group belong to the group's conversation and
+		// the agent doesn't answer for us in a group.
+		if msg.Group != (common.Address{}) {
+			ui.writeGroupText(msg.Group, currentID, msgContent)
+			return
+		}
+
 		ui.history.add(msg.From, msgContent)
 
 		if msg.From.Hex() == currentID {
@@ -249,7 +329,7 @@ func (ui *TUI) WriteText(msg client.Message) {
 			fmt.Fprintf(ui.textView, "%s\n", msgContent)
 
 			if ui.aiMode {
-				ui.agentResponse(msg.From)
+				ui.agentResponse(msg)
 			}
 
 			return
@@ -264,7 +344,7 @@ func (ui *TUI) WriteText(msg client.Message) {
 				}
 
 				if ui.aiMode {
-					ui.agentResponse(msg.From)
+					ui.agentResponse(msg)
 				}
 
 				return
@@ -278,8 +358,62 @@ func (ui *TUI) AddContact(id common.Address, name string) {
 	ui.list.AddItem(name, id.Hex(), shortcut, nil)
 }
 
+func (ui *TUI) AddGroup(id common.Address, name string) {
+	shortcut := rune(ui.list.GetItemCount() + 49)
+	ui.list.AddItem(name, "[blue]"+id.Hex(), shortcut, nil)
+}
+
+func (ui *TUI) RemoveContact(id common.Address) {
+	for i := range ui.list.GetItemCount() {
+		if _, idStr := ui.GetItemText(i); idStr == id.Hex() {
+			ui.list.RemoveItem(i)
+			return
+		}
+	}
+}
+
 var re = regexp.MustCompile(`\s{2,}`)
 
+// TransferOffer asks the user to accept or reject a file from a contact.
+func (ui *TUI) TransferOffer(offer client.TransferOffer) {
+	name := offer.From.Hex()
+	if usr, err := ui.app.QueryContactByID(offer.From); err == nil {
+		name = usr.Name
+	}
+
+	page := "transfer-" + offer.ID.String()
+
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("%s wants to send you %s (%d bytes)", name, offer.Name, offer.Size)).
+		AddButtons([]string{"Accept", "Reject"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage(page)
+			ui.tviewApp.SetFocus(ui.textArea)
+
+			go func() {
+				var err error
+				switch buttonLabel {
+				case "Accept":
+					err = ui.app.AcceptTransfer(context.Background(), offer.ID)
+				default:
+					err = ui.app.RejectTransfer(offer.ID)
+				}
+
+				if err != nil {
+					ui.WriteText(client.Message{
+						Name:    "system",
+						Content: [][]byte{fmt.Appendf(nil, "Error with transfer %s: %s", offer.Name, err)},
+					})
+				}
+			}()
+		})
+
+	ui.tviewApp.QueueUpdateDraw(func() {
+		ui.pages.AddPage(page, modal, false, true)
+		ui.tviewApp.SetFocus(modal)
+	})
+}
+
 func (ui *TUI) ApplyContactPrefix(id common.Address, option string, add bool) {
 	for i := range ui.list.GetItemCount() {
 		name, idStr := ui.GetItemText(i)
@@ -368,33 +502,105 @@ func (ui *TUI) updateState() {
 	}
 }
 
-func (ui *TUI) agentResponse(from common.Address) {
-	ctx := context.TODO()
+func (ui *TUI) writeGroupText(groupID common.Address, currentID string, msgContent string) {
+	if groupID.Hex() == currentID {
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintf(ui.textView, "%s\n", msgContent)
+		return
+	}
 
-	msgs := ui.history.retrieve(from)
+	for i := range ui.list.GetItemCount() {
+		name, idStr := ui.GetItemText(i)
+		if groupID.Hex() == idStr {
+			if !strings.Contains(name, "*") {
+				ui.list.SetItemText(i, "* "+name, idStr)
+				ui.tviewApp.Draw()
+			}
+			return
+		}
+	}
+}
 
-	input := msgs[len(msgs)-1]
-	history := msgs[:len(msgs)-1]
+func (ui *TUI) groupCommand(to common.Address, msg string) error {
+	parts := strings.Fields(msg)
+	if len(parts) < 2 {
+		return fmt.Errorf("usage: /group create <name> | invite <addr> | kick <addr> | leave")
+	}
 
-	start := time.Now()
+	switch parts[1] {
+	case "create":
+		if len(parts) < 3 {
+			return fmt.Errorf("usage: /group create <name>")
+		}
+
+		groupID, err := ui.app.CreateGroup(strings.Join(parts[2:], " "))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintf(ui.textView, "Group created: %s\n", groupID.Hex())
+
+	case "invite", "kick":
+		if len(parts) < 3 || !common.IsHexAddress(parts[2]) {
+			return fmt.Errorf("usage: /group %s <addr>", parts[1])
+		}
+
+		member := common.HexToAddress(parts[2])
+
+		f := ui.app.InviteToGroup
+		if parts[1] == "kick" {
+			f = ui.app.KickFromGroup
+		}
+
+		if err := f(to, member); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(ui.textView, "-----")
+		fmt.Fprintf(ui.textView, "Group %s sent for %s\n", parts[1], member.Hex())
+
+	case "leave":
+		if err := ui.app.LeaveGroup(to); err != nil {
+			return err
+		}
 
-	resp, err := ui.agent.Chat(ctx, input, history)
-	if err != nil {
 		fmt.Fprintln(ui.textView, "-----")
-		fmt.Fprintln(ui.textView, "failed ollama response: "+err.Error())
+		fmt.Fprintln(ui.textView, "You left the group")
+
+	default:
+		return fmt.Errorf("unknown group command: %s", parts[1])
+	}
+
+	return nil
+}
+
+func (ui *TUI) fileCommand(to common.Address, msg string) error {
+	parts := strings.Fields(msg)
+	if len(parts) < 2 {
+		return fmt.Errorf("usage: /file <path> | resume <id>")
 	}
 
-	// Create some artificial delay to simulate thinking
-	// We don't care about negative calculations because
-	// that means we've already waited long enough.
+	if parts[1] == "resume" && len(parts) == 3 {
+		id, err := uuid.Parse(parts[2])
+		if err != nil {
+			return fmt.Errorf("invalid transfer id: %w", err)
+		}
+
+		return ui.app.ResumeTransfer(context.Background(), id)
+	}
+
+	path := strings.TrimSpace(strings.TrimPrefix(msg, "/file "))
+
+	id, err := ui.app.OfferFile(to, path)
+	if err != nil {
+		return err
+	}
 
-	dur := int(math.Ceil(time.Since(start).Seconds()))
-	delayInSeconds := max(rand.IntN(11), 3)
-	delayInSeconds -= dur
-	time.Sleep(time.Duration(delayInSeconds) * time.Second)
+	fmt.Fprintln(ui.textView, "-----")
+	fmt.Fprintf(ui.textView, "Offered %s, transfer id: %s\n", path, id)
 
-	ui.textArea.SetText(resp, true)
-	ui.buttonHandler(from)
+	return nil
 }
 
 func (ui *TUI) buttonHandler(to common.Address) {
@@ -413,7 +619,79 @@ func (ui *TUI) buttonHandler(to common.Address) {
 		msg = msg[1:]
 	}
 
+	if strings.HasPrefix(msg, "/group ") {
+		if err := ui.groupCommand(to, msg); err != nil {
+			ui.WriteText(client.Message{
+				Name:    "system",
+				Content: [][]byte{fmt.Appendf(nil, "Error with group command: %s", err)},
+			})
+			return
+		}
+
+		ui.textArea.SetText("", false)
+		return
+	}
+
+	if strings.TrimSpace(msg) == "/passphrase" {
+		ui.textArea.SetText("", false)
+		ui.passphraseForm()
+		return
+	}
+
+	if strings.TrimSpace(msg) == "/rotate" {
+		ui.textArea.SetText("", false)
+		ui.rotateForm()
+		return
+	}
+
+	if strings.TrimSpace(msg) == "/backup" {
+		ui.textArea.SetText("", false)
+		ui.backupForm()
+		return
+	}
+
+	if cmd := strings.TrimSpace(msg); cmd == "/export" || strings.HasPrefix(cmd, "/export ") {
+		ui.textArea.SetText("", false)
+		ui.exportForm(strings.TrimSpace(strings.TrimPrefix(cmd, "/export")))
+		return
+	}
+
+	if cmd := strings.TrimSpace(msg); cmd == "/import" || strings.HasPrefix(cmd, "/import ") {
+		ui.textArea.SetText("", false)
+		ui.importForm(strings.TrimSpace(strings.TrimPrefix(cmd, "/import")))
+		return
+	}
+
+	if strings.TrimSpace(msg) == "/verify" {
+		ui.textArea.SetText("", false)
+		ui.verifyContact(to)
+		return
+	}
+
+	if strings.HasPrefix(msg, "/file ") {
+		if err := ui.fileCommand(to, msg); err != nil {
+			if errors.Is(err, client.ErrKeyChanged) {
+				ui.keyChangedWarning(to)
+				return
+			}
+
+			ui.WriteText(client.Message{
+				Name:    "system",
+				Content: [][]byte{fmt.Appendf(nil, "Error with file command: %s", err)},
+			})
+			return
+		}
+
+		ui.textArea.SetText("", false)
+		return
+	}
+
 	if err := ui.app.SendMessageHandler(to, []byte(msg)); err != nil {
+		if errors.Is(err, client.ErrKeyChanged) {
+			ui.keyChangedWarning(to)
+			return
+		}
+
 		msg := client.Message{
 			Name:    "system",
 			Content: [][]byte{fmt.Appendf(nil, "Error sending message: %s", err)},
@@ -427,6 +705,11 @@ func (ui *TUI) buttonHandler(to common.Address) {
 	ui.textArea.SetText("", false)
 }
 
+// ToggleAgent turns the agent on or off when an agent is available.
+func (ui *TUI) ToggleAgent() {
+	ui.aiToggleHandler(ui.agent != nil)
+}
+
 func (ui *TUI) aiToggleHandler(agent bool) {
 	if !agent {
 		return
@@ -0,0 +1,122 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/fingerprint"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rivo/tview"
+)
+
+// verifyContact shows the safety number for the contact so the user can
+// compare it with the contact's and mark the contact as verified. It must
+// be called on the tview goroutine.
+func (ui *TUI) verifyContact(to common.Address) {
+	usr, err := ui.app.QueryContactByID(to)
+	if err != nil {
+		ui.writeSystem("Error with verify: %s", err)
+		return
+	}
+
+	number, err := ui.app.SafetyNumber(to)
+	if err != nil {
+		ui.writeSystem("Error with verify: %s", err)
+		return
+	}
+
+	status := "not verified"
+	switch {
+	case usr.KeyChanged:
+		status = "KEY CHANGED"
+	case usr.Verified:
+		status = "verified"
+	}
+
+	text := fmt.Sprintf("Safety number with %s (%s)\n\n%s\n\nCompare it with the number %s sees. If they match, nobody is in the middle.",
+		usr.Name, status, fingerprint.Format(number, 4), usr.Name)
+
+	const page = "verify"
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Mark Verified", "Close"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage(page)
+			ui.tviewApp.SetFocus(ui.textArea)
+
+			if buttonLabel != "Mark Verified" {
+				return
+			}
+
+			if err := ui.app.VerifyContact(to); err != nil {
+				ui.writeSystem("Error with verify: %s", err)
+				return
+			}
+
+			ui.setContactColor(to, "[green]")
+			ui.writeSystem("%s is verified", usr.Name)
+		})
+
+	ui.pages.AddPage(page, modal, false, true)
+	ui.tviewApp.SetFocus(modal)
+}
+
+// keyChangedWarning stops a message from being sent to a contact whose key
+// changed until the user verifies the new key, sends anyway or cancels. It
+// must be called on the tview goroutine.
+func (ui *TUI) keyChangedWarning(to common.Address) {
+	name := to.Hex()
+	if usr, err := ui.app.QueryContactByID(to); err == nil {
+		name = usr.Name
+	}
+
+	text := fmt.Sprintf("%s's key changed. They may have reinstalled, or someone may be in the middle. Your message has not been sent.", name)
+
+	const page = "key-changed"
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Verify", "Send Anyway", "Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage(page)
+			ui.tviewApp.SetFocus(ui.textArea)
+
+			switch buttonLabel {
+			case "Verify":
+				ui.verifyContact(to)
+
+			case "Send Anyway":
+				if err := ui.app.AcceptContactKey(to); err != nil {
+					ui.writeSystem("Error accepting key: %s", err)
+					return
+				}
+
+				ui.setContactColor(to, "[green]")
+				ui.buttonHandler(to)
+			}
+		})
+
+	ui.pages.AddPage(page, modal, false, true)
+	ui.tviewApp.SetFocus(modal)
+}
+
+// =============================================================================
+
+func (ui *TUI) writeSystem(format string, a ...any) {
+	ui.WriteText(client.Message{
+		Name:    "system",
+		Content: [][]byte{fmt.Appendf(nil, format, a...)},
+	})
+}
+
+// setContactColor changes the color the contact's address is shown in.
+func (ui *TUI) setContactColor(id common.Address, color string) {
+	for i := range ui.list.GetItemCount() {
+		name, idStr := ui.GetItemText(i)
+		if idStr == id.Hex() {
+			ui.list.SetItemText(i, name, color+idStr)
+			return
+		}
+	}
+}
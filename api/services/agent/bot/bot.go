@@ -0,0 +1,313 @@
+// Package bot answers chat messages with an LLM on behalf of an agent's
+// own identity. The conversation memory for each contact is the message
+// history kept in the client storage.
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/foundation/agents"
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// defaultTimeout is how long the LLM is given to answer when the config
+// doesn't say.
+const defaultTimeout = 2 * time.Minute
+
+// Memory represents storage that can find the past messages with a contact
+// that are relevant to a question.
+type Memory interface {
+	Recall(id common.Address, query string, maxTokens int) ([]client.Message, error)
+}
+
+// Sender represents the client app the bot answers through.
+type Sender interface {
+	SendMessageHandler(to common.Address, msg []byte) error
+}
+
+// Config represents what the bot needs to answer messages. With a memory,
+// past messages relevant to the question are added to the history, up to
+// the recall token budget. The LLM is given Timeout to answer, so a stuck
+// backend doesn't hold up the other contacts.
+type Config struct {
+	Log          *logger.Logger
+	ID           common.Address
+	Storage      client.Storage
+	Memory       Memory
+	LLM          agents.Agent
+	Guard        *guard.Guard
+	History      int
+	RecallTokens int
+	Timeout      time.Duration
+}
+
+// Bot implements the client UI. Incoming messages are queued per contact
+// and answered one contact at a time, so messages that arrive while the
+// LLM is busy are answered together.
+type Bot struct {
+	log          *logger.Logger
+	id           common.Address
+	db           client.Storage
+	memory       Memory
+	llm          agents.Agent
+	guard        *guard.Guard
+	history      int
+	recallTokens int
+	timeout      time.Duration
+	signal       chan struct{}
+
+	mu      sync.Mutex
+	queue   []common.Address
+	pending map[common.Address]bool
+}
+
+// New constructs a bot. Without a guard the bot answers every message.
+func New(cfg Config) *Bot {
+	g := cfg.Guard
+	if g == nil {
+		g = guard.New(guard.Config{})
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Bot{
+		log:          cfg.Log,
+		id:           cfg.ID,
+		db:           cfg.Storage,
+		memory:       cfg.Memory,
+		llm:          cfg.LLM,
+		guard:        g,
+		history:      cfg.History,
+		recallTokens: cfg.RecallTokens,
+		timeout:      timeout,
+		signal:       make(chan struct{}, 1),
+		pending:      make(map[common.Address]bool),
+	}
+}
+
+// Serve answers queued messages through the sender until the context
+// is canceled.
+func (b *Bot) Serve(ctx context.Context, sender Sender) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.signal:
+		}
+
+		for {
+			from, ok := b.next()
+			if !ok {
+				break
+			}
+
+			if err := b.answer(ctx, sender, from); err != nil {
+				b.log.Error(ctx, "bot: answer", "contact", from, "ERROR", err)
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// =============================================================================
+
+// Run implements the client UI.
+func (b *Bot) Run() error {
+	return nil
+}
+
+// WriteText implements the client UI. Messages from contacts are queued
+// to be answered; system, group and our own messages are ignored.
+func (b *Bot) WriteText(msg client.Message) {
+	switch {
+	case msg.Name == "system":
+		b.log.Info(context.Background(), "bot: system", "msg", client.StitchMessages(msg.Content))
+		return
+
+	case msg.From == (common.Address{}), msg.From == b.id, msg.Group != (common.Address{}):
+		return
+
+	case client.StitchMessages(msg.Content) == client.KeyUpdated:
+		return
+	}
+
+	b.enqueue(msg.From)
+}
+
+// AddContact implements the client UI.
+func (b *Bot) AddContact(id common.Address, name string) {
+	b.log.Info(context.Background(), "bot: new contact", "contact", id, "name", name)
+}
+
+// AddGroup implements the client UI.
+func (b *Bot) AddGroup(id common.Address, name string) {}
+
+// RemoveContact implements the client UI.
+func (b *Bot) RemoveContact(id common.Address) {}
+
+// TransferOffer implements the client UI. The bot doesn't accept files.
+func (b *Bot) TransferOffer(offer client.TransferOffer) {}
+
+// ApplyContactPrefix implements the client UI.
+func (b *Bot) ApplyContactPrefix(id common.Address, option string, add bool) {}
+
+// =============================================================================
+
+func (b *Bot) enqueue(from common.Address) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.pending[from] {
+		b.pending[from] = true
+		b.queue = append(b.queue, from)
+	}
+
+	select {
+	case b.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Bot) next() (common.Address, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.queue) == 0 {
+		return common.Address{}, false
+	}
+
+	from := b.queue[0]
+	b.queue = b.queue[1:]
+	delete(b.pending, from)
+
+	return from, true
+}
+
+// answer replies to the messages the contact sent since our last reply,
+// using the messages before them as the conversation history.
+func (b *Bot) answer(ctx context.Context, sender Sender, from common.Address) error {
+	usr, err := b.db.QueryContactByID(from)
+	if err != nil {
+		return fmt.Errorf("query contact: %w", err)
+	}
+
+	inputs, history := conversation(usr.Messages, b.history)
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	input := strings.Join(inputs, "\n")
+
+	if err := b.guard.Check(from, input); err != nil {
+		b.log.Info(ctx, "bot: not answering", "contact", from, "reason", err)
+		return nil
+	}
+
+	history = b.recall(ctx, from, input, inputs, history)
+
+	start := time.Now()
+
+	chatCtx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	resp, err := b.llm.Chat(chatCtx, input, history)
+	if err != nil {
+		return fmt.Errorf("chat: %w", err)
+	}
+
+	// The model sometimes starts a response with a /, which the client
+	// would send as a command.
+	resp = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(resp), "/"))
+	if resp == "" {
+		return nil
+	}
+
+	if err := sender.SendMessageHandler(from, []byte(resp)); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+
+	b.guard.Replied(from, resp)
+
+	b.log.Info(ctx, "bot: answered", "contact", from, "name", usr.Name, "took", time.Since(start))
+
+	return nil
+}
+
+// recall puts the past messages relevant to the input in front of the
+// history, leaving out the ones already in the conversation.
+func (b *Bot) recall(ctx context.Context, from common.Address, input string, inputs []string, history []string) []string {
+	if b.memory == nil || b.recallTokens <= 0 {
+		return history
+	}
+
+	found, err := b.memory.Recall(from, input, b.recallTokens)
+	if err != nil {
+		b.log.Error(ctx, "bot: recall", "contact", from, "ERROR", err)
+		return history
+	}
+
+	seen := make(map[string]bool)
+	for _, h := range history {
+		seen[h] = true
+	}
+	for _, in := range inputs {
+		seen[in] = true
+	}
+
+	var recalled []string
+	for _, msg := range found {
+		text := client.StitchMessages(msg.Content)
+		line := msg.Name + ": " + text
+
+		if seen[line] || seen[text] {
+			continue
+		}
+		seen[line] = true
+
+		recalled = append(recalled, line)
+	}
+
+	return append(recalled, history...)
+}
+
+// conversation splits the messages into the input, the messages the
+// contact sent since our last reply, and up to size messages of history
+// before them.
+func conversation(msgs []client.Message, size int) ([]string, []string) {
+	end := len(msgs)
+	for end > 0 && msgs[end-1].Name != "You" {
+		end--
+	}
+
+	var input []string
+	for _, msg := range msgs[end:] {
+		text := client.StitchMessages(msg.Content)
+		if text == client.KeyUpdated {
+			continue
+		}
+
+		input = append(input, text)
+	}
+
+	start := max(end-size, 0)
+
+	history := make([]string, 0, end-start)
+	for _, msg := range msgs[start:end] {
+		history = append(history, msg.Name+": "+client.StitchMessages(msg.Content))
+	}
+
+	return input, history
+}
@@ -0,0 +1,120 @@
+package bot_test
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/services/agent/bot"
+	"github.com/ardanlabs/usdl/api/services/cap/captest"
+	"github.com/ardanlabs/usdl/foundation/agents/fakellm"
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
+	"github.com/ardanlabs/usdl/foundation/logger"
+)
+
+// TestBot provides a test of an agent answering a contact through a CAP.
+func TestBot(t *testing.T) {
+	t.Log("Given the need for an agent to answer the messages it receives.")
+	{
+		net := captest.New(t)
+		cap1 := net.StartCAP()
+
+		agent := net.NewClient("agent")
+
+		// The leading / must be stripped so the answer isn't sent as a command.
+		llm := fakellm.New("/hi alice", "fine thanks", "one too many")
+
+		b := bot.New(bot.Config{
+			Log:     logger.New(io.Discard, logger.LevelInfo, "BOT", func(context.Context) string { return "" }),
+			ID:      agent.ID,
+			Storage: agent.Storage(),
+			LLM:     llm,
+			Guard:   guard.New(guard.Config{MaxReplies: 2, Window: time.Hour}),
+			History: 10,
+		})
+
+		app := agent.ConnectUI(cap1, b)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go b.Serve(ctx, app)
+
+		alice := net.Connect(cap1, "alice")
+		alice.AddContact(agent)
+
+		alice.Send(agent, "hello")
+		alice.WaitForMessage(agent, "hi alice")
+		t.Log("\tShould answer a contact it has never seen.", "OK")
+
+		alice.Send(agent, "how are you")
+		alice.WaitForMessage(agent, "fine thanks")
+
+		calls := llm.Calls()
+		exp := []string{"alice: hello", "You: hi alice"}
+		if got := calls[len(calls)-1].History; !slices.Equal(got, exp) {
+			t.Fatalf("\tShould send the conversation as history, got %q, exp %q. %s", got, exp, "X")
+		}
+		t.Log("\tShould send the conversation as history.", "OK")
+
+		alice.Send(agent, "one more")
+		alice.ExpectNoMessage(agent, "one too many", 500*time.Millisecond)
+		t.Log("\tShould stop answering at the reply limit.", "OK")
+	}
+}
+
+// TestBotTimeout provides a test of the agent giving up on an LLM that
+// doesn't answer and still answering the next message.
+func TestBotTimeout(t *testing.T) {
+	t.Log("Given the need for a stuck LLM to not stop the agent.")
+	{
+		net := captest.New(t)
+		cap1 := net.StartCAP()
+
+		agent := net.NewClient("agent")
+
+		b := bot.New(bot.Config{
+			Log:     logger.New(io.Discard, logger.LevelInfo, "BOT", func(context.Context) string { return "" }),
+			ID:      agent.ID,
+			Storage: agent.Storage(),
+			LLM:     &stuckLLM{answer: "back again"},
+			History: 10,
+			Timeout: 100 * time.Millisecond,
+		})
+
+		app := agent.ConnectUI(cap1, b)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go b.Serve(ctx, app)
+
+		alice := net.Connect(cap1, "alice")
+		alice.AddContact(agent)
+
+		alice.Send(agent, "hello")
+		alice.ExpectNoMessage(agent, "back again", 300*time.Millisecond)
+
+		alice.Send(agent, "are you there")
+		alice.WaitForMessage(agent, "back again")
+		t.Log("\tShould answer after the LLM timed out.", "OK")
+	}
+}
+
+// stuckLLM never answers the first question.
+type stuckLLM struct {
+	calls  int
+	answer string
+}
+
+func (l *stuckLLM) Chat(ctx context.Context, input string, history []string) (string, error) {
+	l.calls++
+	if l.calls == 1 {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	return l.answer, nil
+}
@@ -0,0 +1,307 @@
+// This program provides an agent that connects to a CAP with its own ID and
+// answers the messages it receives using an LLM.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ardanlabs/conf/v3"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbfile"
+	"github.com/ardanlabs/usdl/api/services/agent/bot"
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/foundation/agents"
+	"github.com/ardanlabs/usdl/foundation/agents/fakellm"
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
+	"github.com/ardanlabs/usdl/foundation/agents/ollamallm"
+	"github.com/ardanlabs/usdl/foundation/agents/openaillm"
+	"github.com/ardanlabs/usdl/foundation/keystore"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var build = "develop"
+
+func main() {
+	var log *logger.Logger
+
+	traceIDFn := func(ctx context.Context) string {
+		return ""
+	}
+
+	log = logger.New(os.Stdout, logger.LevelInfo, "AGENT", traceIDFn)
+
+	// -------------------------------------------------------------------------
+
+	ctx := context.Background()
+
+	if err := run(ctx, log); err != nil {
+		log.Error(ctx, "startup", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, log *logger.Logger) error {
+
+	// -------------------------------------------------------------------------
+	// GOMAXPROCS
+
+	log.Info(ctx, "startup", "GOMAXPROCS", runtime.GOMAXPROCS(0))
+
+	// -------------------------------------------------------------------------
+	// Configuration
+
+	cfg := struct {
+		conf.Version
+		Bot struct {
+			Name         string `conf:"default:Agent"`
+			Path         string `conf:"default:zarf/agent,help:folder for the agent's id and storage"`
+			Passphrase   string `conf:"mask,help:encrypts the agent's keys"`
+			Profile      string `conf:"default:zarf/agent/profile/assistant.txt"`
+			History      int    `conf:"default:10,help:messages of history sent with each question"`
+			RecallTokens int    `conf:"default:500,help:token budget for relevant older messages (0 is off)"`
+		}
+		Guard struct {
+			Allow      []string      `conf:"help:addresses the agent may answer (all when empty)"`
+			MaxReplies int           `conf:"default:30,help:replies to one contact per window (0 is no limit)"`
+			Window     time.Duration `conf:"default:1h"`
+			MaxTurns   int           `conf:"default:20,help:replies in a row before the agent assumes a loop"`
+			Similarity float64       `conf:"default:0.9,help:word overlap with a reply that counts as an echo"`
+		}
+		LLM struct {
+			Backend     string `conf:"default:ollama,help:ollama or openai or fake"`
+			Model       string `conf:"help:defaults to llama3.2:latest for ollama"`
+			BaseURL     string
+			APIKey      string        `conf:"mask"`
+			Temperature float64       `conf:"default:1.0"`
+			MaxTokens   int           `conf:"default:500"`
+			Timeout     time.Duration `conf:"default:2m,help:how long the llm is given to answer"`
+		}
+		CAP struct {
+			URL       string `conf:"default:http://localhost:3000"`
+			CAFile    string
+			CertFile  string
+			KeyFile   string
+			Reconnect time.Duration `conf:"default:5s"`
+		}
+		Auth struct {
+			KeysFolder string `conf:"default:zarf/client/id/"`
+			ActiveKID  string `conf:"default:key"`
+			Issuer     string `conf:"default:usdl project"`
+		}
+	}{
+		Version: conf.Version{
+			Build: build,
+			Desc:  "AGENT",
+		},
+	}
+
+	const prefix = "AGENT"
+	help, err := conf.Parse(prefix, &cfg)
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			fmt.Println(help)
+			return nil
+		}
+		return fmt.Errorf("parsing config: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+	// App Starting
+
+	log.Info(ctx, "starting service", "version", cfg.Build)
+	defer log.Info(ctx, "shutdown complete")
+
+	out, err := conf.String(&cfg)
+	if err != nil {
+		return fmt.Errorf("generating config for output: %w", err)
+	}
+	log.Info(ctx, "startup", "config", out)
+
+	// -------------------------------------------------------------------------
+	// ID Support
+
+	id, err := client.NewID(cfg.Bot.Path, cfg.Bot.Passphrase)
+	if err != nil {
+		return fmt.Errorf("id: %w", err)
+	}
+
+	log.Info(ctx, "startup", "status", "id loaded", "id", id.MyAccountID)
+
+	// -------------------------------------------------------------------------
+	// Auth Support
+
+	ks := keystore.New()
+
+	if _, err := ks.LoadByFileSystem(os.DirFS(cfg.Auth.KeysFolder)); err != nil {
+		return fmt.Errorf("loading keys by fs: %w", err)
+	}
+
+	ath, err := auth.New(auth.Config{
+		Log:       log,
+		KeyLookup: ks,
+		Issuer:    cfg.Auth.Issuer,
+	})
+	if err != nil {
+		return fmt.Errorf("constructing auth: %w", err)
+	}
+
+	tkn, err := ath.GenerateToken(cfg.Auth.ActiveKID, auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id.MyAccountID.Hex(),
+			Issuer:    cfg.Auth.Issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(8760 * time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("generating token: %w", err)
+	}
+
+	// -------------------------------------------------------------------------
+	// Storage Support
+
+	db, err := dbfile.NewDB(cfg.Bot.Path, id, tkn)
+	if err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+
+	acct := db.MyAccount()
+	acct.Name = cfg.Bot.Name
+
+	// -------------------------------------------------------------------------
+	// LLM Support
+
+	profile, err := agents.LoadProfile(cfg.Bot.Profile)
+	if err != nil {
+		return fmt.Errorf("llm: %w", err)
+	}
+
+	llm, err := newAgent(cfg.LLM.Backend, agents.Config{
+		Profile:     profile,
+		Model:       cfg.LLM.Model,
+		BaseURL:     cfg.LLM.BaseURL,
+		APIKey:      cfg.LLM.APIKey,
+		Temperature: cfg.LLM.Temperature,
+		MaxTokens:   cfg.LLM.MaxTokens,
+	})
+	if err != nil {
+		return fmt.Errorf("llm: %w", err)
+	}
+
+	log.Info(ctx, "startup", "status", "llm constructed", "backend", cfg.LLM.Backend)
+
+	// -------------------------------------------------------------------------
+	// Start Agent
+
+	allow, err := guard.ParseAddresses(cfg.Guard.Allow)
+	if err != nil {
+		return fmt.Errorf("guard: %w", err)
+	}
+
+	b := bot.New(bot.Config{
+		Log:     log,
+		ID:      id.MyAccountID,
+		Storage: db,
+		Memory:  db,
+		LLM:     llm,
+		Guard: guard.New(guard.Config{
+			Allow:      allow,
+			MaxReplies: cfg.Guard.MaxReplies,
+			Window:     cfg.Guard.Window,
+			MaxTurns:   cfg.Guard.MaxTurns,
+			Similarity: cfg.Guard.Similarity,
+		}),
+		History:      cfg.Bot.History,
+		RecallTokens: cfg.Bot.RecallTokens,
+		Timeout:      cfg.LLM.Timeout,
+	})
+
+	var options []client.Option
+
+	if strings.HasPrefix(cfg.CAP.URL, "https://") {
+		tlsConfig, err := client.NewTLSConfig(cfg.CAP.CAFile, cfg.CAP.CertFile, cfg.CAP.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls config: %w", err)
+		}
+
+		options = append(options, client.WithTLSConfig(tlsConfig))
+	}
+
+	app := client.NewApp(db, id, cfg.CAP.URL, b, tkn, filepath.Join(cfg.Bot.Path, "transfers"), options...)
+	defer app.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		b.Serve(ctx, app)
+	}()
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	// -------------------------------------------------------------------------
+	// Connect to the CAP, reconnecting when the connection drops.
+
+	for {
+		if err := app.Handshake(acct); err != nil {
+			log.Error(ctx, "connect", "url", cfg.CAP.URL, "ERROR", err, "retry", cfg.CAP.Reconnect)
+		} else {
+			log.Info(ctx, "connect", "status", "connected", "url", cfg.CAP.URL, "name", acct.Name)
+
+			select {
+			case <-app.Done():
+				log.Info(ctx, "connect", "status", "connection dropped", "retry", cfg.CAP.Reconnect)
+
+			case sig := <-shutdown:
+				return stop(ctx, log, sig, cancel, served)
+			}
+		}
+
+		select {
+		case <-time.After(cfg.CAP.Reconnect):
+
+		case sig := <-shutdown:
+			return stop(ctx, log, sig, cancel, served)
+		}
+	}
+}
+
+// newAgent constructs the agent for the backend.
+func newAgent(backend string, cfg agents.Config) (agents.Agent, error) {
+	switch backend {
+	case "ollama":
+		return ollamallm.New(cfg)
+
+	case "openai":
+		return openaillm.New(cfg)
+
+	case "fake":
+		return fakellm.New(), nil
+	}
+
+	return nil, fmt.Errorf("unknown backend: %q", backend)
+}
+
+// stop cancels any answer in progress and waits for the bot to finish.
+func stop(ctx context.Context, log *logger.Logger, sig os.Signal, cancel context.CancelFunc, served <-chan struct{}) error {
+	log.Info(ctx, "shutdown", "status", "shutdown started", "signal", sig)
+	defer log.Info(ctx, "shutdown", "status", "shutdown complete", "signal", sig)
+
+	cancel()
+	<-served
+
+	return nil
+}
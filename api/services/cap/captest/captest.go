@@ -0,0 +1,321 @@
+// Package captest provides support for running a network of CAPs inside a
+// test process and connecting headless clients to them.
+package captest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/app/sdk/mux"
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/busmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/groupmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/mailboxmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/noncemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/presencemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/rotatemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/uicltmgr"
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
+	"github.com/ardanlabs/usdl/business/domain/transferbus/managers/chunkmgr"
+	"github.com/ardanlabs/usdl/foundation/keystore"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ardanlabs/usdl/foundation/tcp"
+	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+)
+
+// AckWait is how long a CAP waits for another CAP to ack a message before
+// storing it in the mailbox. It is kept short so tests don't wait long.
+const AckWait = 200 * time.Millisecond
+
+// WaitTimeout is how long the wait helpers wait for something to happen.
+const WaitTimeout = 5 * time.Second
+
+const (
+	kid    = "captest"
+	issuer = "captest"
+)
+
+// Network represents a set of CAPs sharing an in memory message bus and
+// in memory storage, the same as CAPs sharing a NATS cluster.
+type Network struct {
+	t        *testing.T
+	log      *logger.Logger
+	auth     *auth.Auth
+	bus      *busmgr.Memory
+	mailbox  *mailboxmgr.Memory
+	presence *presencemgr.Memory
+	groups   *groupmgr.Memory
+	nonces   *noncemgr.Memory
+	rotates  *rotatemgr.Memory
+	chunks   *chunkmgr.Memory
+	allow    []common.Address
+}
+
+// New constructs a network with no CAPs. The logs of the CAPs are written
+// to the test output when the test fails.
+func New(t *testing.T) *Network {
+	var buf logBuffer
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Log("******************** LOGS ********************")
+			t.Log(buf.String())
+			t.Log("******************** LOGS ********************")
+		}
+	})
+
+	traceIDFn := func(ctx context.Context) string {
+		return web.GetTraceID(ctx).String()
+	}
+
+	log := logger.New(&buf, logger.LevelInfo, "CAPTEST", traceIDFn)
+
+	ath, err := newAuth(log)
+	if err != nil {
+		t.Fatalf("constructing auth: %s", err)
+	}
+
+	n := Network{
+		t:        t,
+		log:      log,
+		auth:     ath,
+		bus:      busmgr.NewMemory(),
+		mailbox:  mailboxmgr.NewMemory(log, 100, time.Hour),
+		presence: presencemgr.NewMemory(log, time.Minute),
+		groups:   groupmgr.NewMemory(log),
+		nonces:   noncemgr.NewMemory(log),
+		rotates:  rotatemgr.NewMemory(log, time.Hour),
+		chunks:   chunkmgr.NewMemory(log, time.Hour),
+	}
+
+	return &n
+}
+
+// AllowLogin lets the account log in from the browser on the CAPs started
+// after the call.
+func (n *Network) AllowLogin(id common.Address) {
+	n.allow = append(n.allow, id)
+}
+
+// StartCAPs starts the number of CAPs specified.
+func (n *Network) StartCAPs(count int) []*CAP {
+	caps := make([]*CAP, count)
+	for i := range caps {
+		caps[i] = n.StartCAP()
+	}
+
+	return caps
+}
+
+// =============================================================================
+
+// CAP represents a CAP running on ephemeral ports.
+type CAP struct {
+	ID      uuid.UUID
+	URL     string
+	TCPAddr string
+	ChatBus *chatbus.Business
+}
+
+// StartCAP starts a CAP with its own web server, tcp server and tcp client
+// manager. Everything is shut down when the test completes.
+func (n *Network) StartCAP() *CAP {
+	t := n.t
+	ctx := context.Background()
+
+	capID := uuid.New()
+	uiCltMgr := uicltmgr.New(n.log, n.presence, capID)
+
+	// -------------------------------------------------------------------------
+	// TCP Server
+
+	tcpLogger := func(ctx context.Context, name string, evt string, typ string, ipAddress string, format string, a ...any) {
+		n.log.Info(ctx, "Tcp Event", "name", name, "evt", evt, "typ", typ, "ipAddress", ipAddress, "info", fmt.Sprintf(format, a...))
+	}
+
+	tcpSrvCfg := tcp.ServerConfig{
+		NetType:  "tcp4",
+		Addr:     "127.0.0.1:0",
+		Handlers: chatbus.NewServerHandlers(n.log, uiCltMgr, n.mailbox, n.groups, n.nonces),
+		Logger:   tcpLogger,
+	}
+
+	tcpSrv, err := tcp.NewServer("CAPTEST", tcpSrvCfg)
+	if err != nil {
+		t.Fatalf("constructing tcp server: %s", err)
+	}
+	t.Cleanup(func() { tcpSrv.Shutdown(ctx) })
+
+	go tcpSrv.Listen()
+
+	tcpAddr, err := waitForAddr(tcpSrv)
+	if err != nil {
+		t.Fatalf("starting tcp server: %s", err)
+	}
+
+	// -------------------------------------------------------------------------
+	// TCP Client Manager
+
+	tcpCltCfg := tcp.ClientConfig{
+		Handlers: chatbus.NewClientHandlers(n.log),
+		Logger:   tcpLogger,
+	}
+
+	tcpCM, err := tcp.NewClientManager("CAPTEST", tcpCltCfg)
+	if err != nil {
+		t.Fatalf("constructing tcp client manager: %s", err)
+	}
+	t.Cleanup(func() { tcpCM.Shutdown(ctx) })
+
+	// -------------------------------------------------------------------------
+	// ChatBus
+
+	cfgBus := chatbus.Config{
+		Log:       n.log,
+		Bus:       n.bus,
+		UICltMgr:  uiCltMgr,
+		TCPCltMgr: tcpCM,
+		TCPServer: tcpSrv,
+		Mailbox:   n.mailbox,
+		Presence:  n.presence,
+		GroupMgr:  n.groups,
+		NonceMgr:  n.nonces,
+		RotateMgr: n.rotates,
+		UIConn: chatbus.UIConnConfig{
+			QueueSize:    256,
+			WriteTimeout: 5 * time.Second,
+			Overflow:     chatbus.OverflowDrop,
+		},
+		AckWait: AckWait,
+		CAPID:   capID,
+	}
+
+	chatBus, err := chatbus.NewBusiness(cfgBus)
+	if err != nil {
+		t.Fatalf("constructing chat: %s", err)
+	}
+
+	transferBus := transferbus.NewBusiness(transferbus.Config{
+		Log:          n.log,
+		ChunkMgr:     n.chunks,
+		MaxChunkSize: 1 << 20,
+	})
+
+	// -------------------------------------------------------------------------
+	// Web Server
+
+	cfgMux := mux.Config{
+		Log:              n.log,
+		ChatBus:          chatBus,
+		TransferBus:      transferBus,
+		ServerAddr:       tcpAddr,
+		Auth:             n.auth,
+		ActiveKID:        kid,
+		TransferTokenTTL: time.Minute,
+		WebClient:        true,
+		LoginTokenTTL:    time.Hour,
+		LoginAllow:       n.allow,
+	}
+
+	srv := httptest.NewServer(mux.WebAPI(cfgMux))
+	t.Cleanup(srv.Close)
+
+	c := CAP{
+		ID:      capID,
+		URL:     srv.URL,
+		TCPAddr: tcpAddr,
+		ChatBus: chatBus,
+	}
+
+	return &c
+}
+
+// =============================================================================
+
+// waitForAddr waits for the tcp server to start listening.
+func waitForAddr(srv *tcp.Server) (string, error) {
+	deadline := time.Now().Add(WaitTimeout)
+
+	for time.Now().Before(deadline) {
+		if addr := srv.Addr(); addr != nil {
+			return addr.String(), nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "", fmt.Errorf("timed out waiting for the listener")
+}
+
+// newAuth constructs auth support using a key generated for the network.
+func newAuth(log *logger.Logger) (*auth.Auth, error) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	privateBlock := pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(pk),
+	}
+
+	doc := struct {
+		Key string `json:"key"`
+		PEM string `json:"pem"`
+	}{
+		Key: kid,
+		PEM: string(pem.EncodeToMemory(&privateBlock)),
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	ks := keystore.New()
+	if _, err := ks.LoadByJSON(string(data)); err != nil {
+		return nil, fmt.Errorf("loading keys: %w", err)
+	}
+
+	authCfg := auth.Config{
+		Log:       log,
+		KeyLookup: ks,
+		Issuer:    issuer,
+	}
+
+	return auth.New(authCfg)
+}
+
+// logBuffer is a buffer that can be written to by the CAPs and read by the
+// test at the same time.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
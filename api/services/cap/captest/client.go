@@ -0,0 +1,439 @@
+package captest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client/storage/dbmem"
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/mnemonic"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Client represents a headless client app with a generated ID and in
+// memory storage.
+type Client struct {
+	ID   common.Address
+	Name string
+	net  *Network
+	id   client.ID
+	jwt  string
+	db   *dbmem.DB
+	ui   *headlessUI
+	app  *client.App
+	cap  *CAP
+}
+
+// NewClient constructs a client that is not connected to a CAP.
+func (n *Network) NewClient(name string) *Client {
+	t := n.t
+
+	id, err := client.GenerateID()
+	if err != nil {
+		t.Fatalf("generating id: %s", err)
+	}
+
+	tkn := n.token(id.MyAccountID)
+
+	c := Client{
+		ID:   id.MyAccountID,
+		Name: name,
+		net:  n,
+		id:   id,
+		jwt:  tkn,
+		db:   dbmem.NewDB(id, name, tkn),
+		ui:   newHeadlessUI(),
+	}
+
+	return &c
+}
+
+// token generates a token the CAPs accept for the ID.
+func (n *Network) token(id common.Address) string {
+	tkn, err := n.auth.GenerateToken(kid, auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id.Hex(),
+			Issuer:    issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		},
+	})
+	if err != nil {
+		n.t.Fatalf("generating token: %s", err)
+	}
+
+	return tkn
+}
+
+// Connect constructs a client and connects it to the CAP.
+func (n *Network) Connect(node *CAP, name string) *Client {
+	c := n.NewClient(name)
+	c.Connect(node)
+
+	return c
+}
+
+// Connect connects the client to the CAP. A client that was disconnected
+// can connect again, to the same or a different CAP, and keeps its storage.
+func (c *Client) Connect(node *CAP) {
+	c.connect(node, c.ui)
+}
+
+// ConnectUI connects the client to the CAP with the UI replacing the
+// headless UI, so the wait helpers no longer see the messages.
+func (c *Client) ConnectUI(node *CAP, ui client.UI) *client.App {
+	c.connect(node, ui)
+
+	return c.app
+}
+
+func (c *Client) connect(node *CAP, ui client.UI) {
+	t := c.net.t
+
+	app := client.NewApp(c.db, c.id, node.URL, ui, c.jwt, t.TempDir())
+
+	if err := app.Handshake(c.db.MyAccount()); err != nil {
+		t.Fatalf("%s: handshake: %s", c.Name, err)
+	}
+	t.Cleanup(func() { app.Close() })
+
+	c.app = app
+	c.cap = node
+}
+
+// Disconnect closes the connection to the CAP and waits for the CAP to
+// notice the client is gone.
+func (c *Client) Disconnect() {
+	t := c.net.t
+
+	if err := c.app.Close(); err != nil {
+		t.Fatalf("%s: close: %s", c.Name, err)
+	}
+
+	deadline := time.Now().Add(WaitTimeout)
+
+	for time.Now().Before(deadline) {
+		_, err := c.net.presence.Lookup(context.Background(), c.ID)
+		if errors.Is(err, chatbus.ErrNotExists) {
+			c.cap = nil
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("%s: timed out waiting for the CAP to drop the connection", c.Name)
+}
+
+// ReplaceKeys gives the client new encryption keys for the same account,
+// like a user who set the account up again on a new device. The client
+// must be disconnected and keeps its storage.
+func (c *Client) ReplaceKeys() {
+	id, err := client.GenerateID()
+	if err != nil {
+		c.net.t.Fatalf("%s: generating id: %s", c.Name, err)
+	}
+
+	id.MyAccountID = c.id.MyAccountID
+	id.PrivKeyECDSA = c.id.PrivKeyECDSA
+
+	c.id = id
+}
+
+// Rotate moves the client to a new ID, which tells the CAP and the
+// client's contacts, and connects again to the same CAP with the new ID.
+// It returns the old ID.
+func (c *Client) Rotate() common.Address {
+	t := c.net.t
+
+	// RotateID checks the passphrase against a keyfile, so give it one.
+	const passphrase = "captest"
+	dir := t.TempDir()
+
+	if _, err := client.NewID(dir, passphrase); err != nil {
+		t.Fatalf("%s: keyfile: %s", c.Name, err)
+	}
+
+	newID, err := c.app.RotateID(context.Background(), dir, passphrase)
+	if err != nil {
+		t.Fatalf("%s: rotate: %s", c.Name, err)
+	}
+
+	id, err := client.NewID(dir, passphrase)
+	if err != nil {
+		t.Fatalf("%s: reading rotated id: %s", c.Name, err)
+	}
+
+	if id.MyAccountID != newID {
+		t.Fatalf("%s: keyfile holds %s, rotated to %s", c.Name, id.MyAccountID, newID)
+	}
+
+	node := c.cap
+	c.Disconnect()
+
+	oldID := c.ID
+
+	c.ID = id.MyAccountID
+	c.id = id
+	c.jwt = c.net.token(id.MyAccountID)
+
+	c.Connect(node)
+
+	return oldID
+}
+
+// Restore recreates the client's keys from its backup words, like a user
+// who lost the keys and restored without an archive. The contacts are kept,
+// but the sessions and nonces are lost with the keys. The client must be
+// disconnected.
+func (c *Client) Restore() {
+	t := c.net.t
+
+	words, err := mnemonic.New(crypto.FromECDSA(c.id.PrivKeyECDSA))
+	if err != nil {
+		t.Fatalf("%s: mnemonic: %s", c.Name, err)
+	}
+
+	id, err := client.RestoreID(t.TempDir(), words, "captest")
+	if err != nil {
+		t.Fatalf("%s: restore: %s", c.Name, err)
+	}
+
+	if id.MyAccountID != c.ID {
+		t.Fatalf("%s: restored %s, expected %s", c.Name, id.MyAccountID, c.ID)
+	}
+
+	for _, usr := range c.db.Contacts() {
+		if err := c.db.UpdateSession(usr.ID, nil); err != nil {
+			t.Fatalf("%s: drop session: %s", c.Name, err)
+		}
+
+		if err := c.db.UpdateAppNonce(usr.ID, 0); err != nil {
+			t.Fatalf("%s: reset nonce: %s", c.Name, err)
+		}
+
+		if err := c.db.UpdateContactNonce(usr.ID, 0); err != nil {
+			t.Fatalf("%s: reset nonce: %s", c.Name, err)
+		}
+	}
+
+	c.id = id
+}
+
+// App returns the client app for scenarios the helpers don't cover.
+func (c *Client) App() *client.App {
+	return c.app
+}
+
+// Token returns the token the client connects to the CAP with.
+func (c *Client) Token() string {
+	return c.jwt
+}
+
+// AddContact adds the other client to this client's contacts.
+func (c *Client) AddContact(other *Client) {
+	if _, err := c.db.InsertContact(other.ID, other.Name); err != nil {
+		c.net.t.Fatalf("%s: add contact: %s", c.Name, err)
+	}
+}
+
+// Storage returns the client's storage.
+func (c *Client) Storage() client.Storage {
+	return c.db
+}
+
+// Contact returns what this client knows about the other client.
+func (c *Client) Contact(other *Client) client.User {
+	usr, err := c.db.QueryContactByID(other.ID)
+	if err != nil {
+		c.net.t.Fatalf("%s: query contact: %s", c.Name, err)
+	}
+
+	return usr
+}
+
+// Send sends the text to the other client.
+func (c *Client) Send(to *Client, text string) {
+	if err := c.app.SendMessageHandler(to.ID, []byte(text)); err != nil {
+		c.net.t.Fatalf("%s: send: %s", c.Name, err)
+	}
+}
+
+// ShareKey sends this client's public key to the other client so the other
+// client encrypts the messages it sends.
+func (c *Client) ShareKey(to *Client) {
+	c.Send(to, "/share key")
+}
+
+// PinLegacyKey pins the other client's RSA key, the key older clients
+// shared before keys were signed.
+func (c *Client) PinLegacyKey(other *Client) {
+	if err := c.db.UpdateContactKey(other.ID, other.id.PubKeyRSA); err != nil {
+		c.net.t.Fatalf("%s: update key: %s", c.Name, err)
+	}
+}
+
+// DialTCP asks this client's CAP to open a peer-to-peer connection to the
+// CAP the other client is connected to.
+func (c *Client) DialTCP(to *Client) {
+	t := c.net.t
+
+	if err := c.db.UpdateContactTCPHost(to.ID, to.cap.TCPAddr); err != nil {
+		t.Fatalf("%s: update tcp host: %s", c.Name, err)
+	}
+
+	if err := c.app.EstablishTCPConnection(context.Background(), c.ID, to.ID); err != nil {
+		t.Fatalf("%s: establish tcp connection: %s", c.Name, err)
+	}
+}
+
+// Messages returns everything the client has displayed.
+func (c *Client) Messages() []client.Message {
+	return c.ui.messages()
+}
+
+// WaitForMessage waits for the text to arrive from the other client.
+func (c *Client) WaitForMessage(from *Client, text string) client.Message {
+	match := func(msg client.Message) bool {
+		return msg.From == from.ID && client.StitchMessages(msg.Content) == text
+	}
+
+	msg, found := c.ui.wait(match, WaitTimeout)
+	if !found {
+		c.net.t.Fatalf("%s: timed out waiting for %q from %s: got %s", c.Name, text, from.Name, c.ui)
+	}
+
+	return msg
+}
+
+// WaitForMessages waits for the text to have arrived from the other client
+// n times.
+func (c *Client) WaitForMessages(from *Client, text string, n int) {
+	match := func(msg client.Message) bool {
+		return msg.From == from.ID && client.StitchMessages(msg.Content) == text
+	}
+
+	if _, found := c.ui.waitN(match, n, WaitTimeout); !found {
+		c.net.t.Fatalf("%s: timed out waiting for %q %d times from %s: got %s", c.Name, text, n, from.Name, c.ui)
+	}
+}
+
+// WaitForSystem waits for a system message containing the text.
+func (c *Client) WaitForSystem(text string) client.Message {
+	match := func(msg client.Message) bool {
+		return msg.Name == "system" && strings.Contains(client.StitchMessages(msg.Content), text)
+	}
+
+	msg, found := c.ui.wait(match, WaitTimeout)
+	if !found {
+		c.net.t.Fatalf("%s: timed out waiting for system message %q: got %s", c.Name, text, c.ui)
+	}
+
+	return msg
+}
+
+// ExpectNoMessage checks the text doesn't arrive from the other client
+// within the duration.
+func (c *Client) ExpectNoMessage(from *Client, text string, d time.Duration) {
+	match := func(msg client.Message) bool {
+		return msg.From == from.ID && client.StitchMessages(msg.Content) == text
+	}
+
+	if _, found := c.ui.wait(match, d); found {
+		c.net.t.Fatalf("%s: received %q from %s", c.Name, text, from.Name)
+	}
+}
+
+// =============================================================================
+
+// headlessUI records what the client app displays.
+type headlessUI struct {
+	mu      sync.Mutex
+	msgs    []client.Message
+	changed chan struct{}
+}
+
+func newHeadlessUI() *headlessUI {
+	return &headlessUI{
+		changed: make(chan struct{}),
+	}
+}
+
+func (ui *headlessUI) Run() error {
+	return nil
+}
+
+func (ui *headlessUI) WriteText(msg client.Message) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	ui.msgs = append(ui.msgs, msg)
+
+	close(ui.changed)
+	ui.changed = make(chan struct{})
+}
+
+func (ui *headlessUI) AddContact(id common.Address, name string)                     {}
+func (ui *headlessUI) AddGroup(id common.Address, name string)                       {}
+func (ui *headlessUI) RemoveContact(id common.Address)                               {}
+func (ui *headlessUI) TransferOffer(offer client.TransferOffer)                      {}
+func (ui *headlessUI) ApplyContactPrefix(id common.Address, option string, add bool) {}
+
+func (ui *headlessUI) String() string {
+	var b strings.Builder
+	for _, msg := range ui.messages() {
+		b.WriteString("\n\t")
+		b.WriteString(msg.Name)
+		b.WriteString(": ")
+		b.WriteString(client.StitchMessages(msg.Content))
+	}
+
+	return b.String()
+}
+
+func (ui *headlessUI) messages() []client.Message {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+
+	return append([]client.Message(nil), ui.msgs...)
+}
+
+// wait waits for a message that matches, including messages that arrived
+// before wait was called.
+func (ui *headlessUI) wait(match func(client.Message) bool, d time.Duration) (client.Message, bool) {
+	return ui.waitN(match, 1, d)
+}
+
+// waitN waits for the nth message that matches.
+func (ui *headlessUI) waitN(match func(client.Message) bool, n int, d time.Duration) (client.Message, bool) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		ui.mu.Lock()
+		var count int
+		for _, msg := range ui.msgs {
+			if match(msg) {
+				if count++; count == n {
+					ui.mu.Unlock()
+					return msg, true
+				}
+			}
+		}
+		changed := ui.changed
+		ui.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return client.Message{}, false
+		}
+	}
+}
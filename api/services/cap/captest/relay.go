@@ -0,0 +1,133 @@
+package captest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Relay sits between a client and a CAP, like a relay the client can't
+// trust. It keeps what the CAP sends the client so a test can send it again.
+type Relay struct {
+	node   *CAP
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	frames [][]byte
+}
+
+// StartRelay starts a relay in front of the CAP. Clients connect to the CAP
+// it returns to go through the relay.
+func (n *Network) StartRelay(node *CAP) (*Relay, *CAP) {
+	t := n.t
+
+	target, err := url.Parse(node.URL)
+	if err != nil {
+		t.Fatalf("parsing cap url: %s", err)
+	}
+
+	rly := Relay{
+		node: node,
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		rly.relay(w, r)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(h))
+	t.Cleanup(srv.Close)
+
+	relayed := *node
+	relayed.URL = srv.URL
+
+	return &rly, &relayed
+}
+
+// Last returns the last message the CAP sent the client.
+func (rly *Relay) Last() []byte {
+	rly.mu.Lock()
+	defer rly.mu.Unlock()
+
+	if len(rly.frames) == 0 {
+		return nil
+	}
+
+	return rly.frames[len(rly.frames)-1]
+}
+
+// Replay sends the message to the client again.
+func (rly *Relay) Replay(frame []byte) error {
+	rly.mu.Lock()
+	defer rly.mu.Unlock()
+
+	return rly.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+// relay connects the client to the CAP and copies the messages both ways.
+func (rly *Relay) relay(w http.ResponseWriter, r *http.Request) {
+	capURL := "ws" + strings.TrimPrefix(rly.node.URL, "http") + r.URL.Path
+
+	header := http.Header{}
+	header.Set("Authorization", r.Header.Get("Authorization"))
+
+	capConn, _, err := websocket.DefaultDialer.Dial(capURL, header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer capConn.Close()
+
+	var upgrader websocket.Upgrader
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	rly.mu.Lock()
+	rly.conn = conn
+	rly.mu.Unlock()
+
+	go func() {
+		defer capConn.Close()
+
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := capConn.WriteMessage(typ, data); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		typ, data, err := capConn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		rly.mu.Lock()
+		rly.frames = append(rly.frames, data)
+		err = conn.WriteMessage(typ, data)
+		rly.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -17,37 +19,31 @@ import (
 	"github.com/ardanlabs/usdl/app/sdk/auth"
 	"github.com/ardanlabs/usdl/app/sdk/mux"
 	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/busmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/groupmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/mailboxmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/noncemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/presencemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/rotatemgr"
 	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/uicltmgr"
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
+	"github.com/ardanlabs/usdl/business/domain/transferbus/managers/chunkmgr"
 	"github.com/ardanlabs/usdl/foundation/keystore"
 	"github.com/ardanlabs/usdl/foundation/logger"
 	"github.com/ardanlabs/usdl/foundation/tcp"
 	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 /*
-	MISC
-		- Fix agents from responding to tcp connections
-
 	Datafile transfer
-		- Use HTTP Form for file uploads
-		- Need to exchange a JWT
-		- Encryption
-		- Have TUI be asked to approve the transfer
-
-	Terminate TLS Connections
-		- TCP P2P
-
-	Group Chat
-		- Allow users to create groups
+		- Stream over the P2P TCP link when one is established
 
 	Refactor client
 		- Clear history button
-
-	Write Tests
-		- Unit tests
-		- Integration tests
 */
 
 var build = "develop"
@@ -99,22 +95,61 @@ func run(ctx context.Context, log *logger.Logger) error {
 			IdleTimeout     time.Duration `conf:"default:120s"`
 			ShutdownTimeout time.Duration `conf:"default:20s"`
 			APIHost         string        `conf:"default:0.0.0.0:3000"`
+			Client          bool          `conf:"default:false,help:serve the browser client at /web/ and the login at /login"`
+			TLS             struct {
+				CertFile     string
+				KeyFile      string
+				ClientCAFile string
+			}
 		}
 		NATS struct {
-			Host       string `conf:"default:demo.nats.io"`
-			Subject    string `conf:"default:ardanlabs-cap"`
-			IDFilePath string `conf:"default:zarf/cap"`
+			Host       string        `conf:"default:demo.nats.io"`
+			Subject    string        `conf:"default:ardanlabs-cap"`
+			IDFilePath string        `conf:"default:zarf/cap"`
+			AckWait    time.Duration `conf:"default:2s"`
+			Mode       string        `conf:"default:jetstream,help:jetstream or memory"`
+		}
+		Presence struct {
+			TTL time.Duration `conf:"default:30s"`
+		}
+		Mailbox struct {
+			MaxMsgs int64         `conf:"default:1000"`
+			MaxAge  time.Duration `conf:"default:168h"`
+		}
+		UI struct {
+			QueueSize    int           `conf:"default:256"`
+			WriteTimeout time.Duration `conf:"default:10s"`
+			Overflow     string        `conf:"default:drop,help:drop or disconnect"`
+		}
+		Route struct {
+			Order []string `conf:"default:websocket;tcp;bus"`
+		}
+		Rotation struct {
+			Grace time.Duration `conf:"default:168h,help:how long messages to a rotated id are forwarded"`
+		}
+		Transfer struct {
+			TokenTTL     time.Duration `conf:"default:15m"`
+			MaxAge       time.Duration `conf:"default:24h"`
+			MaxChunkSize int           `conf:"default:1048576"`
 		}
 		TCP struct {
 			ServerName string `conf:"default:tcp-server"`
 			ClientName string `conf:"default:tcp-clientmanager"`
 			NetType    string `conf:"default:tcp4"`
 			Addr       string `conf:"default:0.0.0.0:4000"`
+			TLS        struct {
+				CertFile string
+				KeyFile  string
+				CAFile   string
+				Pins     []string
+			}
 		}
 		Auth struct {
-			KeysFolder string `conf:"default:zarf/client/id/"`
-			ActiveKID  string `conf:"default:key"`
-			Issuer     string `conf:"default:usdl project"`
+			KeysFolder string        `conf:"default:zarf/client/id/"`
+			ActiveKID  string        `conf:"default:key"`
+			Issuer     string        `conf:"default:usdl project"`
+			LoginTTL   time.Duration `conf:"default:24h"`
+			LoginAllow []string      `conf:"help:accounts the browser login issues tokens to"`
 		}
 	}{
 		Version: conf.Version{
@@ -168,6 +203,18 @@ func run(ctx context.Context, log *logger.Logger) error {
 		return fmt.Errorf("constructing auth: %w", err)
 	}
 
+	loginAllow := make([]common.Address, len(cfg.Auth.LoginAllow))
+	for i, id := range cfg.Auth.LoginAllow {
+		if !common.IsHexAddress(id) {
+			return fmt.Errorf("login allow: invalid account %q", id)
+		}
+		loginAllow[i] = common.HexToAddress(id)
+	}
+
+	if cfg.Web.Client && len(loginAllow) == 0 {
+		log.Info(ctx, "startup", "status", "browser client enabled with no accounts allowed to log in")
+	}
+
 	// -------------------------------------------------------------------------
 	// Cap ID
 
@@ -178,10 +225,173 @@ func run(ctx context.Context, log *logger.Logger) error {
 
 	log.Info(ctx, "startup", "status", "getting cap", "capID", capID)
 
+	// -------------------------------------------------------------------------
+	// Message Bus, Mailbox, Presence, Groups, Nonces, Rotations and Transfer
+	// Chunks
+
+	var (
+		msgBus    chatbus.MessageBus
+		mailbox   chatbus.Mailbox
+		presence  chatbus.Presence
+		groupMgr  chatbus.GroupManager
+		nonceMgr  chatbus.NonceManager
+		rotateMgr chatbus.RotationManager
+		chunkMgr  transferbus.ChunkManager
+	)
+
+	switch cfg.NATS.Mode {
+	case "memory":
+		log.Info(ctx, "startup", "status", "nats mode memory, running as a single node")
+
+		msgBus = busmgr.NewMemory()
+		mailbox = mailboxmgr.NewMemory(log, cfg.Mailbox.MaxMsgs, cfg.Mailbox.MaxAge)
+		presence = presencemgr.NewMemory(log, cfg.Presence.TTL)
+		groupMgr = groupmgr.NewMemory(log)
+		nonceMgr = noncemgr.NewMemory(log)
+		rotateMgr = rotatemgr.NewMemory(log, cfg.Rotation.Grace)
+		chunkMgr = chunkmgr.NewMemory(log, cfg.Transfer.MaxAge)
+
+	case "jetstream":
+		nc, err := nats.Connect(cfg.NATS.Host)
+		if err != nil {
+			return fmt.Errorf("nats connect: %w", err)
+		}
+		defer nc.Close()
+
+		js, err := jetstream.New(nc)
+		if err != nil {
+			return fmt.Errorf("nats new js: %w", err)
+		}
+
+		busCfg := busmgr.Config{
+			Log:     log,
+			Conn:    nc,
+			Subject: cfg.NATS.Subject,
+		}
+
+		msgBus, err = busmgr.New(ctx, busCfg)
+		if err != nil {
+			return fmt.Errorf("message bus: %w", err)
+		}
+
+		mailboxCfg := mailboxmgr.Config{
+			Log:     log,
+			JS:      js,
+			Subject: cfg.NATS.Subject,
+			MaxMsgs: cfg.Mailbox.MaxMsgs,
+			MaxAge:  cfg.Mailbox.MaxAge,
+		}
+
+		mailbox, err = mailboxmgr.New(ctx, mailboxCfg)
+		if err != nil {
+			return fmt.Errorf("mailbox: %w", err)
+		}
+
+		presenceCfg := presencemgr.Config{
+			Log:     log,
+			JS:      js,
+			Subject: cfg.NATS.Subject,
+			TTL:     cfg.Presence.TTL,
+		}
+
+		presence, err = presencemgr.New(ctx, presenceCfg)
+		if err != nil {
+			return fmt.Errorf("presence: %w", err)
+		}
+
+		groupCfg := groupmgr.Config{
+			Log:     log,
+			JS:      js,
+			Subject: cfg.NATS.Subject,
+		}
+
+		groupMgr, err = groupmgr.New(ctx, groupCfg)
+		if err != nil {
+			return fmt.Errorf("groups: %w", err)
+		}
+
+		nonceCfg := noncemgr.Config{
+			Log:     log,
+			JS:      js,
+			Subject: cfg.NATS.Subject,
+		}
+
+		nonceMgr, err = noncemgr.New(ctx, nonceCfg)
+		if err != nil {
+			return fmt.Errorf("nonces: %w", err)
+		}
+
+		rotateCfg := rotatemgr.Config{
+			Log:     log,
+			JS:      js,
+			Subject: cfg.NATS.Subject,
+			Grace:   cfg.Rotation.Grace,
+		}
+
+		rotateMgr, err = rotatemgr.New(ctx, rotateCfg)
+		if err != nil {
+			return fmt.Errorf("rotations: %w", err)
+		}
+
+		chunkCfg := chunkmgr.Config{
+			Log:     log,
+			JS:      js,
+			Subject: cfg.NATS.Subject,
+			MaxAge:  cfg.Transfer.MaxAge,
+		}
+
+		chunkMgr, err = chunkmgr.New(ctx, chunkCfg)
+		if err != nil {
+			return fmt.Errorf("transfer chunks: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown nats mode: %q", cfg.NATS.Mode)
+	}
+
+	// -------------------------------------------------------------------------
+	// Transfers
+
+	transferBus := transferbus.NewBusiness(transferbus.Config{
+		Log:          log,
+		ChunkMgr:     chunkMgr,
+		MaxChunkSize: cfg.Transfer.MaxChunkSize,
+	})
+
 	// -------------------------------------------------------------------------
 	// UI Client Manager
 
-	uiCltMgr := uicltmgr.New(log)
+	uiCltMgr := uicltmgr.New(log, presence, capID)
+
+	// -------------------------------------------------------------------------
+	// TCP TLS
+
+	var tcpSrvTLS, tcpCltTLS *tls.Config
+
+	switch cfg.TCP.TLS.CertFile {
+	case "":
+		log.Info(ctx, "startup", "status", "tcp tls disabled, p2p traffic is not encrypted")
+
+	default:
+		tlsFiles := tcp.TLSFiles{
+			CertFile: cfg.TCP.TLS.CertFile,
+			KeyFile:  cfg.TCP.TLS.KeyFile,
+			CAFile:   cfg.TCP.TLS.CAFile,
+			Pins:     cfg.TCP.TLS.Pins,
+		}
+
+		tcpSrvTLS, err = tcp.ServerTLSConfig(tlsFiles)
+		if err != nil {
+			return fmt.Errorf("tcp server tls: %w", err)
+		}
+
+		tcpCltTLS, err = tcp.ClientTLSConfig(tlsFiles)
+		if err != nil {
+			return fmt.Errorf("tcp client tls: %w", err)
+		}
+
+		log.Info(ctx, "startup", "status", "tcp tls enabled", "mutual", cfg.TCP.TLS.CAFile != "" || len(cfg.TCP.TLS.Pins) > 0)
+	}
 
 	// -------------------------------------------------------------------------
 	// TCP Server
@@ -191,10 +401,11 @@ func run(ctx context.Context, log *logger.Logger) error {
 	}
 
 	tcpSrvCfg := tcp.ServerConfig{
-		NetType:  cfg.TCP.NetType,
-		Addr:     cfg.TCP.Addr,
-		Handlers: chatbus.NewServerHandlers(log, uiCltMgr),
-		Logger:   tcpSrvLogger,
+		NetType:   cfg.TCP.NetType,
+		Addr:      cfg.TCP.Addr,
+		Handlers:  chatbus.NewServerHandlers(log, uiCltMgr, mailbox, groupMgr, nonceMgr),
+		Logger:    tcpSrvLogger,
+		TLSConfig: tcpSrvTLS,
 	}
 
 	tcpSrv, err := tcp.NewServer(cfg.TCP.ServerName, tcpSrvCfg)
@@ -221,8 +432,9 @@ func run(ctx context.Context, log *logger.Logger) error {
 	}
 
 	cfgCltCfg := tcp.ClientConfig{
-		Handlers: chatbus.NewClientHandlers(log),
-		Logger:   tcpCltLogger,
+		Handlers:  chatbus.NewClientHandlers(log),
+		Logger:    tcpCltLogger,
+		TLSConfig: tcpCltTLS,
 	}
 
 	tcpCM, err := tcp.NewClientManager(cfg.TCP.ClientName, cfgCltCfg)
@@ -235,22 +447,29 @@ func run(ctx context.Context, log *logger.Logger) error {
 	}()
 
 	// -------------------------------------------------------------------------
-	// NATS and ChatBus
+	// ChatBus
 
-	nc, err := nats.Connect(cfg.NATS.Host)
-	if err != nil {
-		return fmt.Errorf("nats connect: %w", err)
+	uiConnCfg := chatbus.UIConnConfig{
+		QueueSize:    cfg.UI.QueueSize,
+		WriteTimeout: cfg.UI.WriteTimeout,
+		Overflow:     chatbus.OverflowPolicy(cfg.UI.Overflow),
 	}
-	defer nc.Close()
 
 	cfgBus := chatbus.Config{
-		Log:         log,
-		NATSConn:    nc,
-		UICltMgr:    uiCltMgr,
-		TCPCltMgr:   tcpCM,
-		TCPServer:   tcpSrv,
-		NATSSubject: cfg.NATS.Subject,
-		CAPID:       capID,
+		Log:        log,
+		Bus:        msgBus,
+		UICltMgr:   uiCltMgr,
+		TCPCltMgr:  tcpCM,
+		TCPServer:  tcpSrv,
+		Mailbox:    mailbox,
+		Presence:   presence,
+		GroupMgr:   groupMgr,
+		NonceMgr:   nonceMgr,
+		RotateMgr:  rotateMgr,
+		UIConn:     uiConnCfg,
+		RouteOrder: cfg.Route.Order,
+		AckWait:    cfg.NATS.AckWait,
+		CAPID:      capID,
 	}
 
 	chatBus, err := chatbus.NewBusiness(cfgBus)
@@ -267,10 +486,16 @@ func run(ctx context.Context, log *logger.Logger) error {
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
 	cfgMux := mux.Config{
-		Log:        log,
-		ChatBus:    chatBus,
-		ServerAddr: cfg.TCP.Addr,
-		Auth:       ath,
+		Log:              log,
+		ChatBus:          chatBus,
+		TransferBus:      transferBus,
+		ServerAddr:       cfg.TCP.Addr,
+		Auth:             ath,
+		ActiveKID:        cfg.Auth.ActiveKID,
+		TransferTokenTTL: cfg.Transfer.TokenTTL,
+		WebClient:        cfg.Web.Client,
+		LoginTokenTTL:    cfg.Auth.LoginTTL,
+		LoginAllow:       loginAllow,
 	}
 
 	webAPI := mux.WebAPI(cfgMux)
@@ -284,10 +509,23 @@ func run(ctx context.Context, log *logger.Logger) error {
 		ErrorLog:     logger.NewStdLogger(log, logger.LevelError),
 	}
 
+	if cfg.Web.TLS.CertFile != "" {
+		api.TLSConfig, err = webTLSConfig(cfg.Web.TLS.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("web tls: %w", err)
+		}
+	}
+
 	serverErrors := make(chan error, 1)
 
 	go func() {
-		log.Info(ctx, "startup", "status", "api router started", "host", api.Addr)
+		if api.TLSConfig != nil {
+			log.Info(ctx, "startup", "status", "api router started", "host", api.Addr, "tls", true, "clientCerts", cfg.Web.TLS.ClientCAFile != "")
+			serverErrors <- api.ListenAndServeTLS(cfg.Web.TLS.CertFile, cfg.Web.TLS.KeyFile)
+			return
+		}
+
+		log.Info(ctx, "startup", "status", "api router started", "host", api.Addr, "tls", false)
 		serverErrors <- api.ListenAndServe()
 	}()
 
@@ -321,6 +559,33 @@ func run(ctx context.Context, log *logger.Logger) error {
 	return nil
 }
 
+// webTLSConfig constructs the TLS configuration for the web server. When a
+// client CA is provided, clients must present a certificate signed by it.
+func webTLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if clientCAFile == "" {
+		return &cfg, nil
+	}
+
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client ca file: %s", clientCAFile)
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return &cfg, nil
+}
+
 func getCapID(idFilePath string) (uuid.UUID, error) {
 	fileName := filepath.Join(idFilePath, "cap.id")
 
@@ -0,0 +1,607 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/clients/tui/ui/client"
+	"github.com/ardanlabs/usdl/api/services/cap/captest"
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+)
+
+// TestWebSocket provides a test of two clients on the same CAP.
+func TestWebSocket(t *testing.T) {
+	t.Log("Given the need to deliver messages between clients on the same CAP.")
+	{
+		net := captest.New(t)
+		cap1 := net.StartCAP()
+
+		alice := net.Connect(cap1, "alice")
+		bob := net.Connect(cap1, "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.Send(bob, "hello bob")
+		bob.WaitForMessage(alice, "hello bob")
+		t.Log("\tShould receive the message from alice.", "OK")
+
+		bob.Send(alice, "hello alice")
+		alice.WaitForMessage(bob, "hello alice")
+		t.Log("\tShould receive the reply from bob.", "OK")
+
+		if got := cap1.ChatBus.Deliveries()[chatbus.TransportWebSocket]; got != 2 {
+			t.Fatalf("\tShould deliver both messages over the websocket, got %d. %s", got, "X")
+		}
+		t.Log("\tShould deliver both messages over the websocket.", "OK")
+	}
+}
+
+// TestCrossCAP provides a test of clients on different CAPs.
+func TestCrossCAP(t *testing.T) {
+	t.Log("Given the need to deliver messages between clients on different CAPs.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(3)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+		carol := net.Connect(caps[2], "carol")
+
+		alice.AddContact(bob)
+		alice.AddContact(carol)
+
+		alice.Send(bob, "hello bob")
+		alice.Send(carol, "hello carol")
+
+		bob.WaitForMessage(alice, "hello bob")
+		t.Log("\tShould receive the message on the second CAP.", "OK")
+
+		carol.WaitForMessage(alice, "hello carol")
+		t.Log("\tShould receive the message on the third CAP.", "OK")
+
+		if got := caps[0].ChatBus.Deliveries()[chatbus.TransportBus]; got != 2 {
+			t.Fatalf("\tShould deliver both messages over the bus, got %d. %s", got, "X")
+		}
+		t.Log("\tShould deliver both messages over the bus.", "OK")
+
+		carol.Send(alice, "hello back")
+		alice.WaitForMessage(carol, "hello back")
+		t.Log("\tShould receive the reply from a sender who was not a contact.", "OK")
+	}
+}
+
+// TestP2P provides a test of clients with a peer-to-peer tcp connection
+// between their CAPs.
+func TestP2P(t *testing.T) {
+	t.Log("Given the need to deliver messages over a peer-to-peer connection.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+
+		alice.AddContact(bob)
+
+		alice.DialTCP(bob)
+		t.Log("\tShould establish the tcp connection.", "OK")
+
+		bob.WaitForSystem("TCP connection established from: " + alice.ID.String())
+		t.Log("\tShould tell bob about the tcp connection.", "OK")
+
+		alice.Send(bob, "over tcp")
+		bob.WaitForMessage(alice, "over tcp")
+		t.Log("\tShould receive the message.", "OK")
+
+		if got := caps[0].ChatBus.Deliveries()[chatbus.TransportTCP]; got != 1 {
+			t.Fatalf("\tShould deliver the message over tcp, got %d. %s", got, "X")
+		}
+		t.Log("\tShould deliver the message over tcp.", "OK")
+
+		if got := caps[0].ChatBus.Deliveries()[chatbus.TransportBus]; got != 0 {
+			t.Fatalf("\tShould not use the bus, got %d. %s", got, "X")
+		}
+		t.Log("\tShould not use the bus.", "OK")
+	}
+}
+
+// TestKeyExchange provides a test of clients sharing keys and sending
+// encrypted messages.
+func TestKeyExchange(t *testing.T) {
+	t.Log("Given the need to send encrypted messages once keys are shared.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** updated contact's key **")
+		t.Log("\tShould receive bob's key.", "OK")
+
+		if alice.Contact(bob).Key == "" {
+			t.Fatal("\tShould store bob's key.", "X")
+		}
+		t.Log("\tShould store bob's key.", "OK")
+
+		bob.Send(alice, "not encrypted")
+
+		msg := alice.WaitForMessage(bob, "not encrypted")
+		if msg.Encrypted {
+			t.Fatal("\tShould receive a plain message when no key was shared.", "X")
+		}
+		t.Log("\tShould receive a plain message when no key was shared.", "OK")
+
+		alice.Send(bob, "for your eyes only")
+
+		msg = bob.WaitForMessage(alice, "for your eyes only")
+		if !msg.Encrypted {
+			t.Fatal("\tShould receive an encrypted message.", "X")
+		}
+		t.Log("\tShould receive an encrypted message.", "OK")
+
+		bob.Send(alice, "encrypted reply")
+
+		msg = alice.WaitForMessage(bob, "encrypted reply")
+		if !msg.Encrypted {
+			t.Fatal("\tShould reply in the session alice started.", "X")
+		}
+		t.Log("\tShould reply in the session alice started.", "OK")
+	}
+}
+
+// TestSession provides a test of clients that both shared keys talking in
+// a forward secret session, including when both start a session at once.
+func TestSession(t *testing.T) {
+	t.Log("Given the need to talk in a session once both keys are shared.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.ShareKey(bob)
+		bob.ShareKey(alice)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+		alice.WaitForMessage(bob, "** updated contact's key **")
+		t.Log("\tShould receive each other's signed keys.", "OK")
+
+		alice.Send(bob, "hello from alice")
+		bob.Send(alice, "hello from bob")
+
+		if msg := bob.WaitForMessage(alice, "hello from alice"); !msg.Encrypted {
+			t.Fatal("\tShould receive an encrypted message from alice.", "X")
+		}
+
+		if msg := alice.WaitForMessage(bob, "hello from bob"); !msg.Encrypted {
+			t.Fatal("\tShould receive an encrypted message from bob.", "X")
+		}
+		t.Log("\tShould read messages when both start a session at once.", "OK")
+
+		for i := range 3 {
+			text := fmt.Sprintf("alice %d", i)
+			alice.Send(bob, text)
+			bob.WaitForMessage(alice, text)
+
+			text = fmt.Sprintf("bob %d", i)
+			bob.Send(alice, text)
+			alice.WaitForMessage(bob, text)
+		}
+		t.Log("\tShould keep talking as the session ratchets.", "OK")
+
+		data, err := alice.Storage().QuerySession(bob.ID)
+		if err != nil || len(data) == 0 {
+			t.Fatalf("\tShould store the session, got %d bytes: %v. %s", len(data), err, "X")
+		}
+		t.Log("\tShould store the session.", "OK")
+	}
+}
+
+// TestSessionReplay provides a test of a relay sending the message that
+// started a session again, after the session was dropped for newer ones.
+func TestSessionReplay(t *testing.T) {
+	t.Log("Given the need to reject a session start that was already used.")
+	{
+		net := captest.New(t)
+		cap1 := net.StartCAP()
+		relay, relayed := net.StartRelay(cap1)
+
+		alice := net.Connect(relayed, "alice")
+		bob := net.Connect(cap1, "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.ShareKey(bob)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+
+		bob.Send(alice, "first session")
+		alice.WaitForMessage(bob, "first session")
+		start := relay.Last()
+
+		// Sharing the key again has bob start a new session.
+		for i := range 5 {
+			alice.ShareKey(bob)
+			bob.WaitForMessages(alice, "** updated contact's key **", i+2)
+
+			text := fmt.Sprintf("session %d", i)
+			bob.Send(alice, text)
+			alice.WaitForMessage(bob, text)
+		}
+
+		if err := relay.Replay(start); err != nil {
+			t.Fatalf("\tShould be able to replay the first message: %s. %s", err, "X")
+		}
+
+		alice.WaitForSystem("replayed session start")
+		t.Log("\tShould reject the replayed session start.", "OK")
+
+		bob.Send(alice, "after the replay")
+		alice.WaitForMessage(bob, "after the replay")
+
+		var count int
+		for _, msg := range alice.Messages() {
+			if client.StitchMessages(msg.Content) == "first session" {
+				count++
+			}
+		}
+
+		if count != 1 {
+			t.Fatalf("\tShould show the first message once, got %d. %s", count, "X")
+		}
+		t.Log("\tShould show the first message once.", "OK")
+	}
+}
+
+// TestSessionFirstContact provides a test of a contact that starts a
+// session before sharing its key.
+func TestSessionFirstContact(t *testing.T) {
+	t.Log("Given the need to pin the key a contact starts the first session with.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(1)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[0], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.ShareKey(bob)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+
+		bob.Send(alice, "hello from bob")
+		if msg := alice.WaitForMessage(bob, "hello from bob"); !msg.Encrypted {
+			t.Fatal("\tShould receive an encrypted message from bob.", "X")
+		}
+
+		if usr := alice.Contact(bob); usr.Key == "" || usr.KeyChanged {
+			t.Fatalf("\tShould pin bob's key without marking it changed, got %+v. %s", usr, "X")
+		}
+		t.Log("\tShould pin bob's key.", "OK")
+
+		bob.Disconnect()
+		bob.ReplaceKeys()
+		bob.Connect(caps[0])
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** contact's key changed, verify it before sending **")
+		t.Log("\tShould be told when bob's key changes after that.", "OK")
+	}
+}
+
+// TestUnreadableMessage provides a test of a message the client can't read
+// not cutting the client off.
+func TestUnreadableMessage(t *testing.T) {
+	t.Log("Given the need to keep receiving after a message can't be read.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(1)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[0], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.ShareKey(bob)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+
+		alice.Disconnect()
+		alice.ReplaceKeys()
+		alice.Connect(caps[0])
+
+		bob.Send(alice, "sealed for the old key")
+		alice.WaitForSystem("preprocess message")
+		t.Log("\tShould report the message sealed for the old key.", "OK")
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** updated contact's key **")
+		t.Log("\tShould keep receiving after it.", "OK")
+	}
+}
+
+// TestKeyChange provides a test of a contact's key changing after it was
+// verified.
+func TestKeyChange(t *testing.T) {
+	t.Log("Given the need to warn before sending to a contact whose key changed.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.ShareKey(bob)
+		bob.ShareKey(alice)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+		alice.WaitForMessage(bob, "** updated contact's key **")
+
+		aliceNumber, err := alice.App().SafetyNumber(bob.ID)
+		if err != nil {
+			t.Fatalf("\tShould get a safety number: %s. %s", err, "X")
+		}
+
+		bobNumber, err := bob.App().SafetyNumber(alice.ID)
+		if err != nil {
+			t.Fatalf("\tShould get a safety number: %s. %s", err, "X")
+		}
+
+		if aliceNumber != bobNumber {
+			t.Fatalf("\tShould see the same safety number on both sides, got %s and %s. %s", aliceNumber, bobNumber, "X")
+		}
+		t.Log("\tShould see the same safety number on both sides.", "OK")
+
+		if err := alice.App().VerifyContact(bob.ID); err != nil {
+			t.Fatalf("\tShould be able to verify bob: %s. %s", err, "X")
+		}
+
+		alice.Send(bob, "before the change")
+		bob.WaitForMessage(alice, "before the change")
+
+		// ---------------------------------------------------------------------
+
+		bob.Disconnect()
+		bob.ReplaceKeys()
+		bob.Connect(caps[1])
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** contact's key changed, verify it before sending **")
+		t.Log("\tShould be told bob's key changed.", "OK")
+
+		if usr := alice.Contact(bob); !usr.KeyChanged || usr.Verified {
+			t.Fatalf("\tShould mark bob's key as changed and not verified, got %+v. %s", usr, "X")
+		}
+
+		err = alice.App().SendMessageHandler(bob.ID, []byte("are you still bob?"))
+		if !errors.Is(err, client.ErrKeyChanged) {
+			t.Fatalf("\tShould not send until the change is accepted, got %v. %s", err, "X")
+		}
+		t.Log("\tShould not send until the change is accepted.", "OK")
+
+		newNumber, err := alice.App().SafetyNumber(bob.ID)
+		if err != nil || newNumber == aliceNumber {
+			t.Fatalf("\tShould get a new safety number, got %v. %s", err, "X")
+		}
+		t.Log("\tShould get a new safety number.", "OK")
+
+		if err := alice.App().AcceptContactKey(bob.ID); err != nil {
+			t.Fatalf("\tShould be able to accept the new key: %s. %s", err, "X")
+		}
+
+		alice.Send(bob, "after the change")
+		if msg := bob.WaitForMessage(alice, "after the change"); !msg.Encrypted {
+			t.Fatal("\tShould send with the new key.", "X")
+		}
+		t.Log("\tShould send with the new key once accepted.", "OK")
+	}
+}
+
+// TestKeyUpgrade provides a test of a contact moving from its legacy key to
+// a signed X25519 key.
+func TestKeyUpgrade(t *testing.T) {
+	t.Log("Given the need to accept a contact's signed key upgrade without a warning.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(1)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[0], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.PinLegacyKey(bob)
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** updated contact's key **")
+		t.Log("\tShould take bob's new key.", "OK")
+
+		if usr := alice.Contact(bob); usr.KeyChanged {
+			t.Fatalf("\tShould not mark bob's key as changed, got %+v. %s", usr, "X")
+		}
+		t.Log("\tShould not mark bob's key as changed.", "OK")
+
+		alice.Send(bob, "after the upgrade")
+		if msg := bob.WaitForMessage(alice, "after the upgrade"); !msg.Encrypted {
+			t.Fatal("\tShould send with the new key.", "X")
+		}
+		t.Log("\tShould send with the new key.", "OK")
+	}
+}
+
+// TestRotation provides a test of a client moving to a new ID.
+func TestRotation(t *testing.T) {
+	t.Log("Given the need to move an account to a new ID.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+		carol := net.Connect(caps[0], "carol")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+		carol.AddContact(bob)
+
+		alice.ShareKey(bob)
+		bob.ShareKey(alice)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+		alice.WaitForMessage(bob, "** updated contact's key **")
+
+		if err := alice.App().VerifyContact(bob.ID); err != nil {
+			t.Fatalf("\tShould be able to verify bob: %s. %s", err, "X")
+		}
+
+		// ---------------------------------------------------------------------
+
+		oldID := bob.Rotate()
+
+		alice.WaitForMessage(bob, fmt.Sprintf("** contact moved to %s, verify it before trusting it **", bob.ID.Hex()))
+		t.Log("\tShould tell alice bob moved.", "OK")
+
+		if _, err := alice.Storage().QueryContactByID(oldID); err == nil {
+			t.Fatalf("\tShould not keep bob's old ID as a contact. %s", "X")
+		}
+
+		alice.WaitForMessage(bob, "** contact's key changed, verify it before sending **")
+		t.Log("\tShould tell alice bob's key changed.", "OK")
+
+		if usr := alice.Contact(bob); usr.Verified || !usr.KeyChanged {
+			t.Fatalf("\tShould move bob to the new ID unverified with a changed key, got %+v. %s", usr, "X")
+		}
+		t.Log("\tShould move bob to the new ID unverified with a changed key.", "OK")
+
+		err := alice.App().SendMessageHandler(bob.ID, []byte("are you still bob?"))
+		if !errors.Is(err, client.ErrKeyChanged) {
+			t.Fatalf("\tShould not send until the new key is accepted, got %v. %s", err, "X")
+		}
+		t.Log("\tShould not send until the new key is accepted.", "OK")
+
+		if err := alice.App().AcceptContactKey(bob.ID); err != nil {
+			t.Fatalf("\tShould be able to accept the new key: %s. %s", err, "X")
+		}
+
+		alice.Send(bob, "hello new bob")
+		if msg := bob.WaitForMessage(alice, "hello new bob"); !msg.Encrypted {
+			t.Fatal("\tShould send to the new ID with the new key.", "X")
+		}
+		bob.Send(alice, "hello alice")
+		alice.WaitForMessage(bob, "hello alice")
+		t.Log("\tShould exchange messages on the new ID.", "OK")
+
+		// ---------------------------------------------------------------------
+
+		if err := carol.App().SendMessageHandler(oldID, []byte("are you there bob?")); err != nil {
+			t.Fatalf("\tShould be able to send to the old ID: %s. %s", err, "X")
+		}
+		bob.WaitForMessage(carol, "are you there bob?")
+		t.Log("\tShould forward messages for the old ID to the new one.", "OK")
+	}
+}
+
+// TestRestore provides a test of a client restoring its keys from the
+// backup words after losing them.
+func TestRestore(t *testing.T) {
+	t.Log("Given the need to restore an account from its backup words.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[1], "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		alice.ShareKey(bob)
+		bob.ShareKey(alice)
+		bob.WaitForMessage(alice, "** updated contact's key **")
+		alice.WaitForMessage(bob, "** updated contact's key **")
+
+		alice.Send(bob, "before")
+		bob.WaitForMessage(alice, "before")
+		bob.Send(alice, "reply")
+		alice.WaitForMessage(bob, "reply")
+
+		// ---------------------------------------------------------------------
+
+		bob.Disconnect()
+		bob.Restore()
+		bob.Connect(caps[1])
+		t.Log("\tShould restore the same ID from the words.", "OK")
+
+		if err := bob.App().AnnounceKey(); err != nil {
+			t.Fatalf("\tShould be able to announce the key: %s. %s", err, "X")
+		}
+
+		alice.WaitForMessages(bob, "** updated contact's key **", 2)
+
+		if usr := alice.Contact(bob); usr.KeyChanged {
+			t.Fatalf("\tShould restore the same encryption key, got %+v. %s", usr, "X")
+		}
+		t.Log("\tShould restore the same encryption key.", "OK")
+
+		alice.Send(bob, "welcome back")
+		if msg := bob.WaitForMessage(alice, "welcome back"); !msg.Encrypted {
+			t.Fatal("\tShould send encrypted to the restored client.", "X")
+		}
+		t.Log("\tShould start a new session with the restored client.", "OK")
+
+		bob.Send(alice, "thanks")
+		alice.WaitForMessage(bob, "thanks")
+		t.Log("\tShould receive from the restored client.", "OK")
+	}
+}
+
+// TestDisconnect provides a test of messages sent to a client that
+// disconnected and then connected to another CAP.
+func TestDisconnect(t *testing.T) {
+	t.Log("Given the need to deliver messages sent while a client is disconnected.")
+	{
+		net := captest.New(t)
+		caps := net.StartCAPs(2)
+
+		alice := net.Connect(caps[0], "alice")
+		bob := net.Connect(caps[0], "bob")
+
+		alice.AddContact(bob)
+
+		alice.Send(bob, "before")
+		bob.WaitForMessage(alice, "before")
+		t.Log("\tShould receive the message while connected.", "OK")
+
+		bob.Disconnect()
+		t.Log("\tShould remove bob from the CAP.", "OK")
+
+		alice.Send(bob, "while away")
+		bob.ExpectNoMessage(alice, "while away", 3*captest.AckWait)
+		t.Log("\tShould not receive the message while disconnected.", "OK")
+
+		bob.Connect(caps[1])
+
+		bob.WaitForMessage(alice, "while away")
+		t.Log("\tShould receive the queued message on the new CAP.", "OK")
+
+		bob.WaitForSystem("queued message(s) delivered")
+		t.Log("\tShould be told about the queued messages.", "OK")
+
+		alice.Send(bob, "after")
+		bob.WaitForMessage(alice, "after")
+		t.Log("\tShould receive messages on the new CAP.", "OK")
+	}
+}
@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/usdl/api/services/cap/captest"
+	"github.com/google/uuid"
+)
+
+// TestTransferToken provides a test of only the sender and recipient of a
+// transfer being able to get a token for it.
+func TestTransferToken(t *testing.T) {
+	t.Log("Given the need to keep a transfer between its sender and recipient.")
+	{
+		net := captest.New(t)
+		cap1 := net.StartCAP()
+
+		alice := net.Connect(cap1, "alice")
+		bob := net.Connect(cap1, "bob")
+		mallory := net.Connect(cap1, "mallory")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** updated contact's key **")
+
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(path, []byte("secret notes"), 0600); err != nil {
+			t.Fatal("\tShould be able to write the file.", "X", err)
+		}
+
+		id, err := alice.App().OfferFile(bob.ID, path)
+		if err != nil {
+			t.Fatal("\tShould be able to offer the file.", "X", err)
+		}
+		t.Log("\tShould be able to offer the file.", "OK")
+
+		if code, _ := transferToken(t, cap1.URL, uuid.New(), bob.Token()); code != http.StatusNotFound {
+			t.Fatalf("\tShould not issue a token for an unknown transfer, got %d. %s", code, "X")
+		}
+		t.Log("\tShould not issue a token for an unknown transfer.", "OK")
+
+		if code, _ := transferToken(t, cap1.URL, id, mallory.Token()); code != http.StatusForbidden {
+			t.Fatalf("\tShould not issue a token to another user, got %d. %s", code, "X")
+		}
+		t.Log("\tShould not issue a token to another user.", "OK")
+
+		code, tkn := transferToken(t, cap1.URL, id, bob.Token())
+		if code != http.StatusOK {
+			t.Fatalf("\tShould issue a token to the recipient, got %d. %s", code, "X")
+		}
+		t.Log("\tShould issue a token to the recipient.", "OK")
+
+		routes := []struct {
+			method string
+			path   string
+		}{
+			{http.MethodGet, "/state"},
+			{http.MethodPost, "/tcpconnectdrop"},
+			{http.MethodPost, "/rotations"},
+			{http.MethodGet, "/nonces"},
+		}
+
+		for _, rt := range routes {
+			if code := do(t, rt.method, cap1.URL+rt.path, tkn, "{}"); code != http.StatusForbidden {
+				t.Fatalf("\tShould not accept the transfer token for %s, got %d. %s", rt.path, code, "X")
+			}
+		}
+		t.Log("\tShould not accept the transfer token for the user routes.", "OK")
+	}
+}
+
+// TestTransferLimits provides a test of the CAP only taking the chunks the
+// sender said it would upload, and none once the transfer is complete.
+func TestTransferLimits(t *testing.T) {
+	t.Log("Given the need to only store the chunks of a transfer.")
+	{
+		net := captest.New(t)
+		cap1 := net.StartCAP()
+
+		alice := net.Connect(cap1, "alice")
+		bob := net.Connect(cap1, "bob")
+
+		alice.AddContact(bob)
+		bob.AddContact(alice)
+
+		bob.ShareKey(alice)
+		alice.WaitForMessage(bob, "** updated contact's key **")
+
+		if code := do(t, http.MethodPost, fmt.Sprintf("%s/transfers/%s", cap1.URL, uuid.New()), alice.Token(), `{"chunks":1,"chunkSize":2000000}`); code != http.StatusBadRequest {
+			t.Fatalf("\tShould not create a transfer with chunks larger than the CAP takes, got %d. %s", code, "X")
+		}
+		t.Log("\tShould not create a transfer with chunks larger than the CAP takes.", "OK")
+
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		if err := os.WriteFile(path, []byte("secret notes"), 0600); err != nil {
+			t.Fatal("\tShould be able to write the file.", "X", err)
+		}
+
+		id, err := alice.App().OfferFile(bob.ID, path)
+		if err != nil {
+			t.Fatal("\tShould be able to offer the file.", "X", err)
+		}
+
+		code, tkn := transferToken(t, cap1.URL, id, bob.Token())
+		if code != http.StatusOK {
+			t.Fatalf("\tShould issue a token to the recipient, got %d. %s", code, "X")
+		}
+
+		if code := upload(t, cap1.URL, id, 1, []byte("chunk"), tkn); code != http.StatusBadRequest {
+			t.Fatalf("\tShould not take a chunk past the end of the file, got %d. %s", code, "X")
+		}
+		t.Log("\tShould not take a chunk past the end of the file.", "OK")
+
+		if code := upload(t, cap1.URL, id, 0, make([]byte, 256*1024+17), tkn); code != http.StatusBadRequest {
+			t.Fatalf("\tShould not take a chunk larger than the sender said, got %d. %s", code, "X")
+		}
+		t.Log("\tShould not take a chunk larger than the sender said.", "OK")
+
+		if code := upload(t, cap1.URL, id, 0, []byte("chunk"), tkn); code != http.StatusNoContent && code != http.StatusOK {
+			t.Fatalf("\tShould take the file's chunk, got %d. %s", code, "X")
+		}
+		t.Log("\tShould take the file's chunk.", "OK")
+
+		if code := do(t, http.MethodDelete, fmt.Sprintf("%s/transfers/%s", cap1.URL, id), tkn, ""); code != http.StatusNoContent && code != http.StatusOK {
+			t.Fatalf("\tShould complete the transfer, got %d. %s", code, "X")
+		}
+
+		if code := upload(t, cap1.URL, id, 0, []byte("chunk"), tkn); code != http.StatusNotFound {
+			t.Fatalf("\tShould not take a chunk once the transfer is complete, got %d. %s", code, "X")
+		}
+		t.Log("\tShould not take a chunk once the transfer is complete.", "OK")
+	}
+}
+
+func transferToken(t *testing.T, url string, id uuid.UUID, token string) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/transfers/%s/token", url, id), nil)
+	if err != nil {
+		t.Fatal("\tShould be able to create the request.", "X", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("\tShould be able to request a token.", "X", err)
+	}
+	defer resp.Body.Close()
+
+	var tkn struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&tkn)
+
+	return resp.StatusCode, tkn.Token
+}
+
+func do(t *testing.T, method string, url string, token string, body string) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal("\tShould be able to create the request.", "X", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("\tShould be able to make the request.", "X", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func upload(t *testing.T, url string, id uuid.UUID, idx int, data []byte, token string) int {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	fw, err := w.CreateFormFile("chunk", fmt.Sprint(idx))
+	if err != nil {
+		t.Fatal("\tShould be able to create the form.", "X", err)
+	}
+	fw.Write(data)
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/transfers/%s/chunks/%d", url, id, idx), &body)
+	if err != nil {
+		t.Fatal("\tShould be able to create the request.", "X", err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("\tShould be able to upload the chunk.", "X", err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
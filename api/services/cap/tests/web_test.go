@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/api/services/cap/captest"
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/signature"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/websocket"
+)
+
+// TestWebLogin provides a test of the login the browser client uses and of
+// passing the token as a websocket subprotocol.
+func TestWebLogin(t *testing.T) {
+	t.Log("Given the need to log in from a browser and connect with the token.")
+	{
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal("\tShould be able to generate a key.", "X", err)
+		}
+		id := crypto.PubkeyToAddress(key.PublicKey)
+
+		net := captest.New(t)
+		net.AllowLogin(id)
+		cap1 := net.StartCAP()
+
+		stranger, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal("\tShould be able to generate a key.", "X", err)
+		}
+
+		resp := webLogin(t, cap1.URL, crypto.PubkeyToAddress(stranger.PublicKey), time.Now().Unix(), stranger)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("\tShould reject a login for an account not allowed, got %d. %s", resp.StatusCode, "X")
+		}
+		t.Log("\tShould reject a login for an account not allowed.", "OK")
+
+		resp = webLogin(t, cap1.URL, id, time.Now().Add(-5*time.Minute).Unix(), key)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("\tShould reject an expired login, got %d. %s", resp.StatusCode, "X")
+		}
+		t.Log("\tShould reject an expired login.", "OK")
+
+		other := common.HexToAddress("0x01")
+		resp = webLogin(t, cap1.URL, other, time.Now().Unix(), key)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("\tShould reject a login for another id, got %d. %s", resp.StatusCode, "X")
+		}
+		t.Log("\tShould reject a login for another id.", "OK")
+
+		resp = webLogin(t, cap1.URL, id, time.Now().Unix(), key)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("\tShould log in, got %d. %s", resp.StatusCode, "X")
+		}
+		t.Log("\tShould log in.", "OK")
+
+		var login struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+			t.Fatal("\tShould be able to decode the token.", "X", err)
+		}
+
+		dialer := websocket.Dialer{
+			Subprotocols: []string{chatbus.UIProtocol, login.Token},
+		}
+
+		url := "ws" + strings.TrimPrefix(cap1.URL, "http") + "/connect"
+
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal("\tShould connect with the token as a subprotocol.", "X", err)
+		}
+		defer conn.Close()
+		t.Log("\tShould connect with the token as a subprotocol.", "OK")
+
+		if conn.Subprotocol() != chatbus.UIProtocol {
+			t.Fatalf("\tShould select the %q subprotocol, got %q. %s", chatbus.UIProtocol, conn.Subprotocol(), "X")
+		}
+		t.Log("\tShould select the subprotocol.", "OK")
+
+		_, msg, err := conn.ReadMessage()
+		if err != nil || !strings.HasPrefix(string(msg), "HELLO ") {
+			t.Fatalf("\tShould receive a HELLO, got %q: %v. %s", msg, err, "X")
+		}
+		t.Log("\tShould receive a HELLO.", "OK")
+	}
+}
+
+func webLogin(t *testing.T, url string, id common.Address, issued int64, key *ecdsa.PrivateKey) *http.Response {
+	dataToSign := struct {
+		ID     common.Address
+		Issued int64
+	}{
+		ID:     id,
+		Issued: issued,
+	}
+
+	v, r, s, err := signature.Sign(dataToSign, key)
+	if err != nil {
+		t.Fatal("\tShould be able to sign the login.", "X", err)
+	}
+
+	req := struct {
+		ID     common.Address `json:"id"`
+		Issued int64          `json:"issued"`
+		V      *big.Int       `json:"v"`
+		R      *big.Int       `json:"r"`
+		S      *big.Int       `json:"s"`
+	}{
+		ID:     id,
+		Issued: issued,
+		V:      v,
+		R:      r,
+		S:      s,
+	}
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(req); err != nil {
+		t.Fatal("\tShould be able to encode the login.", "X", err)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/login", url), "application/json", &b)
+	if err != nil {
+		t.Fatal("\tShould be able to post the login.", "X", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
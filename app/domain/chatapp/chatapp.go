@@ -5,30 +5,47 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"time"
 
+	"github.com/ardanlabs/usdl/app/sdk/auth"
 	"github.com/ardanlabs/usdl/app/sdk/errs"
 	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
 	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ardanlabs/usdl/foundation/rotation"
 	"github.com/ardanlabs/usdl/foundation/web"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 type app struct {
-	log        *logger.Logger
-	chat       *chatbus.Business
-	serverAddr string
+	log              *logger.Logger
+	chat             *chatbus.Business
+	transfer         *transferbus.Business
+	serverAddr       string
+	auth             *auth.Auth
+	activeKID        string
+	transferTokenTTL time.Duration
 }
 
-func newApp(log *logger.Logger, chat *chatbus.Business, serverAddr string) *app {
+func newApp(cfg Config) *app {
 	return &app{
-		log:        log,
-		chat:       chat,
-		serverAddr: serverAddr,
+		log:              cfg.Log,
+		chat:             cfg.ChatBus,
+		transfer:         cfg.TransferBus,
+		serverAddr:       cfg.ServerAddr,
+		auth:             cfg.Auth,
+		activeKID:        cfg.ActiveKID,
+		transferTokenTTL: cfg.TransferTokenTTL,
 	}
 }
 
 func (a *app) connect(ctx context.Context, r *http.Request) web.Encoder {
-	usr, err := a.chat.UIHandshake(ctx, web.GetWriter(ctx), r)
+	userID, errU := requestUser(ctx)
+	if errU != nil {
+		return errU
+	}
+
+	usr, err := a.chat.UIHandshake(ctx, web.GetWriter(ctx), r, userID)
 	if err != nil {
 		return errs.Newf(errs.FailedPrecondition, "handshake failed: %s", err)
 	}
@@ -49,6 +66,52 @@ func (a *app) state(ctx context.Context, r *http.Request) web.Encoder {
 	}
 }
 
+// rotate forwards messages for the user's old ID to the new ID named in the
+// rotation statement, which both keys signed.
+func (a *app) rotate(ctx context.Context, r *http.Request) web.Encoder {
+	userID, errU := requestUser(ctx)
+	if errU != nil {
+		return errU
+	}
+
+	var req rotationRequest
+	if err := web.Decode(r, &req); err != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid request: %s", err)
+	}
+
+	if err := a.chat.Rotate(ctx, userID, rotation.Statement(req)); err != nil {
+		switch {
+		case errors.Is(err, chatbus.ErrIdentityMismatch):
+			return errs.New(errs.PermissionDenied, err)
+		case errors.Is(err, chatbus.ErrRotationExists):
+			return errs.New(errs.AlreadyExists, err)
+		case errors.Is(err, rotation.ErrInvalid):
+			return errs.New(errs.InvalidArgument, err)
+		}
+		return errs.Newf(errs.Internal, "rotate: %s", err)
+	}
+
+	return nil
+}
+
+// nonces returns the last nonce the CAPs accepted from the user for each
+// recipient, for a client that restored its keys without its storage.
+func (a *app) nonces(ctx context.Context, r *http.Request) web.Encoder {
+	userID, errU := requestUser(ctx)
+	if errU != nil {
+		return errU
+	}
+
+	nonces, err := a.chat.LastNonces(ctx, userID)
+	if err != nil {
+		return errs.Newf(errs.Internal, "last nonces: %s", err)
+	}
+
+	return noncesResponse{
+		Nonces: nonces,
+	}
+}
+
 func (a *app) tcpConnectDrop(ctx context.Context, r *http.Request) web.Encoder {
 	var tcpConnReq tcpConnRequest
 	if err := web.Decode(r, &tcpConnReq); err != nil {
@@ -71,3 +134,19 @@ func (a *app) tcpConnectDrop(ctx context.Context, r *http.Request) web.Encoder {
 
 	return tcpConnDropResponse{Connected: true, Message: "tcp connection established"}
 }
+
+// =============================================================================
+
+// userOnly rejects tokens that weren't issued to a user, like the transfer
+// tokens that are only good for the chunks of one transfer.
+func userOnly(next web.HandlerFunc) web.HandlerFunc {
+	h := func(ctx context.Context, r *http.Request) web.Encoder {
+		if _, err := requestUser(ctx); err != nil {
+			return err
+		}
+
+		return next(ctx, r)
+	}
+
+	return h
+}
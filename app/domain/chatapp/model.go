@@ -2,7 +2,9 @@ package chatapp
 
 import (
 	"encoding/json"
+	"time"
 
+	"github.com/ardanlabs/usdl/foundation/rotation"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -27,6 +29,13 @@ func (app tcpConnDropResponse) Encode() ([]byte, string, error) {
 	return data, "application/json", err
 }
 
+type rotationRequest rotation.Statement
+
+// Decode implements the decoder interface.
+func (app *rotationRequest) Decode(data []byte) error {
+	return json.Unmarshal(data, app)
+}
+
 type stateResponse struct {
 	TCPConnections []common.Address `json:"tcp_connections"`
 }
@@ -35,3 +44,48 @@ func (app stateResponse) Encode() ([]byte, string, error) {
 	data, err := json.Marshal(app)
 	return data, "application/json", err
 }
+
+type noncesResponse struct {
+	Nonces map[common.Address]uint64 `json:"nonces"`
+}
+
+func (app noncesResponse) Encode() ([]byte, string, error) {
+	data, err := json.Marshal(app)
+	return data, "application/json", err
+}
+
+type transferCreateRequest struct {
+	Recipient common.Address `json:"recipient"`
+	Chunks    int            `json:"chunks"`
+	ChunkSize int            `json:"chunkSize"`
+}
+
+// Decode implements the decoder interface.
+func (app *transferCreateRequest) Decode(data []byte) error {
+	return json.Unmarshal(data, app)
+}
+
+type transferTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (app transferTokenResponse) Encode() ([]byte, string, error) {
+	data, err := json.Marshal(app)
+	return data, "application/json", err
+}
+
+type transferStatusResponse struct {
+	Chunks []int `json:"chunks"`
+}
+
+func (app transferStatusResponse) Encode() ([]byte, string, error) {
+	data, err := json.Marshal(app)
+	return data, "application/json", err
+}
+
+type chunkResponse []byte
+
+func (app chunkResponse) Encode() ([]byte, string, error) {
+	return app, "application/octet-stream", nil
+}
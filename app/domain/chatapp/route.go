@@ -2,21 +2,43 @@ package chatapp
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/ardanlabs/usdl/app/sdk/auth"
 	"github.com/ardanlabs/usdl/app/sdk/mid"
 	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
 	"github.com/ardanlabs/usdl/foundation/logger"
 	"github.com/ardanlabs/usdl/foundation/web"
 )
 
+// Config contains all the mandatory systems required by handlers.
+type Config struct {
+	Log              *logger.Logger
+	ChatBus          *chatbus.Business
+	TransferBus      *transferbus.Business
+	ServerAddr       string
+	Auth             *auth.Auth
+	ActiveKID        string
+	TransferTokenTTL time.Duration
+}
+
 // Routes adds specific routes for this group.
-func Routes(app *web.App, log *logger.Logger, chatBus *chatbus.Business, serverAddr string, auth *auth.Auth) {
-	bearer := mid.Bearer(auth)
+func Routes(app *web.App, cfg Config) {
+	bearer := mid.Bearer(cfg.Auth)
+
+	api := newApp(cfg)
 
-	api := newApp(log, chatBus, serverAddr)
+	app.HandlerFunc(http.MethodGet, "", "/connect", api.connect, mid.BearerWebSocket(cfg.Auth, chatbus.UIProtocol))
+	app.HandlerFunc(http.MethodGet, "", "/state", api.state, bearer, userOnly)
+	app.HandlerFunc(http.MethodPost, "", "/tcpconnectdrop", api.tcpConnectDrop, bearer, userOnly)
+	app.HandlerFunc(http.MethodPost, "", "/rotations", api.rotate, bearer, userOnly)
+	app.HandlerFunc(http.MethodGet, "", "/nonces", api.nonces, bearer, userOnly)
 
-	app.HandlerFunc(http.MethodGet, "", "/connect", api.connect, bearer)
-	app.HandlerFunc(http.MethodGet, "", "/state", api.state, bearer)
-	app.HandlerFunc(http.MethodPost, "", "/tcpconnectdrop", api.tcpConnectDrop, bearer)
+	app.HandlerFunc(http.MethodPost, "", "/transfers/{id}", api.transferCreate, bearer)
+	app.HandlerFunc(http.MethodPost, "", "/transfers/{id}/token", api.transferToken, bearer)
+	app.HandlerFunc(http.MethodGet, "", "/transfers/{id}", api.transferStatus, bearer)
+	app.HandlerFunc(http.MethodDelete, "", "/transfers/{id}", api.transferComplete, bearer)
+	app.HandlerFunc(http.MethodPost, "", "/transfers/{id}/chunks/{idx}", api.transferUpload, bearer)
+	app.HandlerFunc(http.MethodGet, "", "/transfers/{id}/chunks/{idx}", api.transferDownload, bearer)
 }
@@ -0,0 +1,257 @@
+package chatapp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/app/sdk/errs"
+	"github.com/ardanlabs/usdl/app/sdk/mid"
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
+	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// transferSubject prefixes the subject of a transfer token so it can't be
+// confused with a user token.
+const transferSubject = "transfer:"
+
+// transferCreate is called by the sender before the file is offered. It
+// records who the transfer is between so only they can get a token for it,
+// and how many chunks of what size the sender will upload.
+func (a *app) transferCreate(ctx context.Context, r *http.Request) web.Encoder {
+	userID, errU := requestUser(ctx)
+	if errU != nil {
+		return errU
+	}
+
+	transferID, err := uuid.Parse(web.Param(r, "id"))
+	if err != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid transfer id: %s", err)
+	}
+
+	var req transferCreateRequest
+	if err := web.Decode(r, &req); err != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid request: %s", err)
+	}
+
+	parties := transferbus.Parties{
+		Sender:    userID,
+		Recipient: req.Recipient,
+		Chunks:    req.Chunks,
+		ChunkSize: req.ChunkSize,
+	}
+
+	if err := a.transfer.Create(ctx, transferID, parties); err != nil {
+		switch {
+		case errors.Is(err, transferbus.ErrExists):
+			return errs.New(errs.AlreadyExists, err)
+		case errors.Is(err, transferbus.ErrInvalidSize):
+			return errs.New(errs.InvalidArgument, err)
+		}
+		return errs.Newf(errs.Internal, "create: %s", err)
+	}
+
+	return nil
+}
+
+// transferToken is called by the recipient once the transfer is accepted.
+// The token is only good for the chunks of this one transfer and is handed
+// to the sender inside a signed chat message. Only the parties recorded
+// when the transfer was created can get one.
+func (a *app) transferToken(ctx context.Context, r *http.Request) web.Encoder {
+	userID, errU := requestUser(ctx)
+	if errU != nil {
+		return errU
+	}
+
+	transferID, err := uuid.Parse(web.Param(r, "id"))
+	if err != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid transfer id: %s", err)
+	}
+
+	parties, err := a.transfer.Parties(ctx, transferID)
+	if err != nil {
+		if errors.Is(err, transferbus.ErrNotExists) {
+			return errs.New(errs.NotFound, err)
+		}
+		return errs.Newf(errs.Internal, "parties: %s", err)
+	}
+
+	if !parties.Has(userID) {
+		return errs.Newf(errs.PermissionDenied, "not a party to transfer %s", transferID)
+	}
+
+	now := time.Now().UTC()
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   transferSubject + transferID.String(),
+			Issuer:    a.auth.Issuer(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.transferTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	tkn, err := a.auth.GenerateToken(a.activeKID, claims)
+	if err != nil {
+		return errs.Newf(errs.Internal, "generate token: %s", err)
+	}
+
+	a.log.Info(ctx, "transfer-token", "transferID", transferID, "recipient", userID)
+
+	return transferTokenResponse{
+		Token:     tkn,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+}
+
+func (a *app) transferStatus(ctx context.Context, r *http.Request) web.Encoder {
+	transferID, err := a.transferAuthorize(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	chunks, errC := a.transfer.Chunks(ctx, transferID)
+	if errC != nil {
+		if errors.Is(errC, transferbus.ErrNotExists) {
+			return errs.New(errs.NotFound, errC)
+		}
+		return errs.Newf(errs.Internal, "chunks: %s", errC)
+	}
+
+	return transferStatusResponse{
+		Chunks: chunks,
+	}
+}
+
+func (a *app) transferComplete(ctx context.Context, r *http.Request) web.Encoder {
+	transferID, err := a.transferAuthorize(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	if err := a.transfer.Complete(ctx, transferID); err != nil {
+		if errors.Is(err, transferbus.ErrNotExists) {
+			return errs.New(errs.NotFound, err)
+		}
+		return errs.Newf(errs.Internal, "complete: %s", err)
+	}
+
+	return nil
+}
+
+func (a *app) transferUpload(ctx context.Context, r *http.Request) web.Encoder {
+	transferID, err := a.transferAuthorize(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	idx, errP := strconv.Atoi(web.Param(r, "idx"))
+	if errP != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid chunk index: %s", errP)
+	}
+
+	// Leave room for the multipart headers around the chunk.
+	maxSize := int64(a.transfer.MaxChunkSize())
+	r.Body = http.MaxBytesReader(web.GetWriter(ctx), r.Body, maxSize+4096)
+
+	if err := r.ParseMultipartForm(maxSize); err != nil {
+		return errs.Newf(errs.InvalidArgument, "parse form: %s", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	f, _, errF := r.FormFile("chunk")
+	if errF != nil {
+		return errs.Newf(errs.InvalidArgument, "chunk: %s", errF)
+	}
+	defer f.Close()
+
+	data, errR := io.ReadAll(f)
+	if errR != nil {
+		return errs.Newf(errs.InvalidArgument, "read chunk: %s", errR)
+	}
+
+	if err := a.transfer.UploadChunk(ctx, transferID, idx, data); err != nil {
+		switch {
+		case errors.Is(err, transferbus.ErrNotExists):
+			return errs.New(errs.NotFound, err)
+		case errors.Is(err, transferbus.ErrChunkTooLarge), errors.Is(err, transferbus.ErrInvalidChunk):
+			return errs.New(errs.InvalidArgument, err)
+		}
+		return errs.Newf(errs.Internal, "upload: %s", err)
+	}
+
+	return nil
+}
+
+func (a *app) transferDownload(ctx context.Context, r *http.Request) web.Encoder {
+	transferID, err := a.transferAuthorize(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	idx, errP := strconv.Atoi(web.Param(r, "idx"))
+	if errP != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid chunk index: %s", errP)
+	}
+
+	data, errD := a.transfer.DownloadChunk(ctx, transferID, idx)
+	if errD != nil {
+		switch {
+		case errors.Is(errD, transferbus.ErrChunkNotExists):
+			return errs.New(errs.NotFound, errD)
+		case errors.Is(errD, transferbus.ErrInvalidChunk):
+			return errs.New(errs.InvalidArgument, errD)
+		}
+		return errs.Newf(errs.Internal, "download: %s", errD)
+	}
+
+	return chunkResponse(data)
+}
+
+// requestUser returns the user making the request, which must be a user
+// and not a transfer token.
+func requestUser(ctx context.Context) (common.Address, *errs.Error) {
+	userID, err := mid.GetUserID(ctx)
+	if err != nil {
+		return common.Address{}, errs.New(errs.Unauthenticated, err)
+	}
+
+	if strings.HasPrefix(userID, transferSubject) {
+		return common.Address{}, errs.Newf(errs.PermissionDenied, "a transfer token can't be used for this")
+	}
+
+	if !common.IsHexAddress(userID) {
+		return common.Address{}, errs.Newf(errs.PermissionDenied, "token subject is not a user id")
+	}
+
+	return common.HexToAddress(userID), nil
+}
+
+// transferAuthorize validates the request was made with the transfer token
+// issued for the transfer in the path.
+func (a *app) transferAuthorize(ctx context.Context, r *http.Request) (uuid.UUID, *errs.Error) {
+	transferID, err := uuid.Parse(web.Param(r, "id"))
+	if err != nil {
+		return uuid.UUID{}, errs.Newf(errs.InvalidArgument, "invalid transfer id: %s", err)
+	}
+
+	userID, err := mid.GetUserID(ctx)
+	if err != nil {
+		return uuid.UUID{}, errs.New(errs.Unauthenticated, err)
+	}
+
+	if userID != transferSubject+transferID.String() {
+		return uuid.UUID{}, errs.Newf(errs.PermissionDenied, "token not valid for transfer %s", transferID)
+	}
+
+	return transferID, nil
+}
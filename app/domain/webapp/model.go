@@ -0,0 +1,32 @@
+package webapp
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type loginRequest struct {
+	ID     common.Address `json:"id"`
+	Issued int64          `json:"issued"`
+	V      *big.Int       `json:"v"`
+	R      *big.Int       `json:"r"`
+	S      *big.Int       `json:"s"`
+}
+
+// Decode implements the decoder interface.
+func (app *loginRequest) Decode(data []byte) error {
+	return json.Unmarshal(data, app)
+}
+
+type loginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (app loginResponse) Encode() ([]byte, string, error) {
+	data, err := json.Marshal(app)
+	return data, "application/json", err
+}
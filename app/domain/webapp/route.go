@@ -0,0 +1,32 @@
+package webapp
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Config contains all the mandatory systems required by handlers.
+type Config struct {
+	Log       *logger.Logger
+	Auth      *auth.Auth
+	ActiveKID string
+	TokenTTL  time.Duration
+	Allow     []common.Address
+}
+
+// Routes adds specific routes for this group.
+func Routes(app *web.App, cfg Config) {
+	api := newApp(cfg)
+
+	app.HandlerFunc(http.MethodPost, "", "/login", api.login)
+
+	if err := app.FileServer(static, "static", "/web/"); err != nil {
+		cfg.Log.Error(context.Background(), "webapp: file server", "ERROR", err)
+	}
+}
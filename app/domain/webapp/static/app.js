@@ -0,0 +1,519 @@
+// The browser chat client. It speaks the same /connect protocol as the TUI:
+// HELLO challenge, signed handshake, WELCOME and then signed messages.
+//
+// The browser doesn't take part in the encrypted sessions the TUI uses, so
+// messages are sent in the clear and encrypted messages can't be read.
+
+import { decryptKey, encryptKey, generatePrivateKey, privateKeyToAddress, sign, toChecksumAddress } from "./crypto.js";
+import { Store } from "./store.js";
+
+const zeroAddress = "0x0000000000000000000000000000000000000000";
+const protocol = "usdl";
+const reconnectDelay = 3000;
+
+const $ = (id) => document.getElementById(id);
+
+let store;
+let identity;
+let token;
+let ws;
+let connected = false;
+let current = null;
+
+// The last nonce the CAP accepted from us for each contact, and the sends
+// the CAP hasn't rejected yet by contact and nonce.
+let capNonces = {};
+const sent = new Map();
+
+// =============================================================================
+// Encoding helpers
+
+// The CAP verifies signatures over what json.Marshal produces. Go writes
+// addresses as lower case hex and []byte as base64, and big.Int values are
+// JSON numbers too large for a JavaScript number, so documents with
+// signatures are built by hand.
+
+function lower(address) {
+    return address.toLowerCase();
+}
+
+function toBase64(text) {
+    const bytes = new TextEncoder().encode(text);
+    let bin = "";
+    for (const b of bytes) {
+        bin += String.fromCharCode(b);
+    }
+    return btoa(bin);
+}
+
+function fromBase64(b64) {
+    const bin = atob(b64);
+    const bytes = Uint8Array.from(bin, (c) => c.charCodeAt(0));
+    return new TextDecoder().decode(bytes);
+}
+
+function signed(fields, sig) {
+    const parts = Object.entries(fields).map(([k, v]) => `${JSON.stringify(k)}:${JSON.stringify(v)}`);
+    parts.push(`"v":${sig.v}`, `"r":${sig.r}`, `"s":${sig.s}`);
+    return `{${parts.join(",")}}`;
+}
+
+// =============================================================================
+// Setup
+
+// saveIdentity stores the identity with the private key encrypted by the
+// passphrase, the same keyfile the TUI writes.
+async function saveIdentity(privateKey, name, passphrase) {
+    if (passphrase === "") {
+        throw new Error("a passphrase is required");
+    }
+
+    privateKey = privateKey.trim().replace(/^0x/, "");
+    const address = privateKeyToAddress(privateKey);
+
+    await store.saveIdentity({
+        address: address,
+        name: name,
+        keyfile: await encryptKey(privateKey, passphrase),
+    });
+
+    identity = { privateKey: privateKey, address: address, name: name };
+}
+
+async function createIdentity(privateKey) {
+    const name = $("setup-name").value.trim() || "Anonymous";
+
+    await saveIdentity(privateKey, name, $("setup-passphrase").value);
+    start();
+}
+
+function showSetup() {
+    $("setup").hidden = false;
+
+    $("setup-generate").onclick = async () => {
+        try {
+            await createIdentity(generatePrivateKey());
+        } catch (err) {
+            $("setup-error").textContent = err.message;
+        }
+    };
+
+    $("setup-import").onclick = async () => {
+        try {
+            await createIdentity($("setup-key").value);
+        } catch (err) {
+            $("setup-error").textContent = `invalid key: ${err.message}`;
+        }
+    };
+}
+
+// showUnlock asks for the passphrase that decrypts the stored key. A key
+// stored in the clear by an older version is encrypted with the passphrase
+// the user picks now.
+function showUnlock(stored) {
+    $("unlock").hidden = false;
+
+    if (stored.keyfile === undefined) {
+        $("unlock-text").textContent = "Your key is stored unencrypted. Choose a passphrase to encrypt it.";
+    }
+
+    $("unlock-submit").onclick = async () => {
+        const passphrase = $("unlock-passphrase").value;
+
+        try {
+            if (stored.keyfile === undefined) {
+                await saveIdentity(stored.privateKey, stored.name, passphrase);
+            } else {
+                identity = {
+                    privateKey: await decryptKey(stored.keyfile, passphrase),
+                    address: stored.address,
+                    name: stored.name,
+                };
+            }
+        } catch (err) {
+            $("unlock-error").textContent = err.message;
+            return;
+        }
+
+        $("unlock-passphrase").value = "";
+        $("unlock").hidden = true;
+        start();
+    };
+}
+
+// =============================================================================
+// Connection
+
+async function login() {
+    const issued = Math.floor(Date.now() / 1000);
+    const sig = await sign(JSON.stringify({ ID: lower(identity.address), Issued: issued }), identity.privateKey);
+
+    const resp = await fetch("/login", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: signed({ id: lower(identity.address), issued: issued }, sig),
+    });
+
+    if (!resp.ok) {
+        throw new Error(`login: ${resp.status} ${await resp.text()}`);
+    }
+
+    return (await resp.json()).token;
+}
+
+function setStatus(text, ok) {
+    connected = ok;
+
+    $("status").textContent = text;
+    $("status").classList.toggle("connected", ok);
+
+    updateSend();
+}
+
+function updateSend() {
+    const disabled = !connected || current === null;
+
+    $("send-text").disabled = disabled;
+    $("send").querySelector("button").disabled = disabled;
+}
+
+// syncNonces fetches the last nonces the CAP accepted from us, which are
+// used for contacts whose stored nonce is behind, like after the browser's
+// storage was cleared.
+async function syncNonces() {
+    const resp = await fetch("/nonces", {
+        headers: { "Authorization": `Bearer ${token}` },
+    });
+
+    if (!resp.ok) {
+        throw new Error(`${resp.status} ${await resp.text()}`);
+    }
+
+    const nonces = (await resp.json()).nonces || {};
+    capNonces = Object.fromEntries(Object.entries(nonces).map(([id, nonce]) => [lower(id), nonce]));
+}
+
+async function connect() {
+    try {
+        token = await login();
+    } catch (err) {
+        setStatus(err.message, false);
+        setTimeout(connect, reconnectDelay);
+        return;
+    }
+
+    try {
+        await syncNonces();
+    } catch (err) {
+        system(`sync nonces: ${err.message}`);
+    }
+
+    // A rejection can only come back on the connection the message was
+    // sent on.
+    sent.clear();
+
+    const scheme = location.protocol === "https:" ? "wss" : "ws";
+    ws = new WebSocket(`${scheme}://${location.host}/connect`, [protocol, token]);
+
+    let welcomed = false;
+
+    ws.onmessage = (event) => {
+        const data = event.data;
+
+        if (data.startsWith("HELLO ")) {
+            const challenge = data.slice(6);
+            sign(JSON.stringify({ ID: lower(identity.address), Challenge: challenge }), identity.privateKey)
+                .then((sig) => ws.send(signed({ id: lower(identity.address), name: identity.name }, sig)))
+                .catch((err) => setStatus(`handshake: ${err.message}`, false));
+            return;
+        }
+
+        if (!welcomed) {
+            if (data.startsWith("WELCOME")) {
+                welcomed = true;
+                setStatus("connected", true);
+                return;
+            }
+
+            setStatus(data, false);
+            return;
+        }
+
+        receive(JSON.parse(data)).catch((err) => system(`receive: ${err.message}`));
+    };
+
+    ws.onclose = () => {
+        setStatus("disconnected, retrying", false);
+        setTimeout(connect, reconnectDelay);
+    };
+}
+
+// =============================================================================
+// Receiving
+
+async function receive(inMsg) {
+    const msgs = (inMsg.msg || []).map(fromBase64);
+    const fromID = lower(inMsg.from.id);
+
+    if (fromID === zeroAddress && msgs[0] === "EVENT") {
+        event(msgs);
+        return;
+    }
+
+    let contact = await store.contact(fromID);
+    if (contact === null) {
+        contact = { id: fromID, name: inMsg.from.name, appLastNonce: 0, lastNonce: 0 };
+    }
+
+    // The CAP rejects replays, so a stale nonce means the message is dropped.
+    if (inMsg.from.nonce <= contact.lastNonce) {
+        system(`invalid nonce from ${contact.name}: got: ${inMsg.from.nonce}, exp: ${contact.lastNonce + 1}`);
+        return;
+    }
+
+    contact.lastNonce = inMsg.from.nonce;
+    await store.saveContact(contact);
+
+    let text = msgs.join("");
+
+    switch (true) {
+    case inMsg.encrypted:
+        text = "** encrypted message, the browser client can't read it **";
+        break;
+
+    case text.startsWith("/key "):
+        text = "** contact shared a key, the browser client can't use it **";
+        break;
+
+    case text.startsWith("/"):
+        text = `** unsupported command: ${text.split(" ")[0]} **`;
+        break;
+    }
+
+    const conversation = inMsg.group && lower(inMsg.group) !== zeroAddress ? lower(inMsg.group) : fromID;
+
+    const msg = {
+        contact: conversation,
+        from: fromID,
+        name: contact.name,
+        text: text,
+        date: new Date().toISOString(),
+    };
+
+    await store.addMessage(msg);
+
+    await renderContacts(conversation);
+    if (current === conversation) {
+        renderMessage(msg);
+    }
+}
+
+function event(msgs) {
+    switch (msgs[1]) {
+    case "MAILBOX":
+        system(`${msgs[2]} queued message(s) delivered while you were offline`);
+        break;
+
+    case "NONCE-ERROR":
+        rejected(lower(msgs[2] || ""), Number(msgs[4]))
+            .catch((err) => system(`rejected: ${err.message}`))
+            .finally(() => system(`message to ${msgs[2]} rejected: ${msgs[3] || ""}`));
+        break;
+
+    case "GROUP-ERROR":
+        system(`group ${msgs[2]}: ${msgs[3] || ""}`);
+        break;
+
+    default:
+        system(msgs.slice(1).join(" "));
+    }
+}
+
+// =============================================================================
+// Sending
+
+async function send(text) {
+    const contact = await store.contact(current);
+    const nonce = Math.max(contact.appLastNonce, capNonces[contact.id] || 0) + 1;
+    const msg = [toBase64(text)];
+
+    const sig = await sign(JSON.stringify({ ToID: contact.id, Msg: msg, FromNonce: nonce }), identity.privateKey);
+
+    ws.send(signed({ toID: contact.id, encrypted: false, msg: msg, fromNonce: nonce }, sig));
+
+    contact.appLastNonce = nonce;
+    await store.saveContact(contact);
+
+    const out = {
+        contact: contact.id,
+        from: lower(identity.address),
+        name: "You",
+        text: text,
+        date: new Date().toISOString(),
+    };
+
+    sent.set(`${contact.id}:${nonce}`, await store.addMessage(out));
+    renderMessage(out);
+}
+
+// rejected undoes a send the CAP refused. The message is removed from the
+// history and the contact's nonce goes back, unless a later message was
+// sent since. A stale nonce means ours is behind the CAP's, so the CAP's
+// nonces are fetched again.
+async function rejected(toID, nonce) {
+    const key = `${toID}:${nonce}`;
+
+    const seq = sent.get(key);
+    if (seq === undefined) {
+        return;
+    }
+    sent.delete(key);
+
+    await store.removeMessage(seq);
+
+    const contact = await store.contact(toID);
+    if (contact !== null && contact.appLastNonce === nonce) {
+        contact.appLastNonce = nonce - 1;
+        await store.saveContact(contact);
+    }
+
+    await syncNonces();
+
+    if (current === toID) {
+        await selectContact(toID);
+    }
+}
+
+// =============================================================================
+// Rendering
+
+function system(text) {
+    const li = document.createElement("li");
+    li.className = "system";
+    li.textContent = text;
+    $("messages").append(li);
+    li.scrollIntoView();
+}
+
+function renderMessage(msg) {
+    const li = document.createElement("li");
+
+    const from = document.createElement("span");
+    from.className = "from";
+    from.textContent = msg.name;
+
+    const date = document.createElement("span");
+    date.className = "date";
+    date.textContent = new Date(msg.date).toLocaleTimeString();
+
+    li.append(from, msg.text, date);
+    $("messages").append(li);
+    li.scrollIntoView();
+}
+
+async function renderContacts(unread) {
+    const ul = $("contacts");
+    ul.replaceChildren();
+
+    for (const contact of await store.contacts()) {
+        const li = document.createElement("li");
+        li.classList.toggle("selected", contact.id === current);
+        li.classList.toggle("unread", contact.id === unread && contact.id !== current);
+
+        const name = document.createElement("div");
+        name.className = "name";
+        name.textContent = contact.name;
+
+        const address = document.createElement("div");
+        address.className = "address";
+        address.textContent = toChecksumAddress(contact.id);
+
+        li.append(name, address);
+        li.onclick = () => selectContact(contact.id);
+        ul.append(li);
+    }
+}
+
+async function selectContact(id) {
+    current = id;
+
+    const contact = await store.contact(id);
+    $("conversation-title").textContent = `${contact.name} ${toChecksumAddress(id)}`;
+
+    $("messages").replaceChildren();
+    for (const msg of await store.messages(id)) {
+        renderMessage(msg);
+    }
+
+    updateSend();
+    await renderContacts();
+}
+
+// =============================================================================
+
+function start() {
+    $("setup").hidden = true;
+    $("chat").hidden = false;
+
+    $("me-name").textContent = identity.name;
+    $("me-address").textContent = identity.address;
+
+    $("export-key").onclick = () => {
+        prompt("Your private key. Anyone with it can read and send as you.", identity.privateKey);
+    };
+
+    $("add-contact").onsubmit = async (e) => {
+        e.preventDefault();
+
+        const address = $("contact-address").value.trim();
+        const name = $("contact-name").value.trim();
+
+        if (!/^0x[0-9a-fA-F]{40}$/.test(address) || name === "") {
+            system("a contact needs a 0x address and a name");
+            return;
+        }
+
+        const id = lower(address);
+        const contact = (await store.contact(id)) || { id: id, appLastNonce: 0, lastNonce: 0 };
+        contact.name = name;
+        await store.saveContact(contact);
+
+        $("contact-address").value = "";
+        $("contact-name").value = "";
+        await renderContacts();
+    };
+
+    $("send").onsubmit = async (e) => {
+        e.preventDefault();
+
+        const text = $("send-text").value;
+        if (text === "" || current === null) {
+            return;
+        }
+
+        if (text.startsWith("/")) {
+            system("commands are not supported in the browser client");
+            return;
+        }
+
+        $("send-text").value = "";
+        await send(text);
+    };
+
+    renderContacts();
+    connect();
+}
+
+async function main() {
+    store = await Store.open();
+
+    const stored = await store.identity();
+    if (stored === null) {
+        showSetup();
+        return;
+    }
+
+    showUnlock(stored);
+}
+
+main();
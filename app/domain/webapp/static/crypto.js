@@ -0,0 +1,454 @@
+// Package crypto provides the keccak256 hashing and secp256k1 signing the
+// browser needs to produce the same signatures as foundation/signature, and
+// the keyfile encryption foundation/keyfile uses for the key at rest.
+// webapp_test.go checks the signatures against Go with the vectors in
+// testdata and with fresh random keys and messages on every run.
+
+// =============================================================================
+// Keccak256
+
+const keccakRC = [
+    0x0000000000000001n, 0x0000000000008082n, 0x800000000000808an, 0x8000000080008000n,
+    0x000000000000808bn, 0x0000000080000001n, 0x8000000080008081n, 0x8000000000008009n,
+    0x000000000000008an, 0x0000000000000088n, 0x0000000080008009n, 0x000000008000000an,
+    0x000000008000808bn, 0x800000000000008bn, 0x8000000000008089n, 0x8000000000008003n,
+    0x8000000000008002n, 0x8000000000000080n, 0x000000000000800an, 0x800000008000000an,
+    0x8000000080008081n, 0x8000000000008080n, 0x0000000080000001n, 0x8000000080008008n,
+];
+
+const keccakRot = [
+    0, 1, 62, 28, 27,
+    36, 44, 6, 55, 20,
+    3, 10, 43, 25, 39,
+    41, 45, 15, 21, 8,
+    18, 2, 61, 56, 14,
+];
+
+const mask64 = (1n << 64n) - 1n;
+
+function rotl(v, n) {
+    if (n === 0) {
+        return v;
+    }
+    const b = BigInt(n);
+    return ((v << b) | (v >> (64n - b))) & mask64;
+}
+
+function keccakF(s) {
+    const c = new Array(5);
+    const b = new Array(25);
+
+    for (let round = 0; round < 24; round++) {
+        for (let x = 0; x < 5; x++) {
+            c[x] = s[x] ^ s[x + 5] ^ s[x + 10] ^ s[x + 15] ^ s[x + 20];
+        }
+
+        for (let x = 0; x < 5; x++) {
+            const d = c[(x + 4) % 5] ^ rotl(c[(x + 1) % 5], 1);
+            for (let y = 0; y < 25; y += 5) {
+                s[x + y] ^= d;
+            }
+        }
+
+        for (let x = 0; x < 5; x++) {
+            for (let y = 0; y < 5; y++) {
+                b[y + 5 * ((2 * x + 3 * y) % 5)] = rotl(s[x + 5 * y], keccakRot[x + 5 * y]);
+            }
+        }
+
+        for (let y = 0; y < 25; y += 5) {
+            for (let x = 0; x < 5; x++) {
+                s[x + y] = b[x + y] ^ (~b[((x + 1) % 5) + y] & mask64 & b[((x + 2) % 5) + y]);
+            }
+        }
+
+        s[0] ^= keccakRC[round];
+    }
+}
+
+// keccak256 returns the Ethereum flavor of SHA3, which pads with 0x01.
+export function keccak256(...parts) {
+    const rate = 136;
+
+    let length = 0;
+    for (const p of parts) {
+        length += p.length;
+    }
+
+    const padded = new Uint8Array(Math.ceil((length + 1) / rate) * rate);
+
+    let offset = 0;
+    for (const p of parts) {
+        padded.set(p, offset);
+        offset += p.length;
+    }
+    padded[length] ^= 0x01;
+    padded[padded.length - 1] ^= 0x80;
+
+    const s = new Array(25).fill(0n);
+
+    for (let block = 0; block < padded.length; block += rate) {
+        for (let i = 0; i < rate / 8; i++) {
+            let lane = 0n;
+            for (let j = 7; j >= 0; j--) {
+                lane = (lane << 8n) | BigInt(padded[block + i * 8 + j]);
+            }
+            s[i] ^= lane;
+        }
+        keccakF(s);
+    }
+
+    const out = new Uint8Array(32);
+    for (let i = 0; i < 4; i++) {
+        let lane = s[i];
+        for (let j = 0; j < 8; j++) {
+            out[i * 8 + j] = Number(lane & 0xffn);
+            lane >>= 8n;
+        }
+    }
+
+    return out;
+}
+
+// =============================================================================
+// secp256k1
+
+const P = 0xfffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2fn;
+const N = 0xfffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141n;
+const G = {
+    x: 0x79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798n,
+    y: 0x483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8n,
+    z: 1n,
+};
+
+function mod(a, m = P) {
+    const r = a % m;
+    return r >= 0n ? r : r + m;
+}
+
+function invert(a, m = P) {
+    let [oldR, r] = [mod(a, m), m];
+    let [oldS, s] = [1n, 0n];
+
+    while (r !== 0n) {
+        const q = oldR / r;
+        [oldR, r] = [r, oldR - q * r];
+        [oldS, s] = [s, oldS - q * s];
+    }
+
+    return mod(oldS, m);
+}
+
+// Points are kept in jacobian coordinates so only the final conversion needs
+// an inversion. The point at infinity is null.
+
+function double(p) {
+    if (p === null || p.y === 0n) {
+        return null;
+    }
+
+    const a = mod(p.x * p.x);
+    const b = mod(p.y * p.y);
+    const c = mod(b * b);
+    const d = mod(2n * (mod((p.x + b) * (p.x + b)) - a - c));
+    const e = mod(3n * a);
+    const f = mod(e * e);
+
+    const x = mod(f - 2n * d);
+    const y = mod(e * (d - x) - 8n * c);
+    const z = mod(2n * p.y * p.z);
+
+    return { x, y, z };
+}
+
+function add(p, q) {
+    if (p === null) {
+        return q;
+    }
+    if (q === null) {
+        return p;
+    }
+
+    const z1z1 = mod(p.z * p.z);
+    const z2z2 = mod(q.z * q.z);
+    const u1 = mod(p.x * z2z2);
+    const u2 = mod(q.x * z1z1);
+    const s1 = mod(p.y * q.z * z2z2);
+    const s2 = mod(q.y * p.z * z1z1);
+
+    if (u1 === u2) {
+        return s1 === s2 ? double(p) : null;
+    }
+
+    const h = mod(u2 - u1);
+    const r = mod(s2 - s1);
+    const hh = mod(h * h);
+    const hhh = mod(h * hh);
+
+    const x = mod(r * r - hhh - 2n * u1 * hh);
+    const y = mod(r * (u1 * hh - x) - s1 * hhh);
+    const z = mod(h * p.z * q.z);
+
+    return { x, y, z };
+}
+
+// multiply uses a Montgomery ladder so every bit of k costs one add and one
+// double, whatever its value. BigInt arithmetic isn't constant time, so this
+// only removes the branches on the key; it doesn't make the browser a safe
+// place for a key that matters.
+function multiply(k, p = G) {
+    let r0 = null;
+    let r1 = p;
+
+    for (let i = 255n; i >= 0n; i--) {
+        if ((k >> i) & 1n) {
+            r0 = add(r0, r1);
+            r1 = double(r1);
+        } else {
+            r1 = add(r0, r1);
+            r0 = double(r0);
+        }
+    }
+
+    return r0;
+}
+
+function toAffine(p) {
+    const zInv = invert(p.z);
+    const zInv2 = mod(zInv * zInv);
+
+    return {
+        x: mod(p.x * zInv2),
+        y: mod(p.y * zInv2 * zInv),
+    };
+}
+
+// =============================================================================
+// Keys and signatures
+
+export function bytesToHex(bytes) {
+    return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+export function hexToBytes(hex) {
+    hex = hex.trim().replace(/^0x/, "");
+    if (hex.length % 2 !== 0 || !/^[0-9a-fA-F]*$/.test(hex)) {
+        throw new Error("invalid hex");
+    }
+
+    const bytes = new Uint8Array(hex.length / 2);
+    for (let i = 0; i < bytes.length; i++) {
+        bytes[i] = parseInt(hex.substr(i * 2, 2), 16);
+    }
+
+    return bytes;
+}
+
+function bytesToBigInt(bytes) {
+    return bytes.length === 0 ? 0n : BigInt("0x" + bytesToHex(bytes));
+}
+
+function bigIntToBytes(v) {
+    return hexToBytes(v.toString(16).padStart(64, "0"));
+}
+
+function randomScalar() {
+    for (;;) {
+        const k = bytesToBigInt(crypto.getRandomValues(new Uint8Array(32)));
+        if (k > 0n && k < N) {
+            return k;
+        }
+    }
+}
+
+// generatePrivateKey returns a new private key as hex, the same format
+// crypto.SaveECDSA writes to the key.ecdsa file.
+export function generatePrivateKey() {
+    return randomScalar().toString(16).padStart(64, "0");
+}
+
+// privateKeyToAddress returns the checksummed account address for the key.
+export function privateKeyToAddress(privateKeyHex) {
+    const d = bytesToBigInt(hexToBytes(privateKeyHex));
+    if (d <= 0n || d >= N) {
+        throw new Error("invalid private key");
+    }
+
+    const pub = toAffine(multiply(d));
+    const hash = keccak256(bigIntToBytes(pub.x), bigIntToBytes(pub.y));
+
+    return toChecksumAddress(bytesToHex(hash.slice(12)));
+}
+
+// toChecksumAddress returns the EIP-55 form of the address, the same as
+// common.Address.Hex.
+export function toChecksumAddress(address) {
+    const lower = address.toLowerCase().replace(/^0x/, "");
+    const hash = bytesToHex(keccak256(new TextEncoder().encode(lower)));
+
+    let out = "0x";
+    for (let i = 0; i < lower.length; i++) {
+        out += parseInt(hash[i], 16) >= 8 ? lower[i].toUpperCase() : lower[i];
+    }
+
+    return out;
+}
+
+// stamp returns the hash signature.Sign signs: the JSON document with the
+// Ethereum signed message prefix.
+function stamp(json) {
+    const data = new TextEncoder().encode(json);
+    const prefix = new TextEncoder().encode(`\x19Ethereum Signed Message:\n${data.length}`);
+
+    return keccak256(prefix, data);
+}
+
+async function hmac(key, ...parts) {
+    let length = 0;
+    for (const p of parts) {
+        length += p.length;
+    }
+
+    const data = new Uint8Array(length);
+    let offset = 0;
+    for (const p of parts) {
+        data.set(p, offset);
+        offset += p.length;
+    }
+
+    const k = await crypto.subtle.importKey("raw", key, { name: "HMAC", hash: "SHA-256" }, false, ["sign"]);
+    return new Uint8Array(await crypto.subtle.sign("HMAC", k, data));
+}
+
+// nonce derives k from the key and hash as RFC 6979 describes, the same as
+// go-ethereum, so a signature doesn't depend on the browser's random source
+// and matches the one Go produces.
+async function nonce(d, z) {
+    const x = bigIntToBytes(d);
+    const h = bigIntToBytes(mod(z, N));
+
+    let v = new Uint8Array(32).fill(1);
+    let k = new Uint8Array(32);
+
+    k = await hmac(k, v, [0], x, h);
+    v = await hmac(k, v);
+    k = await hmac(k, v, [1], x, h);
+    v = await hmac(k, v);
+
+    for (;;) {
+        v = await hmac(k, v);
+
+        const t = bytesToBigInt(v);
+        if (t > 0n && t < N) {
+            return t;
+        }
+
+        k = await hmac(k, v, [0]);
+        v = await hmac(k, v);
+    }
+}
+
+// sign signs the JSON document and returns v, r and s as BigInts. The JSON
+// must be byte for byte what json.Marshal produces for the value the CAP
+// checks.
+export async function sign(json, privateKeyHex) {
+    const d = bytesToBigInt(hexToBytes(privateKeyHex));
+    const z = bytesToBigInt(stamp(json));
+
+    const k = await nonce(d, z);
+    const point = toAffine(multiply(k));
+
+    const r = mod(point.x, N);
+    let s = mod(invert(k, N) * (z + r * d), N);
+    if (r === 0n || s === 0n) {
+        throw new Error("invalid signature produced");
+    }
+
+    let recovery = Number(point.y & 1n);
+
+    // Keep s in the lower half of the order like go-ethereum.
+    if (s > N / 2n) {
+        s = N - s;
+        recovery ^= 1;
+    }
+
+    return { v: BigInt(27 + recovery), r, s };
+}
+
+// =============================================================================
+// Keyfile
+
+// defaultIterations is the PBKDF2 work factor for new keyfiles, the same as
+// foundation/keyfile.
+export const defaultIterations = 600000;
+
+function toBase64(bytes) {
+    let bin = "";
+    for (const b of bytes) {
+        bin += String.fromCharCode(b);
+    }
+    return btoa(bin);
+}
+
+function fromBase64(b64) {
+    return Uint8Array.from(atob(b64), (c) => c.charCodeAt(0));
+}
+
+async function keyfileKey(passphrase, params) {
+    const material = await crypto.subtle.importKey("raw", new TextEncoder().encode(passphrase), "PBKDF2", false, ["deriveKey"]);
+
+    return crypto.subtle.deriveKey(
+        { name: "PBKDF2", hash: "SHA-256", salt: fromBase64(params.salt), iterations: params.c },
+        material,
+        { name: "AES-GCM", length: params.dklen * 8 },
+        false,
+        ["encrypt", "decrypt"],
+    );
+}
+
+// encryptKey encrypts the private key with the passphrase in the format
+// foundation/keyfile writes, so the key is never stored in the clear.
+export async function encryptKey(privateKeyHex, passphrase, iterations = defaultIterations) {
+    const params = {
+        prf: "hmac-sha256",
+        c: iterations,
+        salt: toBase64(crypto.getRandomValues(new Uint8Array(32))),
+        dklen: 32,
+    };
+
+    const iv = crypto.getRandomValues(new Uint8Array(12));
+    const key = await keyfileKey(passphrase, params);
+    const ciphertext = await crypto.subtle.encrypt({ name: "AES-GCM", iv: iv }, key, hexToBytes(privateKeyHex));
+
+    return {
+        version: 1,
+        crypto: {
+            cipher: "aes-256-gcm",
+            ciphertext: toBase64(new Uint8Array(ciphertext)),
+            nonce: toBase64(iv),
+            kdf: "pbkdf2",
+            kdfparams: params,
+        },
+    };
+}
+
+// decryptKey decrypts the keyfile with the passphrase and returns the
+// private key as hex.
+export async function decryptKey(file, passphrase) {
+    const c = file.crypto;
+
+    if (file.version !== 1 || c.cipher !== "aes-256-gcm" || c.kdf !== "pbkdf2" || c.kdfparams.prf !== "hmac-sha256") {
+        throw new Error("unsupported keyfile");
+    }
+
+    const key = await keyfileKey(passphrase, c.kdfparams);
+
+    let secret;
+    try {
+        secret = await crypto.subtle.decrypt({ name: "AES-GCM", iv: fromBase64(c.nonce) }, key, fromBase64(c.ciphertext));
+    } catch {
+        throw new Error("wrong passphrase");
+    }
+
+    return bytesToHex(new Uint8Array(secret));
+}
@@ -0,0 +1,82 @@
+// Package store keeps the identity, contacts and message history in
+// IndexedDB so they survive a reload. The identity's private key is stored
+// as a keyfile encrypted with the user's passphrase.
+
+const dbName = "usdl";
+const dbVersion = 1;
+
+function request(req) {
+    return new Promise((resolve, reject) => {
+        req.onsuccess = () => resolve(req.result);
+        req.onerror = () => reject(req.error);
+    });
+}
+
+export class Store {
+    static async open() {
+        const req = indexedDB.open(dbName, dbVersion);
+
+        req.onupgradeneeded = () => {
+            const db = req.result;
+            db.createObjectStore("settings", { keyPath: "key" });
+            db.createObjectStore("contacts", { keyPath: "id" });
+
+            const msgs = db.createObjectStore("messages", { keyPath: "seq", autoIncrement: true });
+            msgs.createIndex("contact", "contact");
+        };
+
+        return new Store(await request(req));
+    }
+
+    constructor(db) {
+        this.db = db;
+    }
+
+    store(name, mode = "readonly") {
+        return this.db.transaction(name, mode).objectStore(name);
+    }
+
+    // -------------------------------------------------------------------------
+    // Identity
+
+    async identity() {
+        const row = await request(this.store("settings").get("identity"));
+        return row ? row.value : null;
+    }
+
+    async saveIdentity(identity) {
+        await request(this.store("settings", "readwrite").put({ key: "identity", value: identity }));
+    }
+
+    // -------------------------------------------------------------------------
+    // Contacts
+
+    async contacts() {
+        const contacts = await request(this.store("contacts").getAll());
+        return contacts.sort((a, b) => a.name.localeCompare(b.name));
+    }
+
+    async contact(id) {
+        return (await request(this.store("contacts").get(id))) || null;
+    }
+
+    async saveContact(contact) {
+        await request(this.store("contacts", "readwrite").put(contact));
+    }
+
+    // -------------------------------------------------------------------------
+    // Messages
+
+    async messages(contactID) {
+        return request(this.store("messages").index("contact").getAll(contactID));
+    }
+
+    // addMessage stores the message and returns its key.
+    async addMessage(msg) {
+        return request(this.store("messages", "readwrite").add(msg));
+    }
+
+    async removeMessage(seq) {
+        await request(this.store("messages", "readwrite").delete(seq));
+    }
+}
@@ -0,0 +1,24 @@
+// Signs the vectors with the browser's crypto.js and encrypts a key with a
+// passphrase, so webapp_test.go can check the results against Go. The test
+// copies crypto.js next to this file as crypto.mjs.
+
+import { readFileSync } from "node:fs";
+import { encryptKey, privateKeyToAddress, sign } from "./crypto.mjs";
+
+const vectors = JSON.parse(readFileSync(process.argv[2], "utf8"));
+
+const signatures = [];
+for (const vec of vectors) {
+    const sig = await sign(vec.json, vec.key);
+
+    signatures.push({
+        address: privateKeyToAddress(vec.key),
+        v: Number(sig.v),
+        r: "0x" + sig.r.toString(16),
+        s: "0x" + sig.s.toString(16),
+    });
+}
+
+const keyfile = await encryptKey(vectors[0].key, "passphrase", 1000);
+
+console.log(JSON.stringify({ signatures, keyfile }));
@@ -0,0 +1,114 @@
+// Package webapp provides the browser chat client and the login the browser
+// uses to get a token. The login only issues tokens to the accounts the CAP
+// is configured to allow, everyone else needs a token issued out of band
+// the same as the TUI.
+package webapp
+
+import (
+	"context"
+	"embed"
+	"net/http"
+	"time"
+
+	"github.com/ardanlabs/usdl/app/sdk/auth"
+	"github.com/ardanlabs/usdl/app/sdk/errs"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ardanlabs/usdl/foundation/signature"
+	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+//go:embed static
+var static embed.FS
+
+// loginWindow is how far the issued time of a login can be from now. It
+// keeps a captured login from being used later.
+const loginWindow = time.Minute
+
+type app struct {
+	log       *logger.Logger
+	auth      *auth.Auth
+	activeKID string
+	tokenTTL  time.Duration
+	allow     map[common.Address]struct{}
+}
+
+func newApp(cfg Config) *app {
+	allow := make(map[common.Address]struct{}, len(cfg.Allow))
+	for _, id := range cfg.Allow {
+		allow[id] = struct{}{}
+	}
+
+	return &app{
+		log:       cfg.Log,
+		auth:      cfg.Auth,
+		activeKID: cfg.ActiveKID,
+		tokenTTL:  cfg.TokenTTL,
+		allow:     allow,
+	}
+}
+
+// login issues a token for the account that signed the request. The browser
+// holds its own key, the same as the TUI, so it proves it owns the key, and
+// the account must be on the allow list so the login isn't open
+// registration.
+func (a *app) login(ctx context.Context, r *http.Request) web.Encoder {
+	var req loginRequest
+	if err := web.Decode(r, &req); err != nil {
+		return errs.Newf(errs.InvalidArgument, "invalid request: %s", err)
+	}
+
+	if req.V == nil || req.R == nil || req.S == nil {
+		return errs.Newf(errs.InvalidArgument, "missing signature")
+	}
+
+	issued := time.Unix(req.Issued, 0)
+	if d := time.Since(issued); d > loginWindow || d < -loginWindow {
+		return errs.Newf(errs.Unauthenticated, "login expired")
+	}
+
+	dataThatWasSign := struct {
+		ID     common.Address
+		Issued int64
+	}{
+		ID:     req.ID,
+		Issued: req.Issued,
+	}
+
+	id, err := signature.FromAddress(dataThatWasSign, req.V, req.R, req.S)
+	if err != nil {
+		return errs.Newf(errs.Unauthenticated, "invalid signature: %s", err)
+	}
+
+	if common.HexToAddress(id) != req.ID {
+		return errs.Newf(errs.Unauthenticated, "signature doesn't match id")
+	}
+
+	if _, ok := a.allow[req.ID]; !ok {
+		return errs.Newf(errs.PermissionDenied, "account not allowed to log in")
+	}
+
+	now := time.Now().UTC()
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   req.ID.Hex(),
+			Issuer:    a.auth.Issuer(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.tokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	tkn, err := a.auth.GenerateToken(a.activeKID, claims)
+	if err != nil {
+		return errs.Newf(errs.Internal, "generate token: %s", err)
+	}
+
+	a.log.Info(ctx, "web-login", "userID", req.ID)
+
+	return loginResponse{
+		Token:     tkn,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+}
@@ -0,0 +1,204 @@
+package webapp_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	mrand "math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/keyfile"
+	"github.com/ardanlabs/usdl/foundation/signature"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+type vector struct {
+	Key     string `json:"key"`
+	JSON    string `json:"json"`
+	Address string `json:"address"`
+	V       int64  `json:"v"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+}
+
+// TestBrowserCrypto provides a test of the browser's signatures and
+// keyfile against the Go packages the CAP and the TUI use.
+func TestBrowserCrypto(t *testing.T) {
+	t.Log("Given the need for the browser to sign and store keys the same as Go.")
+	{
+		data, err := os.ReadFile("testdata/signatures.json")
+		if err != nil {
+			t.Fatalf("\tShould be able to read the vectors: %s. %s", err, "X")
+		}
+
+		var vectors []vector
+		if err := json.Unmarshal(data, &vectors); err != nil {
+			t.Fatalf("\tShould be able to decode the vectors: %s. %s", err, "X")
+		}
+
+		// Fresh vectors every run check the browser against many more keys
+		// and message sizes than the ones checked in.
+		vectors = append(vectors, randomVectors(t, 100)...)
+
+		for _, vec := range vectors {
+			checkSignature(t, vec, vec)
+		}
+		t.Log("\tShould recover the address from every vector.", "OK")
+
+		// ---------------------------------------------------------------------
+
+		node, err := exec.LookPath("node")
+		if err != nil {
+			t.Skip("\tnode isn't installed, the browser code can't be run.")
+		}
+
+		dir := t.TempDir()
+		copyFile(t, "static/crypto.js", filepath.Join(dir, "crypto.mjs"))
+		copyFile(t, "testdata/sign.mjs", filepath.Join(dir, "sign.mjs"))
+
+		data, err = json.Marshal(vectors)
+		if err != nil {
+			t.Fatalf("\tShould be able to encode the vectors: %s. %s", err, "X")
+		}
+
+		vectorsFile := filepath.Join(dir, "signatures.json")
+		if err := os.WriteFile(vectorsFile, data, 0600); err != nil {
+			t.Fatalf("\tShould be able to write the vectors: %s. %s", err, "X")
+		}
+
+		out, err := exec.Command(node, filepath.Join(dir, "sign.mjs"), vectorsFile).Output()
+		if err != nil {
+			t.Fatalf("\tShould be able to run the browser code: %s. %s", err, "X")
+		}
+
+		var result struct {
+			Signatures []vector         `json:"signatures"`
+			Keyfile    *json.RawMessage `json:"keyfile"`
+		}
+		if err := json.Unmarshal(out, &result); err != nil {
+			t.Fatalf("\tShould be able to decode the browser's output: %s. %s", err, "X")
+		}
+
+		if len(result.Signatures) != len(vectors) {
+			t.Fatalf("\tShould sign every vector, got %d of %d. %s", len(result.Signatures), len(vectors), "X")
+		}
+
+		for i, vec := range vectors {
+			got := result.Signatures[i]
+			got.Key = vec.Key
+			got.JSON = vec.JSON
+
+			checkSignature(t, vec, got)
+		}
+		t.Log("\tShould produce the same signatures as Go.", "OK")
+
+		// ---------------------------------------------------------------------
+
+		secret, err := keyfile.Decrypt(*result.Keyfile, "passphrase")
+		if err != nil {
+			t.Fatalf("\tShould be able to decrypt the browser's keyfile: %s. %s", err, "X")
+		}
+
+		if exp, _ := hex.DecodeString(vectors[0].Key); !bytes.Equal(secret, exp) {
+			t.Fatalf("\tShould decrypt to the key, got %x. %s", secret, "X")
+		}
+
+		if _, err := keyfile.Decrypt(*result.Keyfile, "wrong"); err == nil {
+			t.Fatalf("\tShould not decrypt with the wrong passphrase. %s", "X")
+		}
+		t.Log("\tShould write a keyfile Go can decrypt.", "OK")
+	}
+}
+
+// checkSignature checks the signature matches the vector and recovers the
+// vector's address.
+func checkSignature(t *testing.T, exp vector, got vector) {
+	t.Helper()
+
+	if got != exp {
+		t.Fatalf("\tShould match the vector for %s, got %+v, exp %+v. %s", exp.JSON, got, exp, "X")
+	}
+
+	r, _ := new(big.Int).SetString(got.R, 0)
+	s, _ := new(big.Int).SetString(got.S, 0)
+	v := big.NewInt(got.V)
+
+	if err := signature.VerifySignature(v, r, s); err != nil {
+		t.Fatalf("\tShould be a valid signature for %s: %s. %s", got.JSON, err, "X")
+	}
+
+	addr, err := signature.FromAddress(json.RawMessage(got.JSON), v, r, s)
+	if err != nil {
+		t.Fatalf("\tShould be able to recover the address for %s: %s. %s", got.JSON, err, "X")
+	}
+
+	if addr != exp.Address {
+		t.Fatalf("\tShould recover %s for %s, got %s. %s", exp.Address, got.JSON, addr, "X")
+	}
+}
+
+// randomVectors signs random messages with random keys the way the CAP
+// expects them to be signed.
+func randomVectors(t *testing.T, n int) []vector {
+	t.Helper()
+
+	vectors := make([]vector, n)
+	for i := range vectors {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("\tShould be able to generate a key: %s. %s", err, "X")
+		}
+
+		msg := make([]byte, mrand.IntN(400))
+		rand.Read(msg)
+
+		data, err := json.Marshal(struct {
+			ToID      common.Address
+			Msg       [][]byte
+			FromNonce uint64
+		}{
+			ToID:      crypto.PubkeyToAddress(key.PublicKey),
+			Msg:       [][]byte{msg},
+			FromNonce: mrand.Uint64(),
+		})
+		if err != nil {
+			t.Fatalf("\tShould be able to encode the message: %s. %s", err, "X")
+		}
+
+		v, r, s, err := signature.Sign(json.RawMessage(data), key)
+		if err != nil {
+			t.Fatalf("\tShould be able to sign the message: %s. %s", err, "X")
+		}
+
+		vectors[i] = vector{
+			Key:     hex.EncodeToString(crypto.FromECDSA(key)),
+			JSON:    string(data),
+			Address: crypto.PubkeyToAddress(key.PublicKey).Hex(),
+			V:       v.Int64(),
+			R:       fmt.Sprintf("%#x", r),
+			S:       fmt.Sprintf("%#x", s),
+		}
+	}
+
+	return vectors
+}
+
+func copyFile(t *testing.T, from string, to string) {
+	t.Helper()
+
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatalf("\tShould be able to read %s: %s. %s", from, err, "X")
+	}
+
+	if err := os.WriteFile(to, data, 0600); err != nil {
+		t.Fatalf("\tShould be able to write %s: %s. %s", to, err, "X")
+	}
+}
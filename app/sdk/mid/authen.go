@@ -3,6 +3,7 @@ package mid
 import (
 	"context"
 	"net/http"
+	"strings"
 
 	"github.com/ardanlabs/usdl/app/sdk/auth"
 	"github.com/ardanlabs/usdl/app/sdk/errs"
@@ -33,3 +34,29 @@ func Bearer(ath *auth.Auth) web.MidFunc {
 
 	return m
 }
+
+// BearerWebSocket processes JWT authentication for websocket upgrades. A
+// browser can't set the authorization header on a websocket request, so the
+// token can be offered as the subprotocol that follows the named protocol.
+func BearerWebSocket(ath *auth.Auth, protocol string) web.MidFunc {
+	bearer := Bearer(ath)
+
+	m := func(next web.HandlerFunc) web.HandlerFunc {
+		h := bearer(next)
+
+		f := func(ctx context.Context, r *http.Request) web.Encoder {
+			if r.Header.Get("authorization") == "" {
+				protocols := strings.Split(r.Header.Get("Sec-Websocket-Protocol"), ",")
+				if len(protocols) == 2 && strings.TrimSpace(protocols[0]) == protocol {
+					r.Header.Set("authorization", "Bearer "+strings.TrimSpace(protocols[1]))
+				}
+			}
+
+			return h(ctx, r)
+		}
+
+		return f
+	}
+
+	return m
+}
@@ -4,21 +4,31 @@ package mux
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/ardanlabs/usdl/app/domain/chatapp"
+	"github.com/ardanlabs/usdl/app/domain/webapp"
 	"github.com/ardanlabs/usdl/app/sdk/auth"
 	"github.com/ardanlabs/usdl/app/sdk/mid"
 	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
 	"github.com/ardanlabs/usdl/foundation/logger"
 	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 // Config contains all the mandatory systems required by handlers.
 type Config struct {
-	Log        *logger.Logger
-	ChatBus    *chatbus.Business
-	ServerAddr string
-	Auth       *auth.Auth
+	Log              *logger.Logger
+	ChatBus          *chatbus.Business
+	TransferBus      *transferbus.Business
+	ServerAddr       string
+	Auth             *auth.Auth
+	ActiveKID        string
+	TransferTokenTTL time.Duration
+	WebClient        bool
+	LoginTokenTTL    time.Duration
+	LoginAllow       []common.Address
 }
 
 // WebAPI constructs a http.Handler with all application routes bound.
@@ -34,7 +44,25 @@ func WebAPI(cfg Config) http.Handler {
 		mid.Panics(),
 	)
 
-	chatapp.Routes(app, cfg.Log, cfg.ChatBus, cfg.ServerAddr, cfg.Auth)
+	chatapp.Routes(app, chatapp.Config{
+		Log:              cfg.Log,
+		ChatBus:          cfg.ChatBus,
+		TransferBus:      cfg.TransferBus,
+		ServerAddr:       cfg.ServerAddr,
+		Auth:             cfg.Auth,
+		ActiveKID:        cfg.ActiveKID,
+		TransferTokenTTL: cfg.TransferTokenTTL,
+	})
+
+	if cfg.WebClient {
+		webapp.Routes(app, webapp.Config{
+			Log:       cfg.Log,
+			Auth:      cfg.Auth,
+			ActiveKID: cfg.ActiveKID,
+			TokenTTL:  cfg.LoginTokenTTL,
+			Allow:     cfg.LoginAllow,
+		})
+	}
 
 	return app
 }
@@ -0,0 +1,139 @@
+package chatbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/foundation/web"
+	"github.com/google/uuid"
+)
+
+func (b *Business) busReadMessage() func(data []byte) {
+	ctx := web.SetTraceID(context.Background(), uuid.New())
+
+	f := func(data []byte) {
+		natsMsg, err := decodeEnvelope(ctx, b.groupMgr, data)
+		if err != nil {
+			b.log.Info(ctx, "busreadmessage: decode", "ERROR", err)
+			return
+		}
+
+		if natsMsg.CapID == b.capID {
+			return
+		}
+
+		b.log.Info(ctx, "busreadmessage: msg recv", "fromNonce", natsMsg.FromNonce, "from", natsMsg.FromID, "to", natsMsg.ToID, "encrypted", natsMsg.Encrypted, "message", natsMsg.Msg, "fromName", natsMsg.FromName)
+
+		// Messages from other CAPs are only delivered to users connected to
+		// this CAP so they don't bounce between CAPs.
+		err = b.local.Deliver(ctx, natsMsg)
+		switch {
+		case errors.Is(err, ErrNotExists):
+
+			// We don't have a web socket connection for the user. If no
+			// other CAP acks the message, the sending CAP will store it in
+			// the mailbox.
+			b.log.Info(ctx, "busreadmessage: deliver", "status", "user not found")
+			return
+
+		case errors.Is(err, ErrDuplicateDelivery):
+			b.log.Info(ctx, "busreadmessage: deliver", "status", "duplicate suppressed", "msgID", natsMsg.MsgID())
+
+		case err != nil:
+			b.log.Info(ctx, "busreadmessage: deliver", "ERROR", err)
+			return
+		}
+
+		b.log.Info(ctx, "busreadmessage: msg sent over web socket", "from", natsMsg.FromID, "to", natsMsg.To())
+
+		// Let the sending CAP know the message was delivered so it doesn't
+		// store the message in the mailbox.
+		if err := b.bus.PublishAck(ctx, natsMsg.CapID, natsMsg.MsgID()); err != nil {
+			b.log.Info(ctx, "busreadmessage: ack", "ERROR", err)
+		}
+	}
+
+	return f
+}
+
+func (b *Business) busReadAck() func(msgID string) {
+	f := func(msgID string) {
+		b.busCancelAck(msgID)
+	}
+
+	return f
+}
+
+func (b *Business) busSendMessage(ctx context.Context, natsMsg Envelope) error {
+	d, err := json.Marshal(natsMsg)
+	if err != nil {
+		return fmt.Errorf("send bus marshal message: %w", err)
+	}
+
+	// If we know which CAP owns the user, send the message directly to that
+	// CAP. Otherwise broadcast the message to all the CAPs.
+	toCapID := uuid.Nil
+
+	capID, err := b.presence.Lookup(ctx, natsMsg.To())
+	switch {
+	case err == nil && capID != b.capID:
+		toCapID = capID
+
+	case err != nil && !errors.Is(err, ErrNotExists):
+		b.log.Info(ctx, "send bus: presence lookup", "ERROR", err)
+	}
+
+	// Start waiting for the ack before publishing so a fast ack isn't missed.
+	b.busWaitAck(ctx, natsMsg)
+
+	if err := b.bus.Publish(ctx, toCapID, d); err != nil {
+		b.busCancelAck(natsMsg.MsgID())
+		return fmt.Errorf("send bus publish: %w", err)
+	}
+
+	return nil
+}
+
+// busWaitAck waits for another CAP to ack the delivery of the message. If no
+// CAP acks the message in time, the message is stored in the mailbox.
+func (b *Business) busWaitAck(ctx context.Context, natsMsg Envelope) {
+	b.acksMu.Lock()
+	defer b.acksMu.Unlock()
+
+	id := natsMsg.MsgID()
+
+	f := func() {
+		if !b.busCancelAck(id) {
+			return
+		}
+
+		ctx := context.WithoutCancel(ctx)
+
+		b.log.Info(ctx, "bus-ack", "status", "no ack, storing in mailbox", "msgID", id)
+
+		if err := mailboxStore(ctx, b.mailbox, natsMsg); err != nil {
+			b.log.Info(ctx, "bus-ack: mailbox", "ERROR", err)
+		}
+	}
+
+	b.acks[id] = time.AfterFunc(b.ackWait, f)
+}
+
+// busCancelAck stops waiting for an ack and reports if it was still pending.
+func (b *Business) busCancelAck(id string) bool {
+	b.acksMu.Lock()
+	defer b.acksMu.Unlock()
+
+	t, exists := b.acks[id]
+	if !exists {
+		return false
+	}
+
+	t.Stop()
+	delete(b.acks, id)
+
+	return true
+}
@@ -13,8 +13,6 @@ import (
 	"github.com/ardanlabs/usdl/foundation/tcp"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
-	"github.com/nats-io/nats.go"
-	"github.com/nats-io/nats.go/jetstream"
 )
 
 // Set of error variables.
@@ -23,6 +21,23 @@ var (
 	ErrNotExists              = fmt.Errorf("user doesn't exists")
 	ErrClientAlreadyConnected = errors.New("client already connected")
 	ErrClientNotConnected     = errors.New("client not connected")
+	ErrGroupExists            = errors.New("group exists")
+	ErrGroupNotExists         = errors.New("group doesn't exists")
+	ErrNotGroupOwner          = errors.New("not the group owner")
+	ErrNotGroupMember         = errors.New("not a group member")
+	ErrGroupChanged           = errors.New("group was changed by another request")
+	ErrIdentityMismatch       = errors.New("id doesn't match the authenticated user")
+	ErrInvalidChallenge       = errors.New("challenge signature doesn't match the id")
+	ErrStaleNonce             = errors.New("nonce already used")
+	ErrDuplicateDelivery      = errors.New("message already delivered")
+	ErrDeliveryTooOld         = errors.New("message too old to tell if it was delivered")
+	ErrQueueFull              = errors.New("outbound queue full")
+	ErrConnClosed             = errors.New("connection closed")
+	ErrInvalidSignature       = errors.New("signature doesn't match the sender")
+	ErrNoRoute                = errors.New("no transport could deliver the message")
+	ErrRotated                = errors.New("id was rotated to a new address")
+	ErrRotationExists         = errors.New("id was already rotated to another address")
+	ErrNotRotated             = errors.New("id wasn't rotated")
 )
 
 // UIClientManager defines the set of behavior for user management.
@@ -41,74 +56,168 @@ type TCPClientManager interface {
 	Retrieve(ctx context.Context, userID string) (*tcp.Client, error)
 }
 
+// Presence defines the set of behavior for knowing which CAP a user is
+// connected to.
+type Presence interface {
+	Set(ctx context.Context, userID common.Address, capID uuid.UUID) error
+	Remove(ctx context.Context, userID common.Address, capID uuid.UUID) error
+	Lookup(ctx context.Context, userID common.Address) (uuid.UUID, error)
+}
+
+// GroupManager defines the set of behavior for group membership storage.
+type GroupManager interface {
+	Create(ctx context.Context, grp Group) error
+	Update(ctx context.Context, grp Group, revision uint64) error
+	Delete(ctx context.Context, groupID common.Address, revision uint64) error
+	Retrieve(ctx context.Context, groupID common.Address) (Group, uint64, error)
+}
+
+// RotationManager defines the set of behavior for remembering which users
+// moved to a new address. Rotations are forgotten after a grace period.
+type RotationManager interface {
+	Add(ctx context.Context, rot Rotation) error
+	Retrieve(ctx context.Context, oldID common.Address) (Rotation, error)
+}
+
+// NonceManager defines the set of behavior for tracking the nonces of the
+// messages that have been accepted and delivered.
+type NonceManager interface {
+	Accept(ctx context.Context, fromID common.Address, toID common.Address, nonce uint64) error
+	LastNonces(ctx context.Context, fromID common.Address) (map[common.Address]uint64, error)
+	Delivered(ctx context.Context, streamID string, nonce uint64) error
+	Undelivered(ctx context.Context, streamID string, nonce uint64) error
+}
+
+// Transport defines the set of behavior for a path a message can take to
+// reach its recipient.
+type Transport interface {
+	Name() string
+	CanReach(ctx context.Context, toID common.Address) bool
+	Deliver(ctx context.Context, env Envelope) error
+}
+
+// MessageBus defines the set of behavior for moving messages between CAPs.
+// Messages published to uuid.Nil are sent to every CAP. Acks let the sending
+// CAP know another CAP delivered the message.
+type MessageBus interface {
+	Publish(ctx context.Context, capID uuid.UUID, data []byte) error
+	Consume(ctx context.Context, capID uuid.UUID, handler func(data []byte)) error
+	PublishAck(ctx context.Context, capID uuid.UUID, msgID string) error
+	ConsumeAcks(ctx context.Context, capID uuid.UUID, handler func(msgID string)) error
+}
+
+// MailboxMsg represents a message held in a user's mailbox. The sequence
+// identifies the message when it's removed.
+type MailboxMsg struct {
+	Seq  uint64
+	Data []byte
+}
+
+// Mailbox defines the set of behavior for holding messages for users that
+// are not connected to any CAP. Messages stay in the mailbox until they are
+// removed, so a message that fails to be delivered is kept for next time.
+type Mailbox interface {
+	Store(ctx context.Context, toID common.Address, msgID string, data []byte) error
+	Read(ctx context.Context, toID common.Address) ([]MailboxMsg, error)
+	Remove(ctx context.Context, toID common.Address, seqs []uint64) error
+}
+
 type Config struct {
-	Log         *logger.Logger
-	NATSConn    *nats.Conn
-	UICltMgr    UIClientManager
-	TCPCltMgr   TCPClientManager
-	TCPServer   *tcp.Server
-	NATSSubject string
-	CAPID       uuid.UUID
+	Log        *logger.Logger
+	Bus        MessageBus
+	UICltMgr   UIClientManager
+	TCPCltMgr  TCPClientManager
+	TCPServer  *tcp.Server
+	Mailbox    Mailbox
+	Presence   Presence
+	GroupMgr   GroupManager
+	NonceMgr   NonceManager
+	RotateMgr  RotationManager
+	UIConn     UIConnConfig
+	Transports []Transport
+	RouteOrder []string
+	AckWait    time.Duration
+	CAPID      uuid.UUID
 }
 
 // Business represents a chat support.
 type Business struct {
 	log          *logger.Logger
-	js           jetstream.JetStream
-	stream       jetstream.Stream
-	consumer     jetstream.Consumer
+	bus          MessageBus
 	capID        uuid.UUID
-	natsSubject  string
+	ackWait      time.Duration
 	uiCltMgr     UIClientManager
 	tcpCltMgr    TCPClientManager
 	tcpServer    *tcp.Server
+	mailbox      Mailbox
+	presence     Presence
+	groupMgr     GroupManager
+	nonceMgr     NonceManager
+	rotateMgr    RotationManager
+	uiConnCfg    UIConnConfig
+	local        uiTransport
+	router       *Router
 	tcpConnMap   map[common.Address][]common.Address
 	tcpConnMapMu sync.Mutex
+	acks         map[string]*time.Timer
+	acksMu       sync.Mutex
 }
 
 // NewBusiness creates a new chat support.
 func NewBusiness(cfg Config) (*Business, error) {
 	ctx := context.TODO()
 
-	js, err := jetstream.New(cfg.NATSConn)
-	if err != nil {
-		return nil, fmt.Errorf("nats new js: %w", err)
+	if err := cfg.UIConn.validate(); err != nil {
+		return nil, fmt.Errorf("ui conn config: %w", err)
 	}
 
-	// js.DeleteStream(ctx, subject)
+	b := Business{
+		log:        cfg.Log,
+		bus:        cfg.Bus,
+		capID:      cfg.CAPID,
+		ackWait:    cfg.AckWait,
+		uiCltMgr:   cfg.UICltMgr,
+		tcpCltMgr:  cfg.TCPCltMgr,
+		tcpServer:  cfg.TCPServer,
+		mailbox:    cfg.Mailbox,
+		presence:   cfg.Presence,
+		groupMgr:   cfg.GroupMgr,
+		nonceMgr:   cfg.NonceMgr,
+		rotateMgr:  cfg.RotateMgr,
+		uiConnCfg:  cfg.UIConn,
+		local:      uiTransport{uiCltMgr: cfg.UICltMgr, nonceMgr: cfg.NonceMgr, mailbox: cfg.Mailbox},
+		tcpConnMap: make(map[common.Address][]common.Address),
+		acks:       make(map[string]*time.Timer),
+	}
 
-	s1, err := js.CreateStream(ctx, jetstream.StreamConfig{
-		Name:     cfg.NATSSubject,
-		Subjects: []string{cfg.NATSSubject},
-		MaxAge:   24 * time.Hour,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("nats create js: %w", err)
+	// Additional transports can be provided and placed anywhere in the
+	// order messages are routed.
+	transports := []Transport{
+		b.local,
+		tcpTransport{tcpCltMgr: cfg.TCPCltMgr},
+		busTransport{b: &b},
 	}
+	transports = append(transports, cfg.Transports...)
 
-	c1, err := s1.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
-		Durable:       cfg.CAPID.String(),
-		AckPolicy:     jetstream.AckExplicitPolicy,
-		DeliverPolicy: jetstream.DeliverNewPolicy,
-	})
+	order := cfg.RouteOrder
+	if len(order) == 0 {
+		order = DefaultTransportOrder
+	}
+
+	ordered, err := orderTransports(order, transports)
 	if err != nil {
-		return nil, fmt.Errorf("nats create consumer: %w", err)
+		return nil, fmt.Errorf("route order: %w", err)
 	}
 
-	b := Business{
-		log:         cfg.Log,
-		js:          js,
-		stream:      s1,
-		consumer:    c1,
-		capID:       cfg.CAPID,
-		natsSubject: cfg.NATSSubject,
-		uiCltMgr:    cfg.UICltMgr,
-		tcpCltMgr:   cfg.TCPCltMgr,
-		tcpServer:   cfg.TCPServer,
-		tcpConnMap:  make(map[common.Address][]common.Address),
+	b.router = NewRouter(cfg.Log, ordered...)
+
+	if err := cfg.Bus.ConsumeAcks(ctx, b.capID, b.busReadAck()); err != nil {
+		return nil, fmt.Errorf("bus consume acks: %w", err)
 	}
 
-	c1.Consume(b.natsReadMessage(), jetstream.PullMaxMessages(1))
+	if err := cfg.Bus.Consume(ctx, b.capID, b.busReadMessage()); err != nil {
+		return nil, fmt.Errorf("bus consume: %w", err)
+	}
 
 	const maxWait = 10 * time.Second
 	b.uiPing(maxWait)
@@ -169,6 +278,22 @@ func (b *Business) DropTCPConnection(ctx context.Context, tuiUserID common.Addre
 	return nil
 }
 
+// Deliveries returns the number of messages each transport has delivered.
+func (b *Business) Deliveries() map[string]uint64 {
+	return b.router.Deliveries()
+}
+
+// LastNonces returns the last nonce accepted from the user for every
+// recipient, so a client that lost its storage can carry on from them.
+func (b *Business) LastNonces(ctx context.Context, userID common.Address) (map[common.Address]uint64, error) {
+	nonces, err := b.nonceMgr.LastNonces(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("last nonces: %w", err)
+	}
+
+	return nonces, nil
+}
+
 // TCPConnections returns the list of client user IDs for a given tui user ID.
 func (b *Business) TCPConnections(ctx context.Context) []common.Address {
 	users := b.tcpServer.Clients()
@@ -0,0 +1,821 @@
+package chatbus_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/busmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/groupmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/mailboxmgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/noncemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/presencemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/rotatemgr"
+	"github.com/ardanlabs/usdl/business/domain/chatbus/managers/uicltmgr"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ardanlabs/usdl/foundation/signature"
+	"github.com/ardanlabs/usdl/foundation/tcp"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TestSameCAP provides a test of two users connected to the same CAP.
+func TestSameCAP(t *testing.T) {
+	t.Log("Given the need to send a message between users on the same CAP.")
+	{
+		net := newTestNetwork()
+		capURL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		alice.connect(t, capURL)
+		bob.connect(t, capURL)
+
+		alice.send(t, bob.id, "hello bob")
+
+		msg := bob.read(t)
+		if string(msg.Msg[0]) != "hello bob" || msg.From.ID != alice.id {
+			t.Fatalf("\tShould receive the message from alice, got %q from %s. %s", msg.Msg[0], msg.From.ID, "X")
+		}
+		t.Log("\tShould receive the message from alice.", "OK")
+	}
+}
+
+// TestAcrossCAPs provides a test of two users connected to different CAPs
+// that share the in memory message bus.
+func TestAcrossCAPs(t *testing.T) {
+	t.Log("Given the need to send a message between users on different CAPs.")
+	{
+		net := newTestNetwork()
+		cap1URL := net.startCAP(t)
+		cap2URL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		alice.connect(t, cap1URL)
+		bob.connect(t, cap2URL)
+
+		alice.send(t, bob.id, "hello from cap1")
+
+		msg := bob.read(t)
+		if string(msg.Msg[0]) != "hello from cap1" || msg.From.ID != alice.id {
+			t.Fatalf("\tShould receive the message from alice, got %q from %s. %s", msg.Msg[0], msg.From.ID, "X")
+		}
+		t.Log("\tShould receive the message from alice.", "OK")
+	}
+}
+
+// TestMailbox provides a test of a message sent to a user who is offline
+// being delivered when the user connects.
+func TestMailbox(t *testing.T) {
+	t.Log("Given the need to deliver messages sent while a user is offline.")
+	{
+		net := newTestNetwork()
+		cap1URL := net.startCAP(t)
+		cap2URL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		alice.connect(t, cap1URL)
+		alice.send(t, bob.id, "you were away")
+
+		// Give the CAP time to give up waiting for an ack.
+		time.Sleep(3 * testAckWait)
+
+		bob.connect(t, cap2URL)
+
+		msg := bob.read(t)
+		if string(msg.Msg[0]) != "you were away" {
+			t.Fatalf("\tShould receive the queued message, got %q. %s", msg.Msg[0], "X")
+		}
+		t.Log("\tShould receive the queued message.", "OK")
+
+		msg = bob.read(t)
+		if string(msg.Msg[0]) != "EVENT" || string(msg.Msg[1]) != "MAILBOX" || string(msg.Msg[2]) != "1" {
+			t.Fatalf("\tShould receive the mailbox event, got %q. %s", msg.Msg, "X")
+		}
+		t.Log("\tShould receive the mailbox event.", "OK")
+
+		msgs, err := net.mailbox.Read(context.Background(), bob.id)
+		if err != nil || len(msgs) != 0 {
+			t.Fatalf("\tShould remove the delivered message from the mailbox, got %d: %v. %s", len(msgs), err, "X")
+		}
+		t.Log("\tShould remove the delivered message from the mailbox.", "OK")
+	}
+}
+
+// TestMailboxDeliveredFailed provides a test of the mailbox keeping its
+// messages when the CAP can't record they were delivered.
+func TestMailboxDeliveredFailed(t *testing.T) {
+	t.Log("Given the need to only deliver queued messages the CAP can track.")
+	{
+		net := newTestNetwork()
+		net.nonces = brokenDelivered{noncemgr.NewMemory(log)}
+		cap1URL := net.startCAP(t)
+		cap2URL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		alice.connect(t, cap1URL)
+		alice.send(t, bob.id, "not tracked")
+		alice.send(t, bob.id, "after it")
+
+		// Give the CAP time to give up waiting for an ack.
+		time.Sleep(3 * testAckWait)
+
+		bob.connect(t, cap2URL)
+
+		msg := bob.read(t)
+		if string(msg.Msg[0]) != "EVENT" || string(msg.Msg[1]) != "MAILBOX" || string(msg.Msg[2]) != "0" {
+			t.Fatalf("\tShould stop delivering at the message it can't track, got %q. %s", msg.Msg, "X")
+		}
+		t.Log("\tShould stop delivering at the message it can't track.", "OK")
+
+		msgs, err := net.mailbox.Read(context.Background(), bob.id)
+		if err != nil || len(msgs) != 2 {
+			t.Fatalf("\tShould keep the messages in the mailbox, got %d: %v. %s", len(msgs), err, "X")
+		}
+		t.Log("\tShould keep the messages in the mailbox.", "OK")
+	}
+}
+
+// TestMailboxTooOld provides a test of the mailbox keeping a message that is
+// too old to tell if it was delivered.
+func TestMailboxTooOld(t *testing.T) {
+	t.Log("Given the need to never lose a queued message the CAP can't track.")
+	{
+		net := newTestNetwork()
+		cap1URL := net.startCAP(t)
+		cap2URL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		alice.connect(t, cap1URL)
+		alice.send(t, bob.id, "too old")
+
+		// Give the CAP time to give up waiting for an ack.
+		time.Sleep(3 * testAckWait)
+
+		// Move the stream's window well past the queued message.
+		streamID := fmt.Sprintf("%s.%s", alice.id.Hex(), bob.id.Hex())
+		if err := net.nonces.Delivered(context.Background(), streamID, 100); err != nil {
+			t.Fatal("\tShould be able to move the delivered window.", "X", err)
+		}
+
+		bob.connect(t, cap2URL)
+
+		msg := bob.read(t)
+		if string(msg.Msg[0]) != "EVENT" || string(msg.Msg[1]) != "MAILBOX" || string(msg.Msg[2]) != "0" {
+			t.Fatalf("\tShould not deliver the message it can't track, got %q. %s", msg.Msg, "X")
+		}
+		t.Log("\tShould not deliver the message it can't track.", "OK")
+
+		msgs, err := net.mailbox.Read(context.Background(), bob.id)
+		if err != nil || len(msgs) != 1 {
+			t.Fatalf("\tShould keep the message in the mailbox, got %d: %v. %s", len(msgs), err, "X")
+		}
+		t.Log("\tShould keep the message in the mailbox.", "OK")
+	}
+}
+
+// TestReplay provides a test of the CAP rejecting a message that is sent
+// a second time.
+func TestReplay(t *testing.T) {
+	t.Log("Given the need to reject replayed messages.")
+	{
+		net := newTestNetwork()
+		capURL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		alice.connect(t, capURL)
+		bob.connect(t, capURL)
+
+		data := alice.send(t, bob.id, "only once")
+		bob.read(t)
+
+		if err := alice.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			t.Fatal("\tShould be able to replay the message.", "X", err)
+		}
+
+		msg := alice.read(t)
+		if string(msg.Msg[0]) != "EVENT" || string(msg.Msg[1]) != "NONCE-ERROR" {
+			t.Fatalf("\tShould receive a nonce error, got %q. %s", msg.Msg, "X")
+		}
+		t.Log("\tShould receive a nonce error.", "OK")
+
+		if len(msg.Msg) != 5 || string(msg.Msg[4]) != "1" {
+			t.Fatalf("\tShould name the rejected nonce, got %q. %s", msg.Msg, "X")
+		}
+		t.Log("\tShould name the rejected nonce.", "OK")
+
+		bob.conn.SetReadDeadline(time.Now().Add(3 * testAckWait))
+		if _, _, err := bob.conn.ReadMessage(); err == nil {
+			t.Fatal("\tShould not receive the replayed message.", "X")
+		}
+		t.Log("\tShould not receive the replayed message.", "OK")
+	}
+}
+
+// TestGroupChanged provides a test of a membership change that races with
+// another change to the same group.
+func TestGroupChanged(t *testing.T) {
+	t.Log("Given the need to keep every membership change made at the same time.")
+	{
+		carol := newTestUser(t, "carol")
+
+		groups := &racingGroups{Memory: groupmgr.NewMemory(log), member: carol.id}
+
+		net := newTestNetwork()
+		net.groups = groups
+		capURL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		groupID := newTestUser(t, "group").id
+
+		alice.connect(t, capURL)
+		alice.sendGroup(t, groupID, "/group create friends")
+		alice.sendGroup(t, groupID, "/group invite "+bob.id.Hex())
+
+		var grp chatbus.Group
+		for range 50 {
+			var err error
+			grp, _, err = net.groups.Retrieve(context.Background(), groupID)
+			if err == nil && grp.IsMember(bob.id) {
+				break
+			}
+			time.Sleep(testAckWait / 10)
+		}
+
+		if !grp.IsMember(bob.id) {
+			t.Fatalf("\tShould add bob to the group, got %v. %s", grp.Members, "X")
+		}
+		t.Log("\tShould add bob to the group.", "OK")
+
+		if !grp.IsMember(carol.id) {
+			t.Fatalf("\tShould keep the member added at the same time, got %v. %s", grp.Members, "X")
+		}
+		t.Log("\tShould keep the member added at the same time.", "OK")
+	}
+}
+
+// TestRotation provides a test of messages to a rotated ID reaching the new
+// ID and the old ID no longer connecting.
+func TestRotation(t *testing.T) {
+	t.Log("Given the need to forward messages to a rotated ID.")
+	{
+		net := newTestNetwork()
+		capURL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+		oldBob := newTestUser(t, "bob")
+		newBob := newTestUser(t, "bob")
+
+		rot := chatbus.Rotation{
+			OldID:       oldBob.id,
+			NewID:       newBob.id,
+			DateCreated: time.Now().UTC(),
+		}
+
+		if err := net.rotates.Add(context.Background(), rot); err != nil {
+			t.Fatal("\tShould be able to add the rotation.", "X", err)
+		}
+
+		alice.connect(t, capURL)
+		newBob.connect(t, capURL)
+
+		alice.send(t, oldBob.id, "hello old bob")
+
+		msg := newBob.read(t)
+		if string(msg.Msg[0]) != "hello old bob" || msg.From.ID != alice.id {
+			t.Fatalf("\tShould receive the message sent to the old ID, got %q from %s. %s", msg.Msg[0], msg.From.ID, "X")
+		}
+		t.Log("\tShould forward the message to the new ID.", "OK")
+
+		if reply := oldBob.handshake(t, capURL); reply != "Identity Rotated" {
+			t.Fatalf("\tShould not let the old ID connect, got %q. %s", reply, "X")
+		}
+		t.Log("\tShould not let the old ID connect.", "OK")
+	}
+}
+
+// TestPumpClosed provides a test of the listener returning when the
+// connection was closed by the CAP rather than the client, like after the
+// write pump fails or a full queue disconnects the client.
+func TestPumpClosed(t *testing.T) {
+	t.Log("Given the need to stop listening once the CAP closes the connection.")
+	{
+		net := newTestNetwork()
+		bus := net.newBusiness(t)
+
+		returned := make(chan struct{})
+
+		h := func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.Background()
+
+			subjectID := common.HexToAddress(r.URL.Query().Get("id"))
+
+			usr, err := bus.UIHandshake(ctx, w, r, subjectID)
+			if err != nil {
+				return
+			}
+
+			// The pumps stop before the listener gets to read.
+			usr.UIConn.Close()
+
+			bus.UIListen(ctx, usr)
+			close(returned)
+		}
+
+		srv := httptest.NewServer(http.HandlerFunc(h))
+		t.Cleanup(srv.Close)
+
+		alice := newTestUser(t, "alice")
+		alice.connect(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+
+		select {
+		case <-returned:
+			t.Log("\tShould stop listening once the connection is closed.", "OK")
+
+		case <-time.After(5 * time.Second):
+			t.Fatal("\tShould stop listening once the connection is closed.", "X")
+		}
+	}
+}
+
+// TestDeliverFailed provides a test of a message that couldn't be written
+// to a user's connection still being delivered from the mailbox.
+func TestDeliverFailed(t *testing.T) {
+	t.Log("Given the need to deliver a message the connection failed to write.")
+	{
+		net := newTestNetwork()
+		cap1URL := net.startCAP(t)
+		cap2URL := net.startCAP(t)
+
+		bus := net.newBusiness(t)
+
+		closed := make(chan struct{})
+		done := make(chan struct{})
+		t.Cleanup(func() { close(done) })
+
+		// The connection is closed but the user stays registered with the
+		// CAP, so messages for the user fail to be written.
+		h := func(w http.ResponseWriter, r *http.Request) {
+			subjectID := common.HexToAddress(r.URL.Query().Get("id"))
+
+			usr, err := bus.UIHandshake(context.Background(), w, r, subjectID)
+			if err != nil {
+				return
+			}
+
+			usr.UIConn.Close()
+			close(closed)
+
+			<-done
+		}
+
+		srv := httptest.NewServer(http.HandlerFunc(h))
+		t.Cleanup(srv.Close)
+
+		alice := newTestUser(t, "alice")
+		bob := newTestUser(t, "bob")
+
+		bob.connect(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+		<-closed
+
+		alice.connect(t, cap1URL)
+		alice.send(t, bob.id, "try again")
+
+		// Give the CAP time to give up waiting for an ack.
+		time.Sleep(3 * testAckWait)
+
+		bob.connect(t, cap2URL)
+
+		msg := bob.read(t)
+		if string(msg.Msg[0]) != "try again" {
+			t.Fatalf("\tShould receive the message from the mailbox, got %q. %s", msg.Msg[0], "X")
+		}
+		t.Log("\tShould receive the message from the mailbox.", "OK")
+	}
+}
+
+// TestHandshakeLookups provides a test of the handshake failing closed when
+// the CAP can't check the user's rotation or record their presence.
+func TestHandshakeLookups(t *testing.T) {
+	t.Log("Given the need to reject a handshake the CAP can't complete.")
+	{
+		net := newTestNetwork()
+		net.rotates = brokenRotations{}
+		capURL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+
+		if reply := alice.handshake(t, capURL); reply != "Service Unavailable" {
+			t.Fatalf("\tShould reject the handshake when the rotation lookup fails, got %q. %s", reply, "X")
+		}
+		t.Log("\tShould reject the handshake when the rotation lookup fails.", "OK")
+
+		net = newTestNetwork()
+		net.presence = brokenPresence{}
+		capURL = net.startCAP(t)
+
+		if reply := alice.handshake(t, capURL); reply != "Service Unavailable" {
+			t.Fatalf("\tShould reject the handshake when the presence can't be set, got %q. %s", reply, "X")
+		}
+		t.Log("\tShould reject the handshake when the presence can't be set.", "OK")
+
+		if reply := alice.handshake(t, capURL); reply != "Service Unavailable" {
+			t.Fatalf("\tShould not keep the user the presence failed for, got %q. %s", reply, "X")
+		}
+		t.Log("\tShould not keep the user the presence failed for.", "OK")
+	}
+}
+
+// TestHandshakeMalformed provides a test of the CAP closing the connection
+// when the handshake can't be read.
+func TestHandshakeMalformed(t *testing.T) {
+	t.Log("Given the need to not hold connections with a bad handshake.")
+	{
+		net := newTestNetwork()
+		capURL := net.startCAP(t)
+
+		alice := newTestUser(t, "alice")
+
+		conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?id=%s", capURL, alice.id.Hex()), nil)
+		if err != nil {
+			t.Fatal("\tShould be able to dial the CAP.", "X", err)
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatal("\tShould be able to read the HELLO.", "X", err)
+		}
+
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+			t.Fatal("\tShould be able to send the handshake.", "X", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(3 * testAckWait))
+
+		_, _, err = conn.ReadMessage()
+		var netErr interface{ Timeout() bool }
+		if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+			t.Fatalf("\tShould close the connection, got %v. %s", err, "X")
+		}
+		t.Log("\tShould close the connection.", "OK")
+	}
+}
+
+// =============================================================================
+
+const testAckWait = 100 * time.Millisecond
+
+var log = logger.New(io.Discard, logger.LevelInfo, "TEST", func(context.Context) string { return "" })
+
+// testNetwork holds the storage shared by all the CAPs in a test.
+type testNetwork struct {
+	bus      *busmgr.Memory
+	mailbox  *mailboxmgr.Memory
+	presence chatbus.Presence
+	groups   chatbus.GroupManager
+	nonces   chatbus.NonceManager
+	rotates  chatbus.RotationManager
+}
+
+func newTestNetwork() *testNetwork {
+	return &testNetwork{
+		bus:      busmgr.NewMemory(),
+		mailbox:  mailboxmgr.NewMemory(log, 100, time.Hour),
+		presence: presencemgr.NewMemory(log, time.Minute),
+		groups:   groupmgr.NewMemory(log),
+		nonces:   noncemgr.NewMemory(log),
+		rotates:  rotatemgr.NewMemory(log, time.Hour),
+	}
+}
+
+// startCAP starts a CAP with a web socket endpoint and returns the URL. The
+// user id is passed in the query string in place of the JWT.
+func (net *testNetwork) startCAP(t *testing.T) string {
+	bus := net.newBusiness(t)
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		subjectID := common.HexToAddress(r.URL.Query().Get("id"))
+
+		usr, err := bus.UIHandshake(ctx, w, r, subjectID)
+		if err != nil {
+			return
+		}
+		defer usr.UIConn.Close()
+
+		bus.UIListen(ctx, usr)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(h))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// newBusiness constructs the chat business of a CAP sharing the network's
+// storage.
+func (net *testNetwork) newBusiness(t *testing.T) *chatbus.Business {
+	capID := uuid.New()
+
+	tcpCM, err := tcp.NewClientManager("TEST", tcp.ClientConfig{
+		Handlers: chatbus.NewClientHandlers(log),
+		Logger:   func(context.Context, string, string, string, string, string, ...any) {},
+	})
+	if err != nil {
+		t.Fatal("\tShould be able to create the tcp client manager.", "X", err)
+	}
+
+	cfg := chatbus.Config{
+		Log:       log,
+		Bus:       net.bus,
+		UICltMgr:  uicltmgr.New(log, net.presence, capID),
+		TCPCltMgr: tcpCM,
+		Mailbox:   net.mailbox,
+		Presence:  net.presence,
+		GroupMgr:  net.groups,
+		NonceMgr:  net.nonces,
+		RotateMgr: net.rotates,
+		UIConn: chatbus.UIConnConfig{
+			QueueSize:    16,
+			WriteTimeout: time.Second,
+			Overflow:     chatbus.OverflowDrop,
+		},
+		AckWait: testAckWait,
+		CAPID:   capID,
+	}
+
+	bus, err := chatbus.NewBusiness(cfg)
+	if err != nil {
+		t.Fatal("\tShould be able to create the chat business.", "X", err)
+	}
+
+	return bus
+}
+
+// =============================================================================
+
+type testMessage struct {
+	From struct {
+		ID    common.Address `json:"id"`
+		Name  string         `json:"name"`
+		Nonce uint64         `json:"nonce"`
+	} `json:"from"`
+	Msg [][]byte `json:"msg"`
+}
+
+type testUser struct {
+	id    common.Address
+	name  string
+	key   *ecdsa.PrivateKey
+	conn  *websocket.Conn
+	nonce uint64
+}
+
+func newTestUser(t *testing.T, name string) *testUser {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal("\tShould be able to generate a key.", "X", err)
+	}
+
+	return &testUser{
+		id:   crypto.PubkeyToAddress(key.PublicKey),
+		name: name,
+		key:  key,
+	}
+}
+
+func (u *testUser) connect(t *testing.T, capURL string) {
+	if msg := u.handshake(t, capURL); !strings.HasPrefix(msg, "WELCOME") {
+		t.Fatalf("\tShould receive a WELCOME, got %q. %s", msg, "X")
+	}
+}
+
+// handshake dials the CAP, answers the HELLO and returns the CAP's reply.
+func (u *testUser) handshake(t *testing.T, capURL string) string {
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?id=%s", capURL, u.id.Hex()), nil)
+	if err != nil {
+		t.Fatal("\tShould be able to dial the CAP.", "X", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	u.conn = conn
+
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal("\tShould be able to read the HELLO.", "X", err)
+	}
+
+	challenge, found := strings.CutPrefix(string(msg), "HELLO ")
+	if !found {
+		t.Fatalf("\tShould receive a HELLO, got %q. %s", msg, "X")
+	}
+
+	dataToSign := struct {
+		ID        common.Address
+		Challenge string
+	}{
+		ID:        u.id,
+		Challenge: challenge,
+	}
+
+	v, r, s, err := signature.Sign(dataToSign, u.key)
+	if err != nil {
+		t.Fatal("\tShould be able to sign the challenge.", "X", err)
+	}
+
+	hs := struct {
+		ID   common.Address `json:"id"`
+		Name string         `json:"name"`
+		V    *big.Int       `json:"v"`
+		R    *big.Int       `json:"r"`
+		S    *big.Int       `json:"s"`
+	}{
+		ID:   u.id,
+		Name: u.name,
+		V:    v,
+		R:    r,
+		S:    s,
+	}
+
+	if err := conn.WriteJSON(hs); err != nil {
+		t.Fatal("\tShould be able to send the handshake.", "X", err)
+	}
+
+	_, msg, err = conn.ReadMessage()
+	if err != nil {
+		t.Fatal("\tShould be able to read the reply.", "X", err)
+	}
+
+	return string(msg)
+}
+
+// send signs and sends the message and returns what was written so the test
+// can replay it.
+func (u *testUser) send(t *testing.T, toID common.Address, text string) []byte {
+	return u.sendTo(t, toID, false, text)
+}
+
+// sendGroup sends the text to the members of the group.
+func (u *testUser) sendGroup(t *testing.T, groupID common.Address, text string) []byte {
+	return u.sendTo(t, groupID, true, text)
+}
+
+func (u *testUser) sendTo(t *testing.T, toID common.Address, group bool, text string) []byte {
+	u.nonce++
+
+	msg := [][]byte{[]byte(text)}
+
+	dataToSign := struct {
+		ToID      common.Address
+		Msg       [][]byte
+		FromNonce uint64
+	}{
+		ToID:      toID,
+		Msg:       msg,
+		FromNonce: u.nonce,
+	}
+
+	v, r, s, err := signature.Sign(dataToSign, u.key)
+	if err != nil {
+		t.Fatal("\tShould be able to sign the message.", "X", err)
+	}
+
+	outMsg := struct {
+		ToID      common.Address `json:"toID"`
+		Group     bool           `json:"group,omitempty"`
+		Msg       [][]byte       `json:"msg"`
+		FromNonce uint64         `json:"fromNonce"`
+		V         *big.Int       `json:"v"`
+		R         *big.Int       `json:"r"`
+		S         *big.Int       `json:"s"`
+	}{
+		ToID:      toID,
+		Group:     group,
+		Msg:       msg,
+		FromNonce: u.nonce,
+		V:         v,
+		R:         r,
+		S:         s,
+	}
+
+	data, err := json.Marshal(outMsg)
+	if err != nil {
+		t.Fatal("\tShould be able to marshal the message.", "X", err)
+	}
+
+	if err := u.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatal("\tShould be able to send the message.", "X", err)
+	}
+
+	return data
+}
+
+func (u *testUser) read(t *testing.T) testMessage {
+	u.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msg testMessage
+	if err := u.conn.ReadJSON(&msg); err != nil {
+		t.Fatal("\tShould be able to read a message.", "X", err)
+	}
+
+	if len(msg.Msg) == 0 {
+		t.Fatal("\tShould receive a message with content.", "X")
+	}
+
+	return msg
+}
+
+// =============================================================================
+
+var errBroken = errors.New("broken")
+
+type brokenRotations struct{}
+
+func (brokenRotations) Add(ctx context.Context, rot chatbus.Rotation) error {
+	return errBroken
+}
+
+func (brokenRotations) Retrieve(ctx context.Context, oldID common.Address) (chatbus.Rotation, error) {
+	return chatbus.Rotation{}, errBroken
+}
+
+type brokenPresence struct{}
+
+func (brokenPresence) Set(ctx context.Context, userID common.Address, capID uuid.UUID) error {
+	return errBroken
+}
+
+func (brokenPresence) Remove(ctx context.Context, userID common.Address, capID uuid.UUID) error {
+	return errBroken
+}
+
+func (brokenPresence) Lookup(ctx context.Context, userID common.Address) (uuid.UUID, error) {
+	return uuid.UUID{}, errBroken
+}
+
+// brokenDelivered can't record the delivery of the first message in a
+// stream.
+type brokenDelivered struct {
+	*noncemgr.Memory
+}
+
+func (b brokenDelivered) Delivered(ctx context.Context, streamID string, nonce uint64) error {
+	if nonce == 1 {
+		return errBroken
+	}
+
+	return b.Memory.Delivered(ctx, streamID, nonce)
+}
+
+// racingGroups adds a member to the group just before the first update, as
+// if another CAP changed the group at the same time.
+type racingGroups struct {
+	*groupmgr.Memory
+	member common.Address
+	raced  bool
+}
+
+func (r *racingGroups) Update(ctx context.Context, grp chatbus.Group, revision uint64) error {
+	if !r.raced {
+		r.raced = true
+
+		other, rev, err := r.Memory.Retrieve(ctx, grp.ID)
+		if err != nil {
+			return err
+		}
+
+		other.Members = append(other.Members, r.member)
+
+		if err := r.Memory.Update(ctx, other, rev); err != nil {
+			return err
+		}
+	}
+
+	return r.Memory.Update(ctx, grp, revision)
+}
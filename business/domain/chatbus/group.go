@@ -0,0 +1,229 @@
+package chatbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Set of group commands a user can send to a group.
+const (
+	groupCmdCreate = "create"
+	groupCmdInvite = "invite"
+	groupCmdKick   = "kick"
+	groupCmdLeave  = "leave"
+)
+
+// groupMaxAttempts is how many times a membership change is tried again
+// when another request changed the group first.
+const groupMaxAttempts = 5
+
+// groupListen handles a signed message sent to a group. Group commands
+// change the membership of the group and normal messages are fanned out
+// to every member of the group.
+func (b *Business) groupListen(ctx context.Context, from UIUser, natsMsg Envelope) {
+	if len(natsMsg.Msg) == 0 || len(natsMsg.Msg[0]) == 0 {
+		return
+	}
+
+	var recipients []common.Address
+
+	switch {
+	case strings.HasPrefix(string(natsMsg.Msg[0]), "/group "):
+		members, err := b.groupOperation(ctx, from.ID, natsMsg.ToID, string(natsMsg.Msg[0]))
+		if err != nil {
+			b.log.Info(ctx, "group-listen: operation", "groupID", natsMsg.ToID, "ERROR", err)
+			b.groupSendError(ctx, from, natsMsg.ToID, err)
+			return
+		}
+
+		recipients = members
+
+	default:
+		grp, _, err := b.groupMgr.Retrieve(ctx, natsMsg.ToID)
+		if err != nil {
+			b.log.Info(ctx, "group-listen: retrieve", "groupID", natsMsg.ToID, "ERROR", err)
+			b.groupSendError(ctx, from, natsMsg.ToID, err)
+			return
+		}
+
+		if !grp.IsMember(from.ID) {
+			b.groupSendError(ctx, from, natsMsg.ToID, ErrNotGroupMember)
+			return
+		}
+
+		recipients = grp.Members
+	}
+
+	b.log.Info(ctx, "group-listen: fan out", "groupID", natsMsg.ToID, "from", from.ID, "members", len(recipients))
+
+	for _, member := range recipients {
+		if member == from.ID {
+			continue
+		}
+
+		msg := natsMsg
+		msg.Recipient = member
+
+		b.send(ctx, msg)
+	}
+}
+
+// groupOperation applies the group command and returns the set of users who
+// need to see the command.
+func (b *Business) groupOperation(ctx context.Context, fromID common.Address, groupID common.Address, cmd string) ([]common.Address, error) {
+	parts := strings.Fields(cmd)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid group command: %q", cmd)
+	}
+
+	if parts[1] == groupCmdCreate {
+		if len(parts) < 3 {
+			return nil, errors.New("group name missing")
+		}
+
+		grp := Group{
+			ID:          groupID,
+			Name:        strings.Join(parts[2:], " "),
+			Owner:       fromID,
+			Members:     []common.Address{fromID},
+			DateCreated: time.Now().UTC(),
+		}
+
+		if err := b.groupMgr.Create(ctx, grp); err != nil {
+			return nil, fmt.Errorf("create: %w", err)
+		}
+
+		return grp.Members, nil
+	}
+
+	for range groupMaxAttempts {
+		recipients, err := b.groupChange(ctx, fromID, groupID, parts)
+		if errors.Is(err, ErrGroupChanged) {
+			continue
+		}
+
+		return recipients, err
+	}
+
+	return nil, fmt.Errorf("too many concurrent changes to group %s", groupID)
+}
+
+// groupChange applies a command that changes an existing group. The group
+// is only written if nobody changed it since it was retrieved, otherwise
+// ErrGroupChanged is returned so the command can be applied again.
+func (b *Business) groupChange(ctx context.Context, fromID common.Address, groupID common.Address, parts []string) ([]common.Address, error) {
+	grp, revision, err := b.groupMgr.Retrieve(ctx, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve: %w", err)
+	}
+
+	switch parts[1] {
+	case groupCmdInvite, groupCmdKick:
+		if grp.Owner != fromID {
+			return nil, ErrNotGroupOwner
+		}
+
+		if len(parts) < 3 || !common.IsHexAddress(parts[2]) {
+			return nil, errors.New("member address missing")
+		}
+
+		member := common.HexToAddress(parts[2])
+
+		if parts[1] == groupCmdInvite {
+			if !grp.IsMember(member) {
+				grp.Members = append(grp.Members, member)
+			}
+
+			if err := b.groupMgr.Update(ctx, grp, revision); err != nil {
+				return nil, fmt.Errorf("update: %w", err)
+			}
+
+			return grp.Members, nil
+		}
+
+		if member == grp.Owner {
+			return nil, errors.New("owner can't be kicked")
+		}
+
+		// The kicked member needs to see the command as well.
+		recipients := slices.Clone(grp.Members)
+
+		grp.Members = slices.DeleteFunc(grp.Members, func(id common.Address) bool {
+			return id == member
+		})
+
+		if err := b.groupMgr.Update(ctx, grp, revision); err != nil {
+			return nil, fmt.Errorf("update: %w", err)
+		}
+
+		if !slices.Contains(recipients, member) {
+			recipients = append(recipients, member)
+		}
+
+		return recipients, nil
+
+	case groupCmdLeave:
+		if !grp.IsMember(fromID) {
+			return nil, ErrNotGroupMember
+		}
+
+		recipients := slices.Clone(grp.Members)
+
+		// When the owner leaves, the group is gone.
+		if fromID == grp.Owner {
+			if err := b.groupMgr.Delete(ctx, groupID, revision); err != nil {
+				return nil, fmt.Errorf("delete: %w", err)
+			}
+
+			return recipients, nil
+		}
+
+		grp.Members = slices.DeleteFunc(grp.Members, func(id common.Address) bool {
+			return id == fromID
+		})
+
+		if err := b.groupMgr.Update(ctx, grp, revision); err != nil {
+			return nil, fmt.Errorf("update: %w", err)
+		}
+
+		return recipients, nil
+	}
+
+	return nil, fmt.Errorf("unknown group command: %q", parts[1])
+}
+
+func (b *Business) groupSendError(ctx context.Context, to UIUser, groupID common.Address, err error) {
+	msg := [][]byte{[]byte("EVENT"), []byte("GROUP-ERROR"), []byte(groupID.Hex()), []byte(err.Error())}
+
+	if err := uiSendMessage(UIUser{}, to, 0, false, msg); err != nil {
+		b.log.Info(ctx, "group-listen: send error", "ERROR", err)
+	}
+}
+
+// groupCheckMember validates the recipient is a member of the group before
+// a group message is delivered to them.
+func groupCheckMember(ctx context.Context, groupMgr GroupManager, natsMsg Envelope) error {
+
+	// Group commands were validated by the sending CAP and need to reach
+	// members who were just removed from the group.
+	if len(natsMsg.Msg) > 0 && strings.HasPrefix(string(natsMsg.Msg[0]), "/group ") {
+		return nil
+	}
+
+	grp, _, err := groupMgr.Retrieve(ctx, natsMsg.ToID)
+	if err != nil {
+		return fmt.Errorf("retrieve: %w", err)
+	}
+
+	if !grp.IsMember(natsMsg.To()) {
+		return ErrNotGroupMember
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package chatbus
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+func mailboxStore(ctx context.Context, mailbox Mailbox, natsMsg Envelope) error {
+	data, err := json.Marshal(natsMsg)
+	if err != nil {
+		return fmt.Errorf("mailbox marshal message: %w", err)
+	}
+
+	if err := mailbox.Store(ctx, natsMsg.To(), natsMsg.MsgID(), data); err != nil {
+		return fmt.Errorf("mailbox store: %w", err)
+	}
+
+	return nil
+}
+
+// mailboxDrain sends the messages in the user's mailbox and then removes the
+// ones that were sent or can never be. Messages for the user are stored in
+// the mailbox until it's empty, so the drain reads it again until there is
+// nothing new. If a delivery fails, the messages stay held in the mailbox
+// for the next time the user connects so they still arrive in order.
+func (b *Business) mailboxDrain(ctx context.Context, usr UIUser) {
+	conn := usr.UIConn
+
+	tried := make(map[uint64]bool)
+
+	var stored, delivered int
+	for {
+		conn.drainMu.Lock()
+
+		msgs, err := b.mailbox.Read(ctx, usr.ID)
+		msgs = slices.DeleteFunc(msgs, func(msg MailboxMsg) bool {
+			return tried[msg.Seq]
+		})
+
+		if err != nil || len(msgs) == 0 {
+			conn.draining = false
+			conn.drainMu.Unlock()
+
+			if err != nil {
+				b.log.Info(ctx, "mailbox-drain", "ERROR", err)
+			}
+			break
+		}
+
+		conn.drainMu.Unlock()
+
+		for _, msg := range msgs {
+			tried[msg.Seq] = true
+		}
+
+		n, ok := b.mailboxDeliver(ctx, usr, msgs)
+
+		stored += len(msgs)
+		delivered += n
+
+		if !ok {
+			break
+		}
+	}
+
+	if stored == 0 {
+		return
+	}
+
+	b.log.Info(ctx, "mailbox-drain", "userID", usr.ID, "stored", stored, "delivered", delivered)
+
+	msg := [][]byte{[]byte("EVENT"), []byte("MAILBOX"), []byte(strconv.Itoa(delivered))}
+
+	if err := uiSendMessage(UIUser{}, usr, 0, false, msg); err != nil {
+		b.log.Info(ctx, "mailbox-drain: send event", "ERROR", err)
+	}
+}
+
+// mailboxDeliver sends the messages read from the mailbox and removes the
+// ones that were sent or were already delivered. A message too old to tell
+// if it was delivered is kept. It returns how many were sent and false if
+// the drain has to stop.
+func (b *Business) mailboxDeliver(ctx context.Context, usr UIUser, msgs []MailboxMsg) (int, bool) {
+	type stored struct {
+		seq     uint64
+		natsMsg Envelope
+	}
+
+	var remove []uint64
+
+	natsMsgs := make([]stored, 0, len(msgs))
+	for _, msg := range msgs {
+		var natsMsg Envelope
+		if err := json.Unmarshal(msg.Data, &natsMsg); err != nil {
+			b.log.Info(ctx, "mailbox-drain: unmarshal", "ERROR", err)
+			remove = append(remove, msg.Seq)
+			continue
+		}
+
+		natsMsgs = append(natsMsgs, stored{seq: msg.Seq, natsMsg: natsMsg})
+	}
+
+	// The client expects to see the nonces from each sender in order.
+	slices.SortStableFunc(natsMsgs, func(a, b stored) int {
+		return cmp.Compare(a.natsMsg.FromNonce, b.natsMsg.FromNonce)
+	})
+
+	// The mailbox can hold more messages than fit in the connection's queue
+	// so wait for the client to catch up instead of dropping messages. Once
+	// a send fails, or a delivery can't be recorded, the rest are kept so
+	// they arrive in order next time.
+	var delivered int
+	ok := true
+	for _, s := range natsMsgs {
+		msgID := s.natsMsg.MsgID()
+
+		if err := b.nonceMgr.Delivered(ctx, s.natsMsg.StreamID(), s.natsMsg.FromNonce); err != nil {
+			b.log.Info(ctx, "mailbox-drain: delivered", "msgID", msgID, "ERROR", err)
+
+			switch {
+			case errors.Is(err, ErrDuplicateDelivery):
+				remove = append(remove, s.seq)
+				continue
+
+			case errors.Is(err, ErrDeliveryTooOld):
+				continue
+			}
+
+			ok = false
+			break
+		}
+
+		if err := usr.UIConn.SendWait(ctx, s.natsMsg.outgoing()); err != nil {
+			b.log.Info(ctx, "mailbox-drain: send", "msgID", msgID, "ERROR", err)
+			if err := b.nonceMgr.Undelivered(ctx, s.natsMsg.StreamID(), s.natsMsg.FromNonce); err != nil {
+				b.log.Info(ctx, "mailbox-drain: undelivered", "msgID", msgID, "ERROR", err)
+			}
+
+			ok = false
+			break
+		}
+
+		remove = append(remove, s.seq)
+		delivered++
+	}
+
+	if err := b.mailbox.Remove(ctx, usr.ID, remove); err != nil {
+		b.log.Info(ctx, "mailbox-drain: remove", "ERROR", err)
+	}
+
+	return delivered, ok
+}
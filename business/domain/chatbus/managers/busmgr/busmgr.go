@@ -0,0 +1,132 @@
+// Package busmgr provides the message bus the CAPs use to send messages to
+// each other, backed by JetStream or kept in memory for a single process.
+package busmgr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config represents the configuration for the JetStream message bus.
+type Config struct {
+	Log     *logger.Logger
+	Conn    *nats.Conn
+	Subject string
+}
+
+// BusMgr provides a message bus backed by a JetStream stream. Messages are
+// published to the base subject when the user's location is unknown and to
+// <subject>.<capID> when we know which CAP owns the user.
+type BusMgr struct {
+	log     *logger.Logger
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	stream  jetstream.Stream
+	subject string
+}
+
+// New creates the message stream if it doesn't exist.
+func New(ctx context.Context, cfg Config) (*BusMgr, error) {
+	js, err := jetstream.New(cfg.Conn)
+	if err != nil {
+		return nil, fmt.Errorf("nats new js: %w", err)
+	}
+
+	// js.DeleteStream(ctx, subject)
+
+	s, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     cfg.Subject,
+		Subjects: []string{cfg.Subject, cfg.Subject + ".*"},
+		MaxAge:   24 * time.Hour,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create js: %w", err)
+	}
+
+	b := BusMgr{
+		log:     cfg.Log,
+		nc:      cfg.Conn,
+		js:      js,
+		stream:  s,
+		subject: cfg.Subject,
+	}
+
+	return &b, nil
+}
+
+// Publish sends the data to the specified CAP or to every CAP when the capID
+// is uuid.Nil.
+func (b *BusMgr) Publish(ctx context.Context, capID uuid.UUID, data []byte) error {
+	subject := b.subject
+	if capID != uuid.Nil {
+		subject = b.capSubject(capID)
+	}
+
+	if _, err := b.js.Publish(ctx, subject, data); err != nil {
+		return fmt.Errorf("nats publish: %w", err)
+	}
+
+	return nil
+}
+
+// Consume starts a durable consumer for the CAP that receives the messages
+// sent to every CAP and the messages sent to the CAP. Messages are acked
+// once the handler returns.
+func (b *BusMgr) Consume(ctx context.Context, capID uuid.UUID, handler func(data []byte)) error {
+	c, err := b.stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:        capID.String(),
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		DeliverPolicy:  jetstream.DeliverNewPolicy,
+		FilterSubjects: []string{b.subject, b.capSubject(capID)},
+	})
+	if err != nil {
+		return fmt.Errorf("nats create consumer: %w", err)
+	}
+
+	f := func(msg jetstream.Msg) {
+		defer msg.Ack()
+		handler(msg.Data())
+	}
+
+	if _, err := c.Consume(f, jetstream.PullMaxMessages(1)); err != nil {
+		return fmt.Errorf("nats consume: %w", err)
+	}
+
+	return nil
+}
+
+// PublishAck lets the specified CAP know the message was delivered.
+func (b *BusMgr) PublishAck(ctx context.Context, capID uuid.UUID, msgID string) error {
+	if err := b.nc.Publish(b.ackSubject(capID), []byte(msgID)); err != nil {
+		return fmt.Errorf("nats publish ack: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeAcks subscribes to the acks sent to the CAP.
+func (b *BusMgr) ConsumeAcks(ctx context.Context, capID uuid.UUID, handler func(msgID string)) error {
+	f := func(msg *nats.Msg) {
+		handler(string(msg.Data))
+	}
+
+	if _, err := b.nc.Subscribe(b.ackSubject(capID), f); err != nil {
+		return fmt.Errorf("nats subscribe acks: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BusMgr) capSubject(capID uuid.UUID) string {
+	return fmt.Sprintf("%s.%s", b.subject, capID)
+}
+
+func (b *BusMgr) ackSubject(capID uuid.UUID) string {
+	return fmt.Sprintf("%s.ack.%s", b.subject, capID)
+}
@@ -0,0 +1,100 @@
+package busmgr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Number of messages that can be waiting for a CAP before publishing blocks.
+const memoryQueueSize = 1024
+
+// Memory provides a message bus for CAPs running in the same process. Share
+// one value between the CAPs that need to talk to each other.
+type Memory struct {
+	mu        sync.RWMutex
+	consumers map[uuid.UUID]chan []byte
+	acks      map[uuid.UUID]func(msgID string)
+}
+
+// NewMemory constructs an in memory message bus.
+func NewMemory() *Memory {
+	return &Memory{
+		consumers: make(map[uuid.UUID]chan []byte),
+		acks:      make(map[uuid.UUID]func(msgID string)),
+	}
+}
+
+// Publish sends the data to the specified CAP or to every CAP when the capID
+// is uuid.Nil. Messages for a CAP that isn't consuming are dropped.
+func (m *Memory) Publish(ctx context.Context, capID uuid.UUID, data []byte) error {
+	var queues []chan []byte
+
+	func() {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		if capID != uuid.Nil {
+			if ch, exists := m.consumers[capID]; exists {
+				queues = append(queues, ch)
+			}
+			return
+		}
+
+		for _, ch := range m.consumers {
+			queues = append(queues, ch)
+		}
+	}()
+
+	for _, ch := range queues {
+		select {
+		case ch <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Consume registers the handler for the CAP. Messages are handled one at a
+// time in the order they were published.
+func (m *Memory) Consume(ctx context.Context, capID uuid.UUID, handler func(data []byte)) error {
+	ch := make(chan []byte, memoryQueueSize)
+
+	m.mu.Lock()
+	m.consumers[capID] = ch
+	m.mu.Unlock()
+
+	go func() {
+		for data := range ch {
+			handler(data)
+		}
+	}()
+
+	return nil
+}
+
+// PublishAck lets the specified CAP know the message was delivered.
+func (m *Memory) PublishAck(ctx context.Context, capID uuid.UUID, msgID string) error {
+	m.mu.RLock()
+	handler, exists := m.acks[capID]
+	m.mu.RUnlock()
+
+	if exists {
+		go handler(msgID)
+	}
+
+	return nil
+}
+
+// ConsumeAcks registers the handler for the acks sent to the CAP.
+func (m *Memory) ConsumeAcks(ctx context.Context, capID uuid.UUID, handler func(msgID string)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.acks[capID] = handler
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// Package groupmgr provides group membership storage for the chatbus
+// service, backed by a JetStream key value bucket.
+package groupmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config represents the configuration for the group storage.
+type Config struct {
+	Log     *logger.Logger
+	JS      jetstream.JetStream
+	Subject string
+}
+
+// GroupMgr provides group membership storage shared by all the CAPs.
+type GroupMgr struct {
+	log *logger.Logger
+	kv  jetstream.KeyValue
+}
+
+// New creates the group bucket if it doesn't exist.
+func New(ctx context.Context, cfg Config) (*GroupMgr, error) {
+	kv, err := cfg.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: cfg.Subject + "-groups",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create groups bucket: %w", err)
+	}
+
+	g := GroupMgr{
+		log: cfg.Log,
+		kv:  kv,
+	}
+
+	return &g, nil
+}
+
+// Create adds a new group to the storage.
+func (g *GroupMgr) Create(ctx context.Context, grp chatbus.Group) error {
+	data, err := json.Marshal(grp)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if _, err := g.kv.Create(ctx, grp.ID.Hex(), data); err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return chatbus.ErrGroupExists
+		}
+		return fmt.Errorf("create: %w", err)
+	}
+
+	g.log.Debug(ctx, "group-create", "id", grp.ID, "name", grp.Name, "owner", grp.Owner)
+
+	return nil
+}
+
+// Update replaces the group in the storage if it's still at the revision
+// it was retrieved at. ErrGroupChanged is returned if it isn't.
+func (g *GroupMgr) Update(ctx context.Context, grp chatbus.Group, revision uint64) error {
+	data, err := json.Marshal(grp)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if _, err := g.kv.Update(ctx, grp.ID.Hex(), data, revision); err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return chatbus.ErrGroupChanged
+		}
+		return fmt.Errorf("update: %w", err)
+	}
+
+	g.log.Debug(ctx, "group-update", "id", grp.ID, "members", len(grp.Members))
+
+	return nil
+}
+
+// Delete removes the group from the storage if it's still at the revision
+// it was retrieved at. ErrGroupChanged is returned if it isn't.
+func (g *GroupMgr) Delete(ctx context.Context, groupID common.Address, revision uint64) error {
+	if err := g.kv.Delete(ctx, groupID.Hex(), jetstream.LastRevision(revision)); err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return chatbus.ErrGroupChanged
+		}
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	g.log.Debug(ctx, "group-delete", "id", groupID)
+
+	return nil
+}
+
+// Retrieve retrieves a group from the storage along with its revision.
+func (g *GroupMgr) Retrieve(ctx context.Context, groupID common.Address) (chatbus.Group, uint64, error) {
+	entry, err := g.kv.Get(ctx, groupID.Hex())
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return chatbus.Group{}, 0, chatbus.ErrGroupNotExists
+		}
+		return chatbus.Group{}, 0, fmt.Errorf("get: %w", err)
+	}
+
+	var grp chatbus.Group
+	if err := json.Unmarshal(entry.Value(), &grp); err != nil {
+		return chatbus.Group{}, 0, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return grp, entry.Revision(), nil
+}
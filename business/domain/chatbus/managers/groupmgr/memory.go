@@ -0,0 +1,109 @@
+package groupmgr
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// entry is a group along with the revision it was last written at.
+type entry struct {
+	grp      chatbus.Group
+	revision uint64
+}
+
+// Memory provides group membership storage for CAPs running in the same
+// process.
+type Memory struct {
+	log      *logger.Logger
+	mu       sync.RWMutex
+	groups   map[common.Address]entry
+	revision uint64
+}
+
+// NewMemory constructs an in memory group storage.
+func NewMemory(log *logger.Logger) *Memory {
+	return &Memory{
+		log:    log,
+		groups: make(map[common.Address]entry),
+	}
+}
+
+// Create adds a new group to the storage.
+func (m *Memory) Create(ctx context.Context, grp chatbus.Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.groups[grp.ID]; exists {
+		return chatbus.ErrGroupExists
+	}
+
+	m.put(grp)
+
+	m.log.Debug(ctx, "group-create", "id", grp.ID, "name", grp.Name, "owner", grp.Owner)
+
+	return nil
+}
+
+// Update replaces the group in the storage if it's still at the revision
+// it was retrieved at. ErrGroupChanged is returned if it isn't.
+func (m *Memory) Update(ctx context.Context, grp chatbus.Group, revision uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.groups[grp.ID].revision != revision {
+		return chatbus.ErrGroupChanged
+	}
+
+	m.put(grp)
+
+	m.log.Debug(ctx, "group-update", "id", grp.ID, "members", len(grp.Members))
+
+	return nil
+}
+
+// Delete removes the group from the storage if it's still at the revision
+// it was retrieved at. ErrGroupChanged is returned if it isn't.
+func (m *Memory) Delete(ctx context.Context, groupID common.Address, revision uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.groups[groupID].revision != revision {
+		return chatbus.ErrGroupChanged
+	}
+
+	delete(m.groups, groupID)
+
+	m.log.Debug(ctx, "group-delete", "id", groupID)
+
+	return nil
+}
+
+// Retrieve retrieves a group from the storage along with its revision.
+func (m *Memory) Retrieve(ctx context.Context, groupID common.Address) (chatbus.Group, uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, exists := m.groups[groupID]
+	if !exists {
+		return chatbus.Group{}, 0, chatbus.ErrGroupNotExists
+	}
+
+	grp := e.grp
+	grp.Members = slices.Clone(grp.Members)
+
+	return grp, e.revision, nil
+}
+
+// put stores a copy of the group at the next revision. The caller must hold
+// the write lock.
+func (m *Memory) put(grp chatbus.Group) {
+	m.revision++
+
+	grp.Members = slices.Clone(grp.Members)
+	m.groups[grp.ID] = entry{grp: grp, revision: m.revision}
+}
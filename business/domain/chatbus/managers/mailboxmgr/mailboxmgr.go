@@ -0,0 +1,112 @@
+// Package mailboxmgr provides a JetStream based mailbox for holding messages
+// for users that are not connected to any CAP.
+package mailboxmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config represents the configuration for the mailbox.
+type Config struct {
+	Log     *logger.Logger
+	JS      jetstream.JetStream
+	Subject string
+	MaxMsgs int64
+	MaxAge  time.Duration
+}
+
+// MailboxMgr provides a per user mailbox backed by a JetStream stream. Each
+// user gets their own subject inside the stream.
+type MailboxMgr struct {
+	log     *logger.Logger
+	js      jetstream.JetStream
+	stream  jetstream.Stream
+	subject string
+}
+
+// New creates the mailbox stream if it doesn't exist.
+func New(ctx context.Context, cfg Config) (*MailboxMgr, error) {
+	name := cfg.Subject + "-mailbox"
+
+	s, err := cfg.JS.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:              name,
+		Subjects:          []string{name + ".*"},
+		MaxAge:            cfg.MaxAge,
+		MaxMsgsPerSubject: cfg.MaxMsgs,
+		Discard:           jetstream.DiscardOld,
+		Duplicates:        2 * time.Minute,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create mailbox stream: %w", err)
+	}
+
+	m := MailboxMgr{
+		log:     cfg.Log,
+		js:      cfg.JS,
+		stream:  s,
+		subject: name,
+	}
+
+	return &m, nil
+}
+
+// Store adds the message to the user's mailbox. The message id is used by
+// JetStream to drop duplicate stores from multiple CAPs.
+func (m *MailboxMgr) Store(ctx context.Context, toID common.Address, msgID string, data []byte) error {
+	if _, err := m.js.Publish(ctx, m.userSubject(toID), data, jetstream.WithMsgID(msgID)); err != nil {
+		return fmt.Errorf("mailbox publish: %w", err)
+	}
+
+	m.log.Debug(ctx, "mailbox-store", "toID", toID, "msgID", msgID)
+
+	return nil
+}
+
+// Read returns all the messages in the user's mailbox in the order they were
+// stored. The messages stay in the mailbox until they are removed.
+func (m *MailboxMgr) Read(ctx context.Context, toID common.Address) ([]chatbus.MailboxMsg, error) {
+	subject := m.userSubject(toID)
+
+	var msgs []chatbus.MailboxMsg
+
+	for seq := uint64(1); ; {
+		msg, err := m.stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(subject))
+		if err != nil {
+			if errors.Is(err, jetstream.ErrMsgNotFound) {
+				break
+			}
+			return nil, fmt.Errorf("mailbox get: %w", err)
+		}
+
+		msgs = append(msgs, chatbus.MailboxMsg{Seq: msg.Sequence, Data: msg.Data})
+		seq = msg.Sequence + 1
+	}
+
+	return msgs, nil
+}
+
+// Remove removes the messages with the sequences from the user's mailbox.
+// Messages that are already gone are ignored.
+func (m *MailboxMgr) Remove(ctx context.Context, toID common.Address, seqs []uint64) error {
+	for _, seq := range seqs {
+		if err := m.stream.DeleteMsg(ctx, seq); err != nil && !errors.Is(err, jetstream.ErrMsgNotFound) {
+			return fmt.Errorf("mailbox delete: %w", err)
+		}
+	}
+
+	m.log.Debug(ctx, "mailbox-remove", "toID", toID, "count", len(seqs))
+
+	return nil
+}
+
+func (m *MailboxMgr) userSubject(id common.Address) string {
+	return fmt.Sprintf("%s.%s", m.subject, id.Hex())
+}
@@ -0,0 +1,105 @@
+package mailboxmgr
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type memoryMsg struct {
+	seq    uint64
+	msgID  string
+	data   []byte
+	stored time.Time
+}
+
+// Memory provides a per user mailbox for CAPs running in the same process.
+type Memory struct {
+	log     *logger.Logger
+	maxMsgs int64
+	maxAge  time.Duration
+	mu      sync.Mutex
+	boxes   map[common.Address][]memoryMsg
+	seq     uint64
+}
+
+// NewMemory constructs an in memory mailbox. Each user's mailbox keeps the
+// newest maxMsgs messages that are younger than maxAge.
+func NewMemory(log *logger.Logger, maxMsgs int64, maxAge time.Duration) *Memory {
+	return &Memory{
+		log:     log,
+		maxMsgs: maxMsgs,
+		maxAge:  maxAge,
+		boxes:   make(map[common.Address][]memoryMsg),
+	}
+}
+
+// Store adds the message to the user's mailbox. The message id is used to
+// drop duplicate stores from multiple CAPs.
+func (m *Memory) Store(ctx context.Context, toID common.Address, msgID string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	box := m.boxes[toID]
+
+	if slices.ContainsFunc(box, func(msg memoryMsg) bool { return msg.msgID == msgID }) {
+		return nil
+	}
+
+	m.seq++
+	box = append(box, memoryMsg{seq: m.seq, msgID: msgID, data: data, stored: time.Now()})
+
+	if m.maxMsgs > 0 && int64(len(box)) > m.maxMsgs {
+		box = box[int64(len(box))-m.maxMsgs:]
+	}
+
+	m.boxes[toID] = box
+
+	m.log.Debug(ctx, "mailbox-store", "toID", toID, "msgID", msgID)
+
+	return nil
+}
+
+// Read returns all the messages in the user's mailbox in the order they were
+// stored. The messages stay in the mailbox until they are removed.
+func (m *Memory) Read(ctx context.Context, toID common.Address) ([]chatbus.MailboxMsg, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var msgs []chatbus.MailboxMsg
+	for _, msg := range m.boxes[toID] {
+		if m.maxAge > 0 && time.Since(msg.stored) > m.maxAge {
+			continue
+		}
+
+		msgs = append(msgs, chatbus.MailboxMsg{Seq: msg.seq, Data: msg.data})
+	}
+
+	return msgs, nil
+}
+
+// Remove removes the messages with the sequences from the user's mailbox.
+// Messages that are already gone are ignored.
+func (m *Memory) Remove(ctx context.Context, toID common.Address, seqs []uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	box := slices.DeleteFunc(m.boxes[toID], func(msg memoryMsg) bool {
+		return slices.Contains(seqs, msg.seq)
+	})
+
+	if len(box) == 0 {
+		delete(m.boxes, toID)
+	} else {
+		m.boxes[toID] = box
+	}
+
+	m.log.Debug(ctx, "mailbox-remove", "toID", toID, "count", len(seqs))
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package noncemgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type memoryKey struct {
+	fromID common.Address
+	toID   common.Address
+}
+
+// Memory tracks nonces and delivered messages for CAPs running in the same
+// process.
+type Memory struct {
+	log       *logger.Logger
+	mu        sync.Mutex
+	nonces    map[memoryKey]uint64
+	delivered map[string]window
+}
+
+// NewMemory constructs an in memory nonce storage.
+func NewMemory(log *logger.Logger) *Memory {
+	return &Memory{
+		log:       log,
+		nonces:    make(map[memoryKey]uint64),
+		delivered: make(map[string]window),
+	}
+}
+
+// Accept records the nonce as the last nonce used by the sender for the
+// recipient. The nonce must be greater than the last accepted nonce.
+func (m *Memory) Accept(ctx context.Context, fromID common.Address, toID common.Address, nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryKey{fromID: fromID, toID: toID}
+
+	if last, exists := m.nonces[key]; exists && nonce <= last {
+		m.log.Info(ctx, "nonce-accept", "status", "stale nonce", "from", fromID, "to", toID, "nonce", nonce, "last", last)
+		return fmt.Errorf("%w: got %d, last %d", chatbus.ErrStaleNonce, nonce, last)
+	}
+
+	m.nonces[key] = nonce
+
+	return nil
+}
+
+// LastNonces returns the last nonce accepted from the sender for every
+// recipient.
+func (m *Memory) LastNonces(ctx context.Context, fromID common.Address) (map[common.Address]uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nonces := make(map[common.Address]uint64)
+	for key, nonce := range m.nonces {
+		if key.fromID == fromID {
+			nonces[key.toID] = nonce
+		}
+	}
+
+	return nonces, nil
+}
+
+// Delivered records the nonce as delivered in the stream. If the message
+// was already delivered ErrDuplicateDelivery is returned, and if it's too
+// old to tell ErrDeliveryTooOld is returned.
+func (m *Memory) Delivered(ctx context.Context, streamID string, nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := m.delivered[streamID]
+
+	if err := w.mark(nonce); err != nil {
+		return err
+	}
+
+	m.delivered[streamID] = w
+
+	return nil
+}
+
+// Undelivered forgets the message was delivered, for when sending it to the
+// user failed after it was recorded.
+func (m *Memory) Undelivered(ctx context.Context, streamID string, nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, exists := m.delivered[streamID]
+	if !exists {
+		return nil
+	}
+
+	w.unmark(nonce)
+	m.delivered[streamID] = w
+
+	return nil
+}
@@ -0,0 +1,206 @@
+// Package noncemgr provides nonce tracking for the chatbus service, backed
+// by JetStream key value buckets.
+package noncemgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Number of times an update is attempted when another CAP is updating the
+// same nonce at the same time.
+const maxAttempts = 5
+
+// Config represents the configuration for the nonce storage.
+type Config struct {
+	Log     *logger.Logger
+	JS      jetstream.JetStream
+	Subject string
+}
+
+// NonceMgr tracks the last nonce accepted for every sender and recipient
+// pair and the messages that have been delivered, shared by all the CAPs.
+type NonceMgr struct {
+	log       *logger.Logger
+	nonces    jetstream.KeyValue
+	delivered jetstream.KeyValue
+}
+
+// New creates the nonce buckets if they don't exist.
+func New(ctx context.Context, cfg Config) (*NonceMgr, error) {
+	nonces, err := cfg.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: cfg.Subject + "-nonces",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create nonces bucket: %w", err)
+	}
+
+	delivered, err := cfg.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: cfg.Subject + "-received",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create received bucket: %w", err)
+	}
+
+	n := NonceMgr{
+		log:       cfg.Log,
+		nonces:    nonces,
+		delivered: delivered,
+	}
+
+	return &n, nil
+}
+
+// Accept records the nonce as the last nonce used by the sender for the
+// recipient. The nonce must be greater than the last accepted nonce.
+func (n *NonceMgr) Accept(ctx context.Context, fromID common.Address, toID common.Address, nonce uint64) error {
+	key := fmt.Sprintf("%s.%s", fromID.Hex(), toID.Hex())
+	value := []byte(strconv.FormatUint(nonce, 10))
+
+	for range maxAttempts {
+		entry, err := n.nonces.Get(ctx, key)
+		if err != nil {
+			if !errors.Is(err, jetstream.ErrKeyNotFound) {
+				return fmt.Errorf("get: %w", err)
+			}
+
+			if _, err := n.nonces.Create(ctx, key, value); err != nil {
+				if errors.Is(err, jetstream.ErrKeyExists) {
+					continue
+				}
+				return fmt.Errorf("create: %w", err)
+			}
+
+			return nil
+		}
+
+		last, err := strconv.ParseUint(string(entry.Value()), 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse: %w", err)
+		}
+
+		if nonce <= last {
+			n.log.Info(ctx, "nonce-accept", "status", "stale nonce", "from", fromID, "to", toID, "nonce", nonce, "last", last)
+			return fmt.Errorf("%w: got %d, last %d", chatbus.ErrStaleNonce, nonce, last)
+		}
+
+		if _, err := n.nonces.Update(ctx, key, value, entry.Revision()); err != nil {
+			if errors.Is(err, jetstream.ErrKeyExists) {
+				continue
+			}
+			return fmt.Errorf("update: %w", err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("accept: too many concurrent updates for %s", key)
+}
+
+// LastNonces returns the last nonce accepted from the sender for every
+// recipient.
+func (n *NonceMgr) LastNonces(ctx context.Context, fromID common.Address) (map[common.Address]uint64, error) {
+	lister, err := n.nonces.ListKeysFiltered(ctx, fromID.Hex()+".*")
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	defer lister.Stop()
+
+	nonces := make(map[common.Address]uint64)
+	for key := range lister.Keys() {
+		entry, err := n.nonces.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("get: %w", err)
+		}
+
+		nonce, err := strconv.ParseUint(string(entry.Value()), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse: %w", err)
+		}
+
+		_, toID, _ := strings.Cut(key, ".")
+		nonces[common.HexToAddress(toID)] = nonce
+	}
+
+	return nonces, nil
+}
+
+// Delivered records the nonce as delivered in the stream. If the message
+// was already delivered ErrDuplicateDelivery is returned, and if it's too
+// old to tell ErrDeliveryTooOld is returned.
+func (n *NonceMgr) Delivered(ctx context.Context, streamID string, nonce uint64) error {
+	return n.updateWindow(ctx, streamID, func(w *window) error {
+		return w.mark(nonce)
+	})
+}
+
+// Undelivered forgets the message was delivered, for when sending it to the
+// user failed after it was recorded.
+func (n *NonceMgr) Undelivered(ctx context.Context, streamID string, nonce uint64) error {
+	return n.updateWindow(ctx, streamID, func(w *window) error {
+		w.unmark(nonce)
+		return nil
+	})
+}
+
+// =============================================================================
+
+// updateWindow applies the change to the stream's window, trying again when
+// another CAP changed it at the same time.
+func (n *NonceMgr) updateWindow(ctx context.Context, streamID string, change func(w *window) error) error {
+	for range maxAttempts {
+		var w window
+		var revision uint64
+
+		entry, err := n.delivered.Get(ctx, streamID)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(entry.Value(), &w); err != nil {
+				return fmt.Errorf("unmarshal: %w", err)
+			}
+			revision = entry.Revision()
+
+		case !errors.Is(err, jetstream.ErrKeyNotFound):
+			return fmt.Errorf("get: %w", err)
+		}
+
+		if err := change(&w); err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(w)
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+
+		switch revision {
+		case 0:
+			_, err = n.delivered.Create(ctx, streamID, value)
+		default:
+			_, err = n.delivered.Update(ctx, streamID, value, revision)
+		}
+
+		if err != nil {
+			if errors.Is(err, jetstream.ErrKeyExists) {
+				continue
+			}
+			return fmt.Errorf("update: %w", err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("delivered: too many concurrent updates for %s", streamID)
+}
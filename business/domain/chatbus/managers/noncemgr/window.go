@@ -0,0 +1,69 @@
+package noncemgr
+
+import (
+	"fmt"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+)
+
+// windowSize is how far behind the newest delivered nonce a message can
+// arrive and still be delivered, for messages that took a slower path like
+// the mailbox.
+const windowSize = 64
+
+// window tracks the nonces delivered in a stream: the highest nonce and a
+// bit for each of the windowSize nonces up to it. Anything older is never
+// delivered, so an envelope can't be delivered twice however late it's sent
+// again.
+type window struct {
+	Highest uint64 `json:"highest"`
+	Seen    uint64 `json:"seen"`
+}
+
+// mark records the nonce as delivered. ErrDuplicateDelivery is returned
+// if it was already delivered and ErrDeliveryTooOld if it's too old to tell.
+func (w *window) mark(nonce uint64) error {
+	if nonce > w.Highest {
+		shift := nonce - w.Highest
+
+		switch {
+		case shift >= windowSize:
+			w.Seen = 0
+		default:
+			w.Seen <<= shift
+		}
+
+		w.Seen |= 1
+		w.Highest = nonce
+
+		return nil
+	}
+
+	age := w.Highest - nonce
+	if age >= windowSize {
+		return fmt.Errorf("%w: nonce %d is more than %d behind %d", chatbus.ErrDeliveryTooOld, nonce, windowSize, w.Highest)
+	}
+
+	bit := uint64(1) << age
+	if w.Seen&bit != 0 {
+		return chatbus.ErrDuplicateDelivery
+	}
+
+	w.Seen |= bit
+
+	return nil
+}
+
+// unmark forgets the nonce was delivered.
+func (w *window) unmark(nonce uint64) {
+	if nonce > w.Highest {
+		return
+	}
+
+	age := w.Highest - nonce
+	if age >= windowSize {
+		return
+	}
+
+	w.Seen &^= uint64(1) << age
+}
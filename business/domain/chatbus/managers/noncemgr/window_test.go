@@ -0,0 +1,148 @@
+package noncemgr
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+)
+
+func TestWindow(t *testing.T) {
+	type step struct {
+		nonce   uint64
+		unmark  bool
+		dupe    bool
+		old     bool
+		highest uint64
+	}
+
+	tt := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "in order",
+			steps: []step{
+				{nonce: 1, highest: 1},
+				{nonce: 2, highest: 2},
+				{nonce: 3, highest: 3},
+			},
+		},
+		{
+			name: "duplicate",
+			steps: []step{
+				{nonce: 1, highest: 1},
+				{nonce: 2, highest: 2},
+				{nonce: 2, dupe: true, highest: 2},
+				{nonce: 1, dupe: true, highest: 2},
+			},
+		},
+		{
+			name: "out of order inside the window",
+			steps: []step{
+				{nonce: 10, highest: 10},
+				{nonce: 5, highest: 10},
+				{nonce: 7, highest: 10},
+				{nonce: 5, dupe: true, highest: 10},
+				{nonce: 9, highest: 10},
+			},
+		},
+		{
+			name: "oldest nonce in the window",
+			steps: []step{
+				{nonce: windowSize, highest: windowSize},
+				{nonce: 1, highest: windowSize},
+				{nonce: 1, dupe: true, highest: windowSize},
+			},
+		},
+		{
+			name: "older than the window",
+			steps: []step{
+				{nonce: windowSize + 1, highest: windowSize + 1},
+				{nonce: 1, old: true, highest: windowSize + 1},
+				{nonce: 2, highest: windowSize + 1},
+			},
+		},
+		{
+			name: "shift inside the window",
+			steps: []step{
+				{nonce: 1, highest: 1},
+				{nonce: 40, highest: 40},
+				{nonce: 1, dupe: true, highest: 40},
+				{nonce: 20, highest: 40},
+			},
+		},
+		{
+			name: "shift past the window",
+			steps: []step{
+				{nonce: 1, highest: 1},
+				{nonce: 2, highest: 2},
+				{nonce: 2 + windowSize, highest: 2 + windowSize},
+				{nonce: 3, highest: 2 + windowSize},
+				{nonce: 2, old: true, highest: 2 + windowSize},
+				{nonce: 2 + windowSize, dupe: true, highest: 2 + windowSize},
+			},
+		},
+		{
+			name: "shift far past the window",
+			steps: []step{
+				{nonce: 5, highest: 5},
+				{nonce: 1_000_000, highest: 1_000_000},
+				{nonce: 5, old: true, highest: 1_000_000},
+				{nonce: 1_000_000 - windowSize + 1, highest: 1_000_000},
+			},
+		},
+		{
+			name: "unmark after a failed delivery",
+			steps: []step{
+				{nonce: 1, highest: 1},
+				{nonce: 2, highest: 2},
+				{nonce: 1, unmark: true, highest: 2},
+				{nonce: 1, highest: 2},
+				{nonce: 2, unmark: true, highest: 2},
+				{nonce: 2, highest: 2},
+				{nonce: 2, dupe: true, highest: 2},
+			},
+		},
+		{
+			name: "unmark outside the window",
+			steps: []step{
+				{nonce: 1, highest: 1},
+				{nonce: 100, highest: 100},
+				{nonce: 1, unmark: true, highest: 100},
+				{nonce: 1, old: true, highest: 100},
+				{nonce: 101, unmark: true, highest: 100},
+				{nonce: 101, highest: 101},
+			},
+		},
+	}
+
+	for _, tst := range tt {
+		t.Run(tst.name, func(t *testing.T) {
+			var w window
+
+			for i, s := range tst.steps {
+				switch {
+				case s.unmark:
+					w.unmark(s.nonce)
+
+				default:
+					err := w.mark(s.nonce)
+
+					switch {
+					case s.dupe && !errors.Is(err, chatbus.ErrDuplicateDelivery):
+						t.Fatalf("step %d: nonce %d: got %v, exp ErrDuplicateDelivery", i, s.nonce, err)
+					case s.old && !errors.Is(err, chatbus.ErrDeliveryTooOld):
+						t.Fatalf("step %d: nonce %d: got %v, exp ErrDeliveryTooOld", i, s.nonce, err)
+					case !s.dupe && !s.old && err != nil:
+						t.Fatalf("step %d: nonce %d: got %v, exp delivered", i, s.nonce, err)
+					}
+				}
+
+				if w.Highest != s.highest {
+					t.Fatalf("step %d: nonce %d: got highest %d, exp %d", i, s.nonce, w.Highest, s.highest)
+				}
+			}
+		})
+	}
+}
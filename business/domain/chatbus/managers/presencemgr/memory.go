@@ -0,0 +1,83 @@
+package presencemgr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+)
+
+type memoryEntry struct {
+	capID   uuid.UUID
+	expires time.Time
+}
+
+// Memory provides the presence directory for CAPs running in the same
+// process.
+type Memory struct {
+	log     *logger.Logger
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[common.Address]memoryEntry
+}
+
+// NewMemory constructs an in memory presence directory. Entries expire after
+// the TTL unless they are heartbeated.
+func NewMemory(log *logger.Logger, ttl time.Duration) *Memory {
+	return &Memory{
+		log:     log,
+		ttl:     ttl,
+		entries: make(map[common.Address]memoryEntry),
+	}
+}
+
+// Set records the user as connected to the specified CAP. Calling Set again
+// refreshes the entry's TTL.
+func (m *Memory) Set(ctx context.Context, userID common.Address, capID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[userID] = memoryEntry{
+		capID:   capID,
+		expires: time.Now().Add(m.ttl),
+	}
+
+	m.log.Debug(ctx, "presence-set", "userID", userID, "capID", capID)
+
+	return nil
+}
+
+// Remove removes the user from the directory if the user is still owned by
+// the specified CAP.
+func (m *Memory) Remove(ctx context.Context, userID common.Address, capID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.entries[userID]
+	if !exists || entry.capID != capID {
+		return nil
+	}
+
+	delete(m.entries, userID)
+
+	m.log.Debug(ctx, "presence-remove", "userID", userID, "capID", capID)
+
+	return nil
+}
+
+// Lookup returns the id of the CAP the user is connected to.
+func (m *Memory) Lookup(ctx context.Context, userID common.Address) (uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, exists := m.entries[userID]
+	if !exists || time.Now().After(entry.expires) {
+		return uuid.UUID{}, chatbus.ErrNotExists
+	}
+
+	return entry.capID, nil
+}
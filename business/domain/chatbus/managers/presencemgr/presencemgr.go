@@ -0,0 +1,104 @@
+// Package presencemgr provides a cluster wide directory of which CAP a user
+// is connected to, backed by a JetStream key value bucket.
+package presencemgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config represents the configuration for the presence directory.
+type Config struct {
+	Log     *logger.Logger
+	JS      jetstream.JetStream
+	Subject string
+	TTL     time.Duration
+}
+
+// PresenceMgr maps user addresses to the CAP that owns their connection.
+// Entries expire after the TTL unless they are heartbeated.
+type PresenceMgr struct {
+	log *logger.Logger
+	kv  jetstream.KeyValue
+}
+
+// New creates the presence bucket if it doesn't exist.
+func New(ctx context.Context, cfg Config) (*PresenceMgr, error) {
+	kv, err := cfg.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: cfg.Subject + "-presence",
+		TTL:    cfg.TTL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create presence bucket: %w", err)
+	}
+
+	p := PresenceMgr{
+		log: cfg.Log,
+		kv:  kv,
+	}
+
+	return &p, nil
+}
+
+// Set records the user as connected to the specified CAP. Calling Set again
+// refreshes the entry's TTL.
+func (p *PresenceMgr) Set(ctx context.Context, userID common.Address, capID uuid.UUID) error {
+	if _, err := p.kv.Put(ctx, userID.Hex(), []byte(capID.String())); err != nil {
+		return fmt.Errorf("presence put: %w", err)
+	}
+
+	p.log.Debug(ctx, "presence-set", "userID", userID, "capID", capID)
+
+	return nil
+}
+
+// Remove removes the user from the directory if the user is still owned by
+// the specified CAP.
+func (p *PresenceMgr) Remove(ctx context.Context, userID common.Address, capID uuid.UUID) error {
+	entry, err := p.kv.Get(ctx, userID.Hex())
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil
+		}
+		return fmt.Errorf("presence get: %w", err)
+	}
+
+	// The user has already connected to a different CAP.
+	if string(entry.Value()) != capID.String() {
+		return nil
+	}
+
+	if err := p.kv.Delete(ctx, userID.Hex(), jetstream.LastRevision(entry.Revision())); err != nil {
+		return fmt.Errorf("presence delete: %w", err)
+	}
+
+	p.log.Debug(ctx, "presence-remove", "userID", userID, "capID", capID)
+
+	return nil
+}
+
+// Lookup returns the id of the CAP the user is connected to.
+func (p *PresenceMgr) Lookup(ctx context.Context, userID common.Address) (uuid.UUID, error) {
+	entry, err := p.kv.Get(ctx, userID.Hex())
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return uuid.UUID{}, chatbus.ErrNotExists
+		}
+		return uuid.UUID{}, fmt.Errorf("presence get: %w", err)
+	}
+
+	capID, err := uuid.Parse(string(entry.Value()))
+	if err != nil {
+		return uuid.UUID{}, fmt.Errorf("presence parse: %w", err)
+	}
+
+	return capID, nil
+}
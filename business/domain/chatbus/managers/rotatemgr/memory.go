@@ -0,0 +1,65 @@
+package rotatemgr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Memory provides rotation storage for CAPs running in the same process.
+type Memory struct {
+	log       *logger.Logger
+	grace     time.Duration
+	mu        sync.RWMutex
+	rotations map[common.Address]chatbus.Rotation
+}
+
+// NewMemory constructs an in memory rotation storage. Rotations are
+// forgotten after the grace period.
+func NewMemory(log *logger.Logger, grace time.Duration) *Memory {
+	return &Memory{
+		log:       log,
+		grace:     grace,
+		rotations: make(map[common.Address]chatbus.Rotation),
+	}
+}
+
+// Add records the rotation. An ID can only be rotated to one new address.
+func (m *Memory) Add(ctx context.Context, rot chatbus.Rotation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, exists := m.rotations[rot.OldID]; exists && !m.expired(existing) {
+		if existing.NewID != rot.NewID {
+			return chatbus.ErrRotationExists
+		}
+		return nil
+	}
+
+	m.rotations[rot.OldID] = rot
+
+	m.log.Debug(ctx, "rotation-add", "oldID", rot.OldID, "newID", rot.NewID)
+
+	return nil
+}
+
+// Retrieve retrieves the rotation of the old ID from the storage.
+func (m *Memory) Retrieve(ctx context.Context, oldID common.Address) (chatbus.Rotation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rot, exists := m.rotations[oldID]
+	if !exists || m.expired(rot) {
+		return chatbus.Rotation{}, chatbus.ErrNotRotated
+	}
+
+	return rot, nil
+}
+
+func (m *Memory) expired(rot chatbus.Rotation) bool {
+	return time.Since(rot.DateCreated) > m.grace
+}
@@ -0,0 +1,94 @@
+// Package rotatemgr provides storage for the IDs users rotated away from,
+// backed by a JetStream key value bucket.
+package rotatemgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config represents the configuration for the rotation storage.
+type Config struct {
+	Log     *logger.Logger
+	JS      jetstream.JetStream
+	Subject string
+	Grace   time.Duration
+}
+
+// RotateMgr provides rotation storage shared by all the CAPs. Entries
+// expire after the grace period.
+type RotateMgr struct {
+	log *logger.Logger
+	kv  jetstream.KeyValue
+}
+
+// New creates the rotation bucket if it doesn't exist.
+func New(ctx context.Context, cfg Config) (*RotateMgr, error) {
+	kv, err := cfg.JS.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket: cfg.Subject + "-rotations",
+		TTL:    cfg.Grace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create rotations bucket: %w", err)
+	}
+
+	r := RotateMgr{
+		log: cfg.Log,
+		kv:  kv,
+	}
+
+	return &r, nil
+}
+
+// Add records the rotation. An ID can only be rotated to one new address.
+func (r *RotateMgr) Add(ctx context.Context, rot chatbus.Rotation) error {
+	data, err := json.Marshal(rot)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if _, err := r.kv.Create(ctx, rot.OldID.Hex(), data); err != nil {
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return fmt.Errorf("create: %w", err)
+		}
+
+		existing, err := r.Retrieve(ctx, rot.OldID)
+		if err != nil {
+			return err
+		}
+
+		if existing.NewID != rot.NewID {
+			return chatbus.ErrRotationExists
+		}
+	}
+
+	r.log.Debug(ctx, "rotation-add", "oldID", rot.OldID, "newID", rot.NewID)
+
+	return nil
+}
+
+// Retrieve retrieves the rotation of the old ID from the storage.
+func (r *RotateMgr) Retrieve(ctx context.Context, oldID common.Address) (chatbus.Rotation, error) {
+	entry, err := r.kv.Get(ctx, oldID.Hex())
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return chatbus.Rotation{}, chatbus.ErrNotRotated
+		}
+		return chatbus.Rotation{}, fmt.Errorf("get: %w", err)
+	}
+
+	var rot chatbus.Rotation
+	if err := json.Unmarshal(entry.Value(), &rot); err != nil {
+		return chatbus.Rotation{}, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return rot, nil
+}
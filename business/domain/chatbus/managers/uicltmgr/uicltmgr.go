@@ -3,26 +3,34 @@ package uicltmgr
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/ardanlabs/usdl/business/domain/chatbus"
 	"github.com/ardanlabs/usdl/foundation/logger"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
 )
 
-// UICltMgr provides user management for UI connections.
+// UICltMgr provides user management for UI connections. The presence
+// directory is kept up to date so other CAPs know where to find the users
+// connected to this CAP.
 type UICltMgr struct {
-	log     *logger.Logger
-	users   map[common.Address]chatbus.UIUser
-	muUsers sync.RWMutex
+	log      *logger.Logger
+	presence chatbus.Presence
+	capID    uuid.UUID
+	users    map[common.Address]chatbus.UIUser
+	muUsers  sync.RWMutex
 }
 
 // New creates a new manager for UI connections.
-func New(log *logger.Logger) *UICltMgr {
+func New(log *logger.Logger, presence chatbus.Presence, capID uuid.UUID) *UICltMgr {
 	u := UICltMgr{
-		log:   log,
-		users: make(map[common.Address]chatbus.UIUser),
+		log:      log,
+		presence: presence,
+		capID:    capID,
+		users:    make(map[common.Address]chatbus.UIUser),
 	}
 
 	return &u
@@ -30,35 +38,66 @@ func New(log *logger.Logger) *UICltMgr {
 
 // Add adds a new user to the storage.
 func (u *UICltMgr) Add(ctx context.Context, usr chatbus.UIUser) error {
-	u.muUsers.Lock()
-	defer u.muUsers.Unlock()
+	err := func() error {
+		u.muUsers.Lock()
+		defer u.muUsers.Unlock()
 
-	if _, exists := u.users[usr.ID]; exists {
-		return chatbus.ErrExists
-	}
+		if _, exists := u.users[usr.ID]; exists {
+			return chatbus.ErrExists
+		}
 
-	u.users[usr.ID] = usr
+		u.users[usr.ID] = usr
+
+		return nil
+	}()
+
+	if err != nil {
+		return err
+	}
 
 	u.log.Debug(ctx, "chat-adduser", "name", usr.Name, "id", usr.ID)
 
+	// A user other CAPs can't find won't get their messages, so the user
+	// isn't added unless the presence directory knows about them.
+	if err := u.presence.Set(ctx, usr.ID, u.capID); err != nil {
+		u.muUsers.Lock()
+		delete(u.users, usr.ID)
+		u.muUsers.Unlock()
+
+		return fmt.Errorf("presence set: %w", err)
+	}
+
 	return nil
 }
 
-// UpdateLastPing updates a user value's ping date/time.
+// UpdateLastPing updates a user value's ping date/time. This also heartbeats
+// the user's entry in the presence directory.
 func (u *UICltMgr) UpdateLastPing(ctx context.Context, userID common.Address) error {
-	u.muUsers.Lock()
-	defer u.muUsers.Unlock()
+	usr, err := func() (chatbus.UIUser, error) {
+		u.muUsers.Lock()
+		defer u.muUsers.Unlock()
 
-	usr, exists := u.users[userID]
-	if !exists {
-		return chatbus.ErrNotExists
-	}
+		usr, exists := u.users[userID]
+		if !exists {
+			return chatbus.UIUser{}, chatbus.ErrNotExists
+		}
 
-	usr.LastPing = time.Now()
-	u.users[usr.ID] = usr
+		usr.LastPing = time.Now()
+		u.users[usr.ID] = usr
+
+		return usr, nil
+	}()
+
+	if err != nil {
+		return err
+	}
 
 	u.log.Debug(ctx, "chat-updping", "name", usr.Name, "id", usr.ID, "lastPing", usr.LastPing)
 
+	if err := u.presence.Set(ctx, usr.ID, u.capID); err != nil {
+		return fmt.Errorf("presence: %w", err)
+	}
+
 	return nil
 }
 
@@ -82,18 +121,30 @@ func (u *UICltMgr) UpdateLastPong(ctx context.Context, userID common.Address) (c
 
 // Remove removes a user from the storage.
 func (u *UICltMgr) Remove(ctx context.Context, userID common.Address) {
-	u.muUsers.Lock()
-	defer u.muUsers.Unlock()
+	usr, exists := func() (chatbus.UIUser, bool) {
+		u.muUsers.Lock()
+		defer u.muUsers.Unlock()
+
+		usr, exists := u.users[userID]
+		if !exists {
+			return chatbus.UIUser{}, false
+		}
+
+		delete(u.users, userID)
+
+		return usr, true
+	}()
 
-	usr, exists := u.users[userID]
 	if !exists {
 		u.log.Debug(ctx, "chat-removeuser", "userID", userID, "status", "does not exists")
 		return
 	}
 
-	delete(u.users, userID)
-
 	u.log.Debug(ctx, "chat-removeuser", "name", usr.Name, "id", usr.ID)
+
+	if err := u.presence.Remove(ctx, userID, u.capID); err != nil {
+		u.log.Info(ctx, "chat-removeuser: presence", "id", userID, "ERROR", err)
+	}
 }
 
 // Connections returns all the know users with their connections. A connection
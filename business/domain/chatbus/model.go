@@ -1,32 +1,69 @@
 package chatbus
 
 import (
+	"fmt"
 	"math/big"
+	"slices"
 	"time"
 
+	"github.com/ardanlabs/usdl/foundation/signature"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 )
 
 // UIUser represents a web socket user in the chat system.
 type UIUser struct {
-	ID       common.Address  `json:"id"`
-	Name     string          `json:"name"`
-	LastPing time.Time       `json:"lastPing"`
-	LastPong time.Time       `json:"lastPong"`
-	UIConn   *websocket.Conn `json:"-"`
+	ID       common.Address `json:"id"`
+	Name     string         `json:"name"`
+	LastPing time.Time      `json:"lastPing"`
+	LastPong time.Time      `json:"lastPong"`
+	UIConn   *UIConn        `json:"-"`
 }
 
 // UIConnection represents a connection to a user.
 type UIConnection struct {
-	Conn     *websocket.Conn
+	Conn     *UIConn
 	LastPing time.Time
 	LastPong time.Time
 }
 
+// Group represents a set of users that receive the messages sent to the
+// group's ID.
+type Group struct {
+	ID          common.Address   `json:"id"`
+	Name        string           `json:"name"`
+	Owner       common.Address   `json:"owner"`
+	Members     []common.Address `json:"members"`
+	DateCreated time.Time        `json:"dateCreated"`
+}
+
+// IsMember reports if the user is a member of the group.
+func (g Group) IsMember(userID common.Address) bool {
+	return slices.Contains(g.Members, userID)
+}
+
+// Rotation records a user moving from one address to another. Messages to
+// the old address are forwarded to the new one while the rotation is
+// remembered.
+type Rotation struct {
+	OldID       common.Address `json:"oldID"`
+	NewID       common.Address `json:"newID"`
+	DateCreated time.Time      `json:"dateCreated"`
+}
+
+// uiHandshake is the identity the client claims with the signature of the
+// challenge sent in the HELLO frame.
+type uiHandshake struct {
+	ID   common.Address `json:"id"`
+	Name string         `json:"name"`
+	V    *big.Int       `json:"v"`
+	R    *big.Int       `json:"r"`
+	S    *big.Int       `json:"s"`
+}
+
 type uiIncomingMessage struct {
 	ToID      common.Address `json:"toID"`
+	Group     bool           `json:"group,omitempty"`
 	Encrypted bool           `json:"encrypted"`
 	Msg       [][]byte       `json:"msg"`
 	FromNonce uint64         `json:"fromNonce"`
@@ -43,13 +80,90 @@ type uiOutgoingUser struct {
 
 type uiOutgoingMessage struct {
 	From      uiOutgoingUser `json:"from"`
+	Group     common.Address `json:"group,omitzero"`
 	Encrypted bool           `json:"encrypted"`
 	Msg       [][]byte       `json:"msg"`
 }
 
-type natsInOutMessage struct {
-	CapID    uuid.UUID      `json:"capID"`
-	FromID   common.Address `json:"fromID"`
-	FromName string         `json:"fromName"`
+// Envelope represents a signed message as it travels between CAPs over any
+// of the transports.
+type Envelope struct {
+	CapID     uuid.UUID      `json:"capID"`
+	FromID    common.Address `json:"fromID"`
+	FromName  string         `json:"fromName"`
+	Recipient common.Address `json:"recipient,omitzero"`
 	uiIncomingMessage
 }
+
+// To returns the user the message needs to be delivered to. For group
+// messages the signed ToID is the group and the recipient is the member.
+func (m Envelope) To() common.Address {
+	if m.Recipient != (common.Address{}) {
+		return m.Recipient
+	}
+
+	return m.ToID
+}
+
+// MsgID returns an id that is unique for every message a user sends to
+// another user.
+func (m Envelope) MsgID() string {
+	if m.Recipient != (common.Address{}) {
+		return fmt.Sprintf("%s-%s-%d-%s", m.FromID.Hex(), m.ToID.Hex(), m.FromNonce, m.Recipient.Hex())
+	}
+
+	return fmt.Sprintf("%s-%s-%d", m.FromID.Hex(), m.ToID.Hex(), m.FromNonce)
+}
+
+// StreamID returns an id for the messages a user sends to a recipient,
+// which the sender's nonces put in order.
+func (m Envelope) StreamID() string {
+	if m.Recipient != (common.Address{}) {
+		return fmt.Sprintf("%s.%s.%s", m.FromID.Hex(), m.ToID.Hex(), m.Recipient.Hex())
+	}
+
+	return fmt.Sprintf("%s.%s", m.FromID.Hex(), m.ToID.Hex())
+}
+
+// verify checks the message was signed by the user it claims to be from.
+func (m Envelope) verify() error {
+	dataThatWasSign := struct {
+		ToID      common.Address
+		Msg       [][]byte
+		FromNonce uint64
+	}{
+		ToID:      m.ToID,
+		Msg:       m.Msg,
+		FromNonce: m.FromNonce,
+	}
+
+	id, err := signature.FromAddress(dataThatWasSign, m.V, m.R, m.S)
+	if err != nil {
+		return fmt.Errorf("from address: %w", err)
+	}
+
+	if id != m.FromID.Hex() {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// outgoing converts the message into what is sent to the UI.
+func (m Envelope) outgoing() uiOutgoingMessage {
+	out := uiOutgoingMessage{
+		From: uiOutgoingUser{
+			ID:    m.FromID,
+			Name:  m.FromName,
+			Nonce: m.FromNonce,
+		},
+		Encrypted: m.Encrypted,
+		Msg:       m.Msg,
+	}
+
+	if m.Group {
+		out.Group = m.ToID
+	}
+
+	return out
+}
@@ -0,0 +1,63 @@
+package chatbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/foundation/rotation"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxForwards limits how many rotations in a row a message follows.
+const maxForwards = 8
+
+// Rotate records the user moving to the new address named in the statement,
+// so messages to the old address reach the new one for the grace period. The
+// authenticated user must hold one of the two addresses.
+func (b *Business) Rotate(ctx context.Context, subjectID common.Address, stmt rotation.Statement) error {
+	if subjectID != stmt.OldID && subjectID != stmt.NewID {
+		return ErrIdentityMismatch
+	}
+
+	if err := stmt.Verify(); err != nil {
+		return err
+	}
+
+	rot := Rotation{
+		OldID:       stmt.OldID,
+		NewID:       stmt.NewID,
+		DateCreated: time.Now().UTC(),
+	}
+
+	if err := b.rotateMgr.Add(ctx, rot); err != nil {
+		return fmt.Errorf("add rotation: %w", err)
+	}
+
+	b.log.Info(ctx, "rotate", "oldID", rot.OldID, "newID", rot.NewID)
+
+	return nil
+}
+
+// =============================================================================
+
+// forward addresses the message to the recipient's new address when the
+// recipient rotated their ID.
+func (b *Business) forward(ctx context.Context, natsMsg Envelope) Envelope {
+	for range maxForwards {
+		rot, err := b.rotateMgr.Retrieve(ctx, natsMsg.To())
+		if err != nil {
+			if !errors.Is(err, ErrNotRotated) {
+				b.log.Info(ctx, "forward: retrieve", "to", natsMsg.To(), "ERROR", err)
+			}
+			return natsMsg
+		}
+
+		b.log.Info(ctx, "forward", "from", natsMsg.FromID, "oldID", rot.OldID, "newID", rot.NewID)
+
+		natsMsg.Recipient = rot.NewID
+	}
+
+	return natsMsg
+}
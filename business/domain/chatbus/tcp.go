@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/ardanlabs/usdl/foundation/logger"
-	"github.com/ardanlabs/usdl/foundation/signature"
 	"github.com/ardanlabs/usdl/foundation/tcp"
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -62,13 +61,19 @@ func (ch ClientHandlers) Drop(clt *tcp.Client) {
 type ServerHandlers struct {
 	log      *logger.Logger
 	uiCltMgr UIClientManager
+	mailbox  Mailbox
+	groupMgr GroupManager
+	local    uiTransport
 }
 
 // NewServerHandlers creates a new instance of ServerHandlers.
-func NewServerHandlers(log *logger.Logger, uiCltMgr UIClientManager) *ServerHandlers {
+func NewServerHandlers(log *logger.Logger, uiCltMgr UIClientManager, mailbox Mailbox, groupMgr GroupManager, nonceMgr NonceManager) *ServerHandlers {
 	return &ServerHandlers{
 		log:      log,
 		uiCltMgr: uiCltMgr,
+		mailbox:  mailbox,
+		groupMgr: groupMgr,
+		local:    uiTransport{uiCltMgr: uiCltMgr, nonceMgr: nonceMgr, mailbox: mailbox},
 	}
 }
 
@@ -106,18 +111,16 @@ func (sh ServerHandlers) Bind(clt *tcp.Client) error {
 
 	// -------------------------------------------------------------------------
 
-	msg := [][]byte{[]byte("EVENT"), []byte("TCP-CONN")}
-
-	from := UIUser{
-		ID: common.HexToAddress(clt.UserID()),
-	}
+	// Events come from the CAP, not the peer, so the client can tell them
+	// apart from a message the peer signed.
+	msg := [][]byte{[]byte("EVENT"), []byte("TCP-CONN"), []byte(common.HexToAddress(clt.UserID()).Hex())}
 
 	for _, conn := range sh.uiCltMgr.Connections() {
 		to := UIUser{
 			UIConn: conn.Conn,
 		}
 
-		if err := uiSendMessage(from, to, 0, false, msg); err != nil {
+		if err := uiSendMessage(UIUser{}, to, 0, false, msg); err != nil {
 			sh.log.Info(clt.Context(), "uilisten: send", "ERROR", err)
 		}
 	}
@@ -143,78 +146,47 @@ func (sh ServerHandlers) Read(clt *tcp.Client) ([]byte, int, error) {
 func (sh ServerHandlers) Process(r *tcp.Request, clt *tcp.Client) {
 	ctx := r.Context
 
-	var natsMsg natsInOutMessage
-	if err := json.Unmarshal(r.Data, &natsMsg); err != nil {
-		sh.log.Info(ctx, "server-process: unmarshal", "ERROR", err)
+	natsMsg, err := decodeEnvelope(ctx, sh.groupMgr, r.Data)
+	if err != nil {
+		sh.log.Info(ctx, "server-process: decode", "ERROR", err)
 		return
 	}
 
 	sh.log.Info(ctx, "server-process: msg recv", "fromNonce", natsMsg.FromNonce, "from", natsMsg.FromID, "to", natsMsg.ToID, "encrypted", natsMsg.Encrypted, "message", natsMsg.Msg, "fromName", natsMsg.FromName)
 
-	dataThatWasSign := struct {
-		ToID      common.Address
-		Msg       [][]byte
-		FromNonce uint64
-	}{
-		ToID:      natsMsg.ToID,
-		Msg:       natsMsg.Msg,
-		FromNonce: natsMsg.FromNonce,
-	}
-
-	id, err := signature.FromAddress(dataThatWasSign, natsMsg.V, natsMsg.R, natsMsg.S)
-	if err != nil {
-		sh.log.Info(ctx, "server-process: fromAddress", "ERROR", err)
+	err = sh.local.Deliver(ctx, natsMsg)
+	if err == nil {
+		sh.log.Info(ctx, "server-process: msg sent over web socket", "from", natsMsg.FromID, "to", natsMsg.To())
 		return
 	}
 
-	if id != natsMsg.FromID.Hex() {
-		sh.log.Info(r.Context, "server-process: signature check", "status", "signature does not match")
+	if !errors.Is(err, ErrNotExists) {
+		sh.log.Info(ctx, "server-process: deliver", "ERROR", err)
 		return
 	}
 
-	// If the user is found, send the message directly to the user.
-	to, err := sh.uiCltMgr.Retrieve(ctx, natsMsg.ToID)
-	if err == nil {
-		sh.log.Info(ctx, "server-process: msg sent over web socket", "from", natsMsg.FromID, "to", natsMsg.ToID)
-
-		from := UIUser{
-			ID:   natsMsg.FromID,
-			Name: natsMsg.FromName,
-		}
-
-		if err := uiSendMessage(from, to, natsMsg.FromNonce, natsMsg.Encrypted, natsMsg.Msg); err != nil {
-			sh.log.Info(ctx, "server-process: send", "ERROR", err)
-		}
+	// We don't have a web socket connection for the user then store the
+	// message in the mailbox so it can be delivered when the user connects.
 
-		return
-	}
+	sh.log.Info(ctx, "server-process: retrieve", "status", "user not found, storing in mailbox")
 
-	if !errors.Is(err, ErrNotExists) {
-		sh.log.Info(ctx, "server-process: retrieve", "ERROR", err)
+	if err := mailboxStore(ctx, sh.mailbox, natsMsg); err != nil {
+		sh.log.Info(ctx, "server-process: mailbox", "ERROR", err)
 	}
-
-	// We don't have a web socket connection for the user then drop the
-	// message on the floor because we can't deliver it.
-
-	sh.log.Info(ctx, "server-process: retrieve", "status", "user not found")
 }
 
 // Drop is called when a connection is dropped.
 func (sh ServerHandlers) Drop(clt *tcp.Client) {
 	sh.log.Info(clt.Context(), "server-drop", "userID", clt.UserID())
 
-	msg := [][]byte{[]byte("EVENT"), []byte("TCP-DROP")}
-
-	from := UIUser{
-		ID: common.HexToAddress(clt.UserID()),
-	}
+	msg := [][]byte{[]byte("EVENT"), []byte("TCP-DROP"), []byte(common.HexToAddress(clt.UserID()).Hex())}
 
 	for _, conn := range sh.uiCltMgr.Connections() {
 		to := UIUser{
 			UIConn: conn.Conn,
 		}
 
-		if err := uiSendMessage(from, to, 0, false, msg); err != nil {
+		if err := uiSendMessage(UIUser{}, to, 0, false, msg); err != nil {
 			sh.log.Info(clt.Context(), "uilisten: send", "ERROR", err)
 		}
 	}
@@ -222,14 +194,7 @@ func (sh ServerHandlers) Drop(clt *tcp.Client) {
 
 // =============================================================================
 
-func (b *Business) tcpSendMessage(clt *tcp.Client, from UIUser, inMsg uiIncomingMessage) error {
-	natsMsg := natsInOutMessage{
-		CapID:             b.capID,
-		FromID:            from.ID,
-		FromName:          from.Name,
-		uiIncomingMessage: inMsg,
-	}
-
+func tcpSendMessage(clt *tcp.Client, natsMsg Envelope) error {
 	d, err := json.Marshal(natsMsg)
 	if err != nil {
 		return fmt.Errorf("send nats marshal message: %w", err)
@@ -0,0 +1,196 @@
+package chatbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Set of names for the transports the CAP provides.
+const (
+	TransportWebSocket = "websocket"
+	TransportTCP       = "tcp"
+	TransportBus       = "bus"
+)
+
+// DefaultTransportOrder is the order of precedence we think about sending a
+// message: websocket, peer-to-peer, message bus.
+var DefaultTransportOrder = []string{TransportWebSocket, TransportTCP, TransportBus}
+
+// Router delivers messages by trying a set of transports in order.
+type Router struct {
+	log        *logger.Logger
+	transports []Transport
+	mu         sync.Mutex
+	deliveries map[string]uint64
+}
+
+// NewRouter constructs a router that tries the transports in the order
+// provided.
+func NewRouter(log *logger.Logger, transports ...Transport) *Router {
+	return &Router{
+		log:        log,
+		transports: transports,
+		deliveries: make(map[string]uint64),
+	}
+}
+
+// Route delivers the message with the first transport that can reach the
+// recipient and returns the name of that transport. When a transport fails,
+// the next transport is tried.
+func (r *Router) Route(ctx context.Context, env Envelope) (string, error) {
+	toID := env.To()
+
+	for _, t := range r.transports {
+		if !t.CanReach(ctx, toID) {
+			continue
+		}
+
+		err := t.Deliver(ctx, env)
+		switch {
+		case err == nil:
+			r.record(t.Name())
+			r.log.Info(ctx, "router: delivered", "transport", t.Name(), "from", env.FromID, "to", toID)
+			return t.Name(), nil
+
+		case errors.Is(err, ErrDuplicateDelivery):
+			return t.Name(), err
+		}
+
+		r.log.Info(ctx, "router: deliver", "transport", t.Name(), "to", toID, "ERROR", err)
+	}
+
+	return "", ErrNoRoute
+}
+
+// Deliveries returns the number of messages each transport has delivered.
+func (r *Router) Deliveries() map[string]uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return maps.Clone(r.deliveries)
+}
+
+func (r *Router) record(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deliveries[name]++
+}
+
+// orderTransports returns the transports in the order of the names provided.
+func orderTransports(order []string, transports []Transport) ([]Transport, error) {
+	byName := make(map[string]Transport, len(transports))
+	for _, t := range transports {
+		byName[t.Name()] = t
+	}
+
+	ordered := make([]Transport, 0, len(order))
+	for _, name := range order {
+		t, exists := byName[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown transport: %q", name)
+		}
+
+		ordered = append(ordered, t)
+	}
+
+	return ordered, nil
+}
+
+// =============================================================================
+
+// decodeEnvelope decodes a message received from another CAP and checks it
+// can be delivered to a user connected to this CAP.
+func decodeEnvelope(ctx context.Context, groupMgr GroupManager, data []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	if err := env.verify(); err != nil {
+		return Envelope{}, fmt.Errorf("verify: %w", err)
+	}
+
+	if env.Group {
+		if err := groupCheckMember(ctx, groupMgr, env); err != nil {
+			return Envelope{}, fmt.Errorf("group check: %w", err)
+		}
+	}
+
+	return env, nil
+}
+
+// =============================================================================
+
+// uiTransport delivers messages to users connected to this CAP.
+type uiTransport struct {
+	uiCltMgr UIClientManager
+	nonceMgr NonceManager
+	mailbox  Mailbox
+}
+
+func (t uiTransport) Name() string {
+	return TransportWebSocket
+}
+
+func (t uiTransport) CanReach(ctx context.Context, toID common.Address) bool {
+	_, err := t.uiCltMgr.Retrieve(ctx, toID)
+	return err == nil
+}
+
+func (t uiTransport) Deliver(ctx context.Context, env Envelope) error {
+	to, err := t.uiCltMgr.Retrieve(ctx, env.To())
+	if err != nil {
+		return fmt.Errorf("retrieve: %w", err)
+	}
+
+	return uiDeliver(ctx, t.nonceMgr, t.mailbox, to, env)
+}
+
+// tcpTransport delivers messages to users with a peer-to-peer connection.
+type tcpTransport struct {
+	tcpCltMgr TCPClientManager
+}
+
+func (t tcpTransport) Name() string {
+	return TransportTCP
+}
+
+func (t tcpTransport) CanReach(ctx context.Context, toID common.Address) bool {
+	_, err := t.tcpCltMgr.Retrieve(ctx, toID.String())
+	return err == nil
+}
+
+func (t tcpTransport) Deliver(ctx context.Context, env Envelope) error {
+	clt, err := t.tcpCltMgr.Retrieve(ctx, env.To().String())
+	if err != nil {
+		return fmt.Errorf("retrieve: %w", err)
+	}
+
+	return tcpSendMessage(clt, env)
+}
+
+// busTransport delivers messages through the CAP that owns the user. It can
+// always be used since messages no CAP delivers end up in the mailbox.
+type busTransport struct {
+	b *Business
+}
+
+func (t busTransport) Name() string {
+	return TransportBus
+}
+
+func (t busTransport) CanReach(ctx context.Context, toID common.Address) bool {
+	return true
+}
+
+func (t busTransport) Deliver(ctx context.Context, env Envelope) error {
+	return t.b.busSendMessage(ctx, env)
+}
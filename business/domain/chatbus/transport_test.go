@@ -0,0 +1,77 @@
+package chatbus_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/usdl/business/domain/chatbus"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// TestRouter provides a test of the router trying the transports in order.
+func TestRouter(t *testing.T) {
+	t.Log("Given the need to route messages over the first working transport.")
+	{
+		env := chatbus.Envelope{
+			Recipient: common.HexToAddress("0x01"),
+		}
+
+		offline := &fakeTransport{name: "offline", reach: false}
+		broken := &fakeTransport{name: "broken", reach: true, err: errors.New("broken")}
+		working := &fakeTransport{name: "working", reach: true}
+		unused := &fakeTransport{name: "unused", reach: true}
+
+		router := chatbus.NewRouter(log, offline, broken, working, unused)
+
+		name, err := router.Route(context.Background(), env)
+		if err != nil {
+			t.Fatal("\tShould be able to route the message.", "X", err)
+		}
+		t.Log("\tShould be able to route the message.", "OK")
+
+		if name != "working" {
+			t.Fatalf("\tShould deliver with the working transport, got %q. %s", name, "X")
+		}
+		t.Log("\tShould deliver with the working transport.", "OK")
+
+		if offline.delivered != 0 || broken.delivered != 1 || working.delivered != 1 || unused.delivered != 0 {
+			t.Fatalf("\tShould try the transports in order, got %d %d %d %d. %s", offline.delivered, broken.delivered, working.delivered, unused.delivered, "X")
+		}
+		t.Log("\tShould try the transports in order.", "OK")
+
+		if got := router.Deliveries()["working"]; got != 1 {
+			t.Fatalf("\tShould record the delivery, got %d. %s", got, "X")
+		}
+		t.Log("\tShould record the delivery.", "OK")
+
+		router = chatbus.NewRouter(log, offline, broken)
+
+		if _, err := router.Route(context.Background(), env); !errors.Is(err, chatbus.ErrNoRoute) {
+			t.Fatalf("\tShould fail when no transport delivers, got %v. %s", err, "X")
+		}
+		t.Log("\tShould fail when no transport delivers.", "OK")
+	}
+}
+
+// =============================================================================
+
+type fakeTransport struct {
+	name      string
+	reach     bool
+	err       error
+	delivered int
+}
+
+func (f *fakeTransport) Name() string {
+	return f.name
+}
+
+func (f *fakeTransport) CanReach(ctx context.Context, toID common.Address) bool {
+	return f.reach
+}
+
+func (f *fakeTransport) Deliver(ctx context.Context, env chatbus.Envelope) error {
+	f.delivered++
+	return f.err
+}
@@ -2,11 +2,14 @@ package chatbus
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ardanlabs/usdl/foundation/signature"
@@ -17,38 +20,62 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-// UIHandshake performs the connection handshake protocol.
-func (b *Business) UIHandshake(ctx context.Context, w http.ResponseWriter, r *http.Request) (UIUser, error) {
-	var ws websocket.Upgrader
+// UIProtocol is the websocket subprotocol a browser offers so it can pass
+// its token, since a browser can't set headers on a websocket request.
+const UIProtocol = "usdl"
+
+// uiHandshakeTimeout is how long the client has to answer the HELLO, and
+// uiLookupTimeout how long the CAP has for the lookups the handshake does.
+const (
+	uiHandshakeTimeout = 100 * time.Millisecond
+	uiLookupTimeout    = 5 * time.Second
+)
+
+// UIHandshake performs the connection handshake protocol. The subjectID is
+// the authenticated user from the JWT. The client must claim the same ID and
+// prove it owns the ID's key by signing the challenge sent with HELLO.
+func (b *Business) UIHandshake(ctx context.Context, w http.ResponseWriter, r *http.Request, subjectID common.Address) (UIUser, error) {
+	ws := websocket.Upgrader{
+		Subprotocols: []string{UIProtocol},
+	}
+
 	conn, err := ws.Upgrade(w, r, nil)
 	if err != nil {
 		return UIUser{}, fmt.Errorf("upgrade: %w", err)
 	}
 
-	if err := conn.WriteMessage(websocket.TextMessage, []byte("HELLO")); err != nil {
-		return UIUser{}, fmt.Errorf("write message: %w", err)
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		conn.Close()
+		return UIUser{}, fmt.Errorf("challenge: %w", err)
 	}
+	challengeHex := hex.EncodeToString(challenge)
 
-	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
-	defer cancel()
-
-	usr := UIUser{
-		UIConn:   conn,
-		LastPing: time.Now(),
-		LastPong: time.Now(),
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("HELLO "+challengeHex)); err != nil {
+		conn.Close()
+		return UIUser{}, fmt.Errorf("write message: %w", err)
 	}
 
-	msg, err := b.uiReadMessage(ctx, usr)
+	// The pumps aren't running yet so the handshake reads from the
+	// connection directly.
+	conn.SetReadDeadline(time.Now().Add(uiHandshakeTimeout))
+
+	_, msg, err := conn.ReadMessage()
 	if err != nil {
+		conn.Close()
 		return UIUser{}, fmt.Errorf("read message: %w", err)
 	}
 
-	if err := json.Unmarshal(msg, &usr); err != nil {
+	conn.SetReadDeadline(time.Time{})
+
+	var hs uiHandshake
+	if err := json.Unmarshal(msg, &hs); err != nil {
+		conn.Close()
 		return UIUser{}, fmt.Errorf("unmarshal message: %w", err)
 	}
 
 	// Check that we have a valid user ID and Name.
-	if usr.ID == (common.Address{}) || usr.Name == "" {
+	if hs.ID == (common.Address{}) || hs.Name == "" {
 		defer conn.Close()
 		if err := conn.WriteMessage(websocket.TextMessage, []byte("Invalid User ID or Name")); err != nil {
 			return UIUser{}, fmt.Errorf("write message: %w", err)
@@ -56,30 +83,115 @@ func (b *Business) UIHandshake(ctx context.Context, w http.ResponseWriter, r *ht
 		return UIUser{}, fmt.Errorf("invalid user ID or name")
 	}
 
-	// -------------------------------------------------------------------------
+	if err := uiVerifyIdentity(hs, subjectID, challengeHex); err != nil {
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("Invalid Identity")); err != nil {
+			return UIUser{}, fmt.Errorf("write message: %w", err)
+		}
+		return UIUser{}, fmt.Errorf("verify identity: id[%s]: subject[%s]: %w", hs.ID, subjectID, err)
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, uiLookupTimeout)
+	defer cancel()
 
-	if err := b.uiCltMgr.Add(ctx, usr); err != nil {
+	// A rotated ID is replaced by its new address, so the old key can't be
+	// used to connect during the grace period. If we can't tell, the user
+	// isn't let in.
+	_, err = b.rotateMgr.Retrieve(lookupCtx, hs.ID)
+	switch {
+	case err == nil:
 		defer conn.Close()
-		if err := conn.WriteMessage(websocket.TextMessage, []byte("Already Connected")); err != nil {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("Identity Rotated")); err != nil {
 			return UIUser{}, fmt.Errorf("write message: %w", err)
 		}
-		return UIUser{}, fmt.Errorf("add user: %w", err)
+		return UIUser{}, fmt.Errorf("id[%s]: %w", hs.ID, ErrRotated)
+
+	case !errors.Is(err, ErrNotRotated):
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("Service Unavailable")); err != nil {
+			return UIUser{}, fmt.Errorf("write message: %w", err)
+		}
+		return UIUser{}, fmt.Errorf("rotation lookup: id[%s]: %w", hs.ID, err)
+	}
+
+	// The pong handler is called by the read pump so it must be set before
+	// the pumps are started.
+	conn.SetPongHandler(b.uiPong(hs.ID))
+
+	usr := UIUser{
+		ID:       hs.ID,
+		Name:     hs.Name,
+		LastPing: time.Now(),
+		LastPong: time.Now(),
+		UIConn:   newUIConn(conn, b.uiConnCfg),
 	}
 
-	usr.UIConn.SetPongHandler(b.uiPong(usr.ID))
+	// -------------------------------------------------------------------------
+
+	if err := b.uiCltMgr.Add(lookupCtx, usr); err != nil {
+		defer usr.UIConn.Close()
+
+		reply := "Service Unavailable"
+		if errors.Is(err, ErrExists) {
+			reply = "Already Connected"
+		}
+
+		if err := usr.UIConn.SendText(reply); err != nil {
+			return UIUser{}, fmt.Errorf("write message: %w", err)
+		}
+		return UIUser{}, fmt.Errorf("add user: %w", err)
+	}
 
 	// -------------------------------------------------------------------------
 
 	v := fmt.Sprintf("WELCOME %s", usr.Name)
-	if err := conn.WriteMessage(websocket.TextMessage, []byte(v)); err != nil {
+	if err := usr.UIConn.SendText(v); err != nil {
 		return UIUser{}, fmt.Errorf("write message: %w", err)
 	}
 
 	b.log.Info(ctx, "chat-handshake", "status", "complete", "usr", usr)
 
+	// -------------------------------------------------------------------------
+
+	// Deliver any messages that were sent while the user was offline. The
+	// drain waits for the client to catch up, so it isn't cut short when
+	// the request ends.
+	b.mailboxDrain(context.WithoutCancel(ctx), usr)
+
 	return usr, nil
 }
 
+// uiVerifyIdentity checks the claimed ID is the authenticated user and that
+// the challenge was signed with the ID's private key.
+func uiVerifyIdentity(hs uiHandshake, subjectID common.Address, challenge string) error {
+	if hs.ID != subjectID {
+		return ErrIdentityMismatch
+	}
+
+	if hs.V == nil || hs.R == nil || hs.S == nil {
+		return ErrInvalidChallenge
+	}
+
+	dataThatWasSign := struct {
+		ID        common.Address
+		Challenge string
+	}{
+		ID:        hs.ID,
+		Challenge: challenge,
+	}
+
+	id, err := signature.FromAddress(dataThatWasSign, hs.V, hs.R, hs.S)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidChallenge, err)
+	}
+
+	if common.HexToAddress(id) != hs.ID {
+		return ErrInvalidChallenge
+	}
+
+	return nil
+}
+
 // UIListen waits for messages from users.
 func (b *Business) UIListen(ctx context.Context, from UIUser) {
 	for {
@@ -99,111 +211,55 @@ func (b *Business) UIListen(ctx context.Context, from UIUser) {
 
 		b.log.Info(ctx, "uilisten: msg recv", "fromNonce", inMsg.FromNonce, "from", from.ID, "to", inMsg.ToID, "encrypted", inMsg.Encrypted, "message", inMsg.Msg)
 
-		dataThatWasSign := struct {
-			ToID      common.Address
-			Msg       [][]byte
-			FromNonce uint64
-		}{
-			ToID:      inMsg.ToID,
-			Msg:       inMsg.Msg,
-			FromNonce: inMsg.FromNonce,
+		natsMsg := Envelope{
+			CapID:             b.capID,
+			FromID:            from.ID,
+			FromName:          from.Name,
+			uiIncomingMessage: inMsg,
 		}
 
-		id, err := signature.FromAddress(dataThatWasSign, inMsg.V, inMsg.R, inMsg.S)
-		if err != nil {
-			b.log.Info(ctx, "uilisten: fromAddress", "ERROR", err)
+		if err := natsMsg.verify(); err != nil {
+			b.log.Info(ctx, "uilisten: signature check", "ERROR", err)
 			continue
 		}
 
-		if id != from.ID.Hex() {
-			b.log.Info(ctx, "uilisten: signature check", "status", "signature does not match")
+		if err := b.nonceMgr.Accept(ctx, from.ID, inMsg.ToID, inMsg.FromNonce); err != nil {
+			b.log.Info(ctx, "uilisten: nonce check", "ERROR", err)
+			b.uiSendNonceError(ctx, from, inMsg.ToID, inMsg.FromNonce, err)
 			continue
 		}
 
-		// BILL: We want the logic to match the order of precedence in the order
-		// we think about sending a message: websocket, peer-to-peer, nats.
-
-		// ---------------------------------------------------------------------
-		// Web Socket
-
-		// If the user is found, send the message directly to the user.
-		uiTo, err := b.uiCltMgr.Retrieve(ctx, inMsg.ToID)
-		if err == nil {
-			b.log.Info(ctx, "uilisten: msg sent over web socket", "from", from.ID, "to", inMsg.ToID)
-
-			if err := uiSendMessage(from, uiTo, inMsg.FromNonce, inMsg.Encrypted, inMsg.Msg); err != nil {
-				b.log.Info(ctx, "uilisten: send", "ERROR", err)
-			}
-
+		if inMsg.Group {
+			b.groupListen(ctx, from, natsMsg)
 			continue
 		}
 
-		if !errors.Is(err, ErrNotExists) {
-			b.log.Info(ctx, "uilisten: retrieve", "ERROR", err)
-		}
-
-		// ---------------------------------------------------------------------
-		// TCP
-
-		clt, err := b.tcpCltMgr.Retrieve(ctx, inMsg.ToID.String())
-		if err == nil {
-			b.log.Info(ctx, "uilisten: msg sent over tcp", "from", from.ID, "to", inMsg.ToID)
-
-			if err := b.tcpSendMessage(clt, from, inMsg); err != nil {
-				b.log.Info(ctx, "uilisten: tcp-send", "ERROR", err)
-			}
-
-			continue
-		}
-
-		// ---------------------------------------------------------------------
-		// NATS
+		b.send(ctx, natsMsg)
+	}
+}
 
-		// We don't have a web socket connection for the user then send the
-		// message over nats to find a CAP that can deliver the message.
-		b.log.Info(ctx, "uilisten: retrieve", "status", "user not found, sending over nats")
+// send delivers the message to the message's recipient using the first
+// transport that can reach the recipient. Messages for a user who rotated
+// their ID go to the new address.
+func (b *Business) send(ctx context.Context, natsMsg Envelope) {
+	natsMsg = b.forward(ctx, natsMsg)
 
-		if err := b.natsSendMessage(ctx, from, inMsg); err != nil {
-			b.log.Info(ctx, "uilisten: nats-send", "ERROR", err)
-		}
+	if _, err := b.router.Route(ctx, natsMsg); err != nil {
+		b.log.Info(ctx, "send: route", "from", natsMsg.FromID, "to", natsMsg.To(), "ERROR", err)
 	}
 }
 
 // =============================================================================
 
 func (b *Business) uiReadMessage(ctx context.Context, usr UIUser) ([]byte, error) {
-	type response struct {
-		msg []byte
-		err error
-	}
-
-	ch := make(chan response, 1)
-
-	go func() {
-		_, msg, err := usr.UIConn.ReadMessage()
-		if err != nil {
-			ch <- response{nil, err}
-		}
-		ch <- response{msg, nil}
-	}()
-
-	var resp response
-
-	select {
-	case <-ctx.Done():
+	msg, err := usr.UIConn.Read(ctx)
+	if err != nil {
 		b.uiCltMgr.Remove(ctx, usr.ID)
 		usr.UIConn.Close()
-		return nil, ctx.Err()
-
-	case resp = <-ch:
-		if resp.err != nil {
-			b.uiCltMgr.Remove(ctx, usr.ID)
-			usr.UIConn.Close()
-			return nil, resp.err
-		}
+		return nil, err
 	}
 
-	return resp.msg, nil
+	return msg, nil
 }
 
 func (b *Business) uiPing(maxWait time.Duration) {
@@ -227,7 +283,7 @@ func (b *Business) uiPing(maxWait time.Duration) {
 
 				b.log.Debug(ctx, "*** PING ***", "status", "sending", "id", id)
 
-				if err := conn.Conn.WriteMessage(websocket.PingMessage, []byte("ping")); err != nil {
+				if err := conn.Conn.Ping(); err != nil {
 					b.log.Info(ctx, "*** PING ***", "status", "failed", "id", id, "ERROR", err)
 				}
 
@@ -287,6 +343,11 @@ func (b *Business) isUICriticalError(ctx context.Context, err error) bool {
 			return true
 		}
 
+		if errors.Is(err, ErrConnClosed) {
+			b.log.Info(ctx, "uilisten", "status", "client-ui connection closed")
+			return true
+		}
+
 		b.log.Info(ctx, "uilisten", "ERROR", err, "TYPE", fmt.Sprintf("%T", err))
 		return false
 	}
@@ -294,6 +355,46 @@ func (b *Business) isUICriticalError(ctx context.Context, err error) bool {
 
 // =============================================================================
 
+// uiDeliver sends the message to the user unless it was already delivered.
+// One envelope can reach a user more than once when it travels more than
+// one path, like NATS and the mailbox, or when it's sent again by a peer.
+// Every path delivers through here, so the check covers them all. If the
+// send fails the message is no longer marked as delivered, so another path
+// can still deliver it. Until the user's mailbox is drained the message is
+// stored in the mailbox instead, so it's delivered in order with the rest.
+func uiDeliver(ctx context.Context, nonceMgr NonceManager, mailbox Mailbox, to UIUser, natsMsg Envelope) error {
+	to.UIConn.drainMu.Lock()
+	defer to.UIConn.drainMu.Unlock()
+
+	if to.UIConn.draining {
+		return mailboxStore(ctx, mailbox, natsMsg)
+	}
+
+	if err := nonceMgr.Delivered(ctx, natsMsg.StreamID(), natsMsg.FromNonce); err != nil {
+		return fmt.Errorf("delivered: %w", err)
+	}
+
+	if err := uiSendOutgoing(to, natsMsg.outgoing()); err != nil {
+		if errU := nonceMgr.Undelivered(ctx, natsMsg.StreamID(), natsMsg.FromNonce); errU != nil {
+			return fmt.Errorf("undelivered: %w: %w", errU, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// uiSendNonceError lets the sender know the message was rejected. The
+// rejected nonce comes last so clients that only show the error still find
+// it in the same place.
+func (b *Business) uiSendNonceError(ctx context.Context, to UIUser, toID common.Address, nonce uint64, err error) {
+	msg := [][]byte{[]byte("EVENT"), []byte("NONCE-ERROR"), []byte(toID.Hex()), []byte(err.Error()), []byte(strconv.FormatUint(nonce, 10))}
+
+	if err := uiSendMessage(UIUser{}, to, 0, false, msg); err != nil {
+		b.log.Info(ctx, "uilisten: send nonce error", "ERROR", err)
+	}
+}
+
 func uiSendMessage(from UIUser, to UIUser, fromNonce uint64, encrypted bool, msg [][]byte) error {
 	m := uiOutgoingMessage{
 		From: uiOutgoingUser{
@@ -305,7 +406,11 @@ func uiSendMessage(from UIUser, to UIUser, fromNonce uint64, encrypted bool, msg
 		Msg:       msg,
 	}
 
-	if err := to.UIConn.WriteJSON(m); err != nil {
+	return uiSendOutgoing(to, m)
+}
+
+func uiSendOutgoing(to UIUser, m uiOutgoingMessage) error {
+	if err := to.UIConn.Send(m); err != nil {
 		return fmt.Errorf("write message: %w", err)
 	}
 
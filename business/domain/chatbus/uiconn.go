@@ -0,0 +1,233 @@
+package chatbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// OverflowPolicy defines what happens when a connection's outbound queue is
+// full because the client isn't reading fast enough.
+type OverflowPolicy string
+
+// Set of overflow policies.
+const (
+	OverflowDrop       OverflowPolicy = "drop"
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// UIConnConfig represents the settings for the pumps of a UI connection.
+type UIConnConfig struct {
+	QueueSize    int
+	WriteTimeout time.Duration
+	Overflow     OverflowPolicy
+}
+
+func (cfg UIConnConfig) validate() error {
+	if cfg.QueueSize <= 0 {
+		return fmt.Errorf("queue size must be positive: %d", cfg.QueueSize)
+	}
+
+	if cfg.WriteTimeout <= 0 {
+		return fmt.Errorf("write timeout must be positive: %s", cfg.WriteTimeout)
+	}
+
+	switch cfg.Overflow {
+	case OverflowDrop, OverflowDisconnect:
+	default:
+		return fmt.Errorf("unknown overflow policy: %q", cfg.Overflow)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+type uiFrame struct {
+	msgType int
+	data    []byte
+}
+
+type uiRead struct {
+	msg []byte
+	err error
+}
+
+// UIConn wraps a web socket connection so only one goroutine ever writes to
+// the connection and only one goroutine ever reads from it. Writes are queued
+// and a slow client can't block the goroutines sending it messages.
+type UIConn struct {
+	conn      *websocket.Conn
+	cfg       UIConnConfig
+	send      chan uiFrame
+	recv      chan uiRead
+	shut      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// While draining is set, messages for the user are stored in the
+	// mailbox so they can't get ahead of the ones already there.
+	drainMu  sync.Mutex
+	draining bool
+}
+
+func newUIConn(conn *websocket.Conn, cfg UIConnConfig) *UIConn {
+	c := UIConn{
+		conn: conn,
+		cfg:  cfg,
+		send: make(chan uiFrame, cfg.QueueSize),
+		recv: make(chan uiRead),
+		shut: make(chan struct{}),
+		done: make(chan struct{}),
+
+		draining: true,
+	}
+
+	go c.writePump()
+	go c.readPump()
+
+	return &c
+}
+
+// Send queues the value to be written as JSON.
+func (c *UIConn) Send(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	return c.queue(uiFrame{msgType: websocket.TextMessage, data: data})
+}
+
+// SendWait queues the value to be written as JSON, waiting for room in the
+// queue instead of applying the overflow policy. This is for bursts the CAP
+// starts itself, like draining the mailbox.
+func (c *UIConn) SendWait(ctx context.Context, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	select {
+	case c.send <- uiFrame{msgType: websocket.TextMessage, data: data}:
+		return nil
+
+	case <-c.shut:
+		return ErrConnClosed
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendText queues the text to be written.
+func (c *UIConn) SendText(text string) error {
+	return c.queue(uiFrame{msgType: websocket.TextMessage, data: []byte(text)})
+}
+
+// Ping writes a ping control frame. Control frames are allowed to be written
+// concurrently with the write pump.
+func (c *UIConn) Ping() error {
+	return c.conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(c.cfg.WriteTimeout))
+}
+
+// Read waits for the next message read by the read pump.
+func (c *UIConn) Read(ctx context.Context) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case r := <-c.recv:
+		return r.msg, r.err
+
+	case <-c.done:
+		return nil, ErrConnClosed
+	}
+}
+
+// Close stops the pumps after the frames already queued are written and
+// closes the connection. It is safe to call Close more than once.
+func (c *UIConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.shut)
+	})
+
+	<-c.done
+
+	return nil
+}
+
+// =============================================================================
+
+func (c *UIConn) queue(f uiFrame) error {
+	select {
+	case <-c.shut:
+		return ErrConnClosed
+	default:
+	}
+
+	select {
+	case c.send <- f:
+		return nil
+
+	default:
+		if c.cfg.Overflow == OverflowDisconnect {
+			go c.Close()
+		}
+
+		return ErrQueueFull
+	}
+}
+
+func (c *UIConn) writePump() {
+	defer close(c.done)
+	defer c.conn.Close()
+	defer c.closeOnce.Do(func() { close(c.shut) })
+
+	for {
+		select {
+		case f := <-c.send:
+			if err := c.write(f); err != nil {
+				return
+			}
+
+		case <-c.shut:
+			for {
+				select {
+				case f := <-c.send:
+					if err := c.write(f); err != nil {
+						return
+					}
+
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *UIConn) write(f uiFrame) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	return c.conn.WriteMessage(f.msgType, f.data)
+}
+
+func (c *UIConn) readPump() {
+	for {
+		_, msg, err := c.conn.ReadMessage()
+
+		select {
+		case c.recv <- uiRead{msg: msg, err: err}:
+		case <-c.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
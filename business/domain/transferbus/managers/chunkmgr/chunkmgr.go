@@ -0,0 +1,158 @@
+// Package chunkmgr provides transfer chunk storage for the transferbus
+// service, backed by a JetStream object store.
+package chunkmgr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Config represents the configuration for the chunk storage.
+type Config struct {
+	Log     *logger.Logger
+	JS      jetstream.JetStream
+	Subject string
+	MaxAge  time.Duration
+}
+
+// ChunkMgr provides chunk storage shared by all the CAPs so the sender and
+// recipient don't need to be connected to the same CAP.
+type ChunkMgr struct {
+	log *logger.Logger
+	obs jetstream.ObjectStore
+}
+
+// New creates the transfer object store if it doesn't exist. Chunks that
+// are never downloaded are removed after MaxAge.
+func New(ctx context.Context, cfg Config) (*ChunkMgr, error) {
+	obs, err := cfg.JS.CreateOrUpdateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket: cfg.Subject + "-transfers",
+		TTL:    cfg.MaxAge,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats create transfers store: %w", err)
+	}
+
+	c := ChunkMgr{
+		log: cfg.Log,
+		obs: obs,
+	}
+
+	return &c, nil
+}
+
+// Create records the parties of the transfer. The parties are stored with
+// the chunks so they expire with them.
+func (c *ChunkMgr) Create(ctx context.Context, transferID uuid.UUID, parties transferbus.Parties) error {
+	_, err := c.obs.GetInfo(ctx, partiesName(transferID))
+	switch {
+	case err == nil:
+		return transferbus.ErrExists
+	case !errors.Is(err, jetstream.ErrObjectNotFound):
+		return fmt.Errorf("get info: %w", err)
+	}
+
+	data, err := json.Marshal(parties)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	if _, err := c.obs.PutBytes(ctx, partiesName(transferID), data); err != nil {
+		return fmt.Errorf("put bytes: %w", err)
+	}
+
+	return nil
+}
+
+// Parties retrieves the parties of the transfer.
+func (c *ChunkMgr) Parties(ctx context.Context, transferID uuid.UUID) (transferbus.Parties, error) {
+	data, err := c.obs.GetBytes(ctx, partiesName(transferID))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			return transferbus.Parties{}, transferbus.ErrNotExists
+		}
+		return transferbus.Parties{}, fmt.Errorf("get bytes: %w", err)
+	}
+
+	var parties transferbus.Parties
+	if err := json.Unmarshal(data, &parties); err != nil {
+		return transferbus.Parties{}, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	return parties, nil
+}
+
+// Put stores the chunk, replacing any chunk with the same index.
+func (c *ChunkMgr) Put(ctx context.Context, transferID uuid.UUID, idx int, data []byte) error {
+	if _, err := c.obs.PutBytes(ctx, name(transferID, idx), data); err != nil {
+		return fmt.Errorf("put bytes: %w", err)
+	}
+
+	return nil
+}
+
+// Get retrieves the chunk.
+func (c *ChunkMgr) Get(ctx context.Context, transferID uuid.UUID, idx int) ([]byte, error) {
+	data, err := c.obs.GetBytes(ctx, name(transferID, idx))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			return nil, transferbus.ErrChunkNotExists
+		}
+		return nil, fmt.Errorf("get bytes: %w", err)
+	}
+
+	return data, nil
+}
+
+// List returns the sorted indexes of the chunks stored for the transfer.
+// Only the transfer's own chunks are looked up, the store holds the chunks
+// of every transfer.
+func (c *ChunkMgr) List(ctx context.Context, transferID uuid.UUID, chunks int) ([]int, error) {
+	stored := []int{}
+	for idx := range chunks {
+		_, err := c.obs.GetInfo(ctx, name(transferID, idx))
+		switch {
+		case err == nil:
+			stored = append(stored, idx)
+		case !errors.Is(err, jetstream.ErrObjectNotFound):
+			return nil, fmt.Errorf("get info: %w", err)
+		}
+	}
+
+	return stored, nil
+}
+
+// Delete removes all the chunks stored for the transfer.
+func (c *ChunkMgr) Delete(ctx context.Context, transferID uuid.UUID, chunks int) error {
+	for idx := range chunks {
+		if err := c.obs.Delete(ctx, name(transferID, idx)); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+
+	if err := c.obs.Delete(ctx, partiesName(transferID)); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	c.log.Debug(ctx, "transfer-delete", "transferID", transferID, "chunks", chunks)
+
+	return nil
+}
+
+// =============================================================================
+
+func name(transferID uuid.UUID, idx int) string {
+	return fmt.Sprintf("%s/%d", transferID, idx)
+}
+
+func partiesName(transferID uuid.UUID) string {
+	return fmt.Sprintf("%s/parties", transferID)
+}
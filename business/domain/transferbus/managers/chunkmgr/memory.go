@@ -0,0 +1,134 @@
+package chunkmgr
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ardanlabs/usdl/business/domain/transferbus"
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/google/uuid"
+)
+
+type memoryChunk struct {
+	data   []byte
+	stored time.Time
+}
+
+type memoryParties struct {
+	parties transferbus.Parties
+	stored  time.Time
+}
+
+// Memory provides chunk storage for CAPs running in the same process.
+type Memory struct {
+	log       *logger.Logger
+	maxAge    time.Duration
+	mu        sync.RWMutex
+	chunks    map[uuid.UUID]map[int]memoryChunk
+	transfers map[uuid.UUID]memoryParties
+}
+
+// NewMemory constructs an in memory chunk storage. Chunks that are older
+// than maxAge are treated as removed.
+func NewMemory(log *logger.Logger, maxAge time.Duration) *Memory {
+	return &Memory{
+		log:       log,
+		maxAge:    maxAge,
+		chunks:    make(map[uuid.UUID]map[int]memoryChunk),
+		transfers: make(map[uuid.UUID]memoryParties),
+	}
+}
+
+// Create records the parties of the transfer.
+func (m *Memory) Create(ctx context.Context, transferID uuid.UUID, parties transferbus.Parties) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, exists := m.transfers[transferID]; exists && !m.expired(t.stored) {
+		return transferbus.ErrExists
+	}
+
+	m.transfers[transferID] = memoryParties{parties: parties, stored: time.Now()}
+
+	return nil
+}
+
+// Parties retrieves the parties of the transfer.
+func (m *Memory) Parties(ctx context.Context, transferID uuid.UUID) (transferbus.Parties, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, exists := m.transfers[transferID]
+	if !exists || m.expired(t.stored) {
+		return transferbus.Parties{}, transferbus.ErrNotExists
+	}
+
+	return t.parties, nil
+}
+
+// Put stores the chunk, replacing any chunk with the same index.
+func (m *Memory) Put(ctx context.Context, transferID uuid.UUID, idx int, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chunks, exists := m.chunks[transferID]
+	if !exists {
+		chunks = make(map[int]memoryChunk)
+		m.chunks[transferID] = chunks
+	}
+
+	chunks[idx] = memoryChunk{data: slices.Clone(data), stored: time.Now()}
+
+	return nil
+}
+
+// Get retrieves the chunk.
+func (m *Memory) Get(ctx context.Context, transferID uuid.UUID, idx int) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chunk, exists := m.chunks[transferID][idx]
+	if !exists || m.expired(chunk.stored) {
+		return nil, transferbus.ErrChunkNotExists
+	}
+
+	return chunk.data, nil
+}
+
+// List returns the sorted indexes of the chunks stored for the transfer.
+func (m *Memory) List(ctx context.Context, transferID uuid.UUID, count int) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chunks := []int{}
+	for idx, chunk := range m.chunks[transferID] {
+		if idx >= count || m.expired(chunk.stored) {
+			continue
+		}
+
+		chunks = append(chunks, idx)
+	}
+
+	slices.Sort(chunks)
+
+	return chunks, nil
+}
+
+// Delete removes all the chunks stored for the transfer.
+func (m *Memory) Delete(ctx context.Context, transferID uuid.UUID, chunks int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.log.Debug(ctx, "transfer-delete", "transferID", transferID, "chunks", len(m.chunks[transferID]))
+
+	delete(m.chunks, transferID)
+	delete(m.transfers, transferID)
+
+	return nil
+}
+
+func (m *Memory) expired(stored time.Time) bool {
+	return m.maxAge > 0 && time.Since(stored) > m.maxAge
+}
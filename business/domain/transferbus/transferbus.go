@@ -0,0 +1,177 @@
+// Package transferbus provides support for relaying file transfers between
+// users. The CAP only ever sees encrypted chunks, the file key is exchanged
+// end to end by the clients.
+package transferbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ardanlabs/usdl/foundation/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+)
+
+// Set of error variables.
+var (
+	ErrChunkNotExists = errors.New("chunk doesn't exists")
+	ErrChunkTooLarge  = errors.New("chunk too large")
+	ErrInvalidChunk   = errors.New("invalid chunk index")
+	ErrNotExists      = errors.New("transfer doesn't exists")
+	ErrExists         = errors.New("transfer already exists")
+	ErrInvalidSize    = errors.New("invalid transfer size")
+)
+
+// Parties represents the two users a transfer is between and the number and
+// largest size of the chunks the sender said it would upload.
+type Parties struct {
+	Sender    common.Address `json:"sender"`
+	Recipient common.Address `json:"recipient"`
+	Chunks    int            `json:"chunks"`
+	ChunkSize int            `json:"chunkSize"`
+}
+
+// Has reports if the user is one of the parties.
+func (p Parties) Has(userID common.Address) bool {
+	return userID == p.Sender || userID == p.Recipient
+}
+
+// ChunkManager defines the set of behavior for storing transfer chunks.
+type ChunkManager interface {
+	Create(ctx context.Context, transferID uuid.UUID, parties Parties) error
+	Parties(ctx context.Context, transferID uuid.UUID) (Parties, error)
+	Put(ctx context.Context, transferID uuid.UUID, idx int, data []byte) error
+	Get(ctx context.Context, transferID uuid.UUID, idx int) ([]byte, error)
+	List(ctx context.Context, transferID uuid.UUID, chunks int) ([]int, error)
+	Delete(ctx context.Context, transferID uuid.UUID, chunks int) error
+}
+
+// Config represents the configuration for the transfer support.
+type Config struct {
+	Log          *logger.Logger
+	ChunkMgr     ChunkManager
+	MaxChunkSize int
+}
+
+// Business represents file transfer support.
+type Business struct {
+	log          *logger.Logger
+	chunkMgr     ChunkManager
+	maxChunkSize int
+}
+
+// NewBusiness creates a new file transfer support.
+func NewBusiness(cfg Config) *Business {
+	return &Business{
+		log:          cfg.Log,
+		chunkMgr:     cfg.ChunkMgr,
+		maxChunkSize: cfg.MaxChunkSize,
+	}
+}
+
+// MaxChunkSize returns the largest chunk that will be accepted.
+func (b *Business) MaxChunkSize() int {
+	return b.maxChunkSize
+}
+
+// Create records the parties of a new transfer, which are the only users a
+// transfer token is issued to, and how many chunks of what size can be
+// uploaded for it.
+func (b *Business) Create(ctx context.Context, transferID uuid.UUID, parties Parties) error {
+	if parties.Chunks < 0 || parties.ChunkSize <= 0 || parties.ChunkSize > b.maxChunkSize {
+		return ErrInvalidSize
+	}
+
+	if err := b.chunkMgr.Create(ctx, transferID, parties); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+
+	b.log.Info(ctx, "transfer-create", "transferID", transferID, "sender", parties.Sender, "recipient", parties.Recipient, "chunks", parties.Chunks)
+
+	return nil
+}
+
+// Parties returns the parties of the transfer.
+func (b *Business) Parties(ctx context.Context, transferID uuid.UUID) (Parties, error) {
+	parties, err := b.chunkMgr.Parties(ctx, transferID)
+	if err != nil {
+		return Parties{}, fmt.Errorf("parties: %w", err)
+	}
+
+	return parties, nil
+}
+
+// UploadChunk stores the encrypted chunk for the transfer. Uploading the
+// same chunk again replaces it, which is what allows a sender to resume.
+// Only the chunks recorded when the transfer was created can be uploaded,
+// and none once it's complete.
+func (b *Business) UploadChunk(ctx context.Context, transferID uuid.UUID, idx int, data []byte) error {
+	parties, err := b.Parties(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	if idx < 0 || idx >= parties.Chunks {
+		return ErrInvalidChunk
+	}
+
+	if len(data) > parties.ChunkSize {
+		return ErrChunkTooLarge
+	}
+
+	if err := b.chunkMgr.Put(ctx, transferID, idx, data); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+
+	b.log.Debug(ctx, "transfer-upload", "transferID", transferID, "idx", idx, "size", len(data))
+
+	return nil
+}
+
+// DownloadChunk returns the encrypted chunk for the transfer.
+func (b *Business) DownloadChunk(ctx context.Context, transferID uuid.UUID, idx int) ([]byte, error) {
+	if idx < 0 {
+		return nil, ErrInvalidChunk
+	}
+
+	data, err := b.chunkMgr.Get(ctx, transferID, idx)
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	return data, nil
+}
+
+// Chunks returns the sorted set of chunk indexes already uploaded for the
+// transfer.
+func (b *Business) Chunks(ctx context.Context, transferID uuid.UUID) ([]int, error) {
+	parties, err := b.Parties(ctx, transferID)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := b.chunkMgr.List(ctx, transferID, parties.Chunks)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+
+	return chunks, nil
+}
+
+// Complete removes the transfer and all its chunks once the recipient has
+// the file.
+func (b *Business) Complete(ctx context.Context, transferID uuid.UUID) error {
+	parties, err := b.Parties(ctx, transferID)
+	if err != nil {
+		return err
+	}
+
+	if err := b.chunkMgr.Delete(ctx, transferID, parties.Chunks); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	b.log.Info(ctx, "transfer-complete", "transferID", transferID)
+
+	return nil
+}
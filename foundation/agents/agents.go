@@ -0,0 +1,79 @@
+// Package agents provides the interface for LLM backends that chat on
+// behalf of a user, along with support shared by the backends.
+package agents
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Agent represents an LLM backend that answers the input given the chat
+// history.
+type Agent interface {
+	Chat(ctx context.Context, input string, history []string) (string, error)
+}
+
+// StreamFunc is called with each chunk of a streamed response. Returning an
+// error stops the stream.
+type StreamFunc func(chunk string) error
+
+// Streamer represents an LLM backend that can stream the response as it's
+// generated. The full response is returned once the stream is finished.
+type Streamer interface {
+	ChatStream(ctx context.Context, input string, history []string, fn StreamFunc) (string, error)
+}
+
+// Stream streams the response when the agent supports it. Otherwise the
+// full response is delivered as a single chunk.
+func Stream(ctx context.Context, agent Agent, input string, history []string, fn StreamFunc) (string, error) {
+	if s, ok := agent.(Streamer); ok {
+		return s.ChatStream(ctx, input, history, fn)
+	}
+
+	resp, err := agent.Chat(ctx, input, history)
+	if err != nil {
+		return "", err
+	}
+
+	if err := fn(resp); err != nil {
+		return "", err
+	}
+
+	return resp, nil
+}
+
+// =============================================================================
+
+// Config represents the settings shared by the backends. The profile is
+// the prompt template, with a %s for the history followed by a %s for the
+// input. A zero MaxTokens leaves the limit to the backend.
+type Config struct {
+	Profile     string
+	Model       string
+	BaseURL     string
+	APIKey      string
+	Temperature float64
+	MaxTokens   int
+}
+
+// LoadProfile reads the prompt template from the file.
+func LoadProfile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("profile: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// Prompt fills the prompt template with the history and the input.
+func Prompt(profile string, input string, history []string) string {
+	var b strings.Builder
+	for _, h := range history {
+		b.WriteString(fmt.Sprintf("%s\n\n", h))
+	}
+
+	return fmt.Sprintf(profile, b.String(), input)
+}
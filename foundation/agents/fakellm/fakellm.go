@@ -0,0 +1,81 @@
+// Package fakellm provides a deterministic agent for tests.
+package fakellm
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ardanlabs/usdl/foundation/agents"
+)
+
+// Call represents what the agent was asked.
+type Call struct {
+	Input   string
+	History []string
+}
+
+// Agent implements the agents.Agent and agents.Streamer interfaces. It
+// answers with the responses it was constructed with, in order, repeating
+// the last one. Without responses it answers with the input.
+type Agent struct {
+	mu        sync.Mutex
+	responses []string
+	calls     []Call
+}
+
+// New constructs an agent that answers with the responses.
+func New(responses ...string) *Agent {
+	return &Agent{
+		responses: responses,
+	}
+}
+
+// Chat answers the input with the next response.
+func (a *Agent) Chat(ctx context.Context, input string, history []string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	resp := input
+	if n := len(a.responses); n > 0 {
+		resp = a.responses[min(len(a.calls), n-1)]
+	}
+
+	a.calls = append(a.calls, Call{Input: input, History: slices.Clone(history)})
+
+	return resp, nil
+}
+
+// ChatStream answers the input with the next response, streaming it a word
+// at a time.
+func (a *Agent) ChatStream(ctx context.Context, input string, history []string, fn agents.StreamFunc) (string, error) {
+	resp, err := a.Chat(ctx, input, history)
+	if err != nil {
+		return "", err
+	}
+
+	for _, chunk := range strings.SplitAfter(resp, " ") {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		if err := fn(chunk); err != nil {
+			return "", err
+		}
+	}
+
+	return resp, nil
+}
+
+// Calls returns what the agent has been asked so far.
+func (a *Agent) Calls() []Call {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return slices.Clone(a.calls)
+}
@@ -0,0 +1,250 @@
+// Package guard decides whether an agent may answer a message. It keeps
+// agents from answering commands and events, from answering contacts they
+// aren't allowed to, from talking to other agents forever and from
+// answering while the human is using the chat.
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Set of errors explaining why the agent may not answer.
+var (
+	ErrIgnored     = errors.New("command or event message")
+	ErrNotAllowed  = errors.New("contact not allowed")
+	ErrPaused      = errors.New("paused while the human is active")
+	ErrLoop        = errors.New("possible agent loop")
+	ErrRateLimited = errors.New("reply limit reached")
+)
+
+// recentSize is how many of the agent's recent replies per contact are
+// compared to find echoes.
+const recentSize = 4
+
+// Config represents the guard settings. A zero value for a setting turns
+// that check off.
+type Config struct {
+	Allow      []common.Address // Contacts the agent may answer.
+	MaxReplies int              // Replies per contact per window.
+	Window     time.Duration    // Window for the reply limit and the turns.
+	MaxTurns   int              // Replies in a row without the human taking part.
+	Similarity float64          // Word overlap, 0 to 1, that counts as a repeat.
+	Pause      time.Duration    // How long the human being active pauses the agent.
+}
+
+type contact struct {
+	replies []time.Time
+	turns   int
+	recent  []string // The agent's recent replies, normalized.
+}
+
+// Guard keeps the state the checks need for each contact.
+type Guard struct {
+	cfg         Config
+	allow       map[common.Address]bool
+	mu          sync.Mutex
+	contacts    map[common.Address]*contact
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+// New constructs a guard.
+func New(cfg Config) *Guard {
+	var allow map[common.Address]bool
+	if len(cfg.Allow) > 0 {
+		allow = make(map[common.Address]bool)
+		for _, id := range cfg.Allow {
+			allow[id] = true
+		}
+	}
+
+	return &Guard{
+		cfg:      cfg,
+		allow:    allow,
+		contacts: make(map[common.Address]*contact),
+		now:      time.Now,
+	}
+}
+
+// ParseAddresses converts the hex addresses for the allowlist.
+func ParseAddresses(hexes []string) ([]common.Address, error) {
+	ids := make([]common.Address, 0, len(hexes))
+
+	for _, h := range hexes {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		if !common.IsHexAddress(h) {
+			return nil, fmt.Errorf("invalid address: %q", h)
+		}
+
+		ids = append(ids, common.HexToAddress(h))
+	}
+
+	return ids, nil
+}
+
+// Check returns an error explaining why the agent may not answer the text
+// from the contact, or nil when it may.
+func (g *Guard) Check(from common.Address, text string) error {
+	text = strings.TrimSpace(text)
+
+	if strings.HasPrefix(text, "/") || strings.HasPrefix(text, "EVENT") {
+		return ErrIgnored
+	}
+
+	if g.allow != nil && !g.allow[from] {
+		return ErrNotAllowed
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+
+	if now.Before(g.pausedUntil) {
+		return ErrPaused
+	}
+
+	c := g.contact(from)
+	c.expire(now, g.cfg.Window)
+
+	// Only the agent's own replies are compared, so a human asking the same
+	// thing twice isn't taken for a loop.
+	if g.cfg.Similarity > 0 {
+		words := normalize(text)
+		for _, prev := range c.recent {
+			if similarity(words, prev) >= g.cfg.Similarity {
+				return fmt.Errorf("%w: message echoes a reply", ErrLoop)
+			}
+		}
+	}
+
+	if g.cfg.MaxTurns > 0 && c.turns >= g.cfg.MaxTurns {
+		return fmt.Errorf("%w: %d replies in a row", ErrLoop, c.turns)
+	}
+
+	if g.cfg.MaxReplies > 0 && len(c.replies) >= g.cfg.MaxReplies {
+		return fmt.Errorf("%w: %d replies in %s", ErrRateLimited, len(c.replies), g.cfg.Window)
+	}
+
+	return nil
+}
+
+// Replied records that the agent answered the contact with the text.
+func (g *Guard) Replied(to common.Address, text string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c := g.contact(to)
+	c.replies = append(c.replies, g.now())
+	c.turns++
+	c.remember(normalize(text))
+}
+
+// HumanActive records that the human is using the chat. The agent pauses
+// and the turns start over.
+func (g *Guard) HumanActive() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pausedUntil = g.now().Add(g.cfg.Pause)
+
+	for _, c := range g.contacts {
+		c.turns = 0
+	}
+}
+
+func (g *Guard) contact(id common.Address) *contact {
+	c, exists := g.contacts[id]
+	if !exists {
+		c = &contact{}
+		g.contacts[id] = c
+	}
+
+	return c
+}
+
+// =============================================================================
+
+// expire drops the replies that fell out of the window. When the agent
+// hasn't replied for a whole window the turns start over.
+func (c *contact) expire(now time.Time, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+
+	var replies []time.Time
+	for _, t := range c.replies {
+		if now.Sub(t) < window {
+			replies = append(replies, t)
+		}
+	}
+
+	if len(replies) == 0 {
+		c.turns = 0
+	}
+
+	c.replies = replies
+}
+
+func (c *contact) remember(words string) {
+	if words == "" {
+		return
+	}
+
+	c.recent = append(c.recent, words)
+	if len(c.recent) > recentSize {
+		c.recent = c.recent[1:]
+	}
+}
+
+// normalize lowercases the text and reduces it to its words.
+func normalize(text string) string {
+	f := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}
+
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), f), " ")
+}
+
+// similarity returns the share of distinct words the two texts have in
+// common, from 0 to 1.
+func similarity(a string, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(a) {
+		set[w] = true
+	}
+
+	var shared int
+	union := len(set)
+
+	seen := make(map[string]bool)
+	for _, w := range strings.Fields(b) {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+
+		if set[w] {
+			shared++
+			continue
+		}
+		union++
+	}
+
+	return float64(shared) / float64(union)
+}
@@ -0,0 +1,132 @@
+package guard_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/foundation/agents/guard"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	alice = common.HexToAddress("0x6327A38415C53FFb36c11db55Ea74cc9cB4976Fd")
+	bob   = common.HexToAddress("0xdd6B972ffcc631a62CAE1BB9d80b7ff429c8ebA4")
+)
+
+// =============================================================================
+
+func TestIgnored(t *testing.T) {
+	g := guard.New(guard.Config{})
+
+	for _, text := range []string{"/key abc", "EVENT TCP-CONN", "  /file x"} {
+		if err := g.Check(alice, text); !errors.Is(err, guard.ErrIgnored) {
+			t.Fatalf("Should ignore %q, got %v", text, err)
+		}
+	}
+
+	if err := g.Check(alice, "hello"); err != nil {
+		t.Fatalf("Should answer a normal message: %s", err)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	g := guard.New(guard.Config{Allow: []common.Address{alice}})
+
+	if err := g.Check(alice, "hello"); err != nil {
+		t.Fatalf("Should answer an allowed contact: %s", err)
+	}
+
+	if err := g.Check(bob, "hello"); !errors.Is(err, guard.ErrNotAllowed) {
+		t.Fatalf("Should not answer other contacts, got %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	g := guard.New(guard.Config{MaxReplies: 2, Window: time.Hour})
+
+	texts := []string{"first question", "second question here", "third one now"}
+
+	for i, text := range texts {
+		err := g.Check(alice, text)
+
+		if i < 2 {
+			if err != nil {
+				t.Fatalf("Should answer message %d: %s", i, err)
+			}
+			g.Replied(alice, "answer number "+text)
+			continue
+		}
+
+		if !errors.Is(err, guard.ErrRateLimited) {
+			t.Fatalf("Should stop at the reply limit, got %v", err)
+		}
+	}
+
+	if err := g.Check(bob, "hello"); err != nil {
+		t.Fatalf("Should limit each contact separately: %s", err)
+	}
+}
+
+func TestLoop(t *testing.T) {
+	g := guard.New(guard.Config{Similarity: 0.8})
+
+	if err := g.Check(alice, "How are you today?"); err != nil {
+		t.Fatalf("Should answer the first message: %s", err)
+	}
+	g.Replied(alice, "I'm great, thanks for asking!")
+
+	if err := g.Check(alice, "i'm great thanks for asking"); !errors.Is(err, guard.ErrLoop) {
+		t.Fatalf("Should detect a message echoing the reply, got %v", err)
+	}
+
+	if err := g.Check(alice, "How are you today?"); err != nil {
+		t.Fatalf("Should answer a message repeating an earlier message: %s", err)
+	}
+	g.Replied(alice, "Still great!")
+
+	if err := g.Check(alice, "I'm great, thanks for asking!"); !errors.Is(err, guard.ErrLoop) {
+		t.Fatalf("Should still detect the echo after rejecting it, got %v", err)
+	}
+
+	g = guard.New(guard.Config{MaxTurns: 2, Pause: time.Millisecond})
+
+	for i, text := range []string{"one", "two", "three"} {
+		err := g.Check(alice, text)
+
+		if i < 2 {
+			if err != nil {
+				t.Fatalf("Should answer turn %d: %s", i, err)
+			}
+			g.Replied(alice, "reply "+text)
+			continue
+		}
+
+		if !errors.Is(err, guard.ErrLoop) {
+			t.Fatalf("Should stop after the max turns, got %v", err)
+		}
+	}
+
+	g.HumanActive()
+	time.Sleep(5 * time.Millisecond)
+
+	if err := g.Check(alice, "four"); err != nil {
+		t.Fatalf("Should start the turns over when the human takes part: %s", err)
+	}
+}
+
+func TestPause(t *testing.T) {
+	g := guard.New(guard.Config{Pause: 50 * time.Millisecond})
+
+	g.HumanActive()
+
+	if err := g.Check(alice, "hello"); !errors.Is(err, guard.ErrPaused) {
+		t.Fatalf("Should pause while the human is active, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := g.Check(alice, "hello again"); err != nil {
+		t.Fatalf("Should answer once the pause is over: %s", err)
+	}
+}
@@ -1,58 +1,81 @@
-// Package ollamallm provides a client to act as the user in the
-// client system.
+// Package ollamallm provides an agent backed by an Ollama server.
 package ollamallm
 
 import (
 	"context"
-	_ "embed"
 	"fmt"
-	"io"
-	"os"
-	"strings"
 
+	"github.com/ardanlabs/usdl/foundation/agents"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/ollama"
 )
 
+// DefaultModel is used when the config doesn't name a model.
+const DefaultModel = "llama3.2:latest"
+
+// Agent implements the agents.Agent and agents.Streamer interfaces.
 type Agent struct {
 	llm    *ollama.LLM
 	prompt string
+	opts   []llms.CallOption
 }
 
-func New(profilePath string) (*Agent, error) {
-	f, err := os.Open(profilePath)
-	if err != nil {
-		return nil, fmt.Errorf("profile: %w", err)
+// New constructs an agent for the Ollama server at the base URL. Without a
+// base URL the OLLAMA_HOST environment variable or Ollama's default is used.
+func New(cfg agents.Config) (*Agent, error) {
+	model := cfg.Model
+	if model == "" {
+		model = DefaultModel
 	}
-	defer f.Close()
 
-	prompt, err := io.ReadAll(f)
-	if err != nil {
-		return nil, fmt.Errorf("reading profile: %w", err)
+	options := []ollama.Option{ollama.WithModel(model)}
+	if cfg.BaseURL != "" {
+		options = append(options, ollama.WithServerURL(cfg.BaseURL))
 	}
 
-	llm, err := ollama.New(ollama.WithModel("llama3.2:latest"))
+	llm, err := ollama.New(options...)
 	if err != nil {
 		return nil, fmt.Errorf("ollama: %w", err)
 	}
 
+	opts := []llms.CallOption{llms.WithTemperature(cfg.Temperature)}
+	if cfg.MaxTokens > 0 {
+		opts = append(opts, llms.WithMaxTokens(cfg.MaxTokens))
+	}
+
 	a := Agent{
 		llm:    llm,
-		prompt: string(prompt),
+		prompt: cfg.Profile,
+		opts:   opts,
 	}
 
 	return &a, nil
 }
 
+// Chat answers the input given the chat history.
 func (a *Agent) Chat(ctx context.Context, input string, history []string) (string, error) {
-	var b strings.Builder
-	for _, h := range history {
-		b.WriteString(fmt.Sprintf("%s\n\n", h))
+	prompt := agents.Prompt(a.prompt, input, history)
+
+	result, err := a.llm.Call(ctx, prompt, a.opts...)
+	if err != nil {
+		return "", fmt.Errorf("call: %w", err)
+	}
+
+	return result, nil
+}
+
+// ChatStream answers the input given the chat history, calling the function
+// with each chunk of the answer as it's generated.
+func (a *Agent) ChatStream(ctx context.Context, input string, history []string, fn agents.StreamFunc) (string, error) {
+	prompt := agents.Prompt(a.prompt, input, history)
+
+	streamFn := func(ctx context.Context, chunk []byte) error {
+		return fn(string(chunk))
 	}
 
-	prompt := fmt.Sprintf(a.prompt, b.String(), input)
+	opts := append([]llms.CallOption{llms.WithStreamingFunc(streamFn)}, a.opts...)
 
-	result, err := a.llm.Call(ctx, prompt, llms.WithMaxTokens(500), llms.WithTemperature(1.0))
+	result, err := a.llm.Call(ctx, prompt, opts...)
 	if err != nil {
 		return "", fmt.Errorf("call: %w", err)
 	}
@@ -0,0 +1,186 @@
+// Package openaillm provides an agent backed by any server implementing the
+// OpenAI chat completions API.
+package openaillm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ardanlabs/usdl/foundation/agents"
+)
+
+// DefaultBaseURL is used when the config doesn't provide a base URL.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type request struct {
+	Model       string    `json:"model"`
+	Messages    []message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Stream      bool      `json:"stream,omitempty"`
+}
+
+type response struct {
+	Choices []struct {
+		Message message `json:"message"`
+		Delta   message `json:"delta"`
+	} `json:"choices"`
+}
+
+// =============================================================================
+
+// Agent implements the agents.Agent and agents.Streamer interfaces.
+type Agent struct {
+	client      *http.Client
+	url         string
+	apiKey      string
+	model       string
+	prompt      string
+	temperature float64
+	maxTokens   int
+}
+
+// New constructs an agent for the chat completions API at the base URL.
+func New(cfg agents.Config) (*Agent, error) {
+	if cfg.Model == "" {
+		return nil, errors.New("model is required")
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+
+	a := Agent{
+		client:      http.DefaultClient,
+		url:         strings.TrimSuffix(baseURL, "/") + "/chat/completions",
+		apiKey:      cfg.APIKey,
+		model:       cfg.Model,
+		prompt:      cfg.Profile,
+		temperature: cfg.Temperature,
+		maxTokens:   cfg.MaxTokens,
+	}
+
+	return &a, nil
+}
+
+// Chat answers the input given the chat history.
+func (a *Agent) Chat(ctx context.Context, input string, history []string) (string, error) {
+	resp, err := a.call(ctx, input, history, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("decode: %w", err)
+	}
+
+	if len(r.Choices) == 0 {
+		return "", errors.New("no choices in response")
+	}
+
+	return r.Choices[0].Message.Content, nil
+}
+
+// ChatStream answers the input given the chat history, calling the function
+// with each chunk of the answer as it's generated.
+func (a *Agent) ChatStream(ctx context.Context, input string, history []string, fn agents.StreamFunc) (string, error) {
+	resp, err := a.call(ctx, input, history, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var b strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var r response
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return "", fmt.Errorf("decode: %w", err)
+		}
+
+		if len(r.Choices) == 0 || r.Choices[0].Delta.Content == "" {
+			continue
+		}
+
+		chunk := r.Choices[0].Delta.Content
+		b.WriteString(chunk)
+
+		if err := fn(chunk); err != nil {
+			return "", err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("read stream: %w", err)
+	}
+
+	return b.String(), nil
+}
+
+// =============================================================================
+
+func (a *Agent) call(ctx context.Context, input string, history []string, stream bool) (*http.Response, error) {
+	req := request{
+		Model: a.model,
+		Messages: []message{
+			{Role: "user", Content: agents.Prompt(a.prompt, input, history)},
+		},
+		Temperature: a.temperature,
+		MaxTokens:   a.maxTokens,
+		Stream:      stream,
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	if a.apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+
+	resp, err := a.client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,140 @@
+package openaillm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/agents"
+	"github.com/ardanlabs/usdl/foundation/agents/openaillm"
+)
+
+type request struct {
+	Model       string  `json:"model"`
+	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens"`
+	Stream      bool    `json:"stream"`
+	Messages    []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+}
+
+// =============================================================================
+
+func TestChat(t *testing.T) {
+	var got request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "bad key", http.StatusUnauthorized)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&got)
+
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hi bill"}}]}`)
+	}))
+	defer srv.Close()
+
+	agent, err := openaillm.New(agents.Config{
+		Profile:     "history: %sinput: %s",
+		Model:       "test-model",
+		BaseURL:     srv.URL + "/v1/",
+		APIKey:      "secret",
+		Temperature: 0.5,
+		MaxTokens:   100,
+	})
+	if err != nil {
+		t.Fatalf("Should be able to construct the agent: %s", err)
+	}
+
+	resp, err := agent.Chat(context.Background(), "hello", []string{"bill: hi"})
+	if err != nil {
+		t.Fatalf("Should be able to chat: %s", err)
+	}
+
+	if resp != "hi bill" {
+		t.Fatalf("Should get the answer, got %q", resp)
+	}
+
+	if got.Model != "test-model" || got.Temperature != 0.5 || got.MaxTokens != 100 || got.Stream {
+		t.Fatalf("Should send the settings, got %+v", got)
+	}
+
+	if len(got.Messages) != 1 || got.Messages[0].Content != "history: bill: hi\n\ninput: hello" {
+		t.Fatalf("Should send the filled prompt, got %+v", got.Messages)
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if !req.Stream {
+			http.Error(w, "expected stream", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for _, chunk := range []string{"hi ", "there ", "bill"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	agent, err := openaillm.New(agents.Config{
+		Profile: "%s%s",
+		Model:   "test-model",
+		BaseURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatalf("Should be able to construct the agent: %s", err)
+	}
+
+	var chunks []string
+	fn := func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}
+
+	resp, err := agents.Stream(context.Background(), agent, "hello", nil, fn)
+	if err != nil {
+		t.Fatalf("Should be able to stream: %s", err)
+	}
+
+	if resp != "hi there bill" || len(chunks) != 3 {
+		t.Fatalf("Should get the answer in chunks, got %q in %d chunks", resp, len(chunks))
+	}
+}
+
+func TestChatError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	agent, err := openaillm.New(agents.Config{
+		Profile: "%s%s",
+		Model:   "missing",
+		BaseURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatalf("Should be able to construct the agent: %s", err)
+	}
+
+	if _, err := agent.Chat(context.Background(), "hello", nil); err == nil {
+		t.Fatal("Should get an error for a failed request")
+	}
+}
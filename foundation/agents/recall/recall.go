@@ -0,0 +1,165 @@
+// Package recall provides a BM25 keyword index for finding past messages
+// that are relevant to a conversation, and support for fitting them into a
+// prompt's token budget.
+package recall
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// BM25 tuning values.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// stopWords are too common to say anything about relevance.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "do": true, "for": true, "from": true,
+	"has": true, "have": true, "he": true, "her": true, "his": true, "i": true,
+	"if": true, "in": true, "is": true, "it": true, "its": true, "me": true,
+	"my": true, "not": true, "of": true, "on": true, "or": true, "our": true,
+	"she": true, "so": true, "that": true, "the": true, "their": true,
+	"them": true, "they": true, "this": true, "to": true, "us": true,
+	"was": true, "we": true, "were": true, "what": true, "when": true,
+	"which": true, "who": true, "will": true, "with": true, "you": true,
+	"your": true,
+}
+
+// Terms returns the indexed words in the text and how often each appears.
+func Terms(text string) map[string]int {
+	f := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}
+
+	terms := make(map[string]int)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), f) {
+		if len(w) < 2 || stopWords[w] {
+			continue
+		}
+		terms[w]++
+	}
+
+	return terms
+}
+
+// Tokens estimates how many LLM tokens the text uses, at about four
+// characters a token.
+func Tokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// =============================================================================
+
+// Result represents a document that matched a search.
+type Result struct {
+	Doc   int
+	Score float64
+}
+
+// Index is a BM25 index over documents numbered in the order they are
+// added. It's not safe for concurrent use.
+type Index struct {
+	docs  []map[string]int
+	lens  []int
+	df    map[string]int
+	total int
+}
+
+// New constructs an empty index.
+func New() *Index {
+	return &Index{
+		df: make(map[string]int),
+	}
+}
+
+// Len returns the number of documents in the index.
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+// Add adds a document by its terms and returns its number.
+func (ix *Index) Add(terms map[string]int) int {
+	var n int
+	for t, c := range terms {
+		ix.df[t]++
+		n += c
+	}
+
+	ix.docs = append(ix.docs, terms)
+	ix.lens = append(ix.lens, n)
+	ix.total += n
+
+	return len(ix.docs) - 1
+}
+
+// Search returns the documents matching the query, best match first.
+func (ix *Index) Search(query string) []Result {
+	if len(ix.docs) == 0 {
+		return nil
+	}
+
+	terms := Terms(query)
+	avg := float64(ix.total) / float64(len(ix.docs))
+	n := float64(len(ix.docs))
+
+	var results []Result
+
+	for doc, dt := range ix.docs {
+		var score float64
+
+		for t := range terms {
+			tf := float64(dt[t])
+			if tf == 0 {
+				continue
+			}
+
+			df := float64(ix.df[t])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			norm := 1 - b + b*float64(ix.lens[doc])/max(avg, 1)
+
+			score += idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+
+		if score > 0 {
+			results = append(results, Result{Doc: doc, Score: score})
+		}
+	}
+
+	slices.SortStableFunc(results, func(x, y Result) int {
+		switch {
+		case x.Score > y.Score:
+			return -1
+		case x.Score < y.Score:
+			return 1
+		}
+		return y.Doc - x.Doc
+	})
+
+	return results
+}
+
+// Select takes the best results that fit in the token budget, given the
+// cost of each document, and returns their numbers in document order.
+func Select(results []Result, cost func(doc int) int, maxTokens int) []int {
+	var docs []int
+	var used int
+
+	for _, r := range results {
+		c := cost(r.Doc)
+		if used+c > maxTokens {
+			continue
+		}
+
+		used += c
+		docs = append(docs, r.Doc)
+	}
+
+	slices.Sort(docs)
+
+	return docs
+}
@@ -0,0 +1,71 @@
+package recall_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/agents/recall"
+)
+
+var docs = []string{
+	"The deploy to production failed last night because of the database migration.",
+	"Lunch at noon?",
+	"Can you review my pull request for the login page?",
+	"The database migration is fixed, production deploy works again.",
+	"Sure, noon works for lunch.",
+}
+
+// =============================================================================
+
+func TestSearch(t *testing.T) {
+	ix := recall.New()
+	for _, d := range docs {
+		ix.Add(recall.Terms(d))
+	}
+
+	results := ix.Search("what happened with the database migration?")
+	if len(results) != 2 {
+		t.Fatalf("Should match the two migration messages, got %+v", results)
+	}
+
+	got := []int{results[0].Doc, results[1].Doc}
+	slices.Sort(got)
+
+	if !slices.Equal(got, []int{0, 3}) {
+		t.Fatalf("Should match the migration messages, got %v", got)
+	}
+
+	if len(ix.Search("the and of")) != 0 {
+		t.Fatal("Should not match on stop words")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	ix := recall.New()
+	for _, d := range docs {
+		ix.Add(recall.Terms(d))
+	}
+
+	cost := func(doc int) int {
+		return recall.Tokens(docs[doc])
+	}
+
+	results := ix.Search("lunch noon migration")
+
+	all := recall.Select(results, cost, 1000)
+	if !slices.Equal(all, []int{0, 1, 3, 4}) {
+		t.Fatalf("Should select every match in document order, got %v", all)
+	}
+
+	budget := cost(1) + cost(4)
+	some := recall.Select(results, cost, budget)
+
+	var used int
+	for _, doc := range some {
+		used += cost(doc)
+	}
+
+	if len(some) == 0 || used > budget {
+		t.Fatalf("Should stay within the budget of %d, got %v using %d", budget, some, used)
+	}
+}
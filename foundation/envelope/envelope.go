@@ -0,0 +1,165 @@
+// Package envelope provides hybrid public key encryption for messages. A
+// message is sealed for a recipient's X25519 key with an ephemeral X25519
+// key exchange, HKDF-SHA256 and AES-256-GCM over the whole message. The
+// envelope records its version and algorithm so the scheme can change
+// without breaking older messages.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the envelope version written by Seal.
+const Version = 1
+
+// AlgX25519AESGCM names the only algorithm in version 1.
+const AlgX25519AESGCM = "X25519-HKDF-SHA256-AES-256-GCM"
+
+// ErrUnsupported is returned for envelopes this package can't open.
+var ErrUnsupported = errors.New("unsupported envelope")
+
+// Envelope represents a sealed message on the wire.
+type Envelope struct {
+	V     int    `json:"v"`
+	Alg   string `json:"alg"`
+	Key   []byte `json:"epk"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// GenerateKey generates a new X25519 key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// Seal encrypts the plaintext for the recipient's key. The associated data
+// isn't encrypted but must be the same to open the envelope, which binds
+// the message to its context, such as the sender and recipient.
+func Seal(to *ecdh.PublicKey, plaintext []byte, ad []byte) ([]byte, error) {
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	secret, err := ephemeral.ECDH(to)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	epk := ephemeral.PublicKey().Bytes()
+
+	aead, err := newAEAD(secret, epk, to.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+
+	env := Envelope{
+		V:     Version,
+		Alg:   AlgX25519AESGCM,
+		Key:   epk,
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, plaintext, ad),
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return data, nil
+}
+
+// Open decrypts a sealed envelope with the recipient's key.
+func Open(key *ecdh.PrivateKey, sealed []byte, ad []byte) ([]byte, error) {
+	env, err := parse(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	epk, err := ecdh.X25519().NewPublicKey(env.Key)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral key: %w", err)
+	}
+
+	secret, err := key.ECDH(epk)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	aead, err := newAEAD(secret, env.Key, key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: bad nonce size", ErrUnsupported)
+	}
+
+	plaintext, err := aead.Open(nil, env.Nonce, env.Data, ad)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// Is reports whether the data is an envelope this package can open. Data
+// from older schemes, such as RSA ciphertext, is not.
+func Is(data []byte) bool {
+	_, err := parse(data)
+	return err == nil
+}
+
+// =============================================================================
+
+func parse(data []byte) (Envelope, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return Envelope{}, ErrUnsupported
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %w", ErrUnsupported, err)
+	}
+
+	if env.V != Version || env.Alg != AlgX25519AESGCM {
+		return Envelope{}, fmt.Errorf("%w: version %d alg %q", ErrUnsupported, env.V, env.Alg)
+	}
+
+	return env, nil
+}
+
+func newAEAD(secret []byte, epk []byte, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, epk...), recipient...)
+
+	key, err := hkdf.Key(sha256.New, secret, salt, AlgX25519AESGCM, 32)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("gcm: %w", err)
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,82 @@
+package envelope_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/envelope"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := envelope.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	msg := bytes.Repeat([]byte("hello bill "), 100)
+	ad := []byte("alice->bill")
+
+	sealed, err := envelope.Seal(key.PublicKey(), msg, ad)
+	if err != nil {
+		t.Fatalf("Should be able to seal: %s", err)
+	}
+
+	if !envelope.Is(sealed) {
+		t.Fatal("Should recognize a sealed envelope")
+	}
+
+	if bytes.Contains(sealed, []byte("hello")) {
+		t.Fatal("Should not leak the plaintext")
+	}
+
+	got, err := envelope.Open(key, sealed, ad)
+	if err != nil {
+		t.Fatalf("Should be able to open: %s", err)
+	}
+
+	if !bytes.Equal(got, msg) {
+		t.Fatal("Should get back the original message")
+	}
+
+	// -------------------------------------------------------------------------
+
+	if _, err := envelope.Open(key, sealed, []byte("alice->carol")); err == nil {
+		t.Fatal("Should not open with different associated data")
+	}
+
+	other, err := envelope.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	if _, err := envelope.Open(other, sealed, ad); err == nil {
+		t.Fatal("Should not open with a different key")
+	}
+
+	tampered := bytes.Replace(sealed, []byte(`"data":"`), []byte(`"data":"A`), 1)
+	if _, err := envelope.Open(key, tampered, ad); err == nil {
+		t.Fatal("Should not open a tampered envelope")
+	}
+}
+
+func TestIsLegacy(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Should be able to generate an RSA key: %s", err)
+	}
+
+	ed, err := rsa.EncryptPKCS1v15(rand.Reader, &pk.PublicKey, []byte("hello"))
+	if err != nil {
+		t.Fatalf("Should be able to encrypt with RSA: %s", err)
+	}
+
+	if envelope.Is(ed) {
+		t.Fatal("Should not treat RSA ciphertext as an envelope")
+	}
+
+	if envelope.Is([]byte(`{"v":2,"alg":"future"}`)) {
+		t.Fatal("Should not accept an unknown version")
+	}
+}
@@ -0,0 +1,76 @@
+// Package fingerprint provides safety numbers two people can compare, in
+// person or over another channel, to check they have each other's real
+// keys. The numbers follow the scheme Signal uses: an iterated hash of each
+// party's identity and key, shown as groups of five digits.
+package fingerprint
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// version is hashed into every fingerprint so the scheme can change.
+const version = 0
+
+// iterations slows down finding a key with a matching fingerprint.
+const iterations = 5200
+
+// Fingerprint returns the 30 digit fingerprint for one party.
+func Fingerprint(id []byte, key []byte) string {
+	h := sha512.New()
+	h.Write(binary.BigEndian.AppendUint16(nil, version))
+	h.Write(key)
+	h.Write(id)
+	sum := h.Sum(nil)
+
+	for range iterations - 1 {
+		h.Reset()
+		h.Write(sum)
+		h.Write(key)
+		sum = h.Sum(nil)
+	}
+
+	var b strings.Builder
+	for i := range 6 {
+		chunk := sum[i*5 : i*5+5]
+		v := uint64(chunk[0])<<32 | uint64(chunk[1])<<24 | uint64(chunk[2])<<16 | uint64(chunk[3])<<8 | uint64(chunk[4])
+		fmt.Fprintf(&b, "%05d", v%100000)
+	}
+
+	return b.String()
+}
+
+// SafetyNumber returns the 60 digit safety number for two parties. Both
+// parties get the same number whichever side computes it.
+func SafetyNumber(localID []byte, localKey []byte, remoteID []byte, remoteKey []byte) string {
+	local := Fingerprint(localID, localKey)
+	remote := Fingerprint(remoteID, remoteKey)
+
+	if local < remote {
+		return local + remote
+	}
+
+	return remote + local
+}
+
+// Format splits the number into groups of five digits, with the given
+// number of groups on each line.
+func Format(number string, perLine int) string {
+	var b strings.Builder
+
+	for i := 0; i < len(number); i += 5 {
+		switch {
+		case i == 0:
+		case perLine > 0 && (i/5)%perLine == 0:
+			b.WriteString("\n")
+		default:
+			b.WriteString(" ")
+		}
+
+		b.WriteString(number[i:min(i+5, len(number))])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package fingerprint_test
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/fingerprint"
+)
+
+var (
+	aliceID  = []byte("alice-address")
+	aliceKey = []byte("alice-key")
+	bobID    = []byte("bob-address")
+	bobKey   = []byte("bob-key")
+)
+
+func TestSafetyNumber(t *testing.T) {
+	fp := fingerprint.Fingerprint(aliceID, aliceKey)
+	if len(fp) != 30 {
+		t.Fatalf("Should have a 30 digit fingerprint, got %q", fp)
+	}
+
+	alice := fingerprint.SafetyNumber(aliceID, aliceKey, bobID, bobKey)
+	bob := fingerprint.SafetyNumber(bobID, bobKey, aliceID, aliceKey)
+
+	if len(alice) != 60 {
+		t.Fatalf("Should have a 60 digit safety number, got %q", alice)
+	}
+
+	if alice != bob {
+		t.Fatalf("Should get the same number on both sides, got %q and %q", alice, bob)
+	}
+
+	changed := fingerprint.SafetyNumber(aliceID, aliceKey, bobID, []byte("mallory-key"))
+	if changed == alice {
+		t.Fatal("Should get a different number when a key changes")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	number := "123451234512345123451234512345"
+
+	got := fingerprint.Format(number, 3)
+	exp := "12345 12345 12345\n12345 12345 12345"
+
+	if got != exp {
+		t.Fatalf("Should format in groups, got %q, exp %q", got, exp)
+	}
+}
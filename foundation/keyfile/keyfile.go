@@ -0,0 +1,150 @@
+// Package keyfile encrypts secrets at rest with a passphrase. The format
+// follows the web3 keystore JSON: the parameters for deriving a key from
+// the passphrase are stored with the ciphertext. The key is derived with
+// PBKDF2-HMAC-SHA256 and the secret is encrypted with AES-256-GCM, which
+// also detects a wrong passphrase.
+package keyfile
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the keyfile version written by Encrypt.
+const Version = 1
+
+// DefaultIterations is the PBKDF2 work factor for new keyfiles.
+const DefaultIterations = 600_000
+
+// ErrPassphrase is returned when the passphrase doesn't open the keyfile.
+var ErrPassphrase = errors.New("wrong passphrase")
+
+// File represents a keyfile on disk.
+type File struct {
+	Version int    `json:"version"`
+	Crypto  Crypto `json:"crypto"`
+}
+
+// Crypto holds the encrypted secret and how to decrypt it.
+type Crypto struct {
+	Cipher     string    `json:"cipher"`
+	CipherText []byte    `json:"ciphertext"`
+	Nonce      []byte    `json:"nonce"`
+	KDF        string    `json:"kdf"`
+	KDFParams  KDFParams `json:"kdfparams"`
+}
+
+// KDFParams are the parameters for deriving the key from the passphrase.
+type KDFParams struct {
+	PRF        string `json:"prf"`
+	Iterations int    `json:"c"`
+	Salt       []byte `json:"salt"`
+	KeyLen     int    `json:"dklen"`
+}
+
+// Encrypt encrypts the secret with the passphrase and returns the keyfile.
+func Encrypt(secret []byte, passphrase string, iterations int) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("salt: %w", err)
+	}
+
+	params := KDFParams{
+		PRF:        "hmac-sha256",
+		Iterations: iterations,
+		Salt:       salt,
+		KeyLen:     32,
+	}
+
+	aead, err := newAEAD(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+
+	f := File{
+		Version: Version,
+		Crypto: Crypto{
+			Cipher:     "aes-256-gcm",
+			CipherText: aead.Seal(nil, nonce, secret, nil),
+			Nonce:      nonce,
+			KDF:        "pbkdf2",
+			KDFParams:  params,
+		},
+	}
+
+	data, err := json.MarshalIndent(f, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return data, nil
+}
+
+// Decrypt decrypts the keyfile with the passphrase and returns the secret.
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	c := f.Crypto
+
+	switch {
+	case f.Version != Version:
+		return nil, fmt.Errorf("unsupported keyfile version %d", f.Version)
+	case c.Cipher != "aes-256-gcm":
+		return nil, fmt.Errorf("unsupported cipher %q", c.Cipher)
+	case c.KDF != "pbkdf2" || c.KDFParams.PRF != "hmac-sha256":
+		return nil, fmt.Errorf("unsupported kdf %q %q", c.KDF, c.KDFParams.PRF)
+	case c.KDFParams.KeyLen != 32 || c.KDFParams.Iterations < 1:
+		return nil, errors.New("invalid kdf parameters")
+	}
+
+	aead, err := newAEAD(passphrase, c.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+
+	secret, err := aead.Open(nil, c.Nonce, c.CipherText, nil)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+
+	return secret, nil
+}
+
+// =============================================================================
+
+func newAEAD(passphrase string, params KDFParams) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, params.Salt, params.Iterations, params.KeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("pbkdf2: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("gcm: %w", err)
+	}
+
+	return aead, nil
+}
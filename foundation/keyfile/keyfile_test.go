@@ -0,0 +1,35 @@
+package keyfile_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/keyfile"
+)
+
+func TestKeyFile(t *testing.T) {
+	secret := []byte("the keys to the kingdom")
+
+	data, err := keyfile.Encrypt(secret, "correct horse", 1000)
+	if err != nil {
+		t.Fatalf("Should be able to encrypt: %s", err)
+	}
+
+	if bytes.Contains(data, secret) {
+		t.Fatal("Should not store the secret in the clear")
+	}
+
+	got, err := keyfile.Decrypt(data, "correct horse")
+	if err != nil {
+		t.Fatalf("Should be able to decrypt: %s", err)
+	}
+
+	if !bytes.Equal(got, secret) {
+		t.Fatalf("Should get back the secret, got %q", got)
+	}
+
+	if _, err := keyfile.Decrypt(data, "battery staple"); !errors.Is(err, keyfile.ErrPassphrase) {
+		t.Fatalf("Should reject the wrong passphrase, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Package mnemonic encodes secrets as BIP-39 mnemonic sentences so they can
+// be written down and typed back in. The English wordlist is used, and the
+// last word carries a checksum that catches most typing mistakes.
+package mnemonic
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+//go:embed english.txt
+var english string
+
+var (
+	words   = strings.Fields(english)
+	indexes = wordIndexes(words)
+)
+
+// ErrInvalid is returned when a mnemonic has an unknown word, the wrong
+// number of words or a bad checksum.
+var ErrInvalid = errors.New("invalid mnemonic")
+
+// New returns the mnemonic for the entropy, which must be 16 to 32 bytes
+// in steps of 4.
+func New(entropy []byte) (string, error) {
+	n := len(entropy)
+	if n < 16 || n > 32 || n%4 != 0 {
+		return "", fmt.Errorf("entropy must be 16 to 32 bytes in steps of 4, got %d", n)
+	}
+
+	// The checksum is the first bits of the entropy's hash, one bit for
+	// every 32 bits of entropy.
+	csBits := n / 4
+	hash := sha256.Sum256(entropy)
+
+	b := new(big.Int).SetBytes(entropy)
+	b.Lsh(b, uint(csBits))
+	b.Or(b, big.NewInt(int64(hash[0]>>(8-csBits))))
+
+	numWords := (n*8 + csBits) / 11
+	out := make([]string, numWords)
+
+	mask := big.NewInt(2047)
+	for i := numWords - 1; i >= 0; i-- {
+		idx := new(big.Int).And(b, mask)
+		out[i] = words[idx.Int64()]
+		b.Rsh(b, 11)
+	}
+
+	return strings.Join(out, " "), nil
+}
+
+// Entropy returns the entropy encoded in the mnemonic after checking the
+// checksum. Case and extra spaces are ignored.
+func Entropy(mnemonic string) ([]byte, error) {
+	fields := strings.Fields(strings.ToLower(mnemonic))
+
+	numWords := len(fields)
+	if numWords < 12 || numWords > 24 || numWords%3 != 0 {
+		return nil, fmt.Errorf("%w: got %d words", ErrInvalid, numWords)
+	}
+
+	b := new(big.Int)
+	for _, word := range fields {
+		idx, exists := indexes[word]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalid, word)
+		}
+
+		b.Lsh(b, 11)
+		b.Or(b, big.NewInt(int64(idx)))
+	}
+
+	csBits := numWords / 3
+	n := (numWords*11 - csBits) / 8
+
+	checksum := new(big.Int).And(b, big.NewInt(int64(1<<csBits-1)))
+	b.Rsh(b, uint(csBits))
+
+	entropy := b.FillBytes(make([]byte, n))
+
+	hash := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(hash[0]>>(8-csBits)) {
+		return nil, fmt.Errorf("%w: checksum", ErrInvalid)
+	}
+
+	return entropy, nil
+}
+
+// Seed returns the 64 byte BIP-39 seed for the mnemonic and passphrase. The
+// mnemonic isn't checked, so call Entropy first for one that was typed in.
+func Seed(mnemonic string, passphrase string) ([]byte, error) {
+	sentence := strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+
+	seed, err := pbkdf2.Key(sha512.New, sentence, []byte("mnemonic"+passphrase), 2048, 64)
+	if err != nil {
+		return nil, fmt.Errorf("seed: %w", err)
+	}
+
+	return seed, nil
+}
+
+// =============================================================================
+
+func wordIndexes(words []string) map[string]int {
+	m := make(map[string]int, len(words))
+	for i, word := range words {
+		m[word] = i
+	}
+
+	return m
+}
@@ -0,0 +1,87 @@
+package mnemonic_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/mnemonic"
+)
+
+// Vectors from the BIP-39 reference implementation, with the passphrase
+// "TREZOR".
+var vectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		entropy:  "00000000000000000000000000000000",
+		mnemonic: "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		seed:     "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		entropy:  "7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		mnemonic: "legal winner thank year wave sausage worth useful legal winner thank yellow",
+		seed:     "2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		entropy:  "ffffffffffffffffffffffffffffffff",
+		mnemonic: "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		seed:     "ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		entropy:  "0000000000000000000000000000000000000000000000000000000000000000",
+		mnemonic: "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		seed:     "bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestMnemonic(t *testing.T) {
+	for _, v := range vectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+
+		got, err := mnemonic.New(entropy)
+		if err != nil {
+			t.Fatalf("Should be able to encode %s: %s", v.entropy, err)
+		}
+
+		if got != v.mnemonic {
+			t.Fatalf("Should encode %s as %q, got %q", v.entropy, v.mnemonic, got)
+		}
+
+		back, err := mnemonic.Entropy(strings.ToUpper(v.mnemonic) + "  ")
+		if err != nil {
+			t.Fatalf("Should be able to decode %q: %s", v.mnemonic, err)
+		}
+
+		if !bytes.Equal(back, entropy) {
+			t.Fatalf("Should decode %q to %s, got %x", v.mnemonic, v.entropy, back)
+		}
+
+		seed, err := mnemonic.Seed(v.mnemonic, "TREZOR")
+		if err != nil {
+			t.Fatalf("Should be able to derive the seed: %s", err)
+		}
+
+		if hex.EncodeToString(seed) != v.seed {
+			t.Fatalf("Should derive the seed for %q, got %x", v.mnemonic, seed)
+		}
+	}
+}
+
+func TestMnemonicInvalid(t *testing.T) {
+	tests := map[string]string{
+		"checksum":     "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"unknown word": "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abou",
+		"word count":   "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+	}
+
+	for name, m := range tests {
+		if _, err := mnemonic.Entropy(m); !errors.Is(err, mnemonic.ErrInvalid) {
+			t.Fatalf("Should reject a bad %s, got %v", name, err)
+		}
+	}
+}
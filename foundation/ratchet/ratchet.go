@@ -0,0 +1,421 @@
+// Package ratchet provides the double ratchet algorithm for forward secret
+// sessions between two parties. Every message is encrypted with a new key
+// from a symmetric-key ratchet, and every reply turns a Diffie-Hellman
+// ratchet over X25519 keys. A session starts from a shared secret agreed
+// with an X3DH style exchange between the parties' long-term keys.
+package ratchet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// MaxSkip is the most message keys kept for messages that haven't arrived.
+const MaxSkip = 1000
+
+// Set of errors for messages that can't be decrypted.
+var (
+	ErrTooManySkipped = errors.New("too many skipped messages")
+	ErrNoKey          = errors.New("no key for message")
+)
+
+// info strings keep the keys derived for each use apart.
+const (
+	infoX3DH    = "usdl-x3dh"
+	infoRoot    = "usdl-ratchet-root"
+	infoMessage = "usdl-ratchet-message"
+)
+
+// =============================================================================
+
+// GenerateKey generates a new X25519 key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// InitiatorSecret returns the secret the initiator of a session shares with
+// the responder, from the initiator's identity and ephemeral keys and the
+// responder's identity key.
+func InitiatorSecret(identity *ecdh.PrivateKey, ephemeral *ecdh.PrivateKey, remote *ecdh.PublicKey) ([]byte, error) {
+	dh1, err := identity.ECDH(remote)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	dh2, err := ephemeral.ECDH(remote)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	return sharedSecret(dh1, dh2, identity.PublicKey(), remote)
+}
+
+// ResponderSecret returns the secret the responder shares with the
+// initiator, from the responder's identity key and the initiator's identity
+// and ephemeral keys.
+func ResponderSecret(identity *ecdh.PrivateKey, remote *ecdh.PublicKey, ephemeral *ecdh.PublicKey) ([]byte, error) {
+	dh1, err := identity.ECDH(remote)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	dh2, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	return sharedSecret(dh1, dh2, remote, identity.PublicKey())
+}
+
+func sharedSecret(dh1 []byte, dh2 []byte, initiator *ecdh.PublicKey, responder *ecdh.PublicKey) ([]byte, error) {
+	ikm := slices.Concat(dh1, dh2)
+	info := infoX3DH + string(initiator.Bytes()) + string(responder.Bytes())
+
+	secret, err := hkdf.Key(sha256.New, ikm, make([]byte, sha256.Size), info, 32)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	return secret, nil
+}
+
+// =============================================================================
+
+// Header is sent in the clear with each message so the receiver can find
+// the key to decrypt it.
+type Header struct {
+	DH []byte `json:"dh"`
+	PN uint32 `json:"pn"`
+	N  uint32 `json:"n"`
+}
+
+// State represents one side of a session. It's marshaled to JSON to be
+// stored between messages and holds secrets, so it must be stored
+// encrypted. It's not safe for concurrent use.
+type State struct {
+	DHs     []byte            `json:"dhs"`
+	DHr     []byte            `json:"dhr,omitempty"`
+	RK      []byte            `json:"rk"`
+	CKs     []byte            `json:"cks,omitempty"`
+	CKr     []byte            `json:"ckr,omitempty"`
+	Ns      uint32            `json:"ns"`
+	Nr      uint32            `json:"nr"`
+	PN      uint32            `json:"pn"`
+	Skipped map[string][]byte `json:"skipped,omitempty"`
+	Order   []string          `json:"order,omitempty"`
+}
+
+// NewInitiator constructs the state for the side that starts the session
+// and sends the first message, given the responder's identity key.
+func NewInitiator(secret []byte, remote *ecdh.PublicKey) (*State, error) {
+	dhs, err := GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	dh, err := dhs.ECDH(remote)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+
+	rk, cks, err := kdfRoot(secret, dh)
+	if err != nil {
+		return nil, err
+	}
+
+	s := State{
+		DHs: dhs.Bytes(),
+		DHr: remote.Bytes(),
+		RK:  rk,
+		CKs: cks,
+	}
+
+	return &s, nil
+}
+
+// NewResponder constructs the state for the side that receives the first
+// message, given its own identity key.
+func NewResponder(secret []byte, identity *ecdh.PrivateKey) *State {
+	s := State{
+		DHs: identity.Bytes(),
+		RK:  secret,
+	}
+
+	return &s
+}
+
+// Encrypt encrypts the plaintext with the next sending key. The associated
+// data isn't encrypted but must be the same to decrypt the message.
+func (s *State) Encrypt(plaintext []byte, ad []byte) (Header, []byte, error) {
+	if s.CKs == nil {
+		return Header{}, nil, errors.New("session can't send until it receives a message")
+	}
+
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return Header{}, nil, fmt.Errorf("ratchet key: %w", err)
+	}
+
+	var mk []byte
+	s.CKs, mk = kdfChain(s.CKs)
+
+	h := Header{
+		DH: dhs.PublicKey().Bytes(),
+		PN: s.PN,
+		N:  s.Ns,
+	}
+	s.Ns++
+
+	ciphertext, err := seal(mk, plaintext, h, ad)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	return h, ciphertext, nil
+}
+
+// Decrypt decrypts a message. Messages can arrive out of order, the keys
+// for messages that were skipped are kept until they arrive. The state is
+// left as it was when the message can't be decrypted.
+func (s *State) Decrypt(h Header, ciphertext []byte, ad []byte) ([]byte, error) {
+	if mk, exists := s.Skipped[skippedKey(h.DH, h.N)]; exists {
+		plaintext, err := open(mk, ciphertext, h, ad)
+		if err != nil {
+			return nil, err
+		}
+
+		s.deleteSkipped(skippedKey(h.DH, h.N))
+
+		return plaintext, nil
+	}
+
+	// Work on a copy so a bad message doesn't damage the state.
+	next := s.clone()
+
+	if !bytes.Equal(h.DH, next.DHr) {
+		if next.CKr != nil {
+			if err := next.skip(h.PN); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := next.turn(h.DH); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.N < next.Nr {
+		return nil, ErrNoKey
+	}
+
+	if err := next.skip(h.N); err != nil {
+		return nil, err
+	}
+
+	var mk []byte
+	next.CKr, mk = kdfChain(next.CKr)
+	next.Nr++
+
+	plaintext, err := open(mk, ciphertext, h, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	*s = *next
+
+	return plaintext, nil
+}
+
+// skip keeps the keys for the messages in the receiving chain up to n.
+func (s *State) skip(n uint32) error {
+	if n < s.Nr {
+		return nil
+	}
+
+	if n-s.Nr > MaxSkip {
+		return ErrTooManySkipped
+	}
+
+	if s.CKr == nil {
+		return nil
+	}
+
+	for s.Nr < n {
+		var mk []byte
+		s.CKr, mk = kdfChain(s.CKr)
+
+		s.addSkipped(skippedKey(s.DHr, s.Nr), mk)
+		s.Nr++
+	}
+
+	return nil
+}
+
+// turn performs a Diffie-Hellman ratchet step for the new remote key.
+func (s *State) turn(remote []byte) error {
+	dhr, err := ecdh.X25519().NewPublicKey(remote)
+	if err != nil {
+		return fmt.Errorf("remote key: %w", err)
+	}
+
+	dhs, err := ecdh.X25519().NewPrivateKey(s.DHs)
+	if err != nil {
+		return fmt.Errorf("ratchet key: %w", err)
+	}
+
+	s.PN = s.Ns
+	s.Ns = 0
+	s.Nr = 0
+	s.DHr = remote
+
+	dh, err := dhs.ECDH(dhr)
+	if err != nil {
+		return fmt.Errorf("ecdh: %w", err)
+	}
+
+	if s.RK, s.CKr, err = kdfRoot(s.RK, dh); err != nil {
+		return err
+	}
+
+	if dhs, err = GenerateKey(); err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	s.DHs = dhs.Bytes()
+
+	if dh, err = dhs.ECDH(dhr); err != nil {
+		return fmt.Errorf("ecdh: %w", err)
+	}
+
+	if s.RK, s.CKs, err = kdfRoot(s.RK, dh); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// addSkipped keeps the message key, dropping the oldest once there are
+// more than MaxSkip.
+func (s *State) addSkipped(key string, mk []byte) {
+	if s.Skipped == nil {
+		s.Skipped = make(map[string][]byte)
+	}
+
+	s.Skipped[key] = mk
+	s.Order = append(s.Order, key)
+
+	for len(s.Order) > MaxSkip {
+		delete(s.Skipped, s.Order[0])
+		s.Order = s.Order[1:]
+	}
+}
+
+func (s *State) deleteSkipped(key string) {
+	delete(s.Skipped, key)
+	s.Order = slices.DeleteFunc(s.Order, func(k string) bool {
+		return k == key
+	})
+}
+
+func (s *State) clone() *State {
+	next := *s
+	next.Skipped = maps.Clone(s.Skipped)
+	next.Order = slices.Clone(s.Order)
+
+	return &next
+}
+
+// =============================================================================
+
+func skippedKey(dh []byte, n uint32) string {
+	return hex.EncodeToString(dh) + "/" + strconv.FormatUint(uint64(n), 10)
+}
+
+// kdfRoot returns the next root key and a new chain key.
+func kdfRoot(rk []byte, dh []byte) ([]byte, []byte, error) {
+	out, err := hkdf.Key(sha256.New, dh, rk, infoRoot, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	return out[:32], out[32:], nil
+}
+
+// kdfChain returns the next chain key and a message key.
+func kdfChain(ck []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x02})
+	next := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, ck)
+	mac.Write([]byte{0x01})
+	mk := mac.Sum(nil)
+
+	return next, mk
+}
+
+// newAEAD returns the cipher and nonce for a message key. Each message key
+// is used once, so the nonce can be derived with the key.
+func newAEAD(mk []byte) (cipher.AEAD, []byte, error) {
+	out, err := hkdf.Key(sha256.New, mk, make([]byte, sha256.Size), infoMessage, 32+12)
+	if err != nil {
+		return nil, nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	block, err := aes.NewCipher(out[:32])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("gcm: %w", err)
+	}
+
+	return aead, out[32:], nil
+}
+
+func seal(mk []byte, plaintext []byte, h Header, ad []byte) ([]byte, error) {
+	aead, nonce, err := newAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+
+	hd, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("marshal header: %w", err)
+	}
+
+	return aead.Seal(nil, nonce, plaintext, slices.Concat(ad, hd)), nil
+}
+
+func open(mk []byte, ciphertext []byte, h Header, ad []byte) ([]byte, error) {
+	aead, nonce, err := newAEAD(mk)
+	if err != nil {
+		return nil, err
+	}
+
+	hd, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("marshal header: %w", err)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, slices.Concat(ad, hd))
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	return plaintext, nil
+}
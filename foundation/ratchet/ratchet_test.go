@@ -0,0 +1,153 @@
+package ratchet_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/ratchet"
+)
+
+var ad = []byte("alice<->bob")
+
+type sealed struct {
+	h    ratchet.Header
+	data []byte
+}
+
+func newPair(t *testing.T) (*ratchet.State, *ratchet.State) {
+	aliceID, err := ratchet.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	bobID, err := ratchet.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	ephemeral, err := ratchet.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	aliceSecret, err := ratchet.InitiatorSecret(aliceID, ephemeral, bobID.PublicKey())
+	if err != nil {
+		t.Fatalf("Should be able to agree a secret: %s", err)
+	}
+
+	bobSecret, err := ratchet.ResponderSecret(bobID, aliceID.PublicKey(), ephemeral.PublicKey())
+	if err != nil {
+		t.Fatalf("Should be able to agree a secret: %s", err)
+	}
+
+	alice, err := ratchet.NewInitiator(aliceSecret, bobID.PublicKey())
+	if err != nil {
+		t.Fatalf("Should be able to start a session: %s", err)
+	}
+
+	return alice, ratchet.NewResponder(bobSecret, bobID)
+}
+
+func encrypt(t *testing.T, s *ratchet.State, text string) sealed {
+	h, data, err := s.Encrypt([]byte(text), ad)
+	if err != nil {
+		t.Fatalf("Should be able to encrypt %q: %s", text, err)
+	}
+
+	return sealed{h: h, data: data}
+}
+
+func decrypt(t *testing.T, s *ratchet.State, msg sealed, exp string) {
+	got, err := s.Decrypt(msg.h, msg.data, ad)
+	if err != nil {
+		t.Fatalf("Should be able to decrypt %q: %s", exp, err)
+	}
+
+	if string(got) != exp {
+		t.Fatalf("Should decrypt %q, got %q", exp, got)
+	}
+}
+
+// =============================================================================
+
+func TestConversation(t *testing.T) {
+	alice, bob := newPair(t)
+
+	if _, _, err := bob.Encrypt([]byte("too soon"), ad); err == nil {
+		t.Fatal("Should not let the responder send before it receives")
+	}
+
+	for turn := range 3 {
+		for i := range 3 {
+			text := fmt.Sprintf("alice %d-%d", turn, i)
+			decrypt(t, bob, encrypt(t, alice, text), text)
+		}
+
+		text := fmt.Sprintf("bob %d", turn)
+		decrypt(t, alice, encrypt(t, bob, text), text)
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	alice, bob := newPair(t)
+
+	m1 := encrypt(t, alice, "one")
+	m2 := encrypt(t, alice, "two")
+	m3 := encrypt(t, alice, "three")
+
+	decrypt(t, bob, m3, "three")
+
+	reply := encrypt(t, bob, "got three")
+	decrypt(t, alice, reply, "got three")
+
+	m4 := encrypt(t, alice, "four")
+	decrypt(t, bob, m4, "four")
+
+	decrypt(t, bob, m1, "one")
+	decrypt(t, bob, m2, "two")
+
+	if _, err := bob.Decrypt(m2.h, m2.data, ad); err == nil {
+		t.Fatal("Should not decrypt a replayed message")
+	}
+}
+
+func TestTampered(t *testing.T) {
+	alice, bob := newPair(t)
+
+	m1 := encrypt(t, alice, "one")
+
+	bad := sealed{h: m1.h, data: append([]byte{}, m1.data...)}
+	bad.data[0] ^= 0xff
+
+	if _, err := bob.Decrypt(bad.h, bad.data, ad); err == nil {
+		t.Fatal("Should not decrypt a tampered message")
+	}
+
+	if _, err := bob.Decrypt(m1.h, m1.data, []byte("alice<->carol")); err == nil {
+		t.Fatal("Should not decrypt with different associated data")
+	}
+
+	decrypt(t, bob, m1, "one")
+}
+
+func TestPersist(t *testing.T) {
+	alice, bob := newPair(t)
+
+	m1 := encrypt(t, alice, "one")
+	m2 := encrypt(t, alice, "two")
+	decrypt(t, bob, m2, "two")
+
+	data, err := json.Marshal(bob)
+	if err != nil {
+		t.Fatalf("Should be able to marshal the state: %s", err)
+	}
+
+	var restored ratchet.State
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Should be able to unmarshal the state: %s", err)
+	}
+
+	decrypt(t, &restored, m1, "one")
+	decrypt(t, alice, encrypt(t, &restored, "back"), "back")
+}
@@ -0,0 +1,133 @@
+// Package rotation provides the statement that moves an identity from one
+// address to another. The old key signs the statement naming the new
+// address and the new key signs it back, so the statement proves the same
+// person holds both keys.
+package rotation
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ardanlabs/usdl/foundation/signature"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrInvalid is returned when a statement isn't signed by both keys.
+var ErrInvalid = errors.New("invalid rotation statement")
+
+// Signature is a signature in the [R|S|V] format.
+type Signature struct {
+	V *big.Int `json:"v"`
+	R *big.Int `json:"r"`
+	S *big.Int `json:"s"`
+}
+
+// Statement says the identity at OldID moved to NewID. Key is the public
+// encryption key of the new identity.
+type Statement struct {
+	OldID  common.Address `json:"oldID"`
+	NewID  common.Address `json:"newID"`
+	Key    string         `json:"key,omitempty"`
+	Issued int64          `json:"issued"`
+	OldSig Signature      `json:"oldSig"`
+	NewSig Signature      `json:"newSig"`
+}
+
+// New constructs a statement moving the identity of the old key to the new
+// key, signed by both.
+func New(oldKey *ecdsa.PrivateKey, newKey *ecdsa.PrivateKey, key string) (Statement, error) {
+	stmt := Statement{
+		OldID:  crypto.PubkeyToAddress(oldKey.PublicKey),
+		NewID:  crypto.PubkeyToAddress(newKey.PublicKey),
+		Key:    key,
+		Issued: time.Now().Unix(),
+	}
+
+	v, r, s, err := signature.Sign(stmt.oldData(), oldKey)
+	if err != nil {
+		return Statement{}, fmt.Errorf("signing with old key: %w", err)
+	}
+
+	stmt.OldSig = Signature{V: v, R: r, S: s}
+
+	v, r, s, err = signature.Sign(stmt.newData(), newKey)
+	if err != nil {
+		return Statement{}, fmt.Errorf("signing with new key: %w", err)
+	}
+
+	stmt.NewSig = Signature{V: v, R: r, S: s}
+
+	return stmt, nil
+}
+
+// Verify checks the statement was signed by the keys of both addresses.
+func (stmt Statement) Verify() error {
+	if stmt.OldID == (common.Address{}) || stmt.NewID == (common.Address{}) || stmt.OldID == stmt.NewID {
+		return fmt.Errorf("%w: addresses", ErrInvalid)
+	}
+
+	if err := verify(stmt.oldData(), stmt.OldSig, stmt.OldID); err != nil {
+		return fmt.Errorf("%w: old key: %w", ErrInvalid, err)
+	}
+
+	if err := verify(stmt.newData(), stmt.NewSig, stmt.NewID); err != nil {
+		return fmt.Errorf("%w: new key: %w", ErrInvalid, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+func (stmt Statement) oldData() any {
+	return struct {
+		OldID  common.Address
+		NewID  common.Address
+		Key    string
+		Issued int64
+	}{
+		OldID:  stmt.OldID,
+		NewID:  stmt.NewID,
+		Key:    stmt.Key,
+		Issued: stmt.Issued,
+	}
+}
+
+// newData includes the old key's signature, so the new key signs the
+// statement back rather than just the same claim.
+func (stmt Statement) newData() any {
+	return struct {
+		OldID  common.Address
+		NewID  common.Address
+		Key    string
+		Issued int64
+		OldSig Signature
+	}{
+		OldID:  stmt.OldID,
+		NewID:  stmt.NewID,
+		Key:    stmt.Key,
+		Issued: stmt.Issued,
+		OldSig: stmt.OldSig,
+	}
+}
+
+func verify(data any, sig Signature, id common.Address) error {
+	if sig.V == nil || sig.R == nil || sig.S == nil {
+		return errors.New("missing signature")
+	}
+
+	addr, err := signature.FromAddress(data, sig.V, sig.R, sig.S)
+	if err != nil {
+		return err
+	}
+
+	if common.HexToAddress(addr) != id {
+		return errors.New("signature doesn't match the address")
+	}
+
+	return nil
+}
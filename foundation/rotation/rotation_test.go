@@ -0,0 +1,78 @@
+package rotation_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ardanlabs/usdl/foundation/rotation"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestStatement(t *testing.T) {
+	oldKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	newKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	stmt, err := rotation.New(oldKey, newKey, "encryption key")
+	if err != nil {
+		t.Fatalf("Should be able to create a statement: %s", err)
+	}
+
+	data, err := json.Marshal(stmt)
+	if err != nil {
+		t.Fatalf("Should be able to marshal the statement: %s", err)
+	}
+
+	var got rotation.Statement
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Should be able to unmarshal the statement: %s", err)
+	}
+
+	if err := got.Verify(); err != nil {
+		t.Fatalf("Should be able to verify the statement: %s", err)
+	}
+
+	if got.NewID != crypto.PubkeyToAddress(newKey.PublicKey) {
+		t.Fatalf("Should name the new address, got %s", got.NewID)
+	}
+
+	// -------------------------------------------------------------------------
+
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Should be able to generate a key: %s", err)
+	}
+
+	redirected := got
+	redirected.NewID = crypto.PubkeyToAddress(other.PublicKey)
+
+	if err := redirected.Verify(); !errors.Is(err, rotation.ErrInvalid) {
+		t.Fatalf("Should reject a statement naming another address, got %v", err)
+	}
+
+	rekeyed := got
+	rekeyed.Key = "another key"
+
+	if err := rekeyed.Verify(); !errors.Is(err, rotation.ErrInvalid) {
+		t.Fatalf("Should reject a statement with another key, got %v", err)
+	}
+
+	// Only the old key signed this one, the new key didn't sign it back.
+	oneSided, err := rotation.New(oldKey, other, "")
+	if err != nil {
+		t.Fatalf("Should be able to create a statement: %s", err)
+	}
+	oneSided.NewID = got.NewID
+	oneSided.NewSig = got.NewSig
+
+	if err := oneSided.Verify(); !errors.Is(err, rotation.ErrInvalid) {
+		t.Fatalf("Should reject a statement the new key didn't sign, got %v", err)
+	}
+}
@@ -1,12 +1,13 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 )
 
 type listener struct {
-	listener   *net.TCPListener
+	listener   net.Listener
 	listenerMu sync.RWMutex
 }
 
@@ -14,7 +15,7 @@ func newListener() *listener {
 	return &listener{}
 }
 
-func (l *listener) tcpListener() *net.TCPListener {
+func (l *listener) netListener() net.Listener {
 	l.listenerMu.RLock()
 	defer l.listenerMu.RUnlock()
 
@@ -25,19 +26,29 @@ func (l *listener) reset() {
 	l.listenerMu.Lock()
 	defer l.listenerMu.Unlock()
 
-	l.listener.Close()
+	if l.listener != nil {
+		l.listener.Close()
+	}
 	l.listener = nil
 }
 
-func (l *listener) start(network string, laddr *net.TCPAddr) (*net.TCPListener, error) {
+// start binds the listener. When a TLS configuration is provided the
+// accepted connections are wrapped, the handshake happens on the first
+// read or write of the connection.
+func (l *listener) start(network string, laddr *net.TCPAddr, tlsConfig *tls.Config) (net.Listener, error) {
 	l.listenerMu.Lock()
 	defer l.listenerMu.Unlock()
 
-	listener, err := net.ListenTCP(network, laddr)
+	tcpListener, err := net.ListenTCP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
 
+	var listener net.Listener = tcpListener
+	if tlsConfig != nil {
+		listener = tls.NewListener(tcpListener, tlsConfig)
+	}
+
 	l.listener = listener
 
 	return listener, nil
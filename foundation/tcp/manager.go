@@ -2,6 +2,7 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -14,8 +15,9 @@ var (
 
 // ClientConfig provides a data structure of required configuration parameters.
 type ClientConfig struct {
-	Handlers Handlers // Support for binding and handling requests.
-	Logger   Logger   // Support for logging events that occur in the TCP listener.
+	Handlers  Handlers    // Support for binding and handling requests.
+	Logger    Logger      // Support for logging events that occur in the TCP listener.
+	TLSConfig *tls.Config // Optional, dialed connections will use TLS.
 }
 
 func (cfg ClientConfig) validate() error {
@@ -32,10 +34,11 @@ func (cfg ClientConfig) validate() error {
 
 // ClientManager manages a collection of TCP client connections.
 type ClientManager struct {
-	name     string
-	log      internalLogger
-	handlers Handlers
-	clients  *clients
+	name      string
+	log       internalLogger
+	handlers  Handlers
+	tlsConfig *tls.Config
+	clients   *clients
 }
 
 // NewClientManager creates a new ClientManager.
@@ -49,10 +52,11 @@ func NewClientManager(name string, cfg ClientConfig) (*ClientManager, error) {
 	}
 
 	cm := ClientManager{
-		name:     name,
-		log:      l,
-		handlers: cfg.Handlers,
-		clients:  newClients(l),
+		name:      name,
+		log:       l,
+		handlers:  cfg.Handlers,
+		tlsConfig: cfg.TLSConfig,
+		clients:   newClients(l),
 	}
 
 	return &cm, nil
@@ -89,7 +93,7 @@ func (cm *ClientManager) Dial(ctx context.Context, key string, network string, a
 		return nil, ErrClientAlreadyConnected
 	}
 
-	conn, err := net.Dial(network, address)
+	conn, err := cm.dial(ctx, network, address)
 	if err != nil {
 		return nil, fmt.Errorf("dial: %w", err)
 	}
@@ -115,3 +119,29 @@ func (cm *ClientManager) Retrieve(ctx context.Context, key string) (*Client, err
 
 	return clt, nil
 }
+
+// =============================================================================
+
+// dial connects to the address, performing the TLS handshake when the
+// manager is configured for TLS.
+func (cm *ClientManager) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	if cm.tlsConfig == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+
+	cfg := cm.tlsConfig.Clone()
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+
+	d := tls.Dialer{
+		Config: cfg,
+	}
+
+	return d.DialContext(ctx, network, address)
+}
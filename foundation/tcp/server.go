@@ -2,6 +2,7 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -15,10 +16,11 @@ import (
 
 // ServerConfig provides a data structure of required configuration parameters.
 type ServerConfig struct {
-	NetType  string   // "tcp", tcp4" or "tcp6"
-	Addr     string   // "host:port" or "[ipv6-host%zone]:port"
-	Handlers Handlers // Support for binding and handling requests.
-	Logger   Logger   // Support for logging events that occur in the TCP listener.
+	NetType   string      // "tcp", tcp4" or "tcp6"
+	Addr      string      // "host:port" or "[ipv6-host%zone]:port"
+	Handlers  Handlers    // Support for binding and handling requests.
+	Logger    Logger      // Support for logging events that occur in the TCP listener.
+	TLSConfig *tls.Config // Optional, accepted connections will use TLS.
 }
 
 func (cfg ServerConfig) validate() error {
@@ -34,6 +36,10 @@ func (cfg ServerConfig) validate() error {
 		return ErrInvalidLoggerHandler
 	}
 
+	if cfg.TLSConfig != nil && len(cfg.TLSConfig.Certificates) == 0 && cfg.TLSConfig.GetCertificate == nil {
+		return ErrInvalidTLSConfig
+	}
+
 	return nil
 }
 
@@ -48,6 +54,7 @@ type Server struct {
 	ipAddress              string
 	port                   int
 	tcpAddr                *net.TCPAddr
+	tlsConfig              *tls.Config
 	listener               *listener
 	clients                *clients
 	wgStartG               sync.WaitGroup
@@ -80,6 +87,7 @@ func NewServer(name string, cfg ServerConfig) (*Server, error) {
 		ipAddress: tcpAddr.IP.String(),
 		port:      tcpAddr.Port,
 		tcpAddr:   tcpAddr,
+		tlsConfig: cfg.TLSConfig,
 		listener:  newListener(),
 		clients:   newClients(l),
 	}
@@ -125,7 +133,7 @@ func (srv *Server) Name() string {
 
 // Listen creates the accept routine and begins to accept connections.
 func (srv *Server) Listen() error {
-	if srv.listener.tcpListener() != nil {
+	if srv.listener.netListener() != nil {
 		return errors.New("this TCP has already been started")
 	}
 
@@ -145,7 +153,7 @@ func (srv *Server) Listen() error {
 				break
 			}
 
-			listener, err := srv.listener.start(srv.netType, srv.tcpAddr)
+			listener, err := srv.listener.start(srv.netType, srv.tcpAddr, srv.tlsConfig)
 			if err != nil {
 				// TODO: Use Context to control the retry / cancel.
 				srv.log(srv.ctx, srv.name, EvtAccept, TypError, "", err.Error())
@@ -209,8 +217,14 @@ func (srv *Server) CloseClient(tcpAddr *net.TCPAddr) error {
 
 // Addr returns the listener's network address. This may be different than the values
 // provided in the configuration, for example if configuration port value is 0.
+// Addr returns nil when the server is not listening.
 func (srv *Server) Addr() net.Addr {
-	return srv.tcpAddr
+	listener := srv.listener.netListener()
+	if listener == nil {
+		return nil
+	}
+
+	return listener.Addr()
 }
 
 // Clients returns the number of active clients connected by user ID.
@@ -48,6 +48,7 @@ var (
 	ErrInvalidNetType       = errors.New("invalid net type configuration")
 	ErrInvalidHandlers      = errors.New("invalid handlers configuration")
 	ErrInvalidLoggerHandler = errors.New("invalid logger handler configuration")
+	ErrInvalidTLSConfig     = errors.New("invalid tls configuration, no certificate")
 )
 
 // =============================================================================
@@ -2,8 +2,11 @@ package tcp_test
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"net"
 	"sync/atomic"
+	"testing"
 	"time"
 
 	"github.com/ardanlabs/usdl/foundation/tcp"
@@ -49,3 +52,21 @@ func (tcpHandlers) Process(r *tcp.Request, clt *tcp.Client) {
 func (tcpHandlers) Drop(clt *tcp.Client) {
 	fmt.Println("***> SERVER: CONNECTION CLOSED")
 }
+
+// =============================================================================
+
+func logger(ctx context.Context, name string, evt string, typ string, ipAddress string, format string, a ...any) {
+}
+
+// waitForAddr waits for the server to be listening since Listen blocks.
+func waitForAddr(t *testing.T, srv *tcp.Server) net.Addr {
+	for range 100 {
+		if addr := srv.Addr(); addr != nil {
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("\tAddr() should be not be nil after Start.", "X")
+	return nil
+}
@@ -21,6 +21,7 @@ func TestTCP(t *testing.T) {
 			NetType:  "tcp4",
 			Addr:     ":0",
 			Handlers: tcpHandlers{},
+			Logger:   logger,
 		}
 
 		// Create a new TCP value.
@@ -39,7 +40,7 @@ func TestTCP(t *testing.T) {
 		}()
 
 		// Let's connect back and send a TCP package
-		conn, err := net.Dial("tcp4", u.Addr().String())
+		conn, err := net.Dial("tcp4", waitForAddr(t, u).String())
 		if err != nil {
 			t.Fatal("\tShould be able to dial a new TCP connection.", "X", err)
 		}
@@ -92,6 +93,7 @@ func TestTCPAddr(t *testing.T) {
 			NetType:  "tcp4",
 			Addr:     ":0", // Defer port assignment to OS.
 			Handlers: tcpHandlers{},
+			Logger:   logger,
 		}
 
 		// Create a new TCP value.
@@ -115,10 +117,7 @@ func TestTCPAddr(t *testing.T) {
 		}()
 
 		// Addr should be non-nil after Start.
-		addr := u.Addr()
-		if addr == nil {
-			t.Fatal("\tAddr() should be not be nil after Start.", "X")
-		}
+		addr := waitForAddr(t, u)
 		t.Log("\tAddr() should be not be nil after Start.", "OK")
 
 		// The OS should assign a random open port, which shouldn't be 0.
@@ -0,0 +1,144 @@
+package tcp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSFiles provides the files needed to construct a TLS configuration.
+// When Pins are provided, the peer's leaf certificate must match one of
+// the SHA-256 fingerprints. Pins can be used with or without a CA.
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Pins     []string
+}
+
+// ServerTLSConfig constructs a TLS configuration for a Server. When a CA or
+// pins are provided, clients must present a certificate (mutual TLS).
+func ServerTLSConfig(files TLSFiles) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load key pair: %w", err)
+	}
+
+	cfg := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if files.CAFile != "" {
+		pool, err := loadCertPool(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if len(files.Pins) > 0 {
+		if cfg.ClientAuth == tls.NoClientCert {
+			cfg.ClientAuth = tls.RequireAnyClientCert
+		}
+
+		cfg.VerifyPeerCertificate = PinCertificates(files.Pins...)
+	}
+
+	return &cfg, nil
+}
+
+// ClientTLSConfig constructs a TLS configuration for a ClientManager. The
+// certificate is optional and only needed when the server requires mutual
+// TLS. When only pins are provided, the pins replace the CA verification.
+func ClientTLSConfig(files TLSFiles) (*tls.Config, error) {
+	cfg := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if files.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load key pair: %w", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if files.CAFile != "" {
+		pool, err := loadCertPool(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if len(files.Pins) > 0 {
+		if files.CAFile == "" {
+			cfg.InsecureSkipVerify = true
+		}
+
+		cfg.VerifyPeerCertificate = PinCertificates(files.Pins...)
+	}
+
+	return &cfg, nil
+}
+
+// PinCertificates returns a function to be used as the VerifyPeerCertificate
+// field of a tls.Config. The peer's leaf certificate must match one of the
+// hex encoded SHA-256 fingerprints.
+func PinCertificates(fingerprints ...string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	pins := make(map[string]bool, len(fingerprints))
+	for _, fp := range fingerprints {
+		fp = strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+		pins[fp] = true
+	}
+
+	f := func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate")
+		}
+
+		sum := sha256.Sum256(rawCerts[0])
+		fp := hex.EncodeToString(sum[:])
+
+		if !pins[fp] {
+			return fmt.Errorf("peer certificate not pinned: %s", fp)
+		}
+
+		return nil
+	}
+
+	return f
+}
+
+// Fingerprint returns the hex encoded SHA-256 fingerprint of the certificate
+// that can be used with PinCertificates.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// =============================================================================
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in ca file: %s", caFile)
+	}
+
+	return pool, nil
+}
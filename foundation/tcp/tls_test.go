@@ -0,0 +1,305 @@
+package tcp_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/usdl/foundation/tcp"
+)
+
+// TestTLS provides a test of mutual TLS between a server and a client
+// manager using certificates signed by a CA.
+func TestTLS(t *testing.T) {
+	t.Log("Given the need to process TCP data over mutual TLS.")
+	{
+		certs := newTestCerts(t)
+
+		srvTLS, err := tcp.ServerTLSConfig(tcp.TLSFiles{
+			CertFile: certs.serverCert,
+			KeyFile:  certs.serverKey,
+			CAFile:   certs.ca,
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create the server TLS config.", "X", err)
+		}
+		t.Log("\tShould be able to create the server TLS config.", "OK")
+
+		cltTLS, err := tcp.ClientTLSConfig(tcp.TLSFiles{
+			CertFile: certs.clientCert,
+			KeyFile:  certs.clientKey,
+			CAFile:   certs.ca,
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create the client TLS config.", "X", err)
+		}
+		t.Log("\tShould be able to create the client TLS config.", "OK")
+
+		testTLSEcho(t, srvTLS, cltTLS)
+	}
+}
+
+// TestTLSPinned provides a test of mutual TLS where the certificates are
+// pinned instead of verified with a CA.
+func TestTLSPinned(t *testing.T) {
+	t.Log("Given the need to process TCP data with pinned certificates.")
+	{
+		certs := newTestCerts(t)
+
+		srvTLS, err := tcp.ServerTLSConfig(tcp.TLSFiles{
+			CertFile: certs.serverCert,
+			KeyFile:  certs.serverKey,
+			Pins:     []string{certs.clientPin},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create the server TLS config.", "X", err)
+		}
+		t.Log("\tShould be able to create the server TLS config.", "OK")
+
+		cltTLS, err := tcp.ClientTLSConfig(tcp.TLSFiles{
+			CertFile: certs.clientCert,
+			KeyFile:  certs.clientKey,
+			Pins:     []string{certs.serverPin},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create the client TLS config.", "X", err)
+		}
+		t.Log("\tShould be able to create the client TLS config.", "OK")
+
+		testTLSEcho(t, srvTLS, cltTLS)
+
+		// A client that doesn't pin the server's certificate must fail.
+		badTLS, err := tcp.ClientTLSConfig(tcp.TLSFiles{
+			CertFile: certs.clientCert,
+			KeyFile:  certs.clientKey,
+			Pins:     []string{certs.clientPin},
+		})
+		if err != nil {
+			t.Fatal("\tShould be able to create the client TLS config.", "X", err)
+		}
+
+		srv := startTLSServer(t, srvTLS)
+		defer srv.Shutdown(context.Background())
+
+		cm, _ := newTLSClientManager(t, badTLS)
+		defer cm.Shutdown(context.Background())
+
+		if _, err := cm.Dial(context.Background(), "bad", "tcp4", srv.Addr().String()); err == nil {
+			t.Fatal("\tShould not be able to dial a server that isn't pinned.", "X")
+		}
+		t.Log("\tShould not be able to dial a server that isn't pinned.", "OK")
+	}
+}
+
+// =============================================================================
+
+func testTLSEcho(t *testing.T, srvTLS *tls.Config, cltTLS *tls.Config) {
+	srv := startTLSServer(t, srvTLS)
+	defer srv.Shutdown(context.Background())
+
+	cm, responses := newTLSClientManager(t, cltTLS)
+	defer cm.Shutdown(context.Background())
+
+	clt, err := cm.Dial(context.Background(), "test", "tcp4", srv.Addr().String())
+	if err != nil {
+		t.Fatal("\tShould be able to dial the TLS server.", "X", err)
+	}
+	t.Log("\tShould be able to dial the TLS server.", "OK")
+
+	if _, ok := clt.Conn.(*tls.Conn); !ok {
+		t.Fatalf("\tShould have a TLS connection, got %T. %s", clt.Conn, "X")
+	}
+	t.Log("\tShould have a TLS connection.", "OK")
+
+	if _, err := clt.Conn.Write([]byte("Hello\n")); err != nil {
+		t.Fatal("\tShould be able to send data to the connection.", "X", err)
+	}
+	t.Log("\tShould be able to send data to the connection.", "OK")
+
+	select {
+	case response := <-responses:
+		if response != "GOT IT\n" {
+			t.Fatal("\tShould receive the string \"GOT IT\".", "X", response)
+		}
+		t.Log("\tShould receive the string \"GOT IT\".", "OK")
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("\tShould receive the string \"GOT IT\".", "X", "timeout")
+	}
+}
+
+func startTLSServer(t *testing.T, tlsConfig *tls.Config) *tcp.Server {
+	cfg := tcp.ServerConfig{
+		NetType:   "tcp4",
+		Addr:      "127.0.0.1:0",
+		Handlers:  tcpHandlers{},
+		Logger:    logger,
+		TLSConfig: tlsConfig,
+	}
+
+	srv, err := tcp.NewServer("TEST", cfg)
+	if err != nil {
+		t.Fatal("\tShould be able to create a new TLS listener.", "X", err)
+	}
+
+	go srv.Listen()
+
+	waitForAddr(t, srv)
+
+	return srv
+}
+
+func newTLSClientManager(t *testing.T, tlsConfig *tls.Config) (*tcp.ClientManager, chan string) {
+	responses := make(chan string, 1)
+
+	cfg := tcp.ClientConfig{
+		Handlers:  cltHandlers{responses: responses},
+		Logger:    logger,
+		TLSConfig: tlsConfig,
+	}
+
+	cm, err := tcp.NewClientManager("TEST", cfg)
+	if err != nil {
+		t.Fatal("\tShould be able to create a new client manager.", "X", err)
+	}
+
+	return cm, responses
+}
+
+// =============================================================================
+
+type cltHandlers struct {
+	responses chan string
+}
+
+func (cltHandlers) Bind(clt *tcp.Client) error {
+	clt.Reader = bufio.NewReader(clt.Conn)
+	return nil
+}
+
+func (cltHandlers) Read(clt *tcp.Client) ([]byte, int, error) {
+	line, err := clt.Reader.(*bufio.Reader).ReadString('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return []byte(line), len(line), nil
+}
+
+func (h cltHandlers) Process(r *tcp.Request, clt *tcp.Client) {
+	h.responses <- string(r.Data)
+}
+
+func (cltHandlers) Drop(clt *tcp.Client) {}
+
+// =============================================================================
+
+type testCerts struct {
+	ca         string
+	serverCert string
+	serverKey  string
+	serverPin  string
+	clientCert string
+	clientKey  string
+	clientPin  string
+}
+
+// newTestCerts generates a CA and a server and client certificate signed by
+// the CA, writing them to a temporary directory.
+func newTestCerts(t *testing.T) testCerts {
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("\tShould be able to generate the CA key.", "X", err)
+	}
+
+	caTmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, &caTmpl, &caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("\tShould be able to create the CA certificate.", "X", err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal("\tShould be able to parse the CA certificate.", "X", err)
+	}
+
+	certs := testCerts{
+		ca: writePEM(t, dir, "ca.crt", "CERTIFICATE", caDER),
+	}
+
+	leaf := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal("\tShould be able to generate the key.", "X", err)
+		}
+
+		tmpl := x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, &tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal("\tShould be able to create the certificate.", "X", err)
+		}
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal("\tShould be able to marshal the key.", "X", err)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal("\tShould be able to parse the certificate.", "X", err)
+		}
+
+		certFile := writePEM(t, dir, name+".crt", "CERTIFICATE", der)
+		keyFile := writePEM(t, dir, name+".key", "EC PRIVATE KEY", keyDER)
+
+		return certFile, keyFile, tcp.Fingerprint(cert)
+	}
+
+	certs.serverCert, certs.serverKey, certs.serverPin = leaf("server", 2, x509.ExtKeyUsageServerAuth)
+	certs.clientCert, certs.clientKey, certs.clientPin = leaf("client", 3, x509.ExtKeyUsageClientAuth)
+
+	return certs
+}
+
+func writePEM(t *testing.T, dir string, name string, typ string, der []byte) string {
+	fileName := filepath.Join(dir, name)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal("\tShould be able to write the PEM file.", "X", err)
+	}
+
+	return fileName
+}
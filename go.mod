@@ -13,6 +13,7 @@ require (
 	github.com/open-policy-agent/opa v1.10.0
 	github.com/rivo/tview v0.42.0
 	github.com/tmc/langchaingo v0.1.14
+	golang.org/x/term v0.36.0
 )
 
 require (
@@ -68,7 +69,6 @@ require (
 	go.yaml.in/yaml/v2 v2.4.3 // indirect
 	golang.org/x/crypto v0.43.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect
-	golang.org/x/term v0.36.0 // indirect
 	golang.org/x/text v0.30.0 // indirect
 	google.golang.org/protobuf v1.36.10 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect